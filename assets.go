@@ -0,0 +1,121 @@
+package main
+
+/**
+ * External assets
+ *
+ * Writes thumbnails as files next to the page instead of inlining them, and encodes WebP
+ * variants through the `cwebp` tool when it is installed.
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/png"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+var ErrWebPUnavailable = errors.New("cwebp not found in PATH")
+
+// AssetLayouts lists the ways assets can be laid out in the assets directory: one subdirectory
+// per family, all in the directory with the family as prefix, or named after their content.
+var AssetLayouts = []string{"family", "flat", "hashed"}
+
+// AssetPath returns the path of an asset within the assets directory for layout. Hashed names
+// change with the content, so they can be cached forever, and identical assets share one file.
+func AssetPath(layout string, family string, name string, data []byte) string {
+	switch layout {
+	case "flat":
+		return family + "." + name
+	case "hashed":
+		hash := sha256.Sum256(data)
+
+		return hex.EncodeToString(hash[:8]) + path.Ext(name)
+	default:
+		return filepath.Join(family, name)
+	}
+}
+
+// WriteAsset stores data in assetsPath, at the place layout gives it, and returns its URL
+// relative to the page.
+func WriteAsset(assetsPath string, layout string, outputPath string, family string, name string, data []byte) (string, error) {
+	assetPath := filepath.Join(assetsPath, AssetPath(layout, family, name, data))
+
+	if err := os.MkdirAll(filepath.Dir(assetPath), 0755); err != nil {
+		return "", err
+	}
+
+	// A hashed asset already written holds the same content.
+	if _, err := os.Stat(assetPath); layout != "hashed" || err != nil {
+		if err := os.WriteFile(assetPath, data, 0644); err != nil {
+			return "", err
+		}
+	}
+
+	outputDirectory, err := filepath.Abs(filepath.Dir(outputPath))
+
+	if err != nil {
+		return "", err
+	}
+
+	absoluteAssetPath, err := filepath.Abs(assetPath)
+
+	if err != nil {
+		return "", err
+	}
+
+	relativePath, err := filepath.Rel(outputDirectory, absoluteAssetPath)
+
+	if err != nil {
+		return "", err
+	}
+
+	return (&url.URL{Path: filepath.ToSlash(relativePath)}).String(), nil
+}
+
+// EncodeWebP encodes img with the external cwebp encoder at the given quality.
+func EncodeWebP(img image.Image, quality int) ([]byte, error) {
+	encoder, err := exec.LookPath("cwebp")
+
+	if err != nil {
+		return nil, ErrWebPUnavailable
+	}
+
+	directory, err := os.MkdirTemp("", "crf2html-webp")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(directory)
+
+	inputPath := filepath.Join(directory, "input.png")
+	outputPath := filepath.Join(directory, "output.webp")
+
+	inputFile, err := os.Create(inputPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = png.Encode(inputFile, img)
+	inputFile.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	command := exec.Command(encoder, "-quiet", "-q", strconv.Itoa(quality), inputPath, "-o", outputPath)
+
+	if output, err := command.CombinedOutput(); err != nil {
+		return nil, errors.New("cwebp: " + string(output) + err.Error())
+	}
+
+	return os.ReadFile(outputPath)
+}
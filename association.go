@@ -0,0 +1,182 @@
+package main
+
+/**
+ * File association
+ *
+ * `crf2html install-association` registers the binary as the handler of `.crf` files: per-user
+ * registry keys on Windows, a desktop entry and MIME type on Linux. Double-clicking a CRF then runs
+ * `crf2html open file.crf`, which generates the gallery into a temporary directory and opens it in
+ * the default browser.
+ *
+ * macOS is left out: Finder hands documents to applications as Apple Events, never as arguments,
+ * so it takes an application bundle answering them, which a command line binary is not. There,
+ * `crf2html open file.crf` opens a gallery from a terminal.
+ */
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	associationProgID   = "CRF2HTML.crf"
+	associationMIMEType = "application/x-thief-crf"
+)
+
+// InstallAssociation registers the running executable as the handler of `.crf` files.
+func InstallAssociation() error {
+	executable, err := os.Executable()
+
+	if err != nil {
+		return err
+	}
+
+	executable, err = filepath.Abs(executable)
+
+	if err != nil {
+		return err
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		return installWindowsAssociation(executable)
+	case "linux", "freebsd", "openbsd", "netbsd":
+		return installDesktopAssociation(executable)
+	case "darwin":
+		return fmt.Errorf("install-association is not supported on macOS, where Finder opens files through an application bundle: run %s open file.crf instead", filepath.Base(executable))
+	default:
+		return fmt.Errorf("install-association is not supported on %s", runtime.GOOS)
+	}
+}
+
+func installWindowsAssociation(executable string) error {
+	classes := `HKCU\Software\Classes`
+	command := fmt.Sprintf(`"%s" open "%%1"`, executable)
+
+	entries := [][]string{
+		{classes + `\.crf`, associationProgID},
+		{classes + `\` + associationProgID, "Thief texture archive"},
+		{classes + `\` + associationProgID + `\shell\open\command`, command},
+	}
+
+	for _, entry := range entries {
+		output, err := exec.Command("reg", "add", entry[0], "/ve", "/d", entry[1], "/f").CombinedOutput()
+
+		if err != nil {
+			return fmt.Errorf("reg add %s: %v: %s", entry[0], err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	return nil
+}
+
+func installDesktopAssociation(executable string) error {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+
+		if err != nil {
+			return err
+		}
+
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+
+	desktopEntry := fmt.Sprintf(`[Desktop Entry]
+Type=Application
+Name=crf2html
+Comment=Browse the textures of a Thief CRF archive
+Exec="%s" open %%f
+MimeType=%s;
+NoDisplay=true
+Terminal=false
+`, strings.ReplaceAll(executable, `"`, `\"`), associationMIMEType)
+
+	mimePackage := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<mime-info xmlns="http://www.freedesktop.org/standards/shared-mime-info">
+  <mime-type type="%s">
+    <comment>Thief texture archive</comment>
+    <glob pattern="*.crf"/>
+  </mime-type>
+</mime-info>
+`, associationMIMEType)
+
+	files := map[string]string{
+		filepath.Join(dataHome, "applications", "crf2html.desktop"): desktopEntry,
+		filepath.Join(dataHome, "mime", "packages", "crf2html.xml"): mimePackage,
+	}
+
+	for filePath, contents := range files {
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(filePath, []byte(contents), 0644); err != nil {
+			return err
+		}
+	}
+
+	// The databases are refreshed on login anyway, so missing tools are only worth a warning.
+	commands := [][]string{
+		{"update-mime-database", filepath.Join(dataHome, "mime")},
+		{"update-desktop-database", filepath.Join(dataHome, "applications")},
+		{"xdg-mime", "default", "crf2html.desktop", associationMIMEType},
+	}
+
+	for _, command := range commands {
+		if err := exec.Command(command[0], command[1:]...).Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", command[0], err)
+		}
+	}
+
+	return nil
+}
+
+// OpenInBrowser generates the gallery of sourcePath into a temporary directory and opens it in
+// the default browser. options are parsed like the ones following output_path.
+func OpenInBrowser(sourcePath string, options []string) error {
+	outputDirectory, err := os.MkdirTemp("", "crf2html-")
+
+	if err != nil {
+		return err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(sourcePath), filepath.Ext(sourcePath))
+	outputPath := filepath.Join(outputDirectory, name+".html")
+
+	settings, err := ParseArguments(append([]string{sourcePath, outputPath}, options...))
+
+	if err != nil {
+		return err
+	}
+
+	if settings.Page.Title == "Textures" {
+		settings.Page.Title = filepath.Base(sourcePath)
+	}
+
+	if err := Generate(settings); err != nil {
+		return err
+	}
+
+	return openBrowser(outputPath)
+}
+
+func openBrowser(target string) error {
+	var command *exec.Cmd
+
+	switch runtime.GOOS {
+	case "windows":
+		command = exec.Command("rundll32", "url.dll,FileProtocolHandler", target)
+	case "darwin":
+		command = exec.Command("open", target)
+	default:
+		command = exec.Command("xdg-open", target)
+	}
+
+	return command.Start()
+}
@@ -0,0 +1,200 @@
+package main
+
+/**
+ * Check mode
+ *
+ * With `-check`, a run regenerates the inventory of the source and compares it with the files a
+ * previous run wrote, instead of writing them: the `-manifest` file, the output of `-format json`,
+ * or else the textures and hashes recorded in the state file of the page. Any difference is
+ * printed as a diff and fails the run, so a pre-commit hook or a CI job of a texture repository
+ * can require the committed gallery to be regenerated along with the textures.
+ */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"crf2html/gallery"
+)
+
+// ErrOutOfDate is returned by -check runs finding the files of the gallery out of date.
+var ErrOutOfDate = errors.New("the gallery is out of date, run crf2html without -check to regenerate it")
+
+// maxDiffLines bounds the lines of the part of two files differing that are matched against each
+// other; larger differences are printed as a whole.
+const maxDiffLines = 4000
+
+// CheckGallery compares inventory and the texture hashes of state with the files of the gallery
+// of settings, and writes their differences to writer. It returns ErrOutOfDate if any.
+func CheckGallery(writer io.Writer, settings Settings, inventory *gallery.Inventory, state GenerationState) error {
+	manifest := new(bytes.Buffer)
+
+	if err := inventory.WriteJSON(manifest); err != nil {
+		return err
+	}
+
+	var paths []string
+
+	if settings.Page.ManifestPath != "" {
+		paths = append(paths, settings.Page.ManifestPath)
+	}
+
+	if settings.Page.Format == "json" {
+		paths = append(paths, settings.Page.OutputPath)
+	}
+
+	upToDate := true
+
+	for _, manifestPath := range paths {
+		committed, err := os.ReadFile(manifestPath)
+
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(writer, "%s: missing\n", manifestPath)
+			upToDate = false
+
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(committed, manifest.Bytes()) {
+			fmt.Fprintf(writer, "--- %s\n+++ %s (regenerated)\n", manifestPath, manifestPath)
+			WriteLineDiff(writer, splitLines(committed), splitLines(manifest.Bytes()))
+			upToDate = false
+		}
+	}
+
+	// The page itself holds thumbnails and dates, so its state tells whether it is up to date.
+	if len(paths) == 0 {
+		previousState, err := LoadState(settings.Page.OutputPath)
+
+		if err != nil {
+			return err
+		}
+
+		if previousState.Generated.IsZero() {
+			fmt.Fprintf(writer, "%s: no state of a previous run\n", StatePath(settings.Page.OutputPath))
+
+			return ErrOutOfDate
+		}
+
+		changes := TextureChanges(previousState, state)
+
+		for _, key := range sortedKeys(changes) {
+			fmt.Fprintf(writer, "%s %s\n", changes[key], key)
+			upToDate = false
+		}
+
+		for _, key := range RemovedTextures(previousState, state) {
+			fmt.Fprintf(writer, "removed %s\n", key)
+			upToDate = false
+		}
+	}
+
+	if !upToDate {
+		return ErrOutOfDate
+	}
+
+	return nil
+}
+
+func splitLines(data []byte) []string {
+	return strings.SplitAfter(string(data), "\n")
+}
+
+// WriteLineDiff writes the lines to turn before into after, in the unified format without
+// context: a "@@ -line,count +line,count @@" header, then the removed lines prefixed with "-"
+// and the added ones with "+", for every run of differing lines.
+func WriteLineDiff(writer io.Writer, before []string, after []string) {
+	prefix := 0
+
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+
+	for suffix < len(before)-prefix && suffix < len(after)-prefix && before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+
+	before, after = before[prefix:len(before)-suffix], after[prefix:len(after)-suffix]
+
+	var removed, added []string
+	i, j := 0, 0
+
+	flush := func() {
+		if len(removed) == 0 && len(added) == 0 {
+			return
+		}
+
+		// Like diff, an empty side is located by the line before it.
+		beforeLine, afterLine := prefix+i-len(removed)+1, prefix+j-len(added)+1
+
+		if len(removed) == 0 {
+			beforeLine--
+		}
+
+		if len(added) == 0 {
+			afterLine--
+		}
+
+		fmt.Fprintf(writer, "@@ -%d,%d +%d,%d @@\n", beforeLine, len(removed), afterLine, len(added))
+
+		for _, line := range removed {
+			fmt.Fprintln(writer, "-"+strings.TrimSuffix(line, "\n"))
+		}
+
+		for _, line := range added {
+			fmt.Fprintln(writer, "+"+strings.TrimSuffix(line, "\n"))
+		}
+
+		removed, added = nil, nil
+	}
+
+	if len(before) > maxDiffLines || len(after) > maxDiffLines {
+		removed, added = before, after
+		i, j = len(before), len(after)
+		flush()
+
+		return
+	}
+
+	// common[i][j] is the length of the longest common subsequence of before[i:] and after[j:].
+	common := make([][]int, len(before)+1)
+
+	for i := range common {
+		common[i] = make([]int, len(after)+1)
+	}
+
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	for i < len(before) || j < len(after) {
+		switch {
+		case i < len(before) && j < len(after) && before[i] == after[j]:
+			flush()
+			i++
+			j++
+		case j >= len(after) || (i < len(before) && common[i+1][j] >= common[i][j+1]):
+			removed = append(removed, before[i])
+			i++
+		default:
+			added = append(added, after[j])
+			j++
+		}
+	}
+
+	flush()
+}
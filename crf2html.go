@@ -5,317 +5,968 @@ package main
  *
  * This program generates an HTML page displaying image textures from a given directory or CRF/ZIP file.
  * It resizes and encodes the images as base64 and creates an organized HTML page.
+ * Layered PSD sources are shown through their flattened composite image, and legacy IFF ILBM/LBM
+ * images are decoded as well.
  *
- * Usage: go build -o crf2html main.go && ./crf2html source_path output_path [-title "Page Title"]
- * Example: go build -o crf2html main.go && ./crf2html ./fam.crf ./textures.html -title "My Custom Title"
+ * Usage: go build -o crf2html . && ./crf2html source_path output_path [-title "Page Title"]
+ * Example: go build -o crf2html . && ./crf2html ./fam.crf ./textures.html -title "My Custom Title"
  *
  * Arguments:
- *  - source_path: Path to the directory containing image files or a CRF/ZIP file.
+ *  - source_path: Path to the directory containing image files or a CRF/ZIP file, or an http(s) URL of a CRF/ZIP file.
  *  - output_path: Path to the HTML file to be generated.
  *
  * Options:
+ *  -config: (Optional) JSON file with settings ({"thumbnails": {"size": 64}, "page": {"title": "..."}, ...}), overridden by the
+ *           other options.
+ *  -profile: (Optional) Preset of options, overridden by the other options: "datasaver" for slow connections (96px
+ *            thumbnails at quality 70 written next to the page with WebP variants, no 4x previews, 100 textures per
+ *            page), or "archive" for long-term preservation (lossless thumbnails, original files and JSON manifest
+ *            next to the page, every detail in the captions).
+ *  -format: (Optional) "html" (default) for the page, "json" for the inventory of families and textures, or "sqlite" to add
+ *           the inventory as a new run of the SQLite database at output_path.
  *  -title: (Optional) Custom title for the HTML page. If not provided, the default title is "Textures".
+ *  -caption: (Optional) Caption template under each thumbnail, e.g. "{name} · {width}x{height} · {format} · {size}". Fields: name,
+ *            file, family, path, width, height, format, size, sha256 and palette.
+ *  -no-captions: (Optional) Show the images alone, their name and dimensions as tooltips, to fit about three times as many per screen.
+ *  -no-js: (Optional) Leave the script out, for a static page without search, filters, slideshow nor lightbox.
+ *  -engine-view: (Optional) Add an "Engine view" button showing the truecolor textures of the families having a full.pcx
+ *                palette as the 8-bit renderer draws them: reduced to that palette with ordered dithering.
+ *  -ratings: (Optional) JSON file of texture reviews ({"brick/wall.png": {"stars": 4, "verdict": "approved", "note": "..."}}),
+ *            shown as star and verdict badges with a filter on the verdicts, and kept in the "json" format output.
+ *  -sort-families: (Optional) Order of the families: "name" (default), or "count" or "bytes" for the most textures or the
+ *                  largest total size first.
+ *  -collation: (Optional) Language whose order sorts the families and textures, as a BCP 47 tag such as "fr" or "de": accents
+ *              next to their base letter, case only telling otherwise equal names apart, numbers by value.
+ *  -family-names: (Optional) JSON file mapping family directories to the names shown in the page ({"corint1": "Corinthian Stone 1"}),
+ *                 the directory name staying in the tooltip of the heading.
  *  -size: (Optional) Custom thumbnail size for the HTML page. If not provided, the default size is "128".
+ *  -columns: (Optional) Maximum number of tiles per row, or "auto" (default) to fit as many as the window allows.
+ *  -min-dim, -max-dim: (Optional) Only show the textures whose longest side is at least, or at most, this many pixels, such as
+ *                     "-max-dim 128" for a worklist of the low-resolution textures still needing an HD replacement.
+ *  -per-page: (Optional) Split the page into pages of about this many textures, "<output_path>-2.html" and so on, linked
+ *             to each other. Families are never split.
+ *  -theme: (Optional) Comma-separated themes applied over the default dark one: "light", and "colorblind" for a color-blind
+ *          safe badge palette with symbols and patterns, e.g. "light,colorblind".
+ *  -print: (Optional) Show the page as printed (white background, one family per page, no controls), for PDF reference sheets.
+ *  -fragment: (Optional) Write only the family sections, to embed in another page, and their stylesheet next to them
+ *             ("<output_path without extension>.css").
+ *  -models: (Optional) Directory or obj CRF/ZIP file with `.bin` models, used to list which models use each texture.
+ *  -missions: (Optional) Directory with `.mis`/`.gam` files, used to mark textures as used in N missions or unused.
+ *  -assets: (Optional) Directory where thumbnails are written as files instead of being inlined. When cwebp is installed,
+ *           a WebP variant is added to each thumbnail through a <picture> element. Each family heading links to a ZIP file of
+ *           the original files of the family, written there too.
+ *  -inline-below: (Optional) With -assets, thumbnails and 4x previews under this many bytes (default 8192) stay inlined, as
+ *                 they cost less than a request of their own. 0 writes them all as files.
+ *  -asset-layout: (Optional) Layout of the -assets directory: "family" (default) for one subdirectory per family, "flat" for
+ *                 "<family>.<file>" names, or "hashed" for names derived from the content, cacheable forever and shared by identical files.
+ *  -sri: (Optional) With -assets, write the stylesheets and the script as assets too, under "crf2html", linked with
+ *        integrity attributes so that browsers refuse them altered by a mirror.
+ *  -max-embed-bytes: (Optional) Size budget of a page with inlined thumbnails. A larger page is encoded again at lower JPEG
+ *                    qualities, down to 20, until it fits, and the quality used is reported.
+ *  -mosaic: (Optional) Directory where a PNG mosaic of all thumbnails is written for each family.
+ *  -stats-json: (Optional) File where the counts, bytes, formats, resolution histogram and HD coverage of the run are
+ *              written as compact JSON, for monitoring dashboards.
+ *  -badges: (Optional) Directory where SVG badges of the texture count, total size and HD coverage of the run are written.
+ *  -quality: (Optional) JPEG quality from 1 to 100. Defaults to 90 for palettized sources and 85 for the others.
+ *  -webp-quality: (Optional) Quality of the WebP variants of -assets, from 1 to 100. Defaults to the JPEG quality of each.
+ *  -subsampling: (Optional) Chroma subsampling, "444" or "420". Defaults to "444" for palettized sources and "420" for the others.
+ *  -progressive: (Optional) Encode thumbnails as progressive JPEGs.
+ *  -auto-format: (Optional) Use PNG for thumbnails with transparency or few colors, JPEG for the others.
+ *  -lossless: (Optional) Encode every thumbnail as PNG, without loss.
+ *  -quantize: (Optional) Reduce every thumbnail to a PNG of this many colors, from 2 to 256, picked by median cut: they
+ *             look like the 8-bit textures of the engine, and flat-colored ones weigh much less than JPEGs.
+ *  -relief: (Optional) Show normal maps as a relief-shaded preview instead of their raw colors.
+ *  -exec: (Optional) Shell command run on each texture, such as "convert {path} {out}", whose output image is shown instead.
+ *  -upscale: (Optional) Add a 4x preview of each texture, shown by the lightbox, scaled up with "nearest" or "bicubic" interpolation.
+ *  -upscale-cmd: (Optional) Scale the 4x previews up with a shell command instead, such as ESRGAN, given {in}, {out} and {factor}.
+ *  -group-variants: (Optional) Group all textures sharing a base name and a variant suffix, plus `.mtl` files, into one material tile.
+ *  -variant-suffixes: (Optional) Comma-separated suffixes used by -group-variants, e.g. "_n,_s,_d,_gloss".
+ *  -only-family: (Optional) Scan and render this family alone, replacing its section in the page already at output_path (from an
+ *                earlier run with the same options) and leaving the other families, their assets and their state untouched.
+ *                With -per-page, the page holding the family is updated.
+ *  -check: (Optional) Write nothing, but fail with a diff if the -manifest file, the -format json output, or else the
+ *          textures recorded by the previous run of the page, are out of date with the source. For pre-commit hooks and CI.
+ *  -changed-only: (Optional) Only show the textures added or modified since the previous run, based on the hashes kept in
+ *                 "<output_path>.state.json".
+ *  -manifest: (Optional) File where the inventory of the page is also written as JSON.
+ *  -feed: (Optional) Atom feed file receiving an entry, with thumbnails, for every run that adds or modifies textures.
+ *  -overlay: (Optional) Directory or CRF/ZIP file loaded over the source, as a NewDark resource path would be: its textures
+ *            replace those of the same family and name, whatever their format. Repeat it to stack several overlays, the last one winning.
+ *  -explain: (Optional) Source file, as listed or as "family/file", whose inclusion is explained instead of writing the page:
+ *            the rule that kept or skipped it, and why.
+ *  -list: (Optional) Print the textures of the source with their format, size and dimensions, read from the headers of
+ *         their files without decoding the pixels, instead of writing the page.
+ *  -max-open-files: (Optional) Maximum number of source files open at the same time. Defaults to "64".
+ *  -jobs: (Optional) Number of textures decoded and rendered at once. By default it follows the CPUs Go may use and the size
+ *         of the pack: one per CPU, fewer on big machines for packs of large textures.
+ *  -tune: (Optional) Print the parallelism chosen (workers and chunks of textures handed to each) to stderr.
+ *  -decode-timeout: (Optional) Time after which the decoding of an image is given up, and the texture shown as broken, so a
+ *                   pathological file cannot hang the run. Defaults to "30s"; "0" waits as long as it takes.
+ *  -spill: (Optional) Keep the decoded textures out of memory: their source files go to a temporary file, and each texture
+ *          is decoded again when its thumbnail is made. Slower, but whole-game scans fit in a modest amount of RAM.
+ *  -stats: (Optional) Add an expandable panel with per-channel histograms and min/max/mean values to each texture.
+ *  -seams: (Optional) Compare the opposite edges of each texture and mark those likely to show a seam once tiled with a
+ *          "seam" badge and their score.
+ *  -on-interrupt: (Optional) What Ctrl-C does, once the file or texture in progress is done: "abort" (default) to write nothing
+ *                 and remove the temporary files, or "partial" to write the gallery of the textures scanned so far, marked as
+ *                 incomplete. A second Ctrl-C quits at once.
+ *  -progress: (Optional) "json" to write progress events to stderr, one JSON object per line: {"stage": "scan", "event":
+ *             "completed", "path": ..., "done": 12, "total": 40, "percent": 30}, then "render" per family and "write" at the end.
+ *  -git-ref: (Optional) Commit, tag or branch of the git work tree holding the source directory to read the textures from,
+ *           through git archive, instead of the files checked out.
+ *  -verify: (Optional) Expected hash of the CRF/ZIP source, as "sha256:<hex>". The run fails on mismatch, and the hash is
+ *           recorded in a <meta name="crf2html:source-sha256"> tag of the page.
+ *  -publish: (Optional) S3 destination ("s3://bucket/prefix") where the page, assets, mosaics and badges are uploaded after a successful run.
+ *  -publish-cmd: (Optional) Shell command run after a successful run, with CRF2HTML_OUTPUT, CRF2HTML_FILES, ... in its environment.
+ *  -notify-webhook: (Optional) Discord or Slack webhook URL receiving a summary and a preview collage once the page is written.
+ *  -notify-link: (Optional) URL of the published page, included in the webhook summary and the -feed entries.
+ *
+ * Subcommands:
+ *  - install-association: Register crf2html as the handler of `.crf` files (registry on Windows, .desktop entry on Linux; not macOS).
+ *  - open source_path [options]: Generate the page into a temporary directory and open it in the default browser.
+ *  - daemon [-listen 127.0.0.1:8080] [-work-dir path] [-source-root path] [-allow-remote]: Serve a REST API queueing
+ *    gallery generation jobs, on uploaded archives, or on paths under -source-root and URLs with -allow-remote.
+ *  - demo output_path [options]: Render a gallery of generated test textures, to check an install or preview options.
+ *  - pack source_dir output_path [-short-names] [-rename-map path]: Check a directory of family directories and pack it as a
+ *    CRF. -rename-map writes 8.3 names for the longer ones, as JSON or as a ".sh" script, and -short-names packs under them.
+ *  - repack input_path output_path [-level 0-9] [-exclude pattern] [-missions path] [-lowercase] [-short-names] [-rename-map path]:
+ *    Rewrite a CRF like pack, dropping the files matching -exclude and, with -missions, the textures no mission uses, and
+ *    report the savings.
+ *  - git-diff repo_dir base_ref head_ref output_path [options]: Render the textures of the git work tree added or modified
+ *    from base_ref to head_ref, the modified ones under a before/after slider, for the review of texture changes.
  */
 
 import (
-	"archive/zip"
 	"bytes"
-	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
 	"image"
 	"image/color"
-	"image/draw"
-	"image/jpeg"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"sort"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/ftrvxmtrx/tga"
-	"github.com/nfnt/resize"
-	"github.com/samuel/go-pcx/pcx"
+	"crf2html/gallery"
 )
 
-type ProgramSettings struct {
-	SourcePath      string
-	OutputPath      string
-	PageTitle       string
-	ThumbnailSize   int
-	BackgroundColor color.RGBA
+func main() {
+	if len(os.Args) >= 2 && (os.Args[1] == "install-association" || os.Args[1] == "open" || os.Args[1] == "daemon" || os.Args[1] == "demo" || os.Args[1] == "pack" || os.Args[1] == "repack" || os.Args[1] == "git-diff") {
+		var err error
+
+		if os.Args[1] == "install-association" {
+			err = InstallAssociation()
+		} else if os.Args[1] == "daemon" {
+			err = RunDaemon(os.Args[2:])
+		} else if os.Args[1] == "demo" {
+			err = RunDemo(os.Args[2:])
+		} else if os.Args[1] == "pack" {
+			err = RunPack(os.Args[2:])
+		} else if os.Args[1] == "repack" {
+			err = RunRepack(os.Args[2:])
+		} else if os.Args[1] == "git-diff" {
+			err = RunGitDiff(os.Args[2:])
+		} else if len(os.Args) < 3 {
+			err = errors.New("Usage: program open source_path [options]")
+		} else {
+			err = OpenInBrowser(os.Args[2], os.Args[3:])
+		}
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: program source_path output_path [-title \"Page Title\"]")
+		return
+	}
+
+	settings, err := ParseArguments(os.Args[1:])
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err := GenerateUntil(settings, NotifyInterrupt()); errors.Is(err, gallery.ErrInterrupted) {
+		fmt.Fprintln(os.Stderr, "interrupted")
+		os.Exit(130)
+	} else if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
 
-func FileListing(directoryPath string) ([]string, error) {
-	var files []string
+// ParseArguments reads the source path, the output path and the options following them. Options
+// override the JSON file given with -config, which overrides the defaults.
+func ParseArguments(args []string) (Settings, error) {
+	settings := DefaultSettings()
 
-	err := filepath.Walk(directoryPath, func(filePath string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	if len(args) < 2 {
+		return settings, errors.New("missing source_path or output_path")
+	}
+
+	options := args[2:]
+
+	// Configuration files and profiles are applied first, in order, for the other options to
+	// override them.
+	for i := 0; i < len(options); i++ {
+		if options[i] != "-config" && options[i] != "-profile" {
+			continue
 		}
 
-		if !info.IsDir() {
-			files = append(files, filePath)
+		if i+1 >= len(options) {
+			return settings, fmt.Errorf("Missing value for %s", options[i])
 		}
 
-		return nil
-	})
+		if options[i] == "-profile" {
+			profile, ok := Profiles[options[i+1]]
 
-	return files, err
-}
+			if !ok {
+				return settings, fmt.Errorf("Invalid value for %s: %s", options[i], options[i+1])
+			}
 
-func GetImageFromZip(zipReader *zip.ReadCloser, filePath string) (image.Image, error) {
-	for _, file := range zipReader.File {
-		if file.Name == filePath {
-			reader, err := file.Open()
+			// Profiles place their files next to the page.
+			settings.Page.OutputPath = args[1]
+			profile(&settings)
+		} else {
+			var err error
+			settings, err = settings.FromFile(options[i+1])
 
 			if err != nil {
-				return nil, err
+				return settings, err
 			}
+		}
 
-			defer reader.Close()
+		options = append(options[:i:i], options[i+2:]...)
+		i--
+	}
 
-			img, _, err := image.Decode(reader)
+	settings, err := settings.FromFlags(options)
 
-			if err != nil {
-				return nil, err
+	if err != nil {
+		return settings, err
+	}
+
+	settings.Source.Path = args[0]
+	settings.Page.OutputPath = args[1]
+
+	return settings, nil
+}
+
+// PagePath returns the path of the page numbered number, from 1, of a gallery split by
+// -per-page: the first one is outputPath, the others get their number before the extension.
+func PagePath(outputPath string, number int) string {
+	if number == 1 {
+		return outputPath
+	}
+
+	extension := filepath.Ext(outputPath)
+
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(outputPath, extension), number, extension)
+}
+
+// familyPagePath returns the page of the gallery at outputPath, split by -per-page, whose section
+// of family -only-family replaces: the page holding it, or else the first one holding a family
+// sorting after it by compare, or else the last one.
+func familyPagePath(outputPath string, family string, compare func(a string, b string) int) (string, error) {
+	const sectionStart = "<section data-family='"
+	insertPath, lastPath := "", ""
+
+	for number := 1; ; number++ {
+		path := PagePath(outputPath, number)
+		page, err := os.ReadFile(path)
+
+		if errors.Is(err, fs.ErrNotExist) && number > 1 {
+			break
+		} else if err != nil {
+			return "", err
+		}
+
+		lastPath = path
+
+		for _, section := range strings.Split(string(page), sectionStart)[1:] {
+			name := html.UnescapeString(strings.SplitN(section, "'", 2)[0])
+
+			if name == family {
+				return path, nil
 			}
 
-			return img, nil
+			if compare(name, family) > 0 && insertPath == "" {
+				insertPath = path
+			}
 		}
 	}
 
-	return nil, fmt.Errorf("file not found: %s", filePath)
+	if insertPath != "" {
+		return insertPath, nil
+	}
+
+	return lastPath, nil
 }
 
-type Texture struct {
-	Caption string
-	HTML    string
+// FragmentStylesheetPath returns the path of the stylesheet written next to a -fragment output.
+func FragmentStylesheetPath(outputPath string) string {
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".css"
 }
 
-func main() {
-	args := os.Args
+// embedQualitySteps are the JPEG qualities tried, in order, to fit a page in -max-embed-bytes.
+var embedQualitySteps = []int{80, 70, 60, 50, 40, 30, 20}
 
-	if len(args) < 3 {
-		fmt.Println("Usage: program source_path output_path [-title \"Page Title\"]")
-		return
-	}
+// Generate renders the gallery page described by settings.
+func Generate(settings Settings) error {
+	return GenerateUntil(settings, nil)
+}
 
-	settings := ProgramSettings{
-		SourcePath:      args[1],
-		OutputPath:      args[2],
-		PageTitle:       "Textures",
-		ThumbnailSize:   128,
-		BackgroundColor: color.RGBA{255, 255, 255, 255},
-	}
+// GenerateUntil is Generate stopping once stop is closed, after the file or texture in progress.
+// It then writes nothing and returns gallery.ErrInterrupted, unless settings.OnInterrupt is
+// "partial" and the scan was stopped: the page of the textures scanned so far is written, marked
+// as incomplete, before returning the error.
+func GenerateUntil(settings Settings, stop <-chan struct{}) error {
+	sourceName := settings.Source.Path
 
-	for i := 3; i < len(args); i += 2 {
-		if i+1 < len(args) && args[i] == "-title" {
-			settings.PageTitle = args[i+1]
+	if IsRemoteSource(settings.Source.Path) {
+		localPath, err := DownloadSource(settings.Source.Path)
+
+		if err != nil {
+			return err
 		}
+
+		defer os.Remove(localPath)
+
+		settings.Source.Path = localPath
 	}
 
-	for i := 3; i < len(args); i += 2 {
-		if i+1 < len(args) && args[i] == "-size" {
-			if size, err := strconv.Atoi(args[i+1]); err == nil {
-				settings.ThumbnailSize = size
-			} else {
-				fmt.Fprintf(os.Stderr, "Invalid value for -size: %s\n", args[i+1])
+	baseArchivePath := ""
+
+	if settings.Source.GitRef != "" {
+		archivePath, err := ArchiveGitRef(settings.Source.Path, settings.Source.GitRef)
+
+		if err != nil {
+			return err
+		}
+
+		defer os.Remove(archivePath)
+
+		if settings.Source.BaseRef != "" {
+			baseArchivePath, err = ArchiveGitRef(settings.Source.Path, settings.Source.BaseRef)
 
-				return
+			if err != nil {
+				return err
 			}
+
+			defer os.Remove(baseArchivePath)
 		}
+
+		sourceName = settings.Source.Path + "@" + settings.Source.GitRef
+		settings.Source.Path = archivePath
 	}
 
-	var fileList []string
-	var zipReader *zip.ReadCloser
-	var err error
+	settings.Source.Path = LongPath(settings.Source.Path)
+	settings.Page.OutputPath = LongPath(settings.Page.OutputPath)
+	settings.Page.AssetsPath = LongPath(settings.Page.AssetsPath)
+	settings.Page.MosaicPath = LongPath(settings.Page.MosaicPath)
+	settings.Page.BadgesPath = LongPath(settings.Page.BadgesPath)
+	settings.Page.FeedPath = LongPath(settings.Page.FeedPath)
+	settings.Page.ManifestPath = LongPath(settings.Page.ManifestPath)
+	settings.Page.StatsJSONPath = LongPath(settings.Page.StatsJSONPath)
 
-	if fileInfo, err := os.Stat(settings.SourcePath); err == nil && fileInfo.IsDir() {
-		fileList, err = FileListing(settings.SourcePath)
+	sourceHash := ""
+
+	if settings.Source.VerifySHA256 != "" {
+		if fileInfo, err := os.Stat(settings.Source.Path); err == nil && fileInfo.IsDir() {
+			return errors.New("-verify requires a CRF/ZIP source")
+		}
+
+		hash, err := FileSHA256(settings.Source.Path)
 
 		if err != nil {
-			fmt.Println(err)
+			return err
+		}
 
-			return
+		if hash != settings.Source.VerifySHA256 {
+			return fmt.Errorf("%s: sha256 mismatch, got %s, expected %s", sourceName, hash, settings.Source.VerifySHA256)
 		}
-	} else {
-		zipReader, err = zip.OpenReader(settings.SourcePath)
+
+		sourceHash = hash
+	}
+
+	options := settings.RenderOptions()
+
+	if settings.Source.ModelsPath != "" {
+		index, err := gallery.LoadModelIndex(settings.Source.ModelsPath)
 
 		if err != nil {
-			fmt.Println(err)
+			return err
+		}
+
+		options.ModelIndex = index
+	}
+
+	if settings.Source.MissionsPath != "" {
+		usage, err := gallery.LoadMissionUsage(settings.Source.MissionsPath)
 
-			return
+		if err != nil {
+			return err
 		}
 
-		defer zipReader.Close()
+		options.MissionUsage = usage
+	}
+
+	if settings.Page.FamilyNamesPath != "" {
+		labels, err := gallery.LoadFamilyLabels(settings.Page.FamilyNamesPath)
 
-		for _, file := range zipReader.File {
-			fileList = append(fileList, file.Name)
+		if err != nil {
+			return err
 		}
+
+		options.FamilyLabels = labels
 	}
 
-	families := make(map[string][]Texture)
+	var ratings map[string]gallery.Rating
+
+	if settings.Page.RatingsPath != "" {
+		loaded, err := gallery.LoadRatings(settings.Page.RatingsPath)
 
-	var imageObj image.Image
+		if err != nil {
+			return err
+		}
+
+		ratings = loaded
+	}
 
-	for _, filePath := range fileList {
-		parts := strings.Split(strings.ToLower(filePath), string(filepath.Separator))
+	scanOptions := settings.ScanOptions()
 
-		family, filename := parts[len(parts)-2], parts[len(parts)-1]
+	if settings.Progress == "json" {
+		progress := JSONProgress(os.Stderr)
+		scanOptions.Progress, options.Progress = progress, progress
+	}
 
-		extension := filepath.Ext(filename)
-		allowedExtensions := map[string]bool{".pcx": true, ".gif": true, ".png": true, ".jpg": true, ".tga": true}
+	// A partial gallery holds every texture scanned, so only the scan stops for it.
+	scanOptions.Stop = stop
 
-		if !allowedExtensions[extension] || filename == "full.pcx" {
-			fmt.Fprintf(os.Stderr, "skipping %s\n", filePath)
+	if settings.OnInterrupt != "partial" {
+		options.Stop = stop
+	}
 
-			continue
+	if settings.Source.Spill {
+		spill, err := gallery.NewSpill("")
+
+		if err != nil {
+			return err
 		}
 
-		if fileInfo, _ := os.Stat(settings.SourcePath); fileInfo.IsDir() {
-			imageFile, err := os.Open(filePath)
+		defer spill.Close()
+
+		scanOptions.Spill = spill
+	}
+
+	inventory, err := gallery.ScanWithOptions(settings.Source.Path, scanOptions)
+
+	if err != nil {
+		return err
+	}
+
+	// Overlays are scanned like the source and shadow its textures, the last one winning.
+	if len(settings.Source.Overlays) > 0 {
+		layers := []*gallery.Inventory{inventory}
+
+		for _, overlayPath := range settings.Source.Overlays {
+			layer, err := gallery.ScanWithOptions(overlayPath, scanOptions)
 
 			if err != nil {
-				fmt.Println(err)
+				return err
+			}
 
-				return
+			layers = append(layers, layer)
+		}
+
+		inventory = gallery.Overlay(layers...)
+	}
+
+	if inventory.Incomplete != "" && settings.OnInterrupt != "partial" {
+		return gallery.ErrInterrupted
+	}
+
+	// Downloads and git archives are temporary files, named after what they were made from.
+	if IsRemoteSource(sourceName) || settings.Source.GitRef != "" {
+		inventory.Source = sourceName
+	}
+
+	if settings.Source.Explain != "" {
+		return Explain(os.Stdout, settings, inventory)
+	}
+
+	if settings.Source.List {
+		return ListTextures(os.Stdout, inventory)
+	}
+
+	if inventory.Metadata["recovered"] != "" {
+		fmt.Fprintf(os.Stderr, "%s is not a valid ZIP file, read it from its local file headers\n", settings.Source.Path)
+	}
+
+	for _, skipped := range inventory.Skipped {
+		fmt.Fprintf(os.Stderr, "skipping %s\n", skipped)
+	}
+
+	for _, family := range inventory.Families {
+		for _, texture := range family.Textures {
+			if texture.Error != "" {
+				fmt.Fprintf(os.Stderr, "cannot decode %s: %s\n", texture.Path, texture.Error)
 			}
+		}
+	}
 
-			defer imageFile.Close()
+	totals := inventory.Totals(time.Time{})
+	tuning := gallery.Tune(settings.Jobs, totals.Textures, totals.Bytes)
+	options.Workers, options.ChunkSize = tuning.Workers, tuning.ChunkSize
+
+	if settings.Tune {
+		fmt.Fprintf(os.Stderr, "tune: %s\n", tuning)
+	}
 
-			if extension == ".pcx" {
-				imageObj, err = pcx.Decode(imageFile)
-			} else if extension == ".tga" {
-				imageObj, err = tga.Decode(imageFile)
-			} else {
-				imageObj, _, err = image.Decode(imageFile)
+	if unknown := inventory.ApplyRatings(ratings); len(unknown) > 0 && settings.Page.OnlyFamily == "" {
+		fmt.Fprintf(os.Stderr, "warning: ratings of unknown textures: %s\n", strings.Join(unknown, ", "))
+	}
+
+	if sourceHash != "" {
+		inventory.Metadata["source-sha256"] = sourceHash
+	}
+
+	previousState, err := LoadState(settings.Page.OutputPath)
+
+	if err != nil {
+		return err
+	}
+
+	// git-diff compares with the base ref instead of the previous run.
+	baseTextures := make(map[string]gallery.Texture)
+
+	if baseArchivePath != "" {
+		baseInventory, err := gallery.ScanWithOptions(baseArchivePath, scanOptions)
+
+		if err != nil {
+			return err
+		}
+
+		previousState = GenerationState{Textures: make(map[string]string)}
+
+		for _, family := range baseInventory.Families {
+			for _, texture := range family.Textures {
+				previousState.Textures[texture.Key()] = texture.SHA256
+				baseTextures[texture.Key()] = texture
 			}
+		}
+	}
 
-			if err != nil {
-				fmt.Println(err)
+	currentState := GenerationState{Generated: time.Now().UTC(), Textures: make(map[string]string)}
 
-				return
+	for _, family := range inventory.Families {
+		for _, texture := range family.Textures {
+			currentState.Textures[texture.Key()] = texture.SHA256
+		}
+	}
+
+	// A single family run keeps the state of the other families, which it did not scan.
+	if settings.Page.OnlyFamily != "" {
+		for key, hash := range previousState.Textures {
+			if !strings.HasPrefix(key, settings.Page.OnlyFamily+"/") {
+				currentState.Textures[key] = hash
+			}
+		}
+	}
+
+	changes := TextureChanges(previousState, currentState)
+	fullInventory := inventory
+	currentState.History = previousState.History
+
+	// A single family run has no totals for the whole pack.
+	if settings.Page.OnlyFamily == "" {
+		currentState.History = append(currentState.History, fullInventory.Totals(currentState.Generated))
+	}
+
+	options.Trend = currentState.History
+
+	if settings.Page.ChangedOnly {
+		options.Status = changes
+
+		inventory = inventory.Filter(func(texture gallery.Texture) bool {
+			return changes[texture.Key()] != ""
+		})
+
+		for _, family := range inventory.Families {
+			for i, texture := range family.Textures {
+				if before, ok := baseTextures[texture.Key()]; ok && changes[texture.Key()] == "changed" {
+					family.Textures[i].Before = &before
+				}
 			}
+		}
+
+		if baseArchivePath != "" {
+			options.Notice = fmt.Sprintf("%d textures added or modified from %s to %s.", inventory.TextureCount(), settings.Source.BaseRef, settings.Source.GitRef)
+		} else if previousState.Generated.IsZero() {
+			options.Notice = fmt.Sprintf("%d textures, no previous run to compare with.", inventory.TextureCount())
 		} else {
-			imageObj, err = GetImageFromZip(zipReader, filePath)
+			options.Notice = fmt.Sprintf("%d textures added or modified since the run of %s.", inventory.TextureCount(), previousState.Generated.Format("2006-01-02 15:04 UTC"))
+		}
 
-			if err != nil {
-				fmt.Println(err)
+		if renames := TextureRenames(previousState, currentState); len(renames) > 0 {
+			var renamed []string
 
-				return
+			for _, key := range sortedKeys(renames) {
+				renamed = append(renamed, fmt.Sprintf("%s → %s", renames[key], key))
 			}
+
+			options.Notice += fmt.Sprintf(" Renamed: %s.", strings.Join(renamed, ", "))
+		}
+
+		if removed := RemovedTextures(previousState, currentState); len(removed) > 0 {
+			options.Notice += fmt.Sprintf(" Removed: %s.", strings.Join(removed, ", "))
 		}
+	}
 
-		newBounds := imageObj.Bounds().Max
+	// A worklist of the textures still needing an HD replacement, or of the largest ones, keeps
+	// those of the longest side asked for.
+	if minDim, maxDim := settings.Page.MinDim, settings.Page.MaxDim; minDim > 0 || maxDim > 0 {
+		inventory = inventory.Filter(func(texture gallery.Texture) bool {
+			side := max(texture.Width, texture.Height)
 
-		if newBounds.X > newBounds.Y {
-			newBounds.Y = int(float64(settings.ThumbnailSize) * float64(newBounds.Y) / float64(newBounds.X))
-			newBounds.X = settings.ThumbnailSize
-		} else {
-			newBounds.X = int(float64(settings.ThumbnailSize) * float64(newBounds.X) / float64(newBounds.Y))
-			newBounds.Y = settings.ThumbnailSize
+			return side > 0 && side >= minDim && (maxDim == 0 || side <= maxDim)
+		})
+
+		notice := fmt.Sprintf("%d textures of %d to %d pixels on their longest side.", inventory.TextureCount(), minDim, maxDim)
+
+		if maxDim == 0 {
+			notice = fmt.Sprintf("%d textures of at least %d pixels on their longest side.", inventory.TextureCount(), minDim)
+		} else if minDim == 0 {
+			notice = fmt.Sprintf("%d textures of at most %d pixels on their longest side.", inventory.TextureCount(), maxDim)
 		}
 
-		imageObj = resize.Resize(uint(newBounds.X), uint(newBounds.Y), imageObj, resize.Bilinear)
+		options.Notice = strings.TrimSpace(options.Notice + " " + notice)
+	}
+
+	compareNames := gallery.NaturalCompare
+
+	if options.CompareNames != nil {
+		compareNames = options.CompareNames
+	}
+
+	if err := inventory.SortFamiliesBy(settings.Page.FamilyOrder, compareNames); err != nil {
+		return err
+	}
+
+	if settings.Page.Check {
+		return CheckGallery(os.Stdout, settings, inventory, currentState)
+	}
+
+	// An empty page is easily mistaken for a rendering problem, so say why it is empty.
+	if settings.Page.OnlyFamily != "" && fullInventory.TextureCount() == 0 {
+		fmt.Fprintf(os.Stderr, "warning: no textures in family %s of %s, its section will be removed\n", settings.Page.OnlyFamily, sourceName)
+	} else if fullInventory.TextureCount() == 0 {
+		fmt.Fprintf(os.Stderr, "warning: no textures found in %s, the page will be empty\n", sourceName)
+	} else if inventory.TextureCount() == 0 && settings.Page.ChangedOnly {
+		fmt.Fprintln(os.Stderr, "warning: no textures added or modified since the previous run, the page will be empty")
+	} else if inventory.TextureCount() == 0 {
+		fmt.Fprintln(os.Stderr, "warning: no textures within -min-dim and -max-dim, the page will be empty")
+	}
 
-		if imageObj.ColorModel() == color.RGBAModel || imageObj.ColorModel() == color.NRGBAModel {
-			backgroundImage := image.NewRGBA(imageObj.Bounds())
-			draw.Draw(backgroundImage, backgroundImage.Bounds(), &image.Uniform{settings.BackgroundColor}, image.Point{}, draw.Over)
-			draw.Draw(backgroundImage, backgroundImage.Bounds(), imageObj, imageObj.Bounds().Min, draw.Over)
-			imageObj = backgroundImage
+	for _, family := range fullInventory.EmptyFamilies {
+		fmt.Fprintf(os.Stderr, "no textures in family %s\n", family)
+	}
+
+	if settings.Page.AssetsPath != "" {
+		// Lossless and quantized thumbnails get no lossy WebP variant.
+		webpAvailable := !settings.Thumbnails.Lossless && settings.Thumbnails.Quantize == 0
+
+		options.Asset = func(family string, name string, data []byte) (string, error) {
+			return WriteAsset(settings.Page.AssetsPath, settings.Page.AssetLayout, settings.Page.OutputPath, family, name, data)
 		}
 
-		buffer := new(bytes.Buffer)
-		err := jpeg.Encode(buffer, imageObj, &jpeg.Options{Quality: 100})
+		options.FamilyArchive = func(family string) (string, error) {
+			archive := new(bytes.Buffer)
 
-		if err != nil {
-			fmt.Println(err)
+			if err := gallery.WriteFamilyArchive(archive, fullInventory, family); err != nil {
+				return "", err
+			}
 
-			return
+			return WriteAsset(settings.Page.AssetsPath, settings.Page.AssetLayout, settings.Page.OutputPath, family, family+".zip", archive.Bytes())
 		}
 
-		contentType := "image/jpg"
-		encodedImage := base64.StdEncoding.EncodeToString(buffer.Bytes())
-		uri := fmt.Sprintf("data:%s;base64,%s", contentType, encodedImage)
+		// Tiles are rendered on several goroutines, which share the finding that cwebp is missing.
+		var webpMutex sync.Mutex
+
+		options.WebP = func(img image.Image, quality int) ([]byte, error) {
+			webpMutex.Lock()
+			available := webpAvailable
+			webpMutex.Unlock()
+
+			if !available {
+				return nil, nil
+			}
+
+			if settings.Thumbnails.WebPQuality != 0 {
+				quality = settings.Thumbnails.WebPQuality
+			}
+
+			data, err := EncodeWebP(img, quality)
+
+			if errors.Is(err, ErrWebPUnavailable) {
+				webpMutex.Lock()
+				defer webpMutex.Unlock()
+
+				if webpAvailable {
+					fmt.Fprintln(os.Stderr, "cwebp not found, writing thumbnails without WebP variants")
+					webpAvailable = false
+				}
+
+				return nil, nil
+			}
 
-		filenameWithoutExtension := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
-		imageDimensions := fmt.Sprintf("%dx%d", imageObj.Bounds().Dx(), imageObj.Bounds().Dy())
-		imageFormat := strings.TrimPrefix(filepath.Ext(filePath), ".")
+			return data, err
+		}
+	}
 
-		filenameSpan := fmt.Sprintf("<span class='filename'>%s</span>", strings.ToLower(filenameWithoutExtension))
-		infoSpan := fmt.Sprintf("<span class='info'>%s (%s)</span>", strings.ToLower(imageDimensions), strings.ToLower(imageFormat))
-		caption := fmt.Sprintf("%s %s", filenameSpan, infoSpan)
+	var allThumbnails []image.Image
 
-		texture := Texture{
-			Caption: caption,
-			HTML:    fmt.Sprintf("<div class='texture'><div class='image'><img src='%s'></div><div class='caption'>%s</div></div>", uri, caption),
+	options.FamilyThumbnails = func(family string, thumbnails []image.Image) error {
+		allThumbnails = append(allThumbnails, thumbnails...)
+
+		if settings.Page.MosaicPath == "" {
+			return nil
 		}
 
-		families[family] = append(families[family], texture)
+		mosaic := gallery.BuildMosaic(thumbnails, settings.Thumbnails.Size, 8, color.RGBA{51, 51, 51, 255})
+
+		return gallery.WriteMosaic(settings.Page.MosaicPath, family, mosaic)
 	}
 
-	var familyKeys []string
-	for family := range families {
-		familyKeys = append(familyKeys, family)
+	// Galleries split by -per-page render their first page like a whole one, the others once the
+	// budget below has settled the thumbnail quality.
+	pages := []*gallery.Inventory{inventory}
+	pagePath := settings.Page.OutputPath
+
+	if settings.Page.PerPage > 0 && settings.Page.OnlyFamily != "" {
+		// -only-family updates the page holding the family, its section keeping the anchor the
+		// family index links to, and leaves the others alone.
+		if pagePath, err = familyPagePath(settings.Page.OutputPath, settings.Page.OnlyFamily, compareNames); err != nil {
+			return err
+		}
+
+		options.FamilyCards = gallery.FamilyCards(pages)
+	} else if settings.Page.PerPage > 0 && settings.Page.Format == "html" && !settings.Page.Fragment {
+		pages = inventory.Paginate(settings.Page.PerPage)
+
+		for i := range pages {
+			options.Pages = append(options.Pages, filepath.Base(PagePath(settings.Page.OutputPath, i+1)))
+		}
+
+		options.FamilyCards = gallery.FamilyCards(pages)
 	}
 
-	sort.Strings(familyKeys)
+	page, err := renderPage(settings, pagePath, pages[0], options)
 
-	var sections []string
+	if err != nil {
+		return err
+	}
 
-	for _, family := range familyKeys {
-		textures := families[family]
+	// Browsers give up on huge inlined pages, so the JPEG quality is stepped down until it fits.
+	if budget := settings.Page.MaxEmbedBytes; budget > 0 && settings.Page.AssetsPath == "" && settings.Page.Format == "html" {
+		initialSize := page.Len()
 
-		sort.Slice(textures, func(i, j int) bool {
-			return textures[i].Caption < textures[j].Caption
-		})
+		for _, quality := range embedQualitySteps {
+			if page.Len() <= budget {
+				break
+			}
+
+			if options.JPEGQuality != 0 && quality >= options.JPEGQuality {
+				continue
+			}
 
-		var texturesHTML []string
-
-		for _, texture := range textures {
-			texturesHTML = append(texturesHTML, texture.HTML)
-		}
-
-		sections = append(sections, fmt.Sprintf("<section><h2>%s</h2><div class='family'>%s</div></section>", html.EscapeString(family), strings.Join(texturesHTML, "")))
-	}
-
-	page := fmt.Sprintf(
-		`<!DOCTYPE html>
-		<html>
-		<head>
-		<title>%s</title>
-		<style>
-		body,h1,h2{color:#fff;font-family:Arial,sans-serif;line-height:1}
-		body{background:#333}
-		h1{font-size:18px;text-transform:uppercase}
-		h2{border-bottom:1px solid #899;font-size:16px;padding:0 0 8px;text-transform:capitalize}
-		section{padding:24px 0}
-		.family{display:flex;flex-wrap:wrap;gap:16px}
-		.texture,.image{width:%dpx}
-		.texture{flex:0 0 auto}
-		.image{height:%dpx}
-		img{width:100%%;height:100%%;object-fit:contain}
-		.caption{color:#899;font-size:12px;text-align:center;padding:16px 0;display:flex;flex-direction:column;gap:8px}
-		.filename{font-size:14px;font-weight:bold}
-		</style>		
-		</head>
-		<body>
-		<h1>%s</h1>
-		%s
-		</body>
-		</html>`,
-		html.EscapeString(settings.PageTitle),
-		settings.ThumbnailSize,
-		settings.ThumbnailSize,
-		html.EscapeString(settings.PageTitle),
-		strings.Join(sections, ""),
-	)
-
-	err = os.WriteFile(settings.OutputPath, []byte(page), 0644)
+			options.JPEGQuality = quality
+			allThumbnails = nil
+
+			if page, err = renderPage(settings, pagePath, pages[0], options); err != nil {
+				return err
+			}
+		}
+
+		if page.Len() > budget {
+			fmt.Fprintf(os.Stderr, "warning: the page is %d bytes even at JPEG quality %d, over the -max-embed-bytes budget of %d\n", page.Len(), options.JPEGQuality, budget)
+		} else if page.Len() != initialSize {
+			fmt.Fprintf(os.Stderr, "the page was %d bytes, thumbnails encoded at JPEG quality %d to fit in %d (%d bytes)\n", initialSize, options.JPEGQuality, budget, page.Len())
+		}
+	}
+
+	if settings.Page.Format == "sqlite" {
+		err = WriteDatabase(settings.Page.OutputPath, inventory, settings.Page.Title, currentState.History[len(currentState.History)-1])
+	} else {
+		err = os.WriteFile(pagePath, page.Bytes(), 0644)
+	}
 
 	if err != nil {
-		fmt.Println(err)
+		return err
 	}
+
+	if len(options.Pages) > 0 {
+		if err := writeOtherPages(settings, pages, options); err != nil {
+			return err
+		}
+	}
+
+	if settings.Page.ManifestPath != "" && settings.Page.OnlyFamily == "" {
+		manifest := new(bytes.Buffer)
+
+		if err := inventory.WriteJSON(manifest); err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(settings.Page.ManifestPath, manifest.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+
+	if options.Progress != nil {
+		options.Progress(gallery.ProgressEvent{Stage: "write", Event: "completed", Path: pagePath, Done: 1, Total: 1, Percent: 100})
+	}
+
+	// The totals and hashes of a partial scan would pass for removed textures in the next run.
+	if inventory.Incomplete != "" {
+		fmt.Fprintf(os.Stderr, "partial gallery written to %s (%s), state, badges and feed left unchanged\n", settings.Page.OutputPath, inventory.Incomplete)
+
+		return gallery.ErrInterrupted
+	}
+
+	// A comparison of two refs is no run of the gallery, whose state is left alone.
+	if baseArchivePath == "" {
+		if err := SaveState(settings.Page.OutputPath, currentState); err != nil {
+			return err
+		}
+	}
+
+	if settings.Page.BadgesPath != "" && settings.Page.OnlyFamily == "" {
+		if err := gallery.WriteBadges(settings.Page.BadgesPath, currentState.History[len(currentState.History)-1]); err != nil {
+			return err
+		}
+	}
+
+	if settings.Page.StatsJSONPath != "" && settings.Page.OnlyFamily == "" {
+		stats, err := json.Marshal(fullInventory.DashboardStats(currentState.Generated))
+
+		if err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(settings.Page.StatsJSONPath, append(stats, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+
+	if settings.Page.FeedPath != "" {
+		if err := UpdateFeed(settings, fullInventory, changes, RemovedTextures(previousState, currentState), currentState.Generated); err != nil {
+			return err
+		}
+	}
+
+	if settings.Delivery.Publish != "" || settings.Delivery.PublishCommand != "" {
+		if err := Publish(settings); err != nil {
+			return err
+		}
+	}
+
+	if settings.Delivery.NotifyWebhook != "" {
+		summary := GenerationSummary{
+			Title:      settings.Page.Title,
+			Families:   len(inventory.Families),
+			Textures:   inventory.TextureCount(),
+			OutputSize: int64(page.Len()),
+			Link:       settings.Delivery.NotifyLink,
+			Thumbnails: allThumbnails,
+			CellSize:   settings.Thumbnails.Size,
+		}
+
+		// The page is already written, so a failed notification is not worth failing the run.
+		if err := NotifyWebhook(settings.Delivery.NotifyWebhook, summary); err != nil {
+			fmt.Fprintf(os.Stderr, "notify-webhook: %v\n", err)
+		}
+	}
+
+	return nil
+}
+
+// writeOtherPages writes the pages of a gallery split by -per-page after the first one, which
+// Generate writes, and removes the pages left over from a previous run with more of them.
+func writeOtherPages(settings Settings, pages []*gallery.Inventory, options gallery.RenderOptions) error {
+	// The notice and the trend are about the whole gallery, shown on its first page.
+	options.Notice, options.Trend = "", nil
+
+	for i := 1; i < len(pages); i++ {
+		options.PageIndex = i
+		page := new(bytes.Buffer)
+
+		if err := gallery.RenderTo(page, pages[i], options); err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(PagePath(settings.Page.OutputPath, i+1), page.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+
+	for number := len(pages) + 1; ; number++ {
+		err := os.Remove(PagePath(settings.Page.OutputPath, number))
+
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// renderPage renders the page, fragment or inventory of settings, or with -only-family the page
+// already written at pagePath with the section of that family replaced.
+func renderPage(settings Settings, pagePath string, inventory *gallery.Inventory, options gallery.RenderOptions) (*bytes.Buffer, error) {
+	page := new(bytes.Buffer)
+	var err error
+
+	if settings.Page.OnlyFamily != "" {
+		var updated []byte
+
+		updated, err = os.ReadFile(pagePath)
+
+		if err == nil {
+			updated, err = gallery.ReplaceSection(updated, inventory, settings.Page.OnlyFamily, options)
+		}
+
+		if err == nil {
+			page.Write(updated)
+		}
+	} else if settings.Page.Format == "sqlite" {
+		// The database grows with every run instead of being rewritten, so Generate writes it.
+	} else if settings.Page.Format == "json" {
+		err = inventory.WriteJSON(page)
+	} else if settings.Page.Fragment {
+		var fragment, stylesheet []byte
+
+		fragment, stylesheet, err = gallery.RenderFragment(inventory, options)
+
+		if err == nil {
+			page.Write(fragment)
+			err = os.WriteFile(FragmentStylesheetPath(settings.Page.OutputPath), stylesheet, 0644)
+		}
+	} else {
+		err = gallery.RenderTo(page, inventory, options)
+	}
+
+	return page, err
 }
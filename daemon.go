@@ -0,0 +1,468 @@
+package main
+
+/**
+ * Daemon mode
+ *
+ * `crf2html daemon` keeps running and generates galleries on request through a small REST API:
+ *
+ *  POST   /jobs              Queue a job. Either a multipart form with an "archive" file and repeated
+ *                            "option" fields, or a JSON body {"source": "path", "options": ["-size", "64"]}
+ *                            whose source is a path under -source-root, or a URL with -allow-remote.
+ *  GET    /jobs/{id}         Job status as JSON.
+ *  GET    /jobs/{id}/result  The generated HTML page, once the job is done.
+ *  DELETE /jobs/{id}         Forget a finished job and remove its directory.
+ *
+ * Jobs run one at a time, in the order they were queued.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"crf2html/gallery"
+)
+
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+
+	maxUploadSize  = 1 << 30
+	maxRequestSize = 1 << 20
+)
+
+// daemonOptions lists the options the API accepts, and whether each takes a value: those shaping
+// the page written in the job directory. Any other option is refused, as it may read or write files
+// out of the job directory, run a command, send a request, lift the -decode-timeout protection of
+// the server, or write something else than the single page served as the result, and so is any
+// option added later until it is listed here.
+var daemonOptions = map[string]bool{
+	"-progressive": false, "-auto-format": false, "-lossless": false, "-stats": false, "-seams": false,
+	"-relief": false, "-group-variants": false, "-no-captions": false, "-no-js": false,
+	"-engine-view": false, "-print": false, "-fragment": false,
+	"-title": true, "-caption": true, "-sort-families": true, "-collation": true,
+	"-columns": true, "-size": true, "-quality": true, "-subsampling": true, "-quantize": true,
+	"-max-embed-bytes": true, "-min-dim": true, "-max-dim": true,
+	"-upscale": true, "-theme": true, "-variant-suffixes": true,
+}
+
+// checkDaemonOptions returns an error for the first option of options the API refuses. Values
+// are skipped, so that a title such as "-exec" is not taken for an option.
+func checkDaemonOptions(options []string) error {
+	for i := 0; i < len(options); i++ {
+		takesValue, ok := daemonOptions[options[i]]
+
+		if !ok {
+			return fmt.Errorf("option %s is not available in daemon mode", options[i])
+		}
+
+		if takesValue {
+			i++
+		}
+	}
+
+	return nil
+}
+
+// Job is a gallery generation requested through the daemon API.
+type Job struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	settings   Settings
+	directory  string
+	outputPath string
+}
+
+// JobQueue holds the jobs known to the daemon and runs them one after the other. Jobs given by
+// JSON read sources by path under SourceRoot only, if set, and by URL only if AllowRemote is set.
+type JobQueue struct {
+	SourceRoot  string
+	AllowRemote bool
+
+	mutex   sync.Mutex
+	jobs    map[string]*Job
+	pending chan *Job
+	workDir string
+}
+
+func NewJobQueue(workDir string) *JobQueue {
+	queue := &JobQueue{
+		jobs:    make(map[string]*Job),
+		pending: make(chan *Job, 64),
+		workDir: workDir,
+	}
+
+	go queue.run()
+
+	return queue
+}
+
+func (queue *JobQueue) run() {
+	for job := range queue.pending {
+		queue.setStatus(job, jobRunning, nil)
+		queue.setStatus(job, jobDone, Generate(job.settings))
+	}
+}
+
+func (queue *JobQueue) setStatus(job *Job, status string, err error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	job.Status = status
+
+	if err != nil {
+		job.Status = jobFailed
+		job.Error = err.Error()
+	}
+
+	if job.Status == jobDone || job.Status == jobFailed {
+		finished := time.Now()
+		job.Finished = &finished
+	}
+}
+
+// newJob creates the working directory of a new job, whose name is the job ID.
+func (queue *JobQueue) newJob() (*Job, error) {
+	directory, err := os.MkdirTemp(queue.workDir, "job-")
+
+	if err != nil {
+		return nil, err
+	}
+
+	id := filepath.Base(directory)
+
+	return &Job{ID: id, Status: jobQueued, Created: time.Now(), directory: directory, outputPath: filepath.Join(directory, "index.html")}, nil
+}
+
+// checkSource returns the path or URL the source of a JSON job stands for, or an error meant for
+// the client: paths are taken relative to SourceRoot and may not leave it, symbolic links
+// included, and URLs, which could make the server request its internal network, are refused
+// unless AllowRemote is set.
+func (queue *JobQueue) checkSource(source string) (string, error) {
+	if IsRemoteSource(source) {
+		if !queue.AllowRemote {
+			return "", errors.New("remote sources are not allowed by this server")
+		}
+
+		return source, nil
+	}
+
+	if queue.SourceRoot == "" {
+		return "", errors.New("sources by path are not allowed by this server, upload an archive")
+	}
+
+	root, err := filepath.EvalSymlinks(queue.SourceRoot)
+
+	if err != nil {
+		return "", err
+	}
+
+	sourcePath, err := filepath.EvalSymlinks(filepath.Join(root, source))
+
+	if err != nil {
+		return "", fmt.Errorf("unknown source: %s", source)
+	}
+
+	if relative, err := filepath.Rel(root, sourcePath); err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("source %s is outside the source root", source)
+	}
+
+	return sourcePath, nil
+}
+
+// enqueue parses the job options, checked by checkDaemonOptions, and queues it, or returns an error
+// meant for the client.
+func (queue *JobQueue) enqueue(job *Job, source string, options []string) error {
+	settings, err := ParseArguments(append([]string{source, job.outputPath}, options...))
+
+	if err != nil {
+		return err
+	}
+
+	job.settings = settings
+
+	queue.mutex.Lock()
+	queue.jobs[job.ID] = job
+	queue.mutex.Unlock()
+
+	select {
+	case queue.pending <- job:
+		return nil
+	default:
+		queue.mutex.Lock()
+		delete(queue.jobs, job.ID)
+		queue.mutex.Unlock()
+
+		return errors.New("job queue is full")
+	}
+}
+
+func (queue *JobQueue) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	path := strings.Trim(request.URL.Path, "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "jobs" && request.Method == http.MethodPost:
+		queue.handleCreate(writer, request)
+	case len(parts) == 2 && parts[0] == "jobs" && request.Method == http.MethodGet:
+		queue.handleStatus(writer, parts[1])
+	case len(parts) == 2 && parts[0] == "jobs" && request.Method == http.MethodDelete:
+		queue.handleDelete(writer, parts[1])
+	case len(parts) == 3 && parts[0] == "jobs" && parts[2] == "result" && request.Method == http.MethodGet:
+		queue.handleResult(writer, request, parts[1])
+	default:
+		http.NotFound(writer, request)
+	}
+}
+
+func (queue *JobQueue) handleCreate(writer http.ResponseWriter, request *http.Request) {
+	job, err := queue.newJob()
+
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	var source string
+	var options []string
+
+	uploaded := strings.HasPrefix(request.Header.Get("Content-Type"), "multipart/form-data")
+
+	if uploaded {
+		request.Body = http.MaxBytesReader(writer, request.Body, maxUploadSize)
+		source, options, err = saveUpload(request, job.directory)
+	} else {
+		var body struct {
+			Source  string   `json:"source"`
+			Options []string `json:"options"`
+		}
+
+		request.Body = http.MaxBytesReader(writer, request.Body, maxRequestSize)
+		err = json.NewDecoder(request.Body).Decode(&body)
+		source, options = body.Source, body.Options
+
+		if err == nil && source == "" {
+			err = errors.New("missing source")
+		}
+	}
+
+	if err == nil {
+		err = checkDaemonOptions(options)
+	}
+
+	// Uploads are in the job directory, sources given by JSON are checked against the server flags.
+	if err == nil && !uploaded {
+		source, err = queue.checkSource(source)
+	}
+
+	if err == nil {
+		err = queue.enqueue(job, source, options)
+	}
+
+	if err != nil {
+		os.RemoveAll(job.directory)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	writer.Header().Set("Location", "/jobs/"+job.ID)
+	queue.writeJob(writer, http.StatusAccepted, job)
+}
+
+// saveUpload stores the uploaded "archive" file in the job directory and returns its path.
+func saveUpload(request *http.Request, directory string) (string, []string, error) {
+	file, header, err := request.FormFile("archive")
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	defer file.Close()
+
+	name := filepath.Base(header.Filename)
+
+	if name == "." || name == string(filepath.Separator) {
+		name = "upload.crf"
+	}
+
+	uploadPath := filepath.Join(directory, name)
+	upload, err := os.Create(uploadPath)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	defer upload.Close()
+
+	if _, err := io.Copy(upload, file); err != nil {
+		return "", nil, err
+	}
+
+	return uploadPath, request.MultipartForm.Value["option"], nil
+}
+
+func (queue *JobQueue) job(id string) *Job {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return queue.jobs[id]
+}
+
+func (queue *JobQueue) handleStatus(writer http.ResponseWriter, id string) {
+	job := queue.job(id)
+
+	if job == nil {
+		http.Error(writer, "unknown job", http.StatusNotFound)
+
+		return
+	}
+
+	queue.writeJob(writer, http.StatusOK, job)
+}
+
+// handleDelete forgets a finished job and removes its directory. Queued and running jobs are
+// left alone, as the queue still has them.
+func (queue *JobQueue) handleDelete(writer http.ResponseWriter, id string) {
+	queue.mutex.Lock()
+	job := queue.jobs[id]
+
+	if job != nil && (job.Status == jobDone || job.Status == jobFailed) {
+		delete(queue.jobs, id)
+	}
+
+	queue.mutex.Unlock()
+
+	if job == nil {
+		http.Error(writer, "unknown job", http.StatusNotFound)
+
+		return
+	}
+
+	if job.Status != jobDone && job.Status != jobFailed {
+		http.Error(writer, "job is "+job.Status, http.StatusConflict)
+
+		return
+	}
+
+	if err := os.RemoveAll(job.directory); err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (queue *JobQueue) handleResult(writer http.ResponseWriter, request *http.Request, id string) {
+	job := queue.job(id)
+
+	if job == nil {
+		http.Error(writer, "unknown job", http.StatusNotFound)
+
+		return
+	}
+
+	queue.mutex.Lock()
+	status := job.Status
+	queue.mutex.Unlock()
+
+	if status != jobDone {
+		http.Error(writer, "job is "+status, http.StatusConflict)
+
+		return
+	}
+
+	page, err := os.ReadFile(job.outputPath)
+
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	// Pages opened from the API rather than saved keep their policy.
+	if policy := gallery.PagePolicy(page); policy != "" {
+		writer.Header().Set("Content-Security-Policy", policy)
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", job.ID+".html"))
+	http.ServeContent(writer, request, job.ID+".html", time.Time{}, bytes.NewReader(page))
+}
+
+func (queue *JobQueue) writeJob(writer http.ResponseWriter, status int, job *Job) {
+	queue.mutex.Lock()
+	data, err := json.Marshal(job)
+	queue.mutex.Unlock()
+
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	writer.Write(append(data, '\n'))
+}
+
+// RunDaemon parses the daemon options and serves the job API until the process is stopped.
+func RunDaemon(args []string) error {
+	listen := "127.0.0.1:8080"
+	workDir := filepath.Join(os.TempDir(), "crf2html-jobs")
+	sourceRoot, allowRemote := "", false
+
+	for i := 0; i < len(args); i++ {
+		option := args[i]
+
+		if option == "-allow-remote" {
+			allowRemote = true
+
+			continue
+		}
+
+		if i+1 >= len(args) {
+			return fmt.Errorf("Missing value for %s", option)
+		}
+
+		i++
+
+		switch option {
+		case "-listen":
+			listen = args[i]
+		case "-work-dir":
+			workDir = args[i]
+		case "-source-root":
+			sourceRoot = args[i]
+		default:
+			return fmt.Errorf("Unknown option: %s", option)
+		}
+	}
+
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "listening on %s, jobs in %s\n", listen, workDir)
+
+	queue := NewJobQueue(workDir)
+	queue.SourceRoot, queue.AllowRemote = sourceRoot, allowRemote
+
+	return http.ListenAndServe(listen, queue)
+}
@@ -0,0 +1,123 @@
+package main
+
+/**
+ * SQLite inventory database
+ *
+ * With -format sqlite, the output is a SQLite database instead of a page. Every run adds to it
+ * rather than replacing it: a row in runs, and the families and textures of the inventory tagged
+ * with that run, so a team can follow a texture pack over time (what changed between two runs,
+ * by hash) and join it with the data of their other tools. The latest_textures view holds the
+ * textures of the last run.
+ */
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"crf2html/gallery"
+)
+
+const databaseSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY,
+	generated TEXT NOT NULL,
+	source TEXT NOT NULL,
+	title TEXT NOT NULL,
+	families INTEGER NOT NULL,
+	textures INTEGER NOT NULL,
+	bytes INTEGER NOT NULL,
+	coverage REAL NOT NULL
+);
+CREATE TABLE IF NOT EXISTS families (
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	name TEXT NOT NULL,
+	textures INTEGER NOT NULL,
+	PRIMARY KEY (run_id, name)
+);
+CREATE TABLE IF NOT EXISTS textures (
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	family TEXT NOT NULL,
+	name TEXT NOT NULL,
+	file TEXT NOT NULL,
+	path TEXT NOT NULL,
+	format TEXT NOT NULL,
+	extension TEXT NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	size INTEGER NOT NULL,
+	sha256 TEXT NOT NULL,
+	map_type TEXT NOT NULL,
+	error TEXT NOT NULL,
+	source TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS textures_run ON textures (run_id, family);
+CREATE INDEX IF NOT EXISTS textures_sha256 ON textures (sha256);
+CREATE VIEW IF NOT EXISTS latest_textures AS
+	SELECT * FROM textures WHERE run_id = (SELECT MAX(id) FROM runs);
+`
+
+// WriteDatabase records inventory, summed up by totals, as a new run of the database at
+// databasePath, creating it if needed.
+func WriteDatabase(databasePath string, inventory *gallery.Inventory, title string, totals gallery.RunTotals) error {
+	database, err := sql.Open("sqlite3", databasePath)
+
+	if err != nil {
+		return err
+	}
+
+	defer database.Close()
+
+	if _, err := database.Exec(databaseSchema); err != nil {
+		return err
+	}
+
+	transaction, err := database.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer transaction.Rollback()
+
+	result, err := transaction.Exec(
+		"INSERT INTO runs (generated, source, title, families, textures, bytes, coverage) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		totals.Generated.UTC().Format(time.RFC3339), inventory.Source, title, len(inventory.Families)+len(inventory.EmptyFamilies), totals.Textures, totals.Bytes, totals.Coverage,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	runID, err := result.LastInsertId()
+
+	if err != nil {
+		return err
+	}
+
+	for _, name := range inventory.EmptyFamilies {
+		if _, err := transaction.Exec("INSERT INTO families (run_id, name, textures) VALUES (?, ?, 0)", runID, name); err != nil {
+			return err
+		}
+	}
+
+	for _, family := range inventory.Families {
+		if _, err := transaction.Exec("INSERT INTO families (run_id, name, textures) VALUES (?, ?, ?)", runID, family.Name, len(family.Textures)); err != nil {
+			return err
+		}
+
+		for _, texture := range family.Textures {
+			_, err := transaction.Exec(
+				"INSERT INTO textures (run_id, family, name, file, path, format, extension, width, height, size, sha256, map_type, error, source) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				runID, texture.Family, texture.Name, texture.File, texture.Path, texture.Format, texture.Extension, texture.Width, texture.Height, texture.Size, texture.SHA256, texture.MapType, texture.Error, texture.Source,
+			)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return transaction.Commit()
+}
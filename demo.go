@@ -0,0 +1,167 @@
+package main
+
+/**
+ * Demo gallery
+ *
+ * `crf2html demo out.html [options]` renders a gallery of procedurally generated textures, in
+ * every family a feature of the page is easy to check on: gradients and checkerboards for
+ * scaling and color, alpha tests for the cutout and blended badges, and a normal map. It needs
+ * no CRF, so users can verify their install and preview themes, captions and other options
+ * right away.
+ */
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// demoTexture is a texture of the demo, drawn by the function of its size.
+type demoTexture struct {
+	path string
+	size int
+	draw func(x int, y int, size int) color.NRGBA
+}
+
+var demoTextures = []demoTexture{
+	{"gradients/linear.png", 256, func(x int, y int, size int) color.NRGBA {
+		return color.NRGBA{uint8(x * 255 / size), uint8(y * 255 / size), 128, 255}
+	}},
+	{"gradients/radial.jpg", 512, func(x int, y int, size int) color.NRGBA {
+		distance := math.Hypot(float64(x-size/2), float64(y-size/2)) / float64(size/2)
+		value := uint8(255 * math.Max(0, 1-distance))
+
+		return color.NRGBA{value, value / 2, 255 - value, 255}
+	}},
+	{"gradients/ramp.png", 256, func(x int, y int, size int) color.NRGBA {
+		value := uint8(x * 16 / size * 17)
+
+		return color.NRGBA{value, value, value, 255}
+	}},
+	{"checkers/fine.gif", 128, func(x int, y int, size int) color.NRGBA {
+		return demoChecker(x, y, 8, color.NRGBA{255, 255, 255, 255}, color.NRGBA{0, 0, 0, 255})
+	}},
+	{"checkers/coarse.png", 256, func(x int, y int, size int) color.NRGBA {
+		return demoChecker(x, y, 32, color.NRGBA{200, 60, 60, 255}, color.NRGBA{60, 60, 200, 255})
+	}},
+	{"checkers/uv.png", 512, func(x int, y int, size int) color.NRGBA {
+		return demoChecker(x, y, 64, color.NRGBA{uint8(x * 255 / size), uint8(y * 255 / size), 255, 255}, color.NRGBA{40, 40, 40, 255})
+	}},
+	{"alpha/opaque.png", 128, func(x int, y int, size int) color.NRGBA {
+		return color.NRGBA{90, 140, 90, 255}
+	}},
+	{"alpha/grate.png", 128, func(x int, y int, size int) color.NRGBA {
+		if x%32 < 8 || y%32 < 8 {
+			return color.NRGBA{110, 110, 120, 255}
+		}
+
+		return color.NRGBA{}
+	}},
+	{"alpha/glass.png", 128, func(x int, y int, size int) color.NRGBA {
+		return color.NRGBA{150, 200, 230, uint8(64 + y*128/size)}
+	}},
+	{"alpha/bumps_n.png", 128, func(x int, y int, size int) color.NRGBA {
+		dx, dy := math.Sin(float64(x)/8), math.Sin(float64(y)/8)
+
+		return color.NRGBA{uint8(128 + 60*dx), uint8(128 + 60*dy), 240, 255}
+	}},
+}
+
+var demoDescriptions = map[string]string{
+	"gradients": "Smooth color and gray ramps, to check scaling and JPEG quality.",
+	"checkers":  "Checkerboards, to check sharpness and aliasing. uv is 512 pixels wide and counts as HD.",
+	"alpha":     "An opaque texture, an alpha-tested grate, a blended glass pane and a normal map.",
+}
+
+func demoChecker(x int, y int, cell int, first color.NRGBA, second color.NRGBA) color.NRGBA {
+	if (x/cell+y/cell)%2 == 0 {
+		return first
+	}
+
+	return second
+}
+
+// WriteDemoTextures writes the demo textures, and the descriptions of their families, to
+// directoryPath.
+func WriteDemoTextures(directoryPath string) error {
+	for family, description := range demoDescriptions {
+		if err := os.MkdirAll(filepath.Join(directoryPath, family), 0755); err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(filepath.Join(directoryPath, family, "description.txt"), []byte(description), 0644); err != nil {
+			return err
+		}
+	}
+
+	for _, texture := range demoTextures {
+		img := image.NewNRGBA(image.Rect(0, 0, texture.size, texture.size))
+
+		for y := 0; y < texture.size; y++ {
+			for x := 0; x < texture.size; x++ {
+				img.SetNRGBA(x, y, texture.draw(x, y, texture.size))
+			}
+		}
+
+		buffer := new(bytes.Buffer)
+		var err error
+
+		switch filepath.Ext(texture.path) {
+		case ".jpg":
+			err = jpeg.Encode(buffer, img, &jpeg.Options{Quality: 90})
+		case ".gif":
+			err = gif.Encode(buffer, img, nil)
+		default:
+			err = png.Encode(buffer, img)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(filepath.Join(directoryPath, filepath.FromSlash(texture.path)), buffer.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RunDemo renders the demo gallery to args[0], the other arguments being options parsed like the
+// ones following output_path.
+func RunDemo(args []string) error {
+	if len(args) < 1 {
+		return errors.New("Usage: program demo output_path [options]")
+	}
+
+	sourcePath, err := os.MkdirTemp("", "crf2html-demo-")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(sourcePath)
+
+	if err := WriteDemoTextures(sourcePath); err != nil {
+		return err
+	}
+
+	settings, err := ParseArguments(append([]string{sourcePath, args[0]}, args[1:]...))
+
+	if err != nil {
+		return err
+	}
+
+	if settings.Page.Title == "Textures" {
+		settings.Page.Title = "crf2html demo"
+	}
+
+	return Generate(settings)
+}
@@ -0,0 +1,107 @@
+package main
+
+/**
+ * Explain
+ *
+ * With -explain, the run stops after the scan and prints the decision of the inclusion rules on
+ * one file of the source, instead of writing the page: which rule kept or skipped it, and why,
+ * plus whether -changed-only hides it. Files are named by their path as listed in the source or
+ * as "family/file".
+ */
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"crf2html/gallery"
+)
+
+// Explain writes to writer the decision taken on the file of settings.Source.Explain.
+func Explain(writer io.Writer, settings Settings, inventory *gallery.Inventory) error {
+	decision, ok := findDecision(inventory.Decisions, settings.Source.Explain)
+
+	if !ok {
+		return fmt.Errorf("%s is not a file of %s", settings.Source.Explain, settings.Source.Path)
+	}
+
+	fmt.Fprintln(writer, decision)
+
+	if overriding, ok := findOverride(inventory, decision.Path); ok {
+		fmt.Fprintf(writer, "overridden by %s from %s\n", overriding.Path, overriding.Source)
+	}
+
+	if decision.Verdict != gallery.VerdictTexture || !settings.Page.ChangedOnly {
+		return nil
+	}
+
+	previousState, err := LoadState(settings.Page.OutputPath)
+
+	if err != nil {
+		return err
+	}
+
+	key := explainKey(decision.Path)
+
+	if hash, known := previousState.Textures[key]; known && hash == textureHash(inventory, key) {
+		fmt.Fprintf(writer, "hidden by -changed-only: unchanged since the run of %s\n", previousState.Generated.Format("2006-01-02 15:04 UTC"))
+	}
+
+	return nil
+}
+
+// findDecision looks name up among decisions, by path or by its last two elements.
+func findDecision(decisions []gallery.Decision, name string) (gallery.Decision, bool) {
+	for _, decision := range decisions {
+		if decision.Path == name || filepath.Clean(decision.Path) == filepath.Clean(name) {
+			return decision, true
+		}
+	}
+
+	for _, decision := range decisions {
+		if explainKey(decision.Path) == strings.ToLower(filepath.ToSlash(name)) {
+			return decision, true
+		}
+	}
+
+	return gallery.Decision{}, false
+}
+
+// explainKey returns the "family/file" key of a file path, as used by Texture.Key.
+func explainKey(filePath string) string {
+	parts := strings.Split(strings.ToLower(filepath.ToSlash(filePath)), "/")
+
+	if len(parts) < 2 {
+		return parts[0]
+	}
+
+	return parts[len(parts)-2] + "/" + parts[len(parts)-1]
+}
+
+func textureHash(inventory *gallery.Inventory, key string) string {
+	for _, family := range inventory.Families {
+		for _, texture := range family.Textures {
+			if texture.Key() == key {
+				return texture.SHA256
+			}
+		}
+	}
+
+	return ""
+}
+
+// findOverride returns the overlay texture shadowing the texture at filePath, if any.
+func findOverride(inventory *gallery.Inventory, filePath string) (gallery.Texture, bool) {
+	for _, family := range inventory.Families {
+		for _, texture := range family.Textures {
+			for _, shadow := range texture.Shadows {
+				if shadow == filePath {
+					return texture, true
+				}
+			}
+		}
+	}
+
+	return gallery.Texture{}, false
+}
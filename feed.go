@@ -0,0 +1,208 @@
+package main
+
+/**
+ * Change feed
+ *
+ * With -feed, every run that adds or modifies textures prepends an entry to an Atom feed, listing
+ * them with a small thumbnail, so a team can follow a texture pack from a feed reader. Changes
+ * come from the state file; the feed keeps the latest feedEntryLimit entries.
+ */
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"crf2html/gallery"
+)
+
+const (
+	feedEntryLimit     = 50
+	feedThumbnailLimit = 100
+	feedThumbnailSize  = 64
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Link    *atomLink   `xml:"link,omitempty"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    *atomLink   `xml:"link,omitempty"`
+	Content atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// UpdateFeed prepends an entry describing changes (texture keys mapped to "new", "changed" or
+// "renamed") and removed to the feed of settings. Runs without changes leave an existing feed alone.
+func UpdateFeed(settings Settings, inventory *gallery.Inventory, changes map[string]string, removed []string, generated time.Time) error {
+	feedPath := settings.Page.FeedPath
+	feed := atomFeed{ID: "urn:crf2html:" + filepath.Base(settings.Page.OutputPath), Author: atomAuthor{Name: "crf2html"}}
+	data, err := os.ReadFile(feedPath)
+
+	if err == nil {
+		if len(changes) == 0 && len(removed) == 0 {
+			return nil
+		}
+
+		if err := xml.Unmarshal(data, &feed); err != nil {
+			return fmt.Errorf("%s: %v", feedPath, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	// A missing feed is written even without changes, so readers can subscribe right away.
+	feed.Title = settings.Page.Title
+	feed.Updated = generated.Format(time.RFC3339)
+
+	if settings.Delivery.NotifyLink != "" {
+		feed.Link = &atomLink{Href: settings.Delivery.NotifyLink}
+	}
+
+	if len(changes) > 0 || len(removed) > 0 {
+		entry, err := feedEntry(settings, inventory, changes, removed, generated)
+
+		if err != nil {
+			return err
+		}
+
+		feed.Entries = append([]atomEntry{entry}, feed.Entries...)
+
+		if len(feed.Entries) > feedEntryLimit {
+			feed.Entries = feed.Entries[:feedEntryLimit]
+		}
+	}
+
+	output, err := xml.MarshalIndent(feed, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(feedPath, append([]byte(xml.Header), append(output, '\n')...), 0644)
+}
+
+func feedEntry(settings Settings, inventory *gallery.Inventory, changes map[string]string, removed []string, generated time.Time) (atomEntry, error) {
+	var added, changed, renamed int
+	var figures []string
+
+	for _, family := range inventory.Families {
+		for _, texture := range family.Textures {
+			status := changes[texture.Key()]
+
+			if status == "new" {
+				added++
+			} else if status == "changed" {
+				changed++
+			} else if status == "renamed" {
+				renamed++
+			} else {
+				continue
+			}
+
+			if len(figures) == feedThumbnailLimit {
+				continue
+			}
+
+			figure, err := feedFigure(texture, status)
+
+			if err != nil {
+				return atomEntry{}, err
+			}
+
+			figures = append(figures, figure)
+		}
+	}
+
+	summary := fmt.Sprintf("%d textures added, %d changed, %d removed", added, changed, len(removed))
+
+	if renamed > 0 {
+		summary = fmt.Sprintf("%d textures added, %d changed, %d renamed, %d removed", added, changed, renamed, len(removed))
+	}
+
+	content := fmt.Sprintf("<p>%s.</p>%s", summary, strings.Join(figures, ""))
+
+	if more := added + changed + renamed - len(figures); more > 0 {
+		content += fmt.Sprintf("<p>And %d more.</p>", more)
+	}
+
+	if len(removed) > 0 {
+		sort.Strings(removed)
+		content += fmt.Sprintf("<p>Removed: %s.</p>", html.EscapeString(strings.Join(removed, ", ")))
+	}
+
+	entry := atomEntry{
+		ID:      fmt.Sprintf("urn:crf2html:%s:%d", filepath.Base(settings.Page.OutputPath), generated.Unix()),
+		Title:   fmt.Sprintf("%s: %s", settings.Page.Title, summary),
+		Updated: generated.Format(time.RFC3339),
+		Content: atomContent{Type: "html", Body: content},
+	}
+
+	if settings.Delivery.NotifyLink != "" {
+		entry.Link = &atomLink{Href: settings.Delivery.NotifyLink}
+	}
+
+	return entry, nil
+}
+
+// feedFigure renders a texture of an entry, with its thumbnail inlined as a JPEG.
+func feedFigure(texture gallery.Texture, status string) (string, error) {
+	caption := html.EscapeString(fmt.Sprintf("%s (%s)", texture.Key(), status))
+
+	img, err := texture.LoadImage()
+
+	if err != nil {
+		return "", err
+	}
+
+	if img == nil {
+		return fmt.Sprintf("<figure><figcaption>%s</figcaption></figure>", caption), nil
+	}
+
+	// JPEG has no transparency: flatten the thumbnail on white, like the page does by default.
+	thumbnail := gallery.Thumbnail(img, feedThumbnailSize)
+	flattened := image.NewRGBA(thumbnail.Bounds())
+	draw.Draw(flattened, flattened.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flattened, flattened.Bounds(), thumbnail, thumbnail.Bounds().Min, draw.Over)
+
+	buffer := new(bytes.Buffer)
+
+	if err := gallery.EncodeJPEG(buffer, flattened, gallery.JPEGDefaults("."+texture.Format)); err != nil {
+		return "", err
+	}
+
+	uri := "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buffer.Bytes())
+
+	return fmt.Sprintf("<figure><img src='%s' alt='%s'><figcaption>%s</figcaption></figure>", uri, html.EscapeString(texture.Name), caption), nil
+}
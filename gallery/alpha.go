@@ -0,0 +1,50 @@
+package gallery
+
+/**
+ * Alpha coverage
+ *
+ * The Dark Engine draws textures whose alpha is only ever fully transparent or fully opaque as
+ * alpha-tested cutouts, and the others as blended surfaces, which sort and light differently. A
+ * stray half-transparent pixel thus turns a grate into a blended texture, so the scan classifies
+ * every texture by its alpha channel and the page badges and filters the two kinds apart.
+ */
+
+import "fmt"
+
+// Alpha kinds of a texture.
+const (
+	AlphaOpaque  = "opaque"
+	AlphaCutout  = "cutout"
+	AlphaBlended = "blended"
+)
+
+// AlphaKind classifies the alpha channel of stats: opaque when every pixel is, cutout when every
+// pixel is fully opaque or fully transparent, and blended otherwise.
+func AlphaKind(stats ImageStats) string {
+	alpha := stats.Channels[3].Histogram
+
+	switch {
+	case alpha[255] == stats.Pixels:
+		return AlphaOpaque
+	case alpha[0]+alpha[255] == stats.Pixels:
+		return AlphaCutout
+	default:
+		return AlphaBlended
+	}
+}
+
+var alphaTitles = map[string]string{
+	AlphaCutout:  "alpha-tested: every pixel is fully opaque or fully transparent",
+	AlphaBlended: "alpha-blended: some pixels are partly transparent",
+}
+
+// renderAlpha renders the badge of a texture that is not opaque.
+func renderAlpha(texture Texture) string {
+	title, ok := alphaTitles[texture.Alpha]
+
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf(" <span class='badge %s' title='%s'>%s</span>", texture.Alpha, title, texture.Alpha)
+}
@@ -0,0 +1,103 @@
+package gallery
+
+/**
+ * Family archives
+ *
+ * WriteFamilyArchive packs the original files of one family, read back from the sources of the
+ * inventory, into a ZIP file: the textures as found in the CRF, material files and the other
+ * files of the family directory, such as its palette. Artists can then download just the family
+ * they work on.
+ */
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// WriteFamilyArchive writes to writer a ZIP file holding the files of family, under a directory
+// named after it. Files shadowed by an overlay are left out. The sources are reopened by name, so
+// they must have been read by Scan rather than ScanFS.
+func WriteFamilyArchive(writer io.Writer, inventory *Inventory, family string) error {
+	shadowed := make(map[string]bool)
+
+	for _, current := range inventory.Families {
+		for _, texture := range current.Textures {
+			for _, shadow := range texture.Shadows {
+				shadowed[shadow] = true
+			}
+		}
+	}
+
+	// Later sources come last among the decisions, so their files replace earlier ones of the
+	// same name.
+	var names []string
+	files := make(map[string]Decision)
+
+	for _, decision := range inventory.Decisions {
+		parts := strings.Split(strings.ToLower(filepath.ToSlash(decision.Path)), "/")
+
+		if len(parts) < 2 || parts[len(parts)-2] != family || shadowed[decision.Path] {
+			continue
+		}
+
+		name := path.Base(filepath.ToSlash(decision.Path))
+
+		if _, ok := files[name]; !ok {
+			names = append(names, name)
+		}
+
+		files[name] = decision
+	}
+
+	if len(names) == 0 {
+		return fmt.Errorf("gallery: no files in family %s", family)
+	}
+
+	sources := make(map[string]*SourceFiles)
+
+	defer func() {
+		for _, source := range sources {
+			source.Close()
+		}
+	}()
+
+	archive := zip.NewWriter(writer)
+
+	for _, name := range names {
+		decision := files[name]
+		source := sources[decision.Source]
+
+		if source == nil {
+			var err error
+			source, err = OpenSource(decision.Source)
+
+			if err != nil {
+				return err
+			}
+
+			sources[decision.Source] = source
+		}
+
+		data, err := source.ReadFile(decision.Path)
+
+		if err != nil {
+			return err
+		}
+
+		entry, err := archive.Create(family + "/" + name)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := entry.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
@@ -0,0 +1,66 @@
+package gallery
+
+/**
+ * Aspect ratio
+ *
+ * Most textures are square, or twice as wide as tall. Thin strips, 1:8 and beyond, are trims:
+ * baseboards, moldings and window frames, mapped along an edge rather than tiled over a surface,
+ * which HD packs upscale and retouch differently. Each tile is tagged with the aspect of its
+ * texture for the aspect filter of the page, and strips get a badge with their ratio.
+ */
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Aspect kinds of a texture.
+const (
+	AspectSquare = "square"
+	AspectWide   = "wide"
+	AspectTall   = "tall"
+	AspectStrip  = "strip"
+)
+
+// StripRatio is the ratio of the longest side to the shortest one from which a texture is a strip.
+const StripRatio = 8
+
+// AspectKind classifies a texture of width by height pixels, or returns "" for one without
+// dimensions, such as a broken texture.
+func AspectKind(width int, height int) string {
+	switch {
+	case width <= 0 || height <= 0:
+		return ""
+	case max(width, height) >= StripRatio*min(width, height):
+		return AspectStrip
+	case width > height:
+		return AspectWide
+	case width < height:
+		return AspectTall
+	default:
+		return AspectSquare
+	}
+}
+
+// AspectRatio writes the ratio of width to height as "16:1" or "1:16", with one decimal at most.
+func AspectRatio(width int, height int) string {
+	ratio := strconv.FormatFloat(math.Round(float64(max(width, height))*10/float64(min(width, height)))/10, 'f', -1, 64)
+
+	if width < height {
+		return "1:" + ratio
+	}
+
+	return ratio + ":1"
+}
+
+// renderAspect renders the badge of a texture that is a strip.
+func renderAspect(texture Texture) string {
+	if AspectKind(texture.Width, texture.Height) != AspectStrip {
+		return ""
+	}
+
+	ratio := AspectRatio(texture.Width, texture.Height)
+
+	return fmt.Sprintf(" <span class='badge strip' title='%s strip, likely a trim texture'>%s</span>", ratio, ratio)
+}
@@ -0,0 +1,102 @@
+package gallery
+
+/**
+ * Status badges
+ *
+ * Badge renders a shields.io-style SVG badge, a gray label next to a colored value, and
+ * WriteBadges writes the badges of a run next to the gallery, so project READMEs elsewhere can
+ * embed its current figures as images. Text widths are estimated from average Verdana glyph
+ * widths, which is how such badges are usually laid out without font metrics.
+ */
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+)
+
+// Badge colors, from the shields.io palette.
+const (
+	BadgeBlue   = "#007ec6"
+	BadgeGreen  = "#4c1"
+	BadgeYellow = "#dfb317"
+	BadgeRed    = "#e05d44"
+)
+
+// Badge returns an SVG badge showing label and value, the value on a background of valueColor.
+func Badge(label string, value string, valueColor string) []byte {
+	labelWidth, valueWidth := badgeTextWidth(label)+10, badgeTextWidth(value)+10
+	width := labelWidth + valueWidth
+	label, value = html.EscapeString(label), html.EscapeString(value)
+
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`+
+		`<title>%s: %s</title>`+
+		`<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`+
+		`<clipPath id="r"><rect width="%d" height="20" rx="3" fill="#fff"/></clipPath>`+
+		`<g clip-path="url(#r)"><rect width="%d" height="20" fill="#555"/><rect x="%d" width="%d" height="20" fill="%s"/><rect width="%d" height="20" fill="url(#s)"/></g>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text>`+
+		`<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text></g></svg>`+"\n",
+		width, label, value,
+		label, value,
+		width,
+		labelWidth, labelWidth, valueWidth, valueColor, width,
+		labelWidth/2, label, labelWidth/2, label,
+		labelWidth+valueWidth/2, value, labelWidth+valueWidth/2, value,
+	))
+}
+
+// badgeTextWidth estimates the width in pixels of text in 11px Verdana.
+func badgeTextWidth(text string) int {
+	width := 0.0
+
+	for _, character := range text {
+		switch {
+		case character == ' ' || character == '.' || character == ':' || character == 'i' || character == 'l':
+			width += 3.9
+		case character == '%' || character == 'm' || character == 'w' || character == 'M' || character == 'W':
+			width += 10.5
+		case character >= 'A' && character <= 'Z':
+			width += 7.6
+		default:
+			width += 7
+		}
+	}
+
+	return int(width + 0.5)
+}
+
+// coverageColor grades an HD coverage from red to green.
+func coverageColor(coverage float64) string {
+	switch {
+	case coverage >= 0.9:
+		return BadgeGreen
+	case coverage >= 0.5:
+		return BadgeYellow
+	default:
+		return BadgeRed
+	}
+}
+
+// WriteBadges writes the badges of totals to directoryPath: textures.svg, size.svg and
+// hd-coverage.svg.
+func WriteBadges(directoryPath string, totals RunTotals) error {
+	if err := os.MkdirAll(directoryPath, 0755); err != nil {
+		return err
+	}
+
+	badges := map[string][]byte{
+		"textures.svg":    Badge("textures", fmt.Sprint(totals.Textures), BadgeBlue),
+		"size.svg":        Badge("size", formatSize(totals.Bytes), BadgeBlue),
+		"hd-coverage.svg": Badge("HD coverage", fmt.Sprintf("%.0f%%", totals.Coverage*100), coverageColor(totals.Coverage)),
+	}
+
+	for name, badge := range badges {
+		if err := os.WriteFile(filepath.Join(directoryPath, name), badge, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
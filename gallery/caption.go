@@ -0,0 +1,79 @@
+package gallery
+
+/**
+ * Caption templates
+ *
+ * RenderOptions.Caption replaces the name and dimensions under each thumbnail with a template
+ * such as "{name} · {width}x{height} · {format} · {size}", whose fields are filled in from the
+ * texture. The badges (status, wrong extension, usage, ...) still follow it.
+ */
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CaptionFields lists the fields of caption templates. width and height are those of the
+// texture, size that of its file, palette the number of colors of paletted textures.
+var CaptionFields = []string{"name", "file", "family", "path", "width", "height", "format", "size", "sha256", "palette"}
+
+var captionField = regexp.MustCompile(`\{(\w+)\}`)
+
+// CheckCaption reports the first unknown field of template.
+func CheckCaption(template string) error {
+	for _, match := range captionField.FindAllStringSubmatch(template, -1) {
+		if !slices.Contains(CaptionFields, match[1]) {
+			return fmt.Errorf("unknown caption field {%s}", match[1])
+		}
+	}
+
+	return nil
+}
+
+// expandCaption fills template in for texture, as HTML. The name keeps its filename span, which
+// the search and the slideshow read, hidden when the template leaves the name out.
+func expandCaption(template string, texture Texture) string {
+	values := map[string]string{
+		"file":    texture.File,
+		"family":  texture.Family,
+		"path":    texture.Path,
+		"width":   strconv.Itoa(texture.Width),
+		"height":  strconv.Itoa(texture.Height),
+		"format":  texture.Format,
+		"size":    formatSize(texture.Size),
+		"sha256":  texture.SHA256,
+		"palette": "no palette",
+	}
+
+	if texture.Palette > 0 {
+		values["palette"] = fmt.Sprintf("%d colors", texture.Palette)
+	}
+
+	var output strings.Builder
+	named := false
+	last := 0
+
+	for _, match := range captionField.FindAllStringSubmatchIndex(template, -1) {
+		output.WriteString(html.EscapeString(template[last:match[0]]))
+		last = match[1]
+
+		if field := template[match[2]:match[3]]; field == "name" {
+			fmt.Fprintf(&output, "<span class='filename'>%s</span>", html.EscapeString(texture.Name))
+			named = true
+		} else {
+			output.WriteString(html.EscapeString(values[field]))
+		}
+	}
+
+	output.WriteString(html.EscapeString(template[last:]))
+
+	if !named {
+		return fmt.Sprintf("<span class='filename' hidden>%s</span>%s", html.EscapeString(texture.Name), output.String())
+	}
+
+	return output.String()
+}
@@ -0,0 +1,89 @@
+package gallery
+
+/**
+ * Collation
+ *
+ * Names are sorted naturally by default: byte by byte, except for runs of digits, compared by
+ * their numeric value, so that numbered series and animation frames stay in order, "tile2" before
+ * "tile10". That still puts "Écorce" after "zinc"; with a collation, families and textures are
+ * sorted the way readers of a language expect: accented letters next to their base letter, case
+ * mattering only between otherwise equal names, and digits compared by their numeric value.
+ */
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// Collation returns the function comparing names in the order of the language of locale, a BCP 47
+// tag such as "fr" or "de-CH", for RenderOptions.CompareNames and SortFamiliesBy.
+func Collation(locale string) (func(a string, b string) int, error) {
+	tag, err := language.Parse(locale)
+
+	if err != nil {
+		return nil, fmt.Errorf("gallery: invalid collation %q: %v", locale, err)
+	}
+
+	// Collators keep buffers between comparisons.
+	collator := collate.New(tag, collate.Numeric)
+	var mutex sync.Mutex
+
+	return func(a string, b string) int {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return collator.CompareString(a, b)
+	}, nil
+}
+
+// NaturalCompare compares a and b byte by byte, except for runs of digits, compared by their
+// numeric value. Names equal but for leading zeros are then compared byte by byte.
+func NaturalCompare(a string, b string) int {
+	i, j := 0, 0
+
+	for i < len(a) && j < len(b) {
+		if !isDigit(a[i]) || !isDigit(b[j]) {
+			if a[i] != b[j] {
+				return int(a[i]) - int(b[j])
+			}
+
+			i, j = i+1, j+1
+
+			continue
+		}
+
+		startA, startB := i, j
+
+		for i < len(a) && isDigit(a[i]) {
+			i++
+		}
+
+		for j < len(b) && isDigit(b[j]) {
+			j++
+		}
+
+		numberA, numberB := strings.TrimLeft(a[startA:i], "0"), strings.TrimLeft(b[startB:j], "0")
+
+		if len(numberA) != len(numberB) {
+			return len(numberA) - len(numberB)
+		}
+
+		if order := strings.Compare(numberA, numberB); order != 0 {
+			return order
+		}
+	}
+
+	if order := (len(a) - i) - (len(b) - j); order != 0 {
+		return order
+	}
+
+	return strings.Compare(a, b)
+}
+
+func isDigit(character byte) bool {
+	return character >= '0' && character <= '9'
+}
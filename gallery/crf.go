@@ -0,0 +1,200 @@
+package gallery
+
+/**
+ * Tolerant CRF reading
+ *
+ * Some CRFs found in the wild are not valid ZIP files as far as archive/zip is concerned: the
+ * central directory is truncated or missing, its offsets disagree with the local headers, names
+ * use backslashes. Their local file headers are usually intact, so RecoverArchive walks those
+ * instead and rebuilds an archive out of the entries it can decompress.
+ */
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+const (
+	localHeaderSignature   = "PK\x03\x04"
+	centralHeaderSignature = "PK\x01\x02"
+	descriptorSignature    = "PK\x07\x08"
+	localHeaderSize        = 30
+)
+
+var errNoEntries = errors.New("gallery: no archive entries found")
+
+// RecoverArchive rebuilds an archive from the local file headers of data, ignoring the central
+// directory. Entries that cannot be recovered (unsupported compression methods, truncated or
+// corrupt data, unsafe names) are left out and their names returned as dropped.
+func RecoverArchive(data []byte) (*zip.Reader, []string, error) {
+	var names []string
+	var dropped []string
+	contents := make(map[string][]byte)
+
+	for offset := 0; ; {
+		index := bytes.Index(data[offset:], []byte(localHeaderSignature))
+
+		if index < 0 {
+			break
+		}
+
+		start := offset + index
+		name, content, next, err := readLocalEntry(data, start)
+
+		if next <= start {
+			next = start + len(localHeaderSignature)
+		}
+
+		offset = next
+
+		if name == "" || strings.HasSuffix(name, "/") || strings.HasSuffix(name, "\\") {
+			continue
+		}
+
+		cleaned := cleanEntryName(name)
+
+		if err != nil || cleaned == "" {
+			dropped = append(dropped, name)
+
+			continue
+		}
+
+		name = cleaned
+
+		// A name repeated further in the archive is a later version of the same file.
+		if _, ok := contents[name]; !ok {
+			names = append(names, name)
+		}
+
+		contents[name] = content
+	}
+
+	if len(names) == 0 {
+		return nil, dropped, errNoEntries
+	}
+
+	archive := new(bytes.Buffer)
+	writer := zip.NewWriter(archive)
+
+	for _, name := range names {
+		entry, err := writer.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+
+		if err != nil {
+			return nil, dropped, err
+		}
+
+		if _, err := entry.Write(contents[name]); err != nil {
+			return nil, dropped, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, dropped, err
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+
+	return reader, dropped, err
+}
+
+// readLocalEntry decodes the entry whose local header starts at data[start:]. It returns the
+// name as stored, the decompressed content and the offset following the entry.
+func readLocalEntry(data []byte, start int) (string, []byte, int, error) {
+	if len(data)-start < localHeaderSize {
+		return "", nil, 0, io.ErrUnexpectedEOF
+	}
+
+	header := data[start : start+localHeaderSize]
+	flags := binary.LittleEndian.Uint16(header[6:])
+	method := binary.LittleEndian.Uint16(header[8:])
+	compressedSize := binary.LittleEndian.Uint32(header[18:])
+	nameLength := int(binary.LittleEndian.Uint16(header[26:]))
+	extraLength := int(binary.LittleEndian.Uint16(header[28:]))
+	dataStart := start + localHeaderSize + nameLength + extraLength
+
+	if dataStart > len(data) {
+		return "", nil, 0, io.ErrUnexpectedEOF
+	}
+
+	name := string(data[start+localHeaderSize : start+localHeaderSize+nameLength])
+
+	// With a data descriptor (flag bit 3) or a ZIP64 size, the local header holds no usable size.
+	sizeKnown := flags&0x8 == 0 && compressedSize != 0xffffffff
+	rest := data[dataStart:]
+
+	if sizeKnown && int64(compressedSize) > int64(len(rest)) {
+		return name, nil, len(data), io.ErrUnexpectedEOF
+	}
+
+	switch method {
+	case zip.Store:
+		if sizeKnown {
+			return name, rest[:compressedSize], dataStart + int(compressedSize), nil
+		}
+
+		end := nextSignature(rest)
+		content := rest[:end]
+
+		// The descriptor (signature, CRC-32 and both sizes) sits between the data and the next header.
+		if len(content) >= 16 && string(content[len(content)-16:len(content)-12]) == descriptorSignature {
+			content = content[:len(content)-16]
+		}
+
+		return name, content, dataStart + end, nil
+
+	case zip.Deflate:
+		if sizeKnown {
+			rest = rest[:compressedSize]
+		}
+
+		// bytes.Reader is an io.ByteReader, so flate reads no further than the end of the stream.
+		reader := bytes.NewReader(rest)
+		content, err := io.ReadAll(flate.NewReader(reader))
+
+		if err != nil {
+			return name, nil, dataStart + len(rest) - reader.Len(), err
+		}
+
+		return name, content, dataStart + len(rest) - reader.Len(), nil
+
+	default:
+		next := dataStart
+
+		if sizeKnown {
+			next += int(compressedSize)
+		}
+
+		return name, nil, next, zip.ErrAlgorithm
+	}
+}
+
+// nextSignature returns the offset of the next local or central header in data, or len(data).
+func nextSignature(data []byte) int {
+	end := len(data)
+
+	for _, signature := range []string{localHeaderSignature, centralHeaderSignature} {
+		if index := bytes.Index(data, []byte(signature)); index >= 0 && index < end {
+			end = index
+		}
+	}
+
+	return end
+}
+
+// cleanEntryName turns a file name into a valid fs.FS path, or "" when it escapes the archive.
+func cleanEntryName(name string) string {
+	name = path.Clean(strings.TrimLeft(strings.ReplaceAll(name, "\\", "/"), "/"))
+
+	if !fs.ValidPath(name) || name == "." {
+		return ""
+	}
+
+	return name
+}
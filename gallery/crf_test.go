@@ -0,0 +1,126 @@
+package gallery
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// brokenArchive returns a CRF whose central directory is cut off, holding a deflated entry with
+// a data descriptor, a stored one with its sizes in the local header, a backslash-separated name
+// and an entry compressed with an unknown method.
+func brokenArchive(t testing.TB) []byte {
+	floor := encodePNG(t, 4, 4)
+	archive := new(bytes.Buffer)
+	writer := zip.NewWriter(archive)
+
+	entries := []struct {
+		header *zip.FileHeader
+		data   []byte
+		raw    bool
+	}{
+		{&zip.FileHeader{Name: "brick/", Method: zip.Store}, nil, false},
+		{&zip.FileHeader{Name: "brick/wall.png", Method: zip.Deflate}, encodePNG(t, 8, 8), false},
+		{&zip.FileHeader{Name: "brick/floor.png", Method: zip.Store, CompressedSize64: uint64(len(floor)), UncompressedSize64: uint64(len(floor))}, floor, true},
+		{&zip.FileHeader{Name: "metal\\plate.png", Method: zip.Deflate}, encodePNG(t, 2, 2), false},
+		{&zip.FileHeader{Name: "metal/odd.png", Method: 99, CompressedSize64: 3, UncompressedSize64: 3}, []byte("odd"), true},
+	}
+
+	for _, entry := range entries {
+		create := writer.CreateHeader
+
+		if entry.raw {
+			create = writer.CreateRaw
+		}
+
+		file, err := create(entry.header)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		file.Write(entry.data)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := archive.Bytes()
+
+	// Cut the archive in the middle of its central directory.
+	return data[:bytes.Index(data, []byte(centralHeaderSignature))+20]
+}
+
+func TestRecoverArchive(t *testing.T) {
+	data := brokenArchive(t)
+
+	if _, err := zip.NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Fatal("archive/zip accepts the broken fixture")
+	}
+
+	inventory, err := ScanFS(mustRecover(t, data), "broken.crf", DefaultScanOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+
+	for _, family := range inventory.Families {
+		for _, texture := range family.Textures {
+			keys = append(keys, texture.Key())
+
+			if texture.Error != "" {
+				t.Errorf("%s: %s", texture.Key(), texture.Error)
+			}
+		}
+	}
+
+	if got := strings.Join(keys, ","); got != "brick/floor.png,brick/wall.png,metal/plate.png" {
+		t.Errorf("recovered textures = %s", got)
+	}
+}
+
+func mustRecover(t *testing.T, data []byte) *zip.Reader {
+	reader, dropped, err := RecoverArchive(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(dropped, ",") != "metal/odd.png" {
+		t.Errorf("dropped = %q", dropped)
+	}
+
+	return reader
+}
+
+func TestScanFallsBackToLocalHeaders(t *testing.T) {
+	sourcePath := filepath.Join(t.TempDir(), "broken.crf")
+
+	if err := os.WriteFile(sourcePath, brokenArchive(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	inventory, err := Scan(sourcePath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.TextureCount() != 3 || inventory.Metadata["recovered"] == "" {
+		t.Errorf("unexpected inventory: %+v", inventory)
+	}
+
+	if strings.Join(inventory.Skipped, ",") != "metal/odd.png" {
+		t.Errorf("skipped = %q", inventory.Skipped)
+	}
+
+	if _, err := Scan(filepath.Join(t.TempDir(), "missing.crf")); err == nil {
+		t.Error("scanning a missing file succeeded")
+	}
+}
@@ -0,0 +1,114 @@
+package gallery
+
+/**
+ * Content Security Policy
+ *
+ * Galleries of community archives show names and descriptions nobody checked, so every string of
+ * an inventory is escaped, and pages carry a strict policy as a <meta http-equiv> tag besides:
+ * nothing is loaded but images, from the page's own site or inlined as data URIs, and the inline
+ * stylesheets and script of the page are allowed by their hash alone. Markup slipping through
+ * could then neither run a script nor style or load anything. Servers can send the same policy
+ * as a header, read from the page with PagePolicy.
+ *
+ * With RenderOptions.SRI, the stylesheets and the script are assets linked with an integrity
+ * attribute instead, so a mirror altering them gets them refused by browsers. Browsers check no
+ * integrity on images: thumbnails are covered by the hashed asset layout of crf2html, naming
+ * them after their content.
+ */
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// ContentSecurityPolicy returns the policy of a page whose inline <style> elements hold styles
+// and whose inline <script> elements hold scripts, if any. Linked pages also load stylesheets and
+// scripts from their own site.
+func ContentSecurityPolicy(styles []string, scripts []string, linked bool) string {
+	var styleSources, scriptSources []string
+
+	if linked {
+		styleSources, scriptSources = []string{"'self'"}, []string{"'self'"}
+	}
+
+	for _, style := range styles {
+		styleSources = append(styleSources, cspHash(style))
+	}
+
+	for _, script := range scripts {
+		scriptSources = append(scriptSources, cspHash(script))
+	}
+
+	if len(scriptSources) == 0 {
+		scriptSources = []string{"'none'"}
+	}
+
+	return fmt.Sprintf(
+		"default-src 'none'; img-src 'self' data:; style-src %s; script-src %s; base-uri 'none'; form-action 'none'",
+		strings.Join(styleSources, " "),
+		strings.Join(scriptSources, " "),
+	)
+}
+
+// cspHash returns the source expression allowing an inline element holding content.
+func cspHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+
+	return "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+}
+
+// integrity returns the value of the integrity attribute of a linked file holding data.
+func integrity(data []byte) string {
+	sum := sha512.Sum384(data)
+
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+var policyPattern = regexp.MustCompile(`<meta http-equiv='Content-Security-Policy' content='([^']*)'>`)
+
+// PagePolicy returns the Content Security Policy of a page rendered by Render, or "" if it has
+// none.
+func PagePolicy(page []byte) string {
+	match := policyPattern.FindSubmatch(page)
+
+	if match == nil {
+		return ""
+	}
+
+	return html.UnescapeString(string(match[1]))
+}
+
+// linkedAsset is a stylesheet or script linked by a page rather than inlined. Attributes are
+// added to the element linking it.
+type linkedAsset struct {
+	name       string
+	content    string
+	attributes string
+}
+
+// linkAssets stores assets through options.Asset and returns the elements linking them, with
+// their integrity.
+func linkAssets(options RenderOptions, assets []linkedAsset) (string, error) {
+	var elements []string
+
+	for _, asset := range assets {
+		url, err := options.Asset("crf2html", asset.name, []byte(asset.content))
+
+		if err != nil {
+			return "", err
+		}
+
+		if strings.HasSuffix(asset.name, ".js") {
+			elements = append(elements, fmt.Sprintf("<script src='%s' integrity='%s'></script>", html.EscapeString(url), integrity([]byte(asset.content))))
+		} else {
+			elements = append(elements, fmt.Sprintf("<link rel='stylesheet' href='%s'%s integrity='%s'>", html.EscapeString(url), asset.attributes, integrity([]byte(asset.content))))
+		}
+	}
+
+	return strings.Join(elements, "\n\t\t"), nil
+}
@@ -0,0 +1,98 @@
+package gallery
+
+import (
+	"image"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// hostileInventory returns an inventory whose every string tries to break out of the page markup.
+func hostileInventory() *Inventory {
+	const payload = `"'><img src=x onerror=alert(1)><script>alert(1)</script>`
+
+	texture := Texture{
+		Family:    payload,
+		Name:      payload,
+		File:      payload + ".png",
+		Path:      payload + "/" + payload + ".png",
+		Format:    "png",
+		Extension: ".png",
+		Alpha:     AlphaOpaque,
+		Image:     image.NewRGBA(image.Rect(0, 0, 4, 4)),
+		Shadows:   []string{payload},
+		Source:    payload,
+		Rating:    &Rating{Stars: 3, Verdict: payload, Note: payload},
+	}
+	broken := texture
+	broken.Name, broken.File, broken.Image, broken.Error = "broken", "broken.png", nil, payload
+
+	return &Inventory{
+		Source:        payload,
+		Families:      []Family{{Name: payload, Description: payload, Textures: []Texture{texture, broken}}},
+		EmptyFamilies: []string{payload},
+		Metadata:      map[string]string{payload: payload},
+		Incomplete:    payload,
+	}
+}
+
+func TestRenderEscapesStrings(t *testing.T) {
+	for _, caption := range []string{"", "{name} {path} {file} {family}"} {
+		options := DefaultRenderOptions()
+		options.Title, options.Notice, options.Caption = hostileInventory().Source, hostileInventory().Source, caption
+		options.FamilyLabels = map[string]string{hostileInventory().Source: hostileInventory().Source}
+		options.ModelIndex = map[string][]string{hostileInventory().Source: {hostileInventory().Source}}
+
+		page, err := Render(hostileInventory(), options)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, markup := range []string{"<img src=x", "<script>alert"} {
+			if strings.Contains(string(page), markup) {
+				t.Errorf("caption %q: unescaped %q in the page", caption, markup)
+			}
+		}
+	}
+}
+
+func TestRenderPolicy(t *testing.T) {
+	stylePattern := regexp.MustCompile(`(?s)<style[^>]*>(.*?)</style>`)
+	scriptPattern := regexp.MustCompile(`(?s)<script>(.*?)</script>`)
+
+	for _, noJS := range []bool{false, true} {
+		options := DefaultRenderOptions()
+		options.NoJS = noJS
+		page, err := Render(hostileInventory(), options)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		policy := PagePolicy(page)
+
+		if !strings.HasPrefix(policy, "default-src 'none'; img-src 'self' data:; style-src 'sha256-") {
+			t.Fatalf("NoJS %v: unexpected policy %q", noJS, policy)
+		}
+
+		// Every inline element is allowed by its hash, and nothing else.
+		var styles, scripts []string
+
+		for _, match := range stylePattern.FindAllStringSubmatch(string(page), -1) {
+			styles = append(styles, match[1])
+		}
+
+		for _, match := range scriptPattern.FindAllStringSubmatch(string(page), -1) {
+			scripts = append(scripts, match[1])
+		}
+
+		if expected := ContentSecurityPolicy(styles, scripts, false); policy != expected {
+			t.Errorf("NoJS %v: policy %q, want %q", noJS, policy, expected)
+		}
+
+		if noJS != strings.Contains(policy, "script-src 'none'") {
+			t.Errorf("NoJS %v: unexpected script sources in %q", noJS, policy)
+		}
+	}
+}
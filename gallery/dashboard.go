@@ -0,0 +1,73 @@
+package gallery
+
+/**
+ * Dashboard statistics
+ *
+ * Asset pipelines watch the health of a texture pack from their monitoring dashboards, such as
+ * Grafana, rather than from the page. DashboardStats sums up an inventory in a compact document
+ * made for them: counts, bytes, formats, a histogram of the resolutions and the HD coverage.
+ */
+
+import (
+	"fmt"
+	"time"
+)
+
+// DashboardStats sums up an inventory for monitoring dashboards. Resolutions counts the decoded
+// textures by longest side, rounded up to a power of two from "16" to "4096", larger ones under
+// "4096+".
+type DashboardStats struct {
+	Generated   time.Time      `json:"generated"`
+	Families    int            `json:"families"`
+	Textures    int            `json:"textures"`
+	Broken      int            `json:"broken"`
+	Bytes       int64          `json:"bytes"`
+	Coverage    float64        `json:"coverage"`
+	Formats     map[string]int `json:"formats"`
+	Resolutions map[string]int `json:"resolutions"`
+}
+
+// DashboardStats sums up the inventory as of generated.
+func (inventory *Inventory) DashboardStats(generated time.Time) DashboardStats {
+	totals := inventory.Totals(generated)
+	stats := DashboardStats{
+		Generated:   generated,
+		Families:    len(inventory.Families),
+		Textures:    totals.Textures,
+		Bytes:       totals.Bytes,
+		Coverage:    totals.Coverage,
+		Formats:     make(map[string]int),
+		Resolutions: make(map[string]int),
+	}
+
+	for _, family := range inventory.Families {
+		for _, texture := range family.Textures {
+			stats.Formats[texture.Format]++
+
+			if texture.Error != "" {
+				stats.Broken++
+
+				continue
+			}
+
+			stats.Resolutions[resolutionBucket(max(texture.Width, texture.Height))]++
+		}
+	}
+
+	return stats
+}
+
+// resolutionBucket returns the bucket of the resolutions histogram holding a longest side.
+func resolutionBucket(side int) string {
+	bucket := 16
+
+	for bucket < side && bucket < 4096 {
+		bucket *= 2
+	}
+
+	if side > bucket {
+		return fmt.Sprintf("%d+", bucket)
+	}
+
+	return fmt.Sprint(bucket)
+}
@@ -0,0 +1,384 @@
+package gallery
+
+/**
+ * DirectDraw Surface decoding
+ *
+ * HD texture packs ship DDS files, mostly block-compressed (DXT1, DXT3, DXT5, or their BC1-3
+ * DX10 names) and up to 4096 pixels wide. Decoding a whole 4K texture only to shrink it to a
+ * thumbnail wastes most of the work, so DecodeDDSPreview stops early: it decodes the smallest
+ * mipmap still large enough, and without mipmaps reduces each sampled 4x4 block to its average
+ * color straight from the block palette and index counts, skipping the blocks the thumbnail
+ * cannot show.
+ */
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+const (
+	ddsHeaderSize     = 128
+	ddsDX10HeaderSize = 20
+	ddsFlagAlpha      = 0x1
+	ddsFlagFourCC     = 0x4
+	ddsFlagRGB        = 0x40
+)
+
+// ddsSurface describes the pixel data of a DDS file.
+type ddsSurface struct {
+	width, height int
+	mipmaps       int
+	// blockSize is the size of a compressed 4x4 block, or zero for uncompressed pixels.
+	blockSize int
+	fourCC    string
+	// Uncompressed pixels: size in bytes and channel masks.
+	pixelSize                               int
+	redMask, greenMask, blueMask, alphaMask uint32
+	data                                    []byte
+}
+
+// DecodeDDS decodes a whole DDS image, its first mipmap level.
+func DecodeDDS(reader io.Reader) (image.Image, error) {
+	return DecodeDDSPreview(reader, 0)
+}
+
+// DecodeDDSConfig returns the dimensions of a DDS image.
+func DecodeDDSConfig(reader io.Reader) (image.Config, error) {
+	header := make([]byte, ddsHeaderSize+ddsDX10HeaderSize)
+	n, err := io.ReadFull(reader, header)
+
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return image.Config{}, err
+	}
+
+	surface, err := parseDDS(header[:n], false)
+
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return image.Config{ColorModel: color.NRGBAModel, Width: surface.width, Height: surface.height}, nil
+}
+
+// DecodeDDSPreview decodes a DDS image no smaller than size on its longest side, when it is that
+// much larger, at a fraction of the cost of decoding it whole. Zero decodes the full image.
+func DecodeDDSPreview(reader io.Reader, size int) (image.Image, error) {
+	data, err := io.ReadAll(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	surface, err := parseDDS(data, true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	width, height, offset := surface.width, surface.height, 0
+
+	for level := 1; size > 0 && level < surface.mipmaps; level++ {
+		nextWidth, nextHeight := max(width/2, 1), max(height/2, 1)
+
+		if max(nextWidth, nextHeight) < size || offset+surface.levelSize(width, height)+surface.levelSize(nextWidth, nextHeight) > len(surface.data) {
+			break
+		}
+
+		offset += surface.levelSize(width, height)
+		width, height = nextWidth, nextHeight
+	}
+
+	pixels := surface.data[offset:]
+
+	if len(pixels) < surface.levelSize(width, height) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if surface.blockSize == 0 {
+		return surface.decodePixels(pixels, width, height), nil
+	}
+
+	// Without a small enough mipmap, every block of a stride gives one pixel, its average color.
+	blocksWide, blocksHigh := (width+3)/4, (height+3)/4
+
+	if size > 0 && max(blocksWide, blocksHigh) >= size {
+		stride := max(blocksWide, blocksHigh) / size
+
+		return surface.averageBlocks(pixels, blocksWide, blocksHigh, stride), nil
+	}
+
+	return surface.decodeBlocks(pixels, width, height), nil
+}
+
+func parseDDS(data []byte, needPixels bool) (ddsSurface, error) {
+	if len(data) < ddsHeaderSize || string(data[:4]) != "DDS " || binary.LittleEndian.Uint32(data[4:]) != 124 {
+		return ddsSurface{}, errors.New("dds: invalid header")
+	}
+
+	word := func(offset int) uint32 {
+		return binary.LittleEndian.Uint32(data[offset:])
+	}
+
+	surface := ddsSurface{
+		height:  int(word(12)),
+		width:   int(word(16)),
+		mipmaps: max(int(word(28)), 1),
+	}
+
+	if surface.width <= 0 || surface.height <= 0 || surface.width > 1<<16 || surface.height > 1<<16 {
+		return ddsSurface{}, fmt.Errorf("dds: invalid dimensions %dx%d", surface.width, surface.height)
+	}
+
+	flags := word(80)
+	start := ddsHeaderSize
+
+	switch {
+	case flags&ddsFlagFourCC != 0:
+		surface.fourCC = string(data[84:88])
+
+		if surface.fourCC == "DX10" {
+			if len(data) < ddsHeaderSize+ddsDX10HeaderSize {
+				return ddsSurface{}, io.ErrUnexpectedEOF
+			}
+
+			// BC1 to BC3, in their typeless, UNORM and SRGB variants.
+			switch binary.LittleEndian.Uint32(data[ddsHeaderSize:]) {
+			case 70, 71, 72:
+				surface.fourCC = "DXT1"
+			case 73, 74, 75:
+				surface.fourCC = "DXT3"
+			case 76, 77, 78:
+				surface.fourCC = "DXT5"
+			}
+
+			start += ddsDX10HeaderSize
+		}
+
+		switch surface.fourCC {
+		case "DXT1":
+			surface.blockSize = 8
+		case "DXT2", "DXT3", "DXT4", "DXT5":
+			surface.blockSize = 16
+		default:
+			return ddsSurface{}, fmt.Errorf("dds: unsupported compression %q", surface.fourCC)
+		}
+	case flags&ddsFlagRGB != 0 && (word(88) == 24 || word(88) == 32):
+		surface.pixelSize = int(word(88)) / 8
+		surface.redMask, surface.greenMask, surface.blueMask = word(92), word(96), word(100)
+
+		if flags&ddsFlagAlpha != 0 {
+			surface.alphaMask = word(104)
+		}
+	default:
+		return ddsSurface{}, errors.New("dds: unsupported pixel format")
+	}
+
+	if needPixels {
+		surface.data = data[start:]
+	}
+
+	return surface, nil
+}
+
+// levelSize returns the size in bytes of a mipmap level.
+func (surface ddsSurface) levelSize(width int, height int) int {
+	if surface.blockSize == 0 {
+		return width * height * surface.pixelSize
+	}
+
+	return ((width + 3) / 4) * ((height + 3) / 4) * surface.blockSize
+}
+
+func (surface ddsSurface) decodePixels(pixels []byte, width int, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	channel := func(value uint32, mask uint32) uint8 {
+		if mask == 0 {
+			return 255
+		}
+
+		for mask&1 == 0 {
+			value, mask = value>>1, mask>>1
+		}
+
+		return uint8((value & mask) * 255 / mask)
+	}
+
+	for i := 0; i < width*height; i++ {
+		var value uint32
+
+		for b := 0; b < surface.pixelSize; b++ {
+			value |= uint32(pixels[i*surface.pixelSize+b]) << (8 * b)
+		}
+
+		img.Pix[i*4] = channel(value, surface.redMask)
+		img.Pix[i*4+1] = channel(value, surface.greenMask)
+		img.Pix[i*4+2] = channel(value, surface.blueMask)
+		img.Pix[i*4+3] = channel(value, surface.alphaMask)
+	}
+
+	return img
+}
+
+func (surface ddsSurface) decodeBlocks(pixels []byte, width int, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	blocksWide := (width + 3) / 4
+
+	for block := 0; block*surface.blockSize < surface.levelSize(width, height); block++ {
+		texels := surface.decodeBlock(pixels[block*surface.blockSize:])
+		x0, y0 := block%blocksWide*4, block/blocksWide*4
+
+		for i, texel := range texels {
+			if x, y := x0+i%4, y0+i/4; x < width && y < height {
+				img.SetNRGBA(x, y, texel)
+			}
+		}
+	}
+
+	return img
+}
+
+// averageBlocks reduces every stride-th block of every stride-th row to one pixel.
+func (surface ddsSurface) averageBlocks(pixels []byte, blocksWide int, blocksHigh int, stride int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, (blocksWide+stride-1)/stride, (blocksHigh+stride-1)/stride))
+
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < img.Rect.Dx(); x++ {
+			block := pixels[(y*stride*blocksWide+x*stride)*surface.blockSize:]
+			img.SetNRGBA(x, y, surface.averageBlock(block))
+		}
+	}
+
+	return img
+}
+
+// blockColors returns the palette of the color part of a block and its 2-bit indices.
+func blockColors(block []byte, alwaysOpaque bool) ([4]color.NRGBA, uint32) {
+	c0, c1 := binary.LittleEndian.Uint16(block), binary.LittleEndian.Uint16(block[2:])
+	first, second := rgb565(c0), rgb565(c1)
+	palette := [4]color.NRGBA{first, second}
+
+	mix := func(a uint8, b uint8, weightA int, weightB int) uint8 {
+		return uint8((int(a)*weightA + int(b)*weightB) / (weightA + weightB))
+	}
+
+	if c0 > c1 || alwaysOpaque {
+		palette[2] = color.NRGBA{mix(first.R, second.R, 2, 1), mix(first.G, second.G, 2, 1), mix(first.B, second.B, 2, 1), 255}
+		palette[3] = color.NRGBA{mix(first.R, second.R, 1, 2), mix(first.G, second.G, 1, 2), mix(first.B, second.B, 1, 2), 255}
+	} else {
+		palette[2] = color.NRGBA{mix(first.R, second.R, 1, 1), mix(first.G, second.G, 1, 1), mix(first.B, second.B, 1, 1), 255}
+	}
+
+	return palette, binary.LittleEndian.Uint32(block[4:])
+}
+
+// blockAlphas returns the 16 alpha values of the alpha part of a DXT3 or DXT5 block.
+func (surface ddsSurface) blockAlphas(block []byte) [16]uint8 {
+	var alphas [16]uint8
+
+	if surface.fourCC == "DXT2" || surface.fourCC == "DXT3" {
+		for i := range alphas {
+			alphas[i] = (block[i/2] >> (4 * (i % 2)) & 0xf) * 17
+		}
+
+		return alphas
+	}
+
+	a0, a1 := int(block[0]), int(block[1])
+	palette := [8]uint8{uint8(a0), uint8(a1)}
+
+	if a0 > a1 {
+		for i := 1; i < 7; i++ {
+			palette[i+1] = uint8(((7-i)*a0 + i*a1) / 7)
+		}
+	} else {
+		for i := 1; i < 5; i++ {
+			palette[i+1] = uint8(((5-i)*a0 + i*a1) / 5)
+		}
+
+		palette[6], palette[7] = 0, 255
+	}
+
+	var indices uint64
+
+	for i := 0; i < 6; i++ {
+		indices |= uint64(block[2+i]) << (8 * i)
+	}
+
+	for i := range alphas {
+		alphas[i] = palette[indices>>(3*i)&7]
+	}
+
+	return alphas
+}
+
+func (surface ddsSurface) decodeBlock(block []byte) [16]color.NRGBA {
+	var texels [16]color.NRGBA
+
+	if surface.blockSize == 8 {
+		palette, indices := blockColors(block, false)
+
+		for i := range texels {
+			texels[i] = palette[indices>>(2*i)&3]
+		}
+
+		return texels
+	}
+
+	alphas := surface.blockAlphas(block)
+	palette, indices := blockColors(block[8:], true)
+
+	for i := range texels {
+		texels[i] = palette[indices>>(2*i)&3]
+		texels[i].A = alphas[i]
+	}
+
+	return texels
+}
+
+// averageBlock returns the average color of a block from how often each palette entry is used,
+// without laying out its texels.
+func (surface ddsSurface) averageBlock(block []byte) color.NRGBA {
+	colorBlock := block
+
+	if surface.blockSize == 16 {
+		colorBlock = block[8:]
+	}
+
+	palette, indices := blockColors(colorBlock, surface.blockSize == 16)
+
+	var counts [4]int
+
+	for i := 0; i < 16; i++ {
+		counts[indices>>(2*i)&3]++
+	}
+
+	var red, green, blue, alpha int
+
+	for i, count := range counts {
+		red += int(palette[i].R) * count
+		green += int(palette[i].G) * count
+		blue += int(palette[i].B) * count
+		alpha += int(palette[i].A) * count
+	}
+
+	if surface.blockSize == 16 {
+		alpha = 0
+
+		for _, value := range surface.blockAlphas(block) {
+			alpha += int(value)
+		}
+	}
+
+	return color.NRGBA{uint8(red / 16), uint8(green / 16), uint8(blue / 16), uint8(alpha / 16)}
+}
+
+func rgb565(value uint16) color.NRGBA {
+	red, green, blue := value>>11, value>>5&0x3f, value&0x1f
+
+	return color.NRGBA{uint8(red<<3 | red>>2), uint8(green<<2 | green>>4), uint8(blue<<3 | blue>>2), 255}
+}
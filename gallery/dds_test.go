@@ -0,0 +1,138 @@
+package gallery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"testing"
+)
+
+// ddsFile returns a DDS file of the given compression whose mipmap levels are each filled with
+// one block, repeated.
+func ddsFile(fourCC string, width int, height int, levels ...[]byte) []byte {
+	header := make([]byte, ddsHeaderSize)
+	copy(header, "DDS ")
+	binary.LittleEndian.PutUint32(header[4:], 124)
+	binary.LittleEndian.PutUint32(header[12:], uint32(height))
+	binary.LittleEndian.PutUint32(header[16:], uint32(width))
+	binary.LittleEndian.PutUint32(header[28:], uint32(len(levels)))
+	binary.LittleEndian.PutUint32(header[80:], ddsFlagFourCC)
+	copy(header[84:], fourCC)
+
+	output := bytes.NewBuffer(header)
+
+	for _, block := range levels {
+		blocks := ((width + 3) / 4) * ((height + 3) / 4)
+		output.Write(bytes.Repeat(block, blocks))
+		width, height = max(width/2, 1), max(height/2, 1)
+	}
+
+	return output.Bytes()
+}
+
+// dxt1Block returns a DXT1 block of one opaque RGB565 color.
+func dxt1Block(value uint16) []byte {
+	block := make([]byte, 8)
+	binary.LittleEndian.PutUint16(block, value)
+	binary.LittleEndian.PutUint16(block[2:], value)
+
+	return block
+}
+
+func TestDecodeDDS(t *testing.T) {
+	red, green, blue := dxt1Block(0xf800), dxt1Block(0x07e0), dxt1Block(0x001f)
+	opaqueRed := color.NRGBA{255, 0, 0, 255}
+
+	flat := ddsFile("DXT1", 256, 256, red)
+	config, err := DecodeDDSConfig(bytes.NewReader(flat))
+
+	if err != nil || config.Width != 256 || config.Height != 256 {
+		t.Fatalf("config: got %+v, %v", config, err)
+	}
+
+	full, err := DecodeDDS(bytes.NewReader(flat))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := full.Bounds().Size(); got.X != 256 || got.Y != 256 {
+		t.Errorf("full decoding: got %v", got)
+	}
+
+	// Without mipmaps, one pixel per block of every other block in both directions.
+	preview, err := DecodeDDSPreview(bytes.NewReader(flat), 32)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := preview.Bounds().Size(); got.X != 32 || got.Y != 32 {
+		t.Errorf("block preview: got %v, want 32x32", got)
+	}
+
+	if got := color.NRGBAModel.Convert(preview.At(5, 7)); got != opaqueRed {
+		t.Errorf("block preview: got %v, want %v", got, opaqueRed)
+	}
+
+	// With mipmaps, the smallest level still as large as the thumbnails.
+	mipmapped := ddsFile("DXT1", 64, 64, red, red, green, blue, red, red, red)
+	preview, err = DecodeDDSPreview(bytes.NewReader(mipmapped), 12)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := preview.Bounds().Size(); got.X != 16 || got.Y != 16 {
+		t.Errorf("mipmap preview: got %v, want 16x16", got)
+	}
+
+	if got := color.NRGBAModel.Convert(preview.At(3, 3)); got != (color.NRGBA{0, 255, 0, 255}) {
+		t.Errorf("mipmap preview: got %v, want the green level", got)
+	}
+
+	// DXT5 alpha, alternating between its two end points, survives the averaging.
+	halfAlpha := append([]byte{255, 0, 0x08, 0x82, 0x20, 0x08, 0x82, 0x20}, dxt1Block(0xf800)...)
+	translucent := ddsFile("DXT5", 32, 32, halfAlpha)
+	preview, err = DecodeDDSPreview(bytes.NewReader(translucent), 4)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := color.NRGBAModel.Convert(preview.At(1, 1)).(color.NRGBA); got.R != 255 || got.A < 100 || got.A > 160 {
+		t.Errorf("dxt5 preview: got %v, want half transparent red", got)
+	}
+
+	if got := color.NRGBAModel.Convert(full.At(100, 100)); got != opaqueRed {
+		t.Errorf("full decoding: got %v, want %v", got, opaqueRed)
+	}
+
+	// A scan keeps the dimensions of the full texture, whatever size it decoded.
+	decoder, _ := DecoderForExtension(".dds")
+	img, size, err := decodeLimited(decoder, flat, ScanOptions{PreviewSize: 32})
+
+	if err != nil || size.X != 256 || size.Y != 256 || img.Bounds().Dx() != 32 {
+		t.Errorf("scan: got %v, %v, %v", img.Bounds(), size, err)
+	}
+
+	if _, err := DecodeDDS(bytes.NewReader(flat[:ddsHeaderSize+100])); err == nil {
+		t.Error("truncated file: got no error")
+	}
+}
+
+func BenchmarkDecodeDDS(b *testing.B) {
+	data := ddsFile("DXT5", 4096, 4096, append([]byte{255, 0, 0x08, 0x82, 0x20, 0x08, 0x82, 0x20}, dxt1Block(0xf800)...))
+
+	b.Run("full", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			DecodeDDS(bytes.NewReader(data))
+		}
+	})
+
+	b.Run("preview", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			DecodeDDSPreview(bytes.NewReader(data), 128)
+		}
+	})
+}
@@ -0,0 +1,257 @@
+package gallery
+
+/**
+ * Decoder registry
+ *
+ * Maps file extensions and magic bytes to image decoders. The built-in formats are registered at
+ * start-up; programs using the package can add their own (proprietary formats, newer versions of
+ * a built-in one) with RegisterDecoder. Formats without a reliable signature, such as TGA, are
+ * only recognized by their extension.
+ */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ftrvxmtrx/tga"
+	"github.com/samuel/go-pcx/pcx"
+)
+
+// DecodeFunc decodes a whole image.
+type DecodeFunc func(reader io.Reader) (image.Image, error)
+
+// Decoder describes an image format. Extensions are lowercase and include the dot; the first one
+// is the canonical extension of the format, the others aliases such as ".jpeg". Magic strings
+// are matched against the first bytes of a file, '?' matching any byte, as in image.RegisterFormat.
+// MediaType is optional and only used to describe the textures in the page metadata.
+// DecodeConfig is optional too: when set, images are measured before being decoded, and those
+// over the pixel limit of the scan are not decoded at all. DecodePreview, also optional, decodes
+// an image no smaller than size on its longest side when the format makes that cheaper than a
+// full decoding; the scan uses it when ScanOptions.PreviewSize is set.
+type Decoder struct {
+	Name          string
+	Extensions    []string
+	Magic         []string
+	MediaType     string
+	Decode        DecodeFunc
+	DecodeConfig  func(reader io.Reader) (image.Config, error)
+	DecodePreview func(reader io.Reader, size int) (image.Image, error)
+}
+
+var ErrUnknownFormat = errors.New("gallery: unknown image format")
+
+// runawayDecodes counts the decoders still running after timing out, which cannot be interrupted.
+var runawayDecodes atomic.Int32
+
+var (
+	decodersMutex sync.RWMutex
+	decoders      []Decoder
+	decoderByExt  = make(map[string]int)
+)
+
+func init() {
+	RegisterDecoder(Decoder{Name: "png", Extensions: []string{".png"}, Magic: []string{"\x89PNG\r\n\x1a\n"}, MediaType: "image/png", Decode: png.Decode, DecodeConfig: png.DecodeConfig})
+	RegisterDecoder(Decoder{Name: "gif", Extensions: []string{".gif"}, Magic: []string{"GIF87a", "GIF89a"}, MediaType: "image/gif", Decode: gif.Decode, DecodeConfig: gif.DecodeConfig})
+	RegisterDecoder(Decoder{Name: "jpeg", Extensions: []string{".jpg", ".jpeg", ".jpe", ".jfif"}, Magic: []string{"\xff\xd8"}, MediaType: "image/jpeg", Decode: jpeg.Decode, DecodeConfig: jpeg.DecodeConfig})
+	RegisterDecoder(Decoder{Name: "pcx", Extensions: []string{".pcx"}, Magic: []string{"\x0a?\x01"}, MediaType: "image/x-pcx", Decode: DecodePCX, DecodeConfig: pcx.DecodeConfig})
+	RegisterDecoder(Decoder{Name: "tga", Extensions: []string{".tga", ".tpic"}, MediaType: "image/x-tga", Decode: DecodeTGA, DecodeConfig: tga.DecodeConfig})
+	RegisterDecoder(Decoder{Name: "psd", Extensions: []string{".psd"}, Magic: []string{"8BPS"}, MediaType: "image/vnd.adobe.photoshop", Decode: DecodePSD, DecodeConfig: DecodePSDConfig})
+	RegisterDecoder(Decoder{Name: "ilbm", Extensions: []string{".lbm", ".iff", ".ilbm"}, Magic: []string{"FORM????ILBM", "FORM????PBM "}, MediaType: "image/x-ilbm", Decode: DecodeILBM, DecodeConfig: DecodeILBMConfig})
+	RegisterDecoder(Decoder{Name: "dds", Extensions: []string{".dds"}, Magic: []string{"DDS "}, MediaType: "image/vnd-ms.dds", Decode: DecodeDDS, DecodeConfig: DecodeDDSConfig, DecodePreview: DecodeDDSPreview})
+}
+
+// Format returns the canonical extension of the decoder, without the dot, under which textures
+// are reported whatever alias their file uses.
+func (decoder Decoder) Format() string {
+	if len(decoder.Extensions) == 0 {
+		return decoder.Name
+	}
+
+	return strings.TrimPrefix(decoder.Extensions[0], ".")
+}
+
+// RegisterDecoder adds a decoder, replacing the previous handler of its extensions. It is safe to
+// call while other goroutines decode images.
+func RegisterDecoder(decoder Decoder) {
+	decodersMutex.Lock()
+	defer decodersMutex.Unlock()
+
+	decoders = append(decoders, decoder)
+
+	for _, extension := range decoder.Extensions {
+		decoderByExt[strings.ToLower(extension)] = len(decoders) - 1
+	}
+}
+
+// DecoderForExtension returns the decoder registered for extension.
+func DecoderForExtension(extension string) (Decoder, bool) {
+	decodersMutex.RLock()
+	defer decodersMutex.RUnlock()
+
+	index, ok := decoderByExt[strings.ToLower(extension)]
+
+	if !ok {
+		return Decoder{}, false
+	}
+
+	return decoders[index], true
+}
+
+// SniffDecoder returns the decoder whose magic string matches the start of data. Later
+// registrations win, so a registered decoder can take over a built-in signature.
+func SniffDecoder(data []byte) (Decoder, bool) {
+	decodersMutex.RLock()
+	defer decodersMutex.RUnlock()
+
+	for i := len(decoders) - 1; i >= 0; i-- {
+		for _, magic := range decoders[i].Magic {
+			if matchMagic(magic, data) {
+				return decoders[i], true
+			}
+		}
+	}
+
+	return Decoder{}, false
+}
+
+func matchMagic(magic string, data []byte) bool {
+	if magic == "" || len(data) < len(magic) {
+		return false
+	}
+
+	for i := 0; i < len(magic); i++ {
+		if magic[i] != '?' && magic[i] != data[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// DecodeImage decodes data with the decoder registered for extension. The TGA package registers
+// an empty magic string, so image.Decode alone would hand most formats to the TGA decoder.
+func DecodeImage(reader io.Reader, extension string) (image.Image, error) {
+	decoder, ok := DecoderForExtension(extension)
+
+	if !ok {
+		return nil, ErrUnknownFormat
+	}
+
+	return decoder.Decode(reader)
+}
+
+// decodeLimited decodes data with decoder, refusing images of more than options.MaxPixels pixels,
+// giving up after options.DecodeTimeout and turning decoder panics into errors, so one bad file
+// cannot stop a scan. Once as many decoders as processors are still running after timing out,
+// images are refused outright until some return, so a pack of such files cannot pile them up.
+// CMYK images, as written by print-oriented tools, are converted to RGB. size is that of the full
+// image, even when a preview was decoded.
+func decodeLimited(decoder Decoder, data []byte, options ScanOptions) (image.Image, image.Point, error) {
+	if options.DecodeTimeout <= 0 {
+		return decodeImage(decoder, data, options)
+	}
+
+	type decoded struct {
+		img  image.Image
+		size image.Point
+		err  error
+	}
+
+	if runaway := int(runawayDecodes.Load()); runaway >= runtime.GOMAXPROCS(0) {
+		return nil, image.Point{}, fmt.Errorf("%s decoder not started, %d decoders that timed out still running", decoder.Name, runaway)
+	}
+
+	// Decoders cannot be interrupted: one timing out goes on in the background, its result dropped.
+	done := make(chan decoded, 1)
+
+	go func() {
+		img, size, err := decodeImage(decoder, data, options)
+		done <- decoded{img, size, err}
+	}()
+
+	timer := time.NewTimer(options.DecodeTimeout)
+	defer timer.Stop()
+
+	select {
+	case result := <-done:
+		return result.img, result.size, result.err
+	case <-timer.C:
+		runawayDecodes.Add(1)
+
+		go func() {
+			<-done
+			runawayDecodes.Add(-1)
+		}()
+
+		return nil, image.Point{}, fmt.Errorf("%s decoder timed out after %v", decoder.Name, options.DecodeTimeout)
+	}
+}
+
+// measureImage reads the dimensions and color model of data from its header with decoder, which
+// must have a DecodeConfig, turning decoder panics into errors.
+func measureImage(decoder Decoder, data []byte) (config image.Config, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("%s decoder failed: %v", decoder.Name, recovered)
+		}
+	}()
+
+	return decoder.DecodeConfig(bytes.NewReader(data))
+}
+
+// decodeImage is decodeLimited without the timeout.
+func decodeImage(decoder Decoder, data []byte, options ScanOptions) (img image.Image, size image.Point, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			img, err = nil, fmt.Errorf("%s decoder failed: %v", decoder.Name, recovered)
+		}
+	}()
+
+	if decoder.DecodeConfig != nil {
+		config, err := decoder.DecodeConfig(bytes.NewReader(data))
+
+		if err != nil {
+			return nil, size, err
+		}
+
+		if options.MaxPixels > 0 && int64(config.Width)*int64(config.Height) > int64(options.MaxPixels) {
+			return nil, size, fmt.Errorf("image too large: %dx%d exceeds %d pixels", config.Width, config.Height, options.MaxPixels)
+		}
+
+		size = image.Pt(config.Width, config.Height)
+	}
+
+	if decoder.DecodePreview != nil && options.PreviewSize > 0 {
+		img, err = decoder.DecodePreview(bytes.NewReader(data), options.PreviewSize)
+	} else {
+		img, err = decoder.Decode(bytes.NewReader(data))
+	}
+
+	if err != nil {
+		return nil, size, err
+	}
+
+	if decoder.DecodeConfig == nil {
+		size = img.Bounds().Size()
+	}
+
+	if cmyk, ok := img.(*image.CMYK); ok {
+		rgba := image.NewNRGBA(cmyk.Bounds())
+		draw.Draw(rgba, rgba.Bounds(), cmyk, cmyk.Bounds().Min, draw.Src)
+		img = rgba
+	}
+
+	return img, size, nil
+}
@@ -0,0 +1,154 @@
+package gallery
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// interlacedPNG returns a 2x2 gray PNG stored with Adam7 interlacing, which image/png reads but
+// cannot write. Of the seven passes, only the first, sixth and seventh hold pixels at that size.
+func interlacedPNG(t *testing.T) []byte {
+	chunk := func(output *bytes.Buffer, kind string, data []byte) {
+		binary.Write(output, binary.BigEndian, uint32(len(data)))
+		output.WriteString(kind)
+		output.Write(data)
+		binary.Write(output, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(kind), data...)))
+	}
+
+	compressed := new(bytes.Buffer)
+	writer := zlib.NewWriter(compressed)
+	writer.Write([]byte{0, 0x10, 0, 0x20, 0, 0x30, 0x40})
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	output := bytes.NewBufferString("\x89PNG\r\n\x1a\n")
+	chunk(output, "IHDR", []byte{0, 0, 0, 2, 0, 0, 0, 2, 8, 0, 0, 0, 1})
+	chunk(output, "IDAT", compressed.Bytes())
+	chunk(output, "IEND", nil)
+
+	return output.Bytes()
+}
+
+func TestDecodeSafeguards(t *testing.T) {
+	// A GIF announcing a 30000x30000 screen, far more than the pixel limit.
+	huge := new(bytes.Buffer)
+
+	if err := gif.Encode(huge, image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White}), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	hugeData := huge.Bytes()
+	binary.LittleEndian.PutUint16(hugeData[6:], 30000)
+	binary.LittleEndian.PutUint16(hugeData[8:], 30000)
+
+	fsys := fstest.MapFS{
+		"stone/interlaced.png": {Data: interlacedPNG(t)},
+		"stone/huge.gif":       {Data: hugeData},
+		"stone/wall.png":       {Data: encodePNG(t, 4, 4)},
+	}
+
+	inventory, err := ScanFS(fsys, "memory", DefaultScanOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	textures := make(map[string]Texture)
+
+	for _, texture := range inventory.Families[0].Textures {
+		textures[texture.Name] = texture
+	}
+
+	if interlaced := textures["interlaced"]; interlaced.Error != "" || color.GrayModel.Convert(interlaced.Image.At(0, 1)).(color.Gray).Y != 0x30 {
+		t.Errorf("unexpected interlaced texture: %+v", interlaced)
+	}
+
+	if !strings.HasPrefix(textures["huge"].Error, "image too large: 30000x30000") || textures["wall"].Image == nil {
+		t.Errorf("unexpected textures: %+v", textures)
+	}
+
+	cmyk := image.NewCMYK(image.Rect(0, 0, 2, 2))
+	cmyk.SetCMYK(0, 0, color.CMYK{C: 255})
+
+	decoder := Decoder{Name: "test", Decode: func(io.Reader) (image.Image, error) { return cmyk, nil }}
+
+	if img, _, err := decodeLimited(decoder, nil, ScanOptions{}); err != nil || img.ColorModel() != color.NRGBAModel || img.At(0, 0) != (color.NRGBA{0, 255, 255, 255}) {
+		t.Errorf("CMYK image decoded as %T, %v", img, err)
+	}
+
+	decoder.Decode = func(io.Reader) (image.Image, error) { panic("index out of range") }
+
+	if _, _, err := decodeLimited(decoder, nil, ScanOptions{}); err == nil || err.Error() != "test decoder failed: index out of range" {
+		t.Errorf("panic reported as %v", err)
+	}
+
+	// A decoder that never returns is given up on.
+	blocked := make(chan struct{})
+	defer close(blocked)
+
+	decoder.Decode = func(io.Reader) (image.Image, error) {
+		<-blocked
+
+		return cmyk, nil
+	}
+
+	if _, _, err := decodeLimited(decoder, nil, ScanOptions{DecodeTimeout: 10 * time.Millisecond}); err == nil || err.Error() != "test decoder timed out after 10ms" {
+		t.Errorf("blocked decoder reported as %v", err)
+	}
+}
+
+// waitRunaways waits for the decoders that timed out to return.
+func waitRunaways(t *testing.T) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); runawayDecodes.Load() != 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d runaway decoders still counted", runawayDecodes.Load())
+		}
+	}
+}
+
+// TestDecodeRunaways checks that decoders left running after timing out are capped, and counted
+// until they actually return.
+func TestDecodeRunaways(t *testing.T) {
+	blocked := make(chan struct{})
+	decoder := Decoder{Name: "test", Decode: func(io.Reader) (image.Image, error) {
+		<-blocked
+
+		return image.NewGray(image.Rect(0, 0, 1, 1)), nil
+	}}
+
+	options := ScanOptions{DecodeTimeout: time.Millisecond}
+	processors := runtime.GOMAXPROCS(0)
+	waitRunaways(t)
+
+	for i := 0; i < processors; i++ {
+		if _, _, err := decodeLimited(decoder, nil, options); err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Fatalf("decode %d: %v", i, err)
+		}
+	}
+
+	if _, _, err := decodeLimited(decoder, nil, options); err == nil || !strings.Contains(err.Error(), "not started") {
+		t.Errorf("decode past the cap: %v", err)
+	}
+
+	close(blocked)
+	waitRunaways(t)
+
+	if _, _, err := decodeLimited(decoder, nil, ScanOptions{DecodeTimeout: time.Minute}); err != nil {
+		t.Errorf("decode once the runaways returned: %v", err)
+	}
+}
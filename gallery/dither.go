@@ -0,0 +1,117 @@
+package gallery
+
+/**
+ * Engine view
+ *
+ * The classic renderer draws 8-bit textures with the palette of their family, so a truecolor
+ * source ends up reduced to those 256 colors. With RenderOptions.EngineView, the tiles of the
+ * truecolor textures of families having a full.pcx palette get a second thumbnail, reduced to
+ * that palette with ordered (Bayer) dithering, which a button of the page shows instead of the
+ * source thumbnails. Textures already paletted are left alone: they are drawn as they are.
+ */
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strconv"
+)
+
+// bayerMatrix holds the thresholds of 4x4 ordered dithering, from 0 to 15.
+var bayerMatrix = [4][4]int{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// ditherSpread is how far, per channel, the thresholds move colors before they are matched with
+// the palette: about the gap between neighbouring colors of a 256-color palette.
+const ditherSpread = 32
+
+// parsePalette returns the colors of a Family.Palette, skipping malformed entries.
+func parsePalette(colors []string) color.Palette {
+	var palette color.Palette
+
+	for _, hexColor := range colors {
+		if len(hexColor) != 7 || hexColor[0] != '#' {
+			continue
+		}
+
+		value, err := strconv.ParseUint(hexColor[1:], 16, 32)
+
+		if err != nil {
+			continue
+		}
+
+		palette = append(palette, color.RGBA{uint8(value >> 16), uint8(value >> 8), uint8(value), 255})
+	}
+
+	return palette
+}
+
+// Dither returns img, drawn over background, reduced to palette with ordered dithering.
+func Dither(img image.Image, palette color.Palette, background color.Color) *image.Paletted {
+	bounds := img.Bounds()
+	flattened := image.NewRGBA(bounds)
+	draw.Draw(flattened, bounds, &image.Uniform{background}, image.Point{}, draw.Src)
+	draw.Draw(flattened, bounds, img, bounds.Min, draw.Over)
+
+	paletted := image.NewPaletted(bounds, palette)
+	indices := make(map[color.RGBA]uint8)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			offset := (bayerMatrix[y&3][x&3]*2 - 15) * ditherSpread / 32
+			pixel := flattened.RGBAAt(x, y)
+			shifted := color.RGBA{shiftChannel(pixel.R, offset), shiftChannel(pixel.G, offset), shiftChannel(pixel.B, offset), 255}
+			index, ok := indices[shifted]
+
+			if !ok {
+				index = uint8(palette.Index(shifted))
+				indices[shifted] = index
+			}
+
+			paletted.SetColorIndex(x, y, index)
+		}
+	}
+
+	return paletted
+}
+
+func shiftChannel(value uint8, offset int) uint8 {
+	return uint8(max(0, min(255, int(value)+offset)))
+}
+
+// renderEngineView returns the element showing the thumbnail of texture dithered to the palette of
+// its family, or nothing when there is none to show.
+func renderEngineView(texture Texture, thumbnail image.Image, options RenderOptions) (string, error) {
+	if !options.EngineView || options.NoJS || texture.Palette > 0 || len(options.palette) == 0 || len(options.palette) > paletteLimit {
+		return "", nil
+	}
+
+	buffer := new(bytes.Buffer)
+
+	if err := png.Encode(buffer, Dither(thumbnail, options.palette, options.Background)); err != nil {
+		return "", err
+	}
+
+	url := "data:image/png;base64," + base64.StdEncoding.EncodeToString(buffer.Bytes())
+
+	if options.Asset != nil && buffer.Len() >= options.InlineBelow {
+		assetURL, err := options.Asset(texture.Family, texture.File+".engine.png", buffer.Bytes())
+
+		if err != nil {
+			return "", err
+		}
+
+		url = assetURL
+	}
+
+	return fmt.Sprintf("<img class='engine' src='%s' alt=''>", html.EscapeString(url)), nil
+}
@@ -0,0 +1,80 @@
+package gallery
+
+/**
+ * External commands
+ *
+ * With ScanOptions.Exec, every texture goes through a user command before it is decoded, such
+ * as a converter or an optimizer: the command gets the texture extracted to a temporary file and
+ * writes the image the gallery shows instead. The size, hash and format of the texture stay
+ * those of the original file, the pixels and dimensions are the command's. The upscaler hook
+ * shares the way commands are run: through the shell, with the paths quoted. Only paths of files
+ * crf2html names itself make it into command lines, never the names of a source.
+ */
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ShellCommand returns the command running commandLine through the shell of the system: sh, or
+// cmd on Windows.
+func ShellCommand(commandLine string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", commandLine)
+	}
+
+	return exec.Command("sh", "-c", commandLine)
+}
+
+// quotePath quotes a path for both sh and cmd. Temporary paths hold no quotes.
+func quotePath(filePath string) string {
+	return `"` + filePath + `"`
+}
+
+// execExtension returns extension if it is a dot and letters or digits only, nothing otherwise.
+func execExtension(extension string) string {
+	if len(extension) < 2 || strings.Trim(extension[1:], "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789") != "" {
+		return ""
+	}
+
+	return extension
+}
+
+// execTexture runs command on the file of candidate, with {path} replaced by the path of the
+// extracted file, named in.<extension>, and {out} by the path of the image the command writes, and returns that image
+// file.
+func execTexture(command string, candidate Candidate) ([]byte, error) {
+	directoryPath, err := os.MkdirTemp("", "crf2html-exec-")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(directoryPath)
+
+	// The file keeps its extension, for commands going by it, but not its name: names come from
+	// the source, and could hold quotes and shell syntax.
+	inputPath, outputPath := filepath.Join(directoryPath, "in"+execExtension(candidate.Extension)), filepath.Join(directoryPath, "out.png")
+
+	if err := os.WriteFile(inputPath, candidate.Data, 0644); err != nil {
+		return nil, err
+	}
+
+	commandLine := strings.NewReplacer("{path}", quotePath(inputPath), "{out}", quotePath(outputPath)).Replace(command)
+
+	if output, err := ShellCommand(commandLine).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("exec: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	data, err := os.ReadFile(outputPath)
+
+	if err != nil {
+		return nil, fmt.Errorf("exec: the command wrote no {out} file")
+	}
+
+	return data, nil
+}
@@ -0,0 +1,149 @@
+package gallery
+
+/**
+ * Family index
+ *
+ * A gallery split into pages opens with an index of all its families: a card per family, showing
+ * the thumbnail of a representative texture, the name and the texture count of the family, and
+ * linking to the page and section holding it. The representative texture is the one named after
+ * the family, such as brick/brick.pcx, or else the largest one, which usually shows the material
+ * best.
+ */
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"image"
+	"image/draw"
+	"strings"
+)
+
+// FamilyCard is the entry of a family in the index of a gallery split into pages.
+type FamilyCard struct {
+	Family   string
+	Page     int
+	Textures int
+	// Texture is the representative texture of the family, unless HasTexture is false.
+	Texture    Texture
+	HasTexture bool
+}
+
+// RepresentativeTexture returns the texture standing for family: the one named after it, or else
+// the one of the most pixels, then of the largest file. Broken textures are never picked.
+func RepresentativeTexture(family Family) (Texture, bool) {
+	var best Texture
+	found := false
+
+	for _, texture := range family.Textures {
+		if texture.Error != "" {
+			continue
+		}
+
+		if strings.EqualFold(texture.Name, family.Name) {
+			return texture, true
+		}
+
+		pixels, bestPixels := texture.Width*texture.Height, best.Width*best.Height
+
+		if !found || pixels > bestPixels || pixels == bestPixels && texture.Size > best.Size {
+			best, found = texture, true
+		}
+	}
+
+	return best, found
+}
+
+// FamilyCards returns the cards of the families of pages, in page order.
+func FamilyCards(pages []*Inventory) []FamilyCard {
+	var cards []FamilyCard
+
+	for page, inventory := range pages {
+		for _, family := range inventory.Families {
+			texture, ok := RepresentativeTexture(family)
+			cards = append(cards, FamilyCard{Family: family.Name, Page: page, Textures: len(family.Textures), Texture: texture, HasTexture: ok})
+		}
+	}
+
+	return cards
+}
+
+// familyAnchor returns the id of the section of family.
+func familyAnchor(family string) string {
+	return "family-" + strings.ReplaceAll(family, " ", "_")
+}
+
+// renderFamilyIndex renders the index of options.FamilyCards, on the first page of a gallery
+// split into pages only.
+func renderFamilyIndex(options RenderOptions) (string, error) {
+	if len(options.Pages) < 2 || options.PageIndex != 0 || len(options.FamilyCards) == 0 {
+		return "", nil
+	}
+
+	var cards []string
+
+	for _, card := range options.FamilyCards {
+		imageHTML := "<span class='image placeholder'></span>"
+
+		if card.HasTexture {
+			url, err := renderCardImage(card.Texture, options)
+
+			if err != nil {
+				return "", err
+			}
+
+			imageHTML = fmt.Sprintf("<img src='%s' alt=''>", html.EscapeString(url))
+		}
+
+		name := card.Family
+
+		if label, ok := options.FamilyLabels[card.Family]; ok {
+			name = label
+		}
+
+		href := ""
+
+		if card.Page != options.PageIndex && card.Page < len(options.Pages) {
+			href = options.Pages[card.Page]
+		}
+
+		cards = append(cards, fmt.Sprintf("<a class='family-card' href='%s#%s'>%s<span class='name'>%s</span> <span class='badge count'>%d</span></a>", html.EscapeString(href), html.EscapeString(familyAnchor(card.Family)), imageHTML, html.EscapeString(name), card.Textures))
+	}
+
+	return fmt.Sprintf("<nav class='family-index'>%s</nav>", strings.Join(cards, "")), nil
+}
+
+// renderCardImage returns the URL of the thumbnail of texture on its card: an asset if
+// options.Asset is set and the thumbnail not under options.InlineBelow bytes, a data URI
+// otherwise.
+func renderCardImage(texture Texture, options RenderOptions) (string, error) {
+	img, err := texture.LoadImage()
+
+	if err != nil {
+		return "", err
+	}
+
+	thumbnail := Thumbnail(img, options.ThumbnailSize)
+	flattened := image.NewRGBA(thumbnail.Bounds())
+	draw.Draw(flattened, flattened.Bounds(), &image.Uniform{options.Background}, image.Point{}, draw.Src)
+	draw.Draw(flattened, flattened.Bounds(), thumbnail, thumbnail.Bounds().Min, draw.Over)
+
+	jpegOptions := JPEGDefaults("." + texture.Format)
+
+	if options.JPEGQuality != 0 {
+		jpegOptions.Quality = options.JPEGQuality
+	}
+
+	buffer := new(bytes.Buffer)
+
+	if err := EncodeJPEG(buffer, flattened, jpegOptions); err != nil {
+		return "", err
+	}
+
+	if options.Asset != nil && buffer.Len() >= options.InlineBelow {
+		return options.Asset(texture.Family, texture.File+".card.jpg", buffer.Bytes())
+	}
+
+	return "data:image/jpg;base64," + base64.StdEncoding.EncodeToString(buffer.Bytes()), nil
+}
@@ -0,0 +1,90 @@
+package gallery
+
+/**
+ * Thumbnail format negotiation
+ *
+ * Picks the output codec per thumbnail: PNG for images with transparency or few colors, where it
+ * is both lossless and small, and JPEG for photographic content.
+ */
+
+import (
+	"image"
+	"image/color"
+)
+
+const paletteLimit = 256
+
+// ChooseThumbnailFormat returns "png" for thumbnails with transparency or at most 256 colors and
+// "jpeg" for everything else.
+func ChooseThumbnailFormat(img image.Image) string {
+	if hasTransparency(img) || len(thumbnailColors(img, paletteLimit)) <= paletteLimit {
+		return "png"
+	}
+
+	return "jpeg"
+}
+
+func hasTransparency(img image.Image) bool {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return !opaque.Opaque()
+	}
+
+	bounds := img.Bounds()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// thumbnailColors collects the distinct colors of img, stopping once more than limit are found.
+func thumbnailColors(img image.Image, limit int) []color.Color {
+	bounds := img.Bounds()
+	seen := make(map[color.NRGBA]bool)
+	var colors []color.Color
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+
+			if seen[c] {
+				continue
+			}
+
+			seen[c] = true
+			colors = append(colors, c)
+
+			if len(colors) > limit {
+				return colors
+			}
+		}
+	}
+
+	return colors
+}
+
+// Palettize converts an image with at most 256 colors to a paletted image, which the PNG encoder
+// stores with one byte per pixel. Images with more colors are returned unchanged.
+func Palettize(img image.Image) image.Image {
+	colors := thumbnailColors(img, paletteLimit)
+
+	if len(colors) > paletteLimit {
+		return img
+	}
+
+	bounds := img.Bounds()
+	paletted := image.NewPaletted(bounds, colors)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			paletted.Set(x, y, img.At(x, y))
+		}
+	}
+
+	return paletted
+}
@@ -0,0 +1,155 @@
+package gallery
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/ftrvxmtrx/tga"
+	"github.com/samuel/go-pcx/pcx"
+)
+
+// Fuzz targets for the input of community archives: the CRF reader, the paths of their entries
+// and the decoders. The seeds run with go test; go test -fuzz=FuzzName explores further.
+
+// fuzzPixels caps the images the fuzzed decoders build, as the scan does with MaxPixels.
+const fuzzPixels = 1 << 20
+
+// rootArchive returns a ZIP file holding a texture at its root, next to a family directory.
+func rootArchive(t testing.TB) []byte {
+	archive := new(bytes.Buffer)
+	writer := zip.NewWriter(archive)
+
+	for _, name := range []string{"wall.png", "brick/wall.png"} {
+		entry, err := writer.Create(name)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entry.Write(encodePNG(t, 4, 4))
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return archive.Bytes()
+}
+
+func FuzzRecoverArchive(f *testing.F) {
+	f.Add(brokenArchive(f))
+	f.Add(rootArchive(f))
+	f.Add([]byte("PK\x03\x04"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader, _, err := RecoverArchive(data)
+
+		if err != nil {
+			return
+		}
+
+		for _, file := range reader.File {
+			if !fs.ValidPath(file.Name) {
+				t.Errorf("invalid entry name %q", file.Name)
+			}
+		}
+	})
+}
+
+func FuzzScanArchive(f *testing.F) {
+	f.Add(rootArchive(f))
+
+	pack := new(bytes.Buffer)
+
+	if err := WritePack(pack, []PackEntry{{"brick/wall.png", encodePNG(f, 4, 4)}, {"brick/full.pcx", pcxFixture(f, 4, 4, true)}, {"brick/wall.mtl", []byte("texture wall")}}, 9); err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(pack.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+
+		if err != nil {
+			return
+		}
+
+		options := DefaultScanOptions()
+		options.MaxPixels = fuzzPixels
+		inventory, err := ScanFS(reader, "fuzz.crf", options)
+
+		if err != nil {
+			return
+		}
+
+		for _, family := range inventory.Families {
+			if family.Name == "" {
+				t.Errorf("family without a name: %+v", family)
+			}
+		}
+	})
+}
+
+func FuzzEntryPath(f *testing.F) {
+	for _, name := range []string{"brick/wall.png", "wall.png", "../wall.png", "/brick\\wall.png", "a//b/", "", "."} {
+		f.Add(name)
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		if cleaned := cleanEntryName(name); cleaned != "" && !fs.ValidPath(cleaned) {
+			t.Errorf("cleanEntryName(%q) = %q, not a valid path", name, cleaned)
+		}
+
+		if !fs.ValidPath(name) {
+			return
+		}
+
+		_, family, file := scanPath(name, "")
+
+		if family == "" && file != strings.ToLower(name) {
+			t.Errorf("scanPath(%q) = %q, %q", name, family, file)
+		}
+	})
+}
+
+func FuzzDecoders(f *testing.F) {
+	f.Add(encodePNG(f, 4, 4))
+	f.Add(pcxFixture(f, 8, 8, true))
+	f.Add(pcxFixture(f, 8, 8, false))
+	f.Add(ddsFile("DXT1", 4, 4, dxt1Block(0xf800)))
+	f.Add(tgaFile(0x10))
+	f.Add([]byte("8BPS\x00\x01\x00\x00\x00\x00\x00\x00\x00\x03\x00\x00\x00\x02\x00\x00\x00\x02\x00\x08\x00\x03"))
+	f.Add([]byte("FORM\x00\x00\x00\x20ILBMBMHD\x00\x00\x00\x14\x00\x02\x00\x02\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x01\x01\x00\x02\x00\x02"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, decoder := range []Decoder{
+			{Name: "pcx", Decode: DecodePCX, DecodeConfig: pcx.DecodeConfig},
+			{Name: "tga", Decode: DecodeTGA, DecodeConfig: tga.DecodeConfig},
+			{Name: "psd", Decode: DecodePSD, DecodeConfig: DecodePSDConfig},
+			{Name: "ilbm", Decode: DecodeILBM, DecodeConfig: DecodeILBMConfig},
+			{Name: "dds", Decode: DecodeDDS, DecodeConfig: DecodeDDSConfig, DecodePreview: DecodeDDSPreview},
+		} {
+			config, err := decoder.DecodeConfig(bytes.NewReader(data))
+
+			if err != nil || int64(config.Width)*int64(config.Height) > fuzzPixels {
+				continue
+			}
+
+			img, err := decoder.Decode(bytes.NewReader(data))
+
+			if err == nil && img == nil {
+				t.Errorf("%s: no image and no error", decoder.Name)
+			}
+
+			if decoder.DecodePreview != nil {
+				decoder.DecodePreview(bytes.NewReader(data), 16)
+			}
+		}
+
+		MissionTextures(data)
+		ModelTextures(data)
+	})
+}
@@ -0,0 +1,362 @@
+(function () {
+  var search = document.getElementById('search');
+  var density = document.getElementById('density');
+  var engineView = document.getElementById('engine-view');
+  var filters = document.querySelectorAll('select.filter');
+  var lightbox = document.getElementById('lightbox');
+  var lightboxImage = lightbox.querySelector('img');
+  var slideshow = document.getElementById('slideshow');
+  var slideshowImage = slideshow.querySelector('img');
+  var slideshowCaption = slideshow.querySelector('.slideshow-caption');
+  var current = null;
+  var slides = [];
+  var slideIndex = 0;
+  var slideInterval = 5;
+  var slideTimer = null;
+  var slidePaused = false;
+
+  // Identical thumbnails are only inlined in the first tile showing them.
+  document.querySelectorAll('img[data-thumb]:not([src])').forEach(function (image) {
+    var source = document.querySelector('img[data-thumb="' + image.dataset.thumb + '"][src]');
+
+    if (source) {
+      image.src = source.src;
+    }
+  });
+
+  function visibleTiles() {
+    return Array.prototype.filter.call(document.querySelectorAll('.texture'), function (tile) {
+      return tile.offsetParent !== null;
+    });
+  }
+
+  function focusTile(tile) {
+    if (tile) {
+      current = tile;
+      tile.focus();
+      tile.scrollIntoView({ block: 'nearest' });
+
+      if (!lightbox.hidden) {
+        openLightbox(tile);
+      }
+    }
+  }
+
+  function verticalNeighbour(tile, direction) {
+    var rect = tile.getBoundingClientRect();
+    var center = rect.left + rect.width / 2;
+    var best = null;
+    var bestRow = null;
+    var bestDistance = Infinity;
+
+    visibleTiles().forEach(function (candidate) {
+      var other = candidate.getBoundingClientRect();
+      var row = other.top;
+
+      if ((direction > 0 && row <= rect.top + 1) || (direction < 0 && row >= rect.top - 1)) {
+        return;
+      }
+
+      if (bestRow !== null && (direction > 0 ? row > bestRow + 1 : row < bestRow - 1)) {
+        return;
+      }
+
+      var distance = Math.abs(other.left + other.width / 2 - center);
+
+      if (bestRow === null || Math.abs(row - bestRow) > 1 || distance < bestDistance) {
+        best = candidate;
+        bestRow = row;
+        bestDistance = distance;
+      }
+    });
+
+    return best;
+  }
+
+  // In engine view, tiles show their dithered thumbnail when they have one.
+  function tileImage(tile) {
+    return (document.body.classList.contains('engine-view') && tile.querySelector('img.engine')) || tile.querySelector('img');
+  }
+
+  function openLightbox(tile) {
+    var image = tileImage(tile);
+
+    if (!image) {
+      return;
+    }
+
+    // The upscaled preview, when there is one, replaces the thumbnail, but not the dithered one.
+    lightboxImage.src = (image.classList.contains('engine') ? '' : tile.dataset.upscale) || image.currentSrc || image.src;
+    lightbox.hidden = false;
+  }
+
+  // Scales the slide by the largest integer factor fitting the screen, so texels stay square and
+  // sharp. Images larger than the screen are scaled down to fit instead.
+  function fitSlide() {
+    var width = slideshowImage.naturalWidth;
+    var height = slideshowImage.naturalHeight;
+    var scale = Math.min(slideshow.clientWidth / width, (slideshow.clientHeight - 48) / height);
+
+    if (scale >= 1) {
+      scale = Math.floor(scale);
+    }
+
+    slideshowImage.style.width = width * scale + 'px';
+    slideshowImage.style.height = height * scale + 'px';
+  }
+
+  function showSlide(index) {
+    slideIndex = (index + slides.length) % slides.length;
+
+    var tile = slides[slideIndex];
+    var image = tileImage(tile);
+
+    slideshowImage.src = image.currentSrc || image.src;
+    slideshowCaption.textContent = tile.querySelector('.filename').textContent + ' (' + (slideIndex + 1) + '/' + slides.length + ', ' + (slidePaused ? 'paused' : slideInterval + ' s') + ')';
+
+    clearTimeout(slideTimer);
+
+    if (!slidePaused) {
+      slideTimer = setTimeout(function () {
+        showSlide(slideIndex + 1);
+      }, slideInterval * 1000);
+    }
+  }
+
+  function startSlideshow(tiles, first) {
+    slides = tiles.filter(function (tile) {
+      return tile.querySelector('img') !== null;
+    });
+
+    if (slides.length === 0) {
+      return;
+    }
+
+    slidePaused = false;
+    slideshow.hidden = false;
+
+    if (slideshow.requestFullscreen) {
+      slideshow.requestFullscreen().catch(function () {
+        // Full screen may be refused; the overlay already covers the window.
+      });
+    }
+
+    showSlide(Math.max(0, slides.indexOf(first)));
+  }
+
+  function stopSlideshow() {
+    clearTimeout(slideTimer);
+    slideshow.hidden = true;
+
+    if (document.fullscreenElement) {
+      document.exitFullscreen();
+    }
+  }
+
+  function slideshowKey(event) {
+    switch (event.key) {
+      case 'ArrowRight':
+        showSlide(slideIndex + 1);
+        break;
+      case 'ArrowLeft':
+        showSlide(slideIndex - 1);
+        break;
+      case ' ':
+        slidePaused = !slidePaused;
+        showSlide(slideIndex);
+        break;
+      case '+':
+        slideInterval = Math.min(60, slideInterval + 1);
+        showSlide(slideIndex);
+        break;
+      case '-':
+        slideInterval = Math.max(1, slideInterval - 1);
+        showSlide(slideIndex);
+        break;
+      case 'Escape':
+        stopSlideshow();
+        break;
+      default:
+        return;
+    }
+
+    event.preventDefault();
+  }
+
+  function toggleFamily(section) {
+    if (section) {
+      section.classList.toggle('collapsed');
+    }
+  }
+
+  // Tiles are shown when their name matches the search and their data attributes the value
+  // selected in each filter ("none" for tiles without the attribute).
+  function applySearch() {
+    var query = search.value.trim().toLowerCase();
+
+    document.querySelectorAll('.texture').forEach(function (tile) {
+      var name = tile.querySelector('.filename').textContent.toLowerCase();
+      var hidden = query !== '' && name.indexOf(query) < 0;
+
+      filters.forEach(function (filter) {
+        if (filter.value !== '' && (tile.dataset[filter.dataset.filter] || 'none') !== filter.value) {
+          hidden = true;
+        }
+      });
+
+      tile.classList.toggle('filtered', hidden);
+    });
+
+    document.querySelectorAll('.material, section').forEach(function (group) {
+      group.classList.toggle('filtered', group.querySelector('.texture:not(.filtered)') === null);
+    });
+  }
+
+  function setCompact(compact) {
+    document.body.classList.toggle('compact', compact);
+    density.textContent = compact ? 'Comfortable' : 'Compact';
+
+    try {
+      localStorage.setItem('crf2html-density', compact ? 'compact' : 'comfortable');
+    } catch (error) {
+      // Storage is unavailable for file:// pages in some browsers; the choice just is not kept.
+    }
+  }
+
+  try {
+    setCompact(localStorage.getItem('crf2html-density') === 'compact');
+  } catch (error) {
+    setCompact(false);
+  }
+
+  search.addEventListener('input', applySearch);
+
+  filters.forEach(function (filter) {
+    filter.addEventListener('change', applySearch);
+  });
+
+  density.addEventListener('click', function () {
+    setCompact(!document.body.classList.contains('compact'));
+  });
+
+  if (engineView) {
+    engineView.addEventListener('click', function () {
+      var engine = document.body.classList.toggle('engine-view');
+
+      engineView.textContent = engine ? 'Source view' : 'Engine view';
+    });
+  }
+
+  lightbox.addEventListener('click', function () {
+    lightbox.hidden = true;
+  });
+
+  slideshowImage.addEventListener('load', fitSlide);
+  slideshow.addEventListener('click', stopSlideshow);
+
+  document.getElementById('slideshow-start').addEventListener('click', function () {
+    startSlideshow(visibleTiles(), current);
+  });
+
+  // Leaving full screen (Escape is handled by the browser there) ends the slideshow.
+  document.addEventListener('fullscreenchange', function () {
+    if (!document.fullscreenElement && !slideshow.hidden) {
+      stopSlideshow();
+    }
+  });
+
+  document.querySelectorAll('section h2').forEach(function (heading) {
+    heading.addEventListener('click', function (event) {
+      if (event.target.closest('a')) {
+        return;
+      }
+
+      toggleFamily(heading.parentNode);
+    });
+  });
+
+  // The slider of a modified texture uncovers its previous version on the left.
+  document.querySelectorAll('.slider').forEach(function (slider) {
+    slider.addEventListener('input', function () {
+      slider.previousElementSibling.querySelector('.after').style.clipPath = 'inset(0 0 0 ' + slider.value + '%)';
+    });
+  });
+
+  document.querySelectorAll('.texture').forEach(function (tile) {
+    tile.addEventListener('focus', function () {
+      current = tile;
+    });
+
+    tile.addEventListener('dblclick', function () {
+      openLightbox(tile);
+    });
+  });
+
+  document.addEventListener('keydown', function (event) {
+    if (!slideshow.hidden) {
+      slideshowKey(event);
+
+      return;
+    }
+
+    if (event.target === search) {
+      if (event.key === 'Escape' || event.key === 'Enter') {
+        search.blur();
+        focusTile(visibleTiles()[0]);
+        event.preventDefault();
+      }
+
+      return;
+    }
+
+    if (event.target.tagName === 'SELECT' || event.target.tagName === 'INPUT' || event.ctrlKey || event.metaKey || event.altKey) {
+      return;
+    }
+
+    var tiles = visibleTiles();
+    var index = tiles.indexOf(current);
+
+    switch (event.key) {
+      case 'ArrowRight':
+        focusTile(index < 0 ? tiles[0] : tiles[index + 1]);
+        break;
+      case 'ArrowLeft':
+        focusTile(index < 0 ? tiles[0] : tiles[index - 1]);
+        break;
+      case 'ArrowDown':
+        focusTile(index < 0 ? tiles[0] : verticalNeighbour(current, 1));
+        break;
+      case 'ArrowUp':
+        focusTile(index < 0 ? tiles[0] : verticalNeighbour(current, -1));
+        break;
+      case 'Enter':
+        if (current) {
+          openLightbox(current);
+        }
+        break;
+      case 'Escape':
+        lightbox.hidden = true;
+        break;
+      case '/':
+        search.focus();
+        break;
+      case 'f':
+        toggleFamily(current ? current.closest('section') : null);
+        break;
+      case 's':
+        startSlideshow(tiles, current);
+        break;
+      case 'S':
+        if (current) {
+          startSlideshow(tiles.filter(function (tile) {
+            return tile.closest('section') === current.closest('section');
+          }), current);
+        }
+        break;
+      default:
+        return;
+    }
+
+    event.preventDefault();
+  });
+})();
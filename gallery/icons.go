@@ -0,0 +1,62 @@
+package gallery
+
+/**
+ * Icons
+ *
+ * The icons of the controls and the favicon of the page are SVG files embedded in the binary and
+ * inlined as data URIs, in the stylesheet and the head of the page: a gallery opened from a disk,
+ * a mirror or an offline machine renders the same, and loads nothing the Content Security Policy
+ * would have to allow besides. Text uses the fonts of the system, no web font.
+ */
+
+import (
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+//go:embed icons/*.svg
+var iconFiles embed.FS
+
+// iconSelectors are the elements getting an icon, by the name of its file.
+var iconSelectors = []struct {
+	name     string
+	selector string
+}{
+	{"compact", "#density::before"},
+	{"engine", "#engine-view::before"},
+	{"slideshow", "#slideshow-start::before"},
+	{"download", "h2 .download::before"},
+}
+
+// iconURI returns the icons/<name>.svg file as a data URI.
+func iconURI(name string) string {
+	data, err := iconFiles.ReadFile("icons/" + name + ".svg")
+
+	if err != nil {
+		panic(err)
+	}
+
+	return "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte(strings.TrimSpace(string(data))))
+}
+
+// iconStylesheet returns the rules drawing the icons of the controls before their labels, and in
+// the search box.
+func iconStylesheet() string {
+	var rules []string
+
+	for _, icon := range iconSelectors {
+		rules = append(rules, fmt.Sprintf("%s{background:url(%s) center/contain no-repeat;content:'';display:inline-block;height:12px;margin-right:6px;vertical-align:-1px;width:12px}", icon.selector, iconURI(icon.name)))
+	}
+
+	rules = append(rules, fmt.Sprintf("#search{background:var(--panel) url(%s) 8px center/14px no-repeat;padding-left:28px}", iconURI("search")))
+
+	return strings.Join(rules, "\n\t\t")
+}
+
+// faviconLink returns the element giving the page its favicon, which also keeps browsers from
+// requesting /favicon.ico.
+func faviconLink() string {
+	return fmt.Sprintf("<link rel='icon' type='image/svg+xml' href='%s'>", iconURI("favicon"))
+}
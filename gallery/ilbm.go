@@ -0,0 +1,364 @@
+package gallery
+
+/**
+ * IFF ILBM decoder
+ *
+ * Decodes Deluxe Paint style IFF images: planar ILBM (1-8 bitplanes, EHB, HAM6/HAM8, 24/32-bit)
+ * and chunky PBM, either uncompressed or ByteRun1 compressed. Mask planes and transparent
+ * color keys are turned into alpha.
+ */
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+const (
+	ilbmMaskPlane            = 1
+	ilbmMaskTransparentColor = 2
+
+	ilbmModeHAM = 0x800
+	ilbmModeEHB = 0x80
+)
+
+type ilbmHeader struct {
+	Width            int
+	Height           int
+	Planes           int
+	Masking          int
+	Compression      int
+	TransparentColor int
+}
+
+type ilbmFile struct {
+	Chunky   bool
+	Header   ilbmHeader
+	Palette  []color.RGBA
+	Viewport uint32
+	Body     []byte
+}
+
+func init() {
+	image.RegisterFormat("ilbm", "FORM????ILBM", DecodeILBM, DecodeILBMConfig)
+	image.RegisterFormat("ilbm", "FORM????PBM ", DecodeILBM, DecodeILBMConfig)
+}
+
+func readILBM(reader io.Reader, headerOnly bool) (*ilbmFile, error) {
+	var form [12]byte
+
+	if _, err := io.ReadFull(reader, form[:]); err != nil {
+		return nil, err
+	}
+
+	if string(form[0:4]) != "FORM" || (string(form[8:12]) != "ILBM" && string(form[8:12]) != "PBM ") {
+		return nil, errors.New("ilbm: invalid signature")
+	}
+
+	file := &ilbmFile{Chunky: string(form[8:12]) == "PBM "}
+	hasHeader := false
+
+	for {
+		var chunk [8]byte
+
+		if _, err := io.ReadFull(reader, chunk[:]); err != nil {
+			if err == io.EOF && hasHeader {
+				return nil, errors.New("ilbm: missing BODY chunk")
+			}
+
+			return nil, err
+		}
+
+		id := string(chunk[0:4])
+		size := int64(binary.BigEndian.Uint32(chunk[4:8]))
+		padded := size + size%2
+
+		switch id {
+		case "BMHD", "CMAP", "CAMG", "BODY":
+			if size > 1<<28 {
+				return nil, fmt.Errorf("ilbm: %s chunk too large", id)
+			}
+
+			data := make([]byte, padded)
+
+			if _, err := io.ReadFull(reader, data); err != nil && !(id == "BODY" && err == io.ErrUnexpectedEOF) {
+				return nil, err
+			}
+
+			data = data[:size]
+
+			switch id {
+			case "BMHD":
+				if len(data) < 20 {
+					return nil, errors.New("ilbm: short BMHD chunk")
+				}
+
+				file.Header = ilbmHeader{
+					Width:            int(binary.BigEndian.Uint16(data[0:2])),
+					Height:           int(binary.BigEndian.Uint16(data[2:4])),
+					Planes:           int(data[8]),
+					Masking:          int(data[9]),
+					Compression:      int(data[10]),
+					TransparentColor: int(binary.BigEndian.Uint16(data[12:14])),
+				}
+				hasHeader = true
+
+				if headerOnly {
+					return file, nil
+				}
+			case "CMAP":
+				for i := 0; i+2 < len(data); i += 3 {
+					file.Palette = append(file.Palette, color.RGBA{data[i], data[i+1], data[i+2], 255})
+				}
+			case "CAMG":
+				if len(data) >= 4 {
+					file.Viewport = binary.BigEndian.Uint32(data[0:4])
+				}
+			case "BODY":
+				if !hasHeader {
+					return nil, errors.New("ilbm: BODY before BMHD")
+				}
+
+				file.Body = data
+
+				return file, nil
+			}
+		default:
+			if _, err := io.CopyN(io.Discard, reader, padded); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func DecodeILBMConfig(reader io.Reader) (image.Config, error) {
+	file, err := readILBM(reader, true)
+
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return image.Config{ColorModel: color.NRGBAModel, Width: file.Header.Width, Height: file.Header.Height}, nil
+}
+
+func DecodeILBM(reader io.Reader) (image.Image, error) {
+	file, err := readILBM(reader, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	header := file.Header
+
+	if header.Width <= 0 || header.Height <= 0 {
+		return nil, errors.New("ilbm: invalid dimensions")
+	}
+
+	if header.Compression > 1 {
+		return nil, fmt.Errorf("ilbm: unsupported compression %d", header.Compression)
+	}
+
+	body := &ilbmBody{data: file.Body, compressed: header.Compression == 1}
+	img := image.NewNRGBA(image.Rect(0, 0, header.Width, header.Height))
+
+	if file.Chunky {
+		if header.Planes != 8 {
+			return nil, fmt.Errorf("ilbm: unsupported PBM depth %d", header.Planes)
+		}
+
+		palette := ilbmPalette(file)
+		row := make([]byte, header.Width+header.Width%2)
+
+		for y := 0; y < header.Height; y++ {
+			if err := body.read(row); err != nil {
+				return nil, err
+			}
+
+			for x := 0; x < header.Width; x++ {
+				ilbmSetIndexed(img, x, y, palette[row[x]], int(row[x]), header)
+			}
+		}
+
+		return img, nil
+	}
+
+	if header.Planes < 1 || (header.Planes > 8 && header.Planes != 24 && header.Planes != 32) {
+		return nil, fmt.Errorf("ilbm: unsupported plane count %d", header.Planes)
+	}
+
+	rowBytes := (header.Width + 15) / 16 * 2
+	planeCount := header.Planes
+
+	if header.Masking == ilbmMaskPlane {
+		planeCount++
+	}
+
+	planes := make([][]byte, planeCount)
+
+	for i := range planes {
+		planes[i] = make([]byte, rowBytes)
+	}
+
+	ham := file.Viewport&ilbmModeHAM != 0 && (header.Planes == 6 || header.Planes == 8)
+	palette := ilbmPalette(file)
+
+	for y := 0; y < header.Height; y++ {
+		for _, plane := range planes {
+			if err := body.read(plane); err != nil {
+				return nil, err
+			}
+		}
+
+		var previous color.RGBA
+
+		if ham {
+			previous = palette[0]
+		}
+
+		for x := 0; x < header.Width; x++ {
+			bit := byte(0x80 >> uint(x%8))
+			value := 0
+
+			for p := 0; p < header.Planes; p++ {
+				if planes[p][x/8]&bit != 0 {
+					value |= 1 << uint(p)
+				}
+			}
+
+			switch {
+			case header.Planes >= 24:
+				alpha := uint8(255)
+
+				if header.Planes == 32 {
+					alpha = uint8(value >> 24)
+				}
+
+				img.SetNRGBA(x, y, color.NRGBA{uint8(value), uint8(value >> 8), uint8(value >> 16), alpha})
+			case ham:
+				previous = ilbmHAM(previous, palette, value, header.Planes)
+				ilbmSetIndexed(img, x, y, previous, -1, header)
+			default:
+				ilbmSetIndexed(img, x, y, palette[value], value, header)
+			}
+
+			if header.Masking == ilbmMaskPlane && planes[header.Planes][x/8]&bit == 0 {
+				img.Pix[img.PixOffset(x, y)+3] = 0
+			}
+		}
+	}
+
+	return img, nil
+}
+
+// ilbmPalette returns the CMAP colors padded to 256 entries, extended for Extra Half-Brite images.
+func ilbmPalette(file *ilbmFile) []color.RGBA {
+	palette := make([]color.RGBA, 256)
+
+	for i := range palette {
+		palette[i] = color.RGBA{uint8(i), uint8(i), uint8(i), 255}
+	}
+
+	copy(palette, file.Palette)
+
+	if file.Viewport&ilbmModeEHB != 0 && file.Header.Planes == 6 {
+		for i := 0; i < 32; i++ {
+			base := palette[i]
+			palette[32+i] = color.RGBA{base.R / 2, base.G / 2, base.B / 2, 255}
+		}
+	}
+
+	return palette
+}
+
+// ilbmHAM applies one Hold-And-Modify pixel: the top two bits select whether the low bits index
+// the palette or replace the blue, red or green component of the previous pixel.
+func ilbmHAM(previous color.RGBA, palette []color.RGBA, value int, planes int) color.RGBA {
+	shift := uint(planes - 2)
+	control := value >> shift
+	data := value & (1<<shift - 1)
+
+	component := uint8(data << (8 - shift))
+	component |= component >> shift
+
+	switch control {
+	case 0:
+		return palette[data]
+	case 1:
+		previous.B = component
+	case 2:
+		previous.R = component
+	case 3:
+		previous.G = component
+	}
+
+	return previous
+}
+
+func ilbmSetIndexed(img *image.NRGBA, x int, y int, c color.RGBA, index int, header ilbmHeader) {
+	alpha := uint8(255)
+
+	if header.Masking == ilbmMaskTransparentColor && index == header.TransparentColor {
+		alpha = 0
+	}
+
+	img.SetNRGBA(x, y, color.NRGBA{c.R, c.G, c.B, alpha})
+}
+
+// ilbmBody reads BODY rows, expanding ByteRun1 runs when the image is compressed.
+type ilbmBody struct {
+	data       []byte
+	compressed bool
+}
+
+func (body *ilbmBody) read(row []byte) error {
+	if !body.compressed {
+		if len(body.data) < len(row) {
+			return io.ErrUnexpectedEOF
+		}
+
+		copy(row, body.data)
+		body.data = body.data[len(row):]
+
+		return nil
+	}
+
+	for n := 0; n < len(row); {
+		if len(body.data) == 0 {
+			return io.ErrUnexpectedEOF
+		}
+
+		control := int(int8(body.data[0]))
+		body.data = body.data[1:]
+
+		switch {
+		case control >= 0:
+			count := control + 1
+
+			if count > len(body.data) || n+count > len(row) {
+				return errors.New("ilbm: corrupt ByteRun1 data")
+			}
+
+			copy(row[n:], body.data[:count])
+			body.data = body.data[count:]
+			n += count
+		case control > -128:
+			count := 1 - control
+
+			if len(body.data) == 0 || n+count > len(row) {
+				return errors.New("ilbm: corrupt ByteRun1 data")
+			}
+
+			for i := 0; i < count; i++ {
+				row[n+i] = body.data[0]
+			}
+
+			body.data = body.data[1:]
+			n += count
+		}
+	}
+
+	return nil
+}
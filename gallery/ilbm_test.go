@@ -0,0 +1,156 @@
+package gallery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+// ilbmFixture returns an ILBM file of planes bitplanes, 16 pixels wide, with the CAMG viewport
+// mode, the colors of palette and body as BODY, ByteRun1 compressed if compressed.
+func ilbmFixture(planes int, height int, viewport uint32, palette []color.RGBA, body []byte, compressed bool) []byte {
+	chunks := new(bytes.Buffer)
+	chunk := func(id string, data []byte) {
+		chunks.WriteString(id)
+		binary.Write(chunks, binary.BigEndian, uint32(len(data)))
+		chunks.Write(data)
+
+		if len(data)%2 == 1 {
+			chunks.WriteByte(0)
+		}
+	}
+
+	header := make([]byte, 20)
+	binary.BigEndian.PutUint16(header[0:], 16)
+	binary.BigEndian.PutUint16(header[2:], uint16(height))
+	header[8] = byte(planes)
+
+	if compressed {
+		header[10] = 1
+	}
+
+	chunk("BMHD", header)
+
+	var colors []byte
+
+	for _, paletteColor := range palette {
+		colors = append(colors, paletteColor.R, paletteColor.G, paletteColor.B)
+	}
+
+	chunk("CMAP", colors)
+	chunk("CAMG", binary.BigEndian.AppendUint32(nil, viewport))
+	chunk("BODY", body)
+
+	file := new(bytes.Buffer)
+	file.WriteString("FORM")
+	binary.Write(file, binary.BigEndian, uint32(4+chunks.Len()))
+	file.WriteString("ILBM")
+	file.Write(chunks.Bytes())
+
+	return file.Bytes()
+}
+
+// ilbmRow returns the uncompressed planes of a row of 16 pixels of values.
+func ilbmRow(planes int, values []int) []byte {
+	var row []byte
+
+	for plane := 0; plane < planes; plane++ {
+		bits := make([]byte, 2)
+
+		for x, value := range values {
+			if value>>uint(plane)&1 != 0 {
+				bits[x/8] |= 0x80 >> uint(x%8)
+			}
+		}
+
+		row = append(row, bits...)
+	}
+
+	return row
+}
+
+// ilbmPixels returns the first count pixels of the first row of the decoded file.
+func ilbmPixels(t *testing.T, file []byte, count int) []color.NRGBA {
+	t.Helper()
+	img, err := DecodeILBM(bytes.NewReader(file))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pixels []color.NRGBA
+
+	for x := 0; x < count; x++ {
+		pixels = append(pixels, img.At(x, 0).(color.NRGBA))
+	}
+
+	return pixels
+}
+
+func TestDecodeILBMByteRun1(t *testing.T) {
+	palette := []color.RGBA{{0, 0, 0, 255}, {255, 255, 255, 255}}
+	// A literal run of two bytes for the first row, a repeated byte for the second, and a no-op.
+	packed := []byte{0x01, 0xf0, 0x0f, 0xff, 0xaa, 0x80}
+	raw := []byte{0xf0, 0x0f, 0xaa, 0xaa}
+
+	compressed, err := DecodeILBM(bytes.NewReader(ilbmFixture(1, 2, 0, palette, packed, true)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uncompressed, err := DecodeILBM(bytes.NewReader(ilbmFixture(1, 2, 0, palette, raw, false)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 16; x++ {
+			if compressed.At(x, y) != uncompressed.At(x, y) {
+				t.Fatalf("pixel %d,%d is %v compressed, %v uncompressed", x, y, compressed.At(x, y), uncompressed.At(x, y))
+			}
+		}
+	}
+
+	if white, black := compressed.At(0, 0).(color.NRGBA), compressed.At(4, 0).(color.NRGBA); white.R != 255 || black.R != 0 {
+		t.Errorf("first row starts with %v and %v, want white then black", white, black)
+	}
+
+	if _, err := DecodeILBM(bytes.NewReader(ilbmFixture(1, 2, 0, palette, packed[:4], true))); err == nil {
+		t.Error("truncated ByteRun1 body accepted")
+	}
+}
+
+func TestDecodeILBMExtraHalfBrite(t *testing.T) {
+	palette := make([]color.RGBA, 32)
+	palette[1] = color.RGBA{200, 100, 50, 255}
+	values := []int{1, 33, 0}
+	pixels := ilbmPixels(t, ilbmFixture(6, 1, ilbmModeEHB, palette, ilbmRow(6, values), false), 3)
+
+	if expected := []color.NRGBA{{200, 100, 50, 255}, {100, 50, 25, 255}, {0, 0, 0, 255}}; !slices.Equal(pixels, expected) {
+		t.Errorf("EHB pixels %v, want %v", pixels, expected)
+	}
+}
+
+func TestDecodeILBMHoldAndModify(t *testing.T) {
+	palette := []color.RGBA{{0, 0, 0, 255}, {16, 32, 48, 255}}
+
+	// HAM6: a palette color, then red, green and blue modified in turn with 4-bit values.
+	values := []int{0<<4 | 1, 2<<4 | 0xf, 3<<4 | 0, 1<<4 | 0x8}
+	pixels := ilbmPixels(t, ilbmFixture(6, 1, ilbmModeHAM, palette, ilbmRow(6, values), false), 4)
+
+	if expected := []color.NRGBA{{16, 32, 48, 255}, {255, 32, 48, 255}, {255, 0, 48, 255}, {255, 0, 0x88, 255}}; !slices.Equal(pixels, expected) {
+		t.Errorf("HAM6 pixels %v, want %v", pixels, expected)
+	}
+
+	// HAM8 modifies with 6-bit values.
+	values = []int{0<<6 | 1, 2<<6 | 0x3f, 1<<6 | 0x20}
+	pixels = ilbmPixels(t, ilbmFixture(8, 1, ilbmModeHAM, palette, ilbmRow(8, values), false), 3)
+
+	if expected := []color.NRGBA{{16, 32, 48, 255}, {255, 32, 48, 255}, {255, 32, 0x82, 255}}; !slices.Equal(pixels, expected) {
+		t.Errorf("HAM8 pixels %v, want %v", pixels, expected)
+	}
+}
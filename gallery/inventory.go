@@ -0,0 +1,830 @@
+package gallery
+
+/**
+ * Texture inventory
+ *
+ * Scan reads a directory or a CRF/ZIP file into an in-memory Inventory: the decoded textures of
+ * each family with their metadata, plus the material files found next to them. Both are read
+ * through fs.FS, and ScanFS accepts any other file system (an embedded or in-memory archive, a
+ * test fixture). Neither Scan nor Render keeps any shared state, so both can run concurrently on
+ * different sources.
+ */
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SourceExtensions marks the layered source formats, shown with a "source" badge.
+var SourceExtensions = map[string]bool{".psd": true}
+
+// MaterialExtensions lists the extensions of the material files gathered next to the textures.
+var MaterialExtensions = map[string]bool{".mtl": true}
+
+// Texture is a decoded texture of an inventory.
+type Texture struct {
+	Family    string `json:"family"`
+	Name      string `json:"name"`
+	File      string `json:"file"`
+	Path      string `json:"path"`
+	Format    string `json:"format"`
+	Extension string `json:"extension"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	MapType   string `json:"map_type,omitempty"`
+	Alpha     string `json:"alpha,omitempty"`
+	// Palette is the number of colors in the palette of paletted textures, zero for the others.
+	Palette int         `json:"palette,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Image   image.Image `json:"-"`
+
+	// PaletteMismatch is the number of entries of the palette of an indexed texture differing
+	// from the palette of its family, which the engine draws it with.
+	PaletteMismatch int `json:"palette_mismatch,omitempty"`
+
+	// Source names the overlay providing the texture, and Shadows the paths of the textures of
+	// earlier sources it replaces. Both are only set by Overlay.
+	Source  string   `json:"source,omitempty"`
+	Shadows []string `json:"shadows,omitempty"`
+
+	// Rating is the review of the texture, set by ApplyRatings.
+	Rating *Rating `json:"rating,omitempty"`
+
+	// EightBit is the texture of a txt directory this one, of the txt16 directory next to it,
+	// replaces; see pairTxt16.
+	EightBit *Texture `json:"eight_bit,omitempty"`
+
+	// Before is the previous version of a modified texture, which the page shows under a
+	// before/after slider.
+	Before *Texture `json:"before,omitempty"`
+
+	spilled *spilledImage
+}
+
+// LoadImage returns the decoded texture: Image, or for a texture spilled by the scan, its source
+// file decoded again. The image is not kept, so the caller holds the only reference.
+func (texture Texture) LoadImage() (image.Image, error) {
+	if texture.Image != nil || texture.spilled == nil {
+		return texture.Image, nil
+	}
+
+	return texture.spilled.load()
+}
+
+// WrongExtension reports whether the detected format disagrees with the file extension, or the
+// file has none.
+func (texture Texture) WrongExtension() bool {
+	return "."+texture.Format != texture.Extension
+}
+
+// Key identifies the texture within its source, as "family/file".
+func (texture Texture) Key() string {
+	return texture.Family + "/" + texture.File
+}
+
+// Family groups the textures sharing a directory.
+type Family struct {
+	Name          string              `json:"name"`
+	Textures      []Texture           `json:"textures"`
+	MaterialFiles map[string][]string `json:"material_files,omitempty"`
+
+	// Description is the text of the description.txt file of the family, shown under its heading.
+	Description string `json:"description,omitempty"`
+
+	// Palette holds the colors of the palette of the family, read from its full.pcx file, as
+	// #rrggbb strings.
+	Palette []string `json:"palette,omitempty"`
+}
+
+// Inventory is the result of a Scan. EmptyFamilies lists the directories without any texture,
+// and after Filter, the families left without any.
+type Inventory struct {
+	Source        string            `json:"source"`
+	Families      []Family          `json:"families"`
+	EmptyFamilies []string          `json:"empty_families,omitempty"`
+	Skipped       []string          `json:"skipped,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	// Incomplete says why the inventory lacks files of the source, for scans stopped early.
+	Incomplete string `json:"incomplete,omitempty"`
+	// OtherFiles lists the files skipped by the rules, family palettes aside, with their size.
+	OtherFiles []OtherFile `json:"other_files,omitempty"`
+	// Decisions holds the verdict of the rules on every file, in scan order.
+	Decisions []Decision `json:"-"`
+}
+
+// OtherFile is a file of a source that is neither a texture nor one of its family: a script, a
+// model, a stray file left by a tool.
+type OtherFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"`
+}
+
+// TextureCount returns the number of textures over all families.
+func (inventory *Inventory) TextureCount() int {
+	count := 0
+
+	for _, family := range inventory.Families {
+		count += len(family.Textures)
+	}
+
+	return count
+}
+
+// FamilyOrders lists the orders of SortFamilies: by name, or from the largest family to the
+// smallest by texture count or by total file size.
+var FamilyOrders = []string{"name", "count", "bytes"}
+
+// SortFamilies puts the families in order, one of FamilyOrders. Ties keep the natural order of
+// the names (see NaturalCompare).
+func (inventory *Inventory) SortFamilies(order string) error {
+	return inventory.SortFamiliesBy(order, NaturalCompare)
+}
+
+// SortFamiliesBy is SortFamilies with the names compared by compare, such as a Collation.
+func (inventory *Inventory) SortFamiliesBy(order string, compare func(a string, b string) int) error {
+	var weight func(family Family) int64
+
+	switch order {
+	case "name":
+		weight = func(Family) int64 { return 0 }
+	case "count":
+		weight = func(family Family) int64 { return int64(len(family.Textures)) }
+	case "bytes":
+		weight = func(family Family) int64 {
+			var size int64
+
+			for _, texture := range family.Textures {
+				size += texture.Size
+			}
+
+			return size
+		}
+	default:
+		return fmt.Errorf("gallery: unknown family order %q", order)
+	}
+
+	sort.SliceStable(inventory.Families, func(i, j int) bool {
+		first, second := inventory.Families[i], inventory.Families[j]
+
+		if weight(first) != weight(second) {
+			return weight(first) > weight(second)
+		}
+
+		return compare(first.Name, second.Name) < 0
+	})
+
+	return nil
+}
+
+// Filter returns a copy of the inventory holding only the textures for which keep returns true.
+func (inventory *Inventory) Filter(keep func(Texture) bool) *Inventory {
+	filtered := *inventory
+	filtered.Families = nil
+	filtered.EmptyFamilies = append([]string(nil), inventory.EmptyFamilies...)
+
+	for _, family := range inventory.Families {
+		var textures []Texture
+
+		for _, texture := range family.Textures {
+			if keep(texture) {
+				textures = append(textures, texture)
+			}
+		}
+
+		if len(textures) > 0 {
+			family.Textures = textures
+			filtered.Families = append(filtered.Families, family)
+		} else {
+			filtered.EmptyFamilies = append(filtered.EmptyFamilies, family.Name)
+		}
+	}
+
+	sort.Strings(filtered.EmptyFamilies)
+
+	return &filtered
+}
+
+// Paginate splits the inventory into pages of at most perPage textures, in family order. A family
+// is never split: one larger than perPage gets a page of its own. The empty families are listed on
+// the first page only. A perPage of zero or less leaves a single page, the inventory itself.
+func (inventory *Inventory) Paginate(perPage int) []*Inventory {
+	if perPage <= 0 || len(inventory.Families) == 0 {
+		return []*Inventory{inventory}
+	}
+
+	var pages []*Inventory
+	count := 0
+
+	for _, family := range inventory.Families {
+		if len(pages) == 0 || (count > 0 && count+len(family.Textures) > perPage) {
+			page := *inventory
+			page.Families = nil
+
+			if len(pages) > 0 {
+				page.EmptyFamilies, page.OtherFiles = nil, nil
+			}
+
+			pages = append(pages, &page)
+			count = 0
+		}
+
+		page := pages[len(pages)-1]
+		page.Families = append(page.Families, family)
+		count += len(family.Textures)
+	}
+
+	return pages
+}
+
+// WriteJSON writes the inventory to writer as indented JSON.
+func (inventory *Inventory) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(inventory)
+}
+
+func FileListing(directoryPath string) ([]string, error) {
+	var files []string
+
+	err := filepath.Walk(directoryPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			files = append(files, filePath)
+		}
+
+		return nil
+	})
+
+	return files, err
+}
+
+// ScanOptions controls how Scan reads a source.
+type ScanOptions struct {
+	// MaxOpenFiles caps the number of source files open at the same time.
+	MaxOpenFiles int
+	// Workers is the number of files decoded at once; zero stands for GOMAXPROCS.
+	Workers int
+	// Rules decide which files are textures; nil stands for DefaultRules. They are called from
+	// several goroutines at once.
+	Rules Rules
+	// MaxPixels caps the size of the images decoded, so a huge file cannot exhaust memory; larger
+	// ones are listed as broken. Zero disables the limit.
+	MaxPixels int
+	// DecodeTimeout caps the time spent decoding an image, so a pathological file cannot hang the
+	// scan; those taking longer are listed as broken. Zero disables the limit.
+	DecodeTimeout time.Duration
+	// PreviewSize, when set, lets formats with a cheap reduced decoding (DDS) stop at images of
+	// this size on their longest side, enough for thumbnails of that size. Texture dimensions
+	// stay those of the full images.
+	PreviewSize int
+	// Families, when set, limits the scan to the families it names: files of the others are
+	// neither read nor listed.
+	Families []string
+	// Spill, when set, receives the source files of the decoded textures, whose Image is then
+	// left nil for LoadImage to decode again when needed.
+	Spill *Spill
+	// Progress, when set, is called before and after each file of the scan.
+	Progress func(event ProgressEvent)
+	// Stop, once closed, ends the scan after the file in progress.
+	Stop <-chan struct{}
+	// Exec, when set, is a shell command run on each texture, such as "convert {path} {out}":
+	// the image it writes to {out} is decoded instead of the file at {path}.
+	Exec string
+	// HeaderOnly measures the textures from the headers of their files instead of decoding them,
+	// for passes needing no pixels. Their Image is then nil, their alpha and normal map detection
+	// left out, and Exec not run; formats without a header parser are still decoded.
+	HeaderOnly bool
+}
+
+// DefaultScanOptions returns the options used by Scan.
+func DefaultScanOptions() ScanOptions {
+	return ScanOptions{MaxOpenFiles: 64, MaxPixels: 1 << 26, DecodeTimeout: 30 * time.Second}
+}
+
+// Scan lists and decodes the textures of a directory or CRF/ZIP file.
+func Scan(sourcePath string) (*Inventory, error) {
+	return ScanWithOptions(sourcePath, DefaultScanOptions())
+}
+
+// ScanWithOptions is Scan with explicit options.
+func ScanWithOptions(sourcePath string, options ScanOptions) (*Inventory, error) {
+	if fileInfo, err := os.Stat(sourcePath); err == nil && fileInfo.IsDir() {
+		return scanFS(os.DirFS(sourcePath), sourcePath, sourcePath, options)
+	}
+
+	zipReader, closer, err := openArchive(sourcePath)
+
+	if err != nil {
+		return scanRecovered(sourcePath, err, options)
+	}
+
+	defer closer.Close()
+
+	return scanFS(zipReader, sourcePath, "", options)
+}
+
+// openZip opens the ZIP archive at archivePath through a file.
+func openZip(archivePath string) (*zip.Reader, io.Closer, error) {
+	zipReader, err := zip.OpenReader(archivePath)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &zipReader.Reader, zipReader, nil
+}
+
+// SourceFiles reads back the files of a scanned source, by the paths its inventory lists.
+type SourceFiles struct {
+	fsys   fs.FS
+	root   string
+	closer io.Closer
+}
+
+// OpenSource opens a directory or CRF/ZIP file, tolerating damaged archives like Scan does.
+func OpenSource(sourcePath string) (*SourceFiles, error) {
+	if fileInfo, err := os.Stat(sourcePath); err == nil && fileInfo.IsDir() {
+		return &SourceFiles{fsys: os.DirFS(sourcePath), root: sourcePath}, nil
+	}
+
+	zipReader, closer, err := openArchive(sourcePath)
+
+	if err == nil {
+		return &SourceFiles{fsys: zipReader, closer: closer}, nil
+	}
+
+	data, readErr := os.ReadFile(sourcePath)
+
+	if readErr != nil {
+		return nil, err
+	}
+
+	recovered, _, recoverErr := RecoverArchive(data)
+
+	if recoverErr != nil {
+		return nil, err
+	}
+
+	return &SourceFiles{fsys: recovered}, nil
+}
+
+// ReadFile returns the content of the file at filePath, a Path of the inventory of the source.
+func (source *SourceFiles) ReadFile(filePath string) ([]byte, error) {
+	name := filePath
+
+	if source.root != "" {
+		relative, err := filepath.Rel(source.root, filePath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		name = filepath.ToSlash(relative)
+	}
+
+	return fs.ReadFile(source.fsys, name)
+}
+
+// Names lists the files of the source, as slash-separated paths within it.
+func (source *SourceFiles) Names() ([]string, error) {
+	var names []string
+
+	err := fs.WalkDir(source.fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			names = append(names, name)
+		}
+
+		return err
+	})
+
+	return names, err
+}
+
+// Close releases the source.
+func (source *SourceFiles) Close() error {
+	if source.closer == nil {
+		return nil
+	}
+
+	return source.closer.Close()
+}
+
+// scanRecovered scans a file zip.OpenReader rejected with openErr through RecoverArchive. The
+// entries it cannot recover are reported as skipped.
+func scanRecovered(sourcePath string, openErr error, options ScanOptions) (*Inventory, error) {
+	data, err := os.ReadFile(sourcePath)
+
+	if err != nil {
+		return nil, openErr
+	}
+
+	recovered, dropped, err := RecoverArchive(data)
+
+	if err != nil {
+		return nil, openErr
+	}
+
+	inventory, err := scanFS(recovered, sourcePath, "", options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	inventory.Skipped = append(inventory.Skipped, dropped...)
+	inventory.Metadata["recovered"] = "local-headers"
+
+	return inventory, nil
+}
+
+// ScanFS lists and decodes the textures of a file system laid out like a CRF, one directory per
+// family. An archive held in memory can be scanned with zip.NewReader; sourceName only names it
+// in the inventory.
+func ScanFS(fsys fs.FS, sourceName string, options ScanOptions) (*Inventory, error) {
+	return scanFS(fsys, sourceName, "", options)
+}
+
+// scanFS scans fsys. Texture paths are joined to root, in the form of the host system, when it is
+// set, and are the slash-separated names of fsys otherwise.
+func scanFS(fsys fs.FS, sourceName string, root string, options ScanOptions) (*Inventory, error) {
+	if options.MaxOpenFiles < 1 {
+		return nil, fmt.Errorf("gallery: invalid open file limit %d", options.MaxOpenFiles)
+	}
+
+	var fileList []string
+
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.IsDir() {
+			fileList = append(fileList, name)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	rules := options.Rules
+
+	if rules == nil {
+		rules = DefaultRules()
+	}
+
+	source := &scanSource{fsys: fsys, openFiles: make(chan struct{}, options.MaxOpenFiles)}
+	inventory := &Inventory{Source: sourceName, Metadata: make(map[string]string)}
+	families := make(map[string]*Family)
+	seenFamilies := make(map[string]bool)
+
+	if len(options.Families) > 0 {
+		fileList = slices.DeleteFunc(fileList, func(name string) bool {
+			_, familyName, _ := scanPath(name, root)
+
+			return !slices.Contains(options.Families, familyName)
+		})
+	}
+
+	// Files are read, judged and decoded ahead of their turn by concurrent workers, a window of
+	// them at a time, then added to the inventory in order.
+	workers := options.Workers
+
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	results := make([]chan scannedFile, len(fileList))
+	window := make(chan struct{}, options.MaxOpenFiles+workers)
+	decoding := make(chan struct{}, workers)
+	done := make(chan struct{})
+	defer close(done)
+
+	for i := range results {
+		results[i] = make(chan scannedFile, 1)
+	}
+
+	go func() {
+		for i, name := range fileList {
+			select {
+			case window <- struct{}{}:
+			case <-done:
+				return
+			}
+
+			go func(i int, name string) {
+				results[i] <- scanFile(source, name, root, rules, decoding, options)
+			}(i, name)
+		}
+	}()
+
+	for i, name := range fileList {
+		if stopped(options.Stop) {
+			inventory.Incomplete = fmt.Sprintf("scan interrupted after %d of %d files", i, len(fileList))
+
+			break
+		}
+
+		filePath, familyName, filename := scanPath(name, root)
+		options.report(ProgressEvent{Stage: "scan", Event: "started", Source: sourceName, Path: filePath}, i, len(fileList))
+
+		if familyName != "" {
+			seenFamilies[familyName] = true
+		}
+
+		family := families[familyName]
+
+		if family == nil {
+			family = &Family{Name: familyName, MaterialFiles: make(map[string][]string)}
+		}
+
+		scanned := <-results[i]
+		<-window
+
+		if scanned.err != nil {
+			return nil, scanned.err
+		}
+
+		data, candidate, decision := scanned.candidate.Data, scanned.candidate, scanned.decision
+		decision.Source = sourceName
+		inventory.Decisions = append(inventory.Decisions, decision)
+		scanError := ""
+
+		// full.pcx is no texture, but its palette is kept for the page.
+		if scanned.palette != nil {
+			family.Palette = scanned.palette
+			families[familyName] = family
+		}
+
+		switch decision.Verdict {
+		case VerdictMaterial:
+			base := strings.TrimSuffix(filename, candidate.Extension)
+			family.MaterialFiles[base] = append(family.MaterialFiles[base], filename)
+			families[familyName] = family
+		case VerdictDescription:
+			family.Description = strings.TrimSpace(string(data))
+			families[familyName] = family
+		case VerdictTexture:
+			texture, ok := scanned.texture, scanned.decoded
+
+			if !ok {
+				inventory.Skipped = append(inventory.Skipped, filePath)
+				decision.Verdict, decision.Reason = VerdictSkip, "no decoder for the format"
+				inventory.Decisions[len(inventory.Decisions)-1] = decision
+
+				break
+			}
+
+			if options.Spill != nil && texture.Image != nil {
+				data, extension := candidate.Data, candidate.Extension
+
+				// The image made by Exec is spilled rather than the file it was made from.
+				if options.Exec != "" {
+					buffer := new(bytes.Buffer)
+
+					if err := png.Encode(buffer, texture.Image); err != nil {
+						return nil, err
+					}
+
+					data, extension = buffer.Bytes(), ".png"
+				}
+
+				spilled, err := options.Spill.store(data, extension, ScanOptions{MaxPixels: options.MaxPixels, DecodeTimeout: options.DecodeTimeout, PreviewSize: options.PreviewSize})
+
+				if err != nil {
+					return nil, err
+				}
+
+				texture.Image, texture.spilled = nil, spilled
+			}
+
+			family.Textures = append(family.Textures, texture)
+			families[familyName] = family
+			scanError = texture.Error
+		default:
+			inventory.Skipped = append(inventory.Skipped, filePath)
+		}
+
+		// Family palettes are expected, anything else skipped may have been shipped by mistake.
+		if decision.Verdict == VerdictSkip && decision.Rule != "family-palette" {
+			inventory.OtherFiles = append(inventory.OtherFiles, OtherFile{Path: name, Size: int64(len(data)), Reason: decision.Reason})
+		}
+
+		event := ProgressEvent{Stage: "scan", Event: "completed", Source: sourceName, Path: filePath, Error: scanError}
+
+		if decision.Verdict == VerdictSkip {
+			event.Event, event.Reason = "skipped", decision.Reason
+		}
+
+		options.report(event, i+1, len(fileList))
+	}
+
+	for name := range seenFamilies {
+		if family := families[name]; family != nil && len(family.Textures) > 0 {
+			inventory.Families = append(inventory.Families, *family)
+		} else {
+			inventory.EmptyFamilies = append(inventory.EmptyFamilies, name)
+		}
+	}
+
+	sort.Strings(inventory.EmptyFamilies)
+
+	sort.Slice(inventory.Families, func(i, j int) bool {
+		return inventory.Families[i].Name < inventory.Families[j].Name
+	})
+
+	inventory.pairTxt16()
+	inventory.checkPalettes()
+
+	return inventory, nil
+}
+
+// scanPath returns the path of a listed file as reported, and its family and file names. The
+// family of a file at the root of an archive is empty.
+func scanPath(name string, root string) (string, string, string) {
+	filePath := name
+
+	if root != "" {
+		filePath = filepath.Join(root, filepath.FromSlash(name))
+	}
+
+	parts := strings.Split(strings.ToLower(filepath.ToSlash(filePath)), "/")
+
+	if len(parts) < 2 {
+		return filePath, "", parts[0]
+	}
+
+	return filePath, parts[len(parts)-2], parts[len(parts)-1]
+}
+
+// scanSource reads the files of a source, holding at most cap(openFiles) of them open at once.
+type scanSource struct {
+	fsys      fs.FS
+	openFiles chan struct{}
+}
+
+func (source *scanSource) read(name string) ([]byte, error) {
+	source.openFiles <- struct{}{}
+	defer func() { <-source.openFiles }()
+
+	file, err := source.fsys.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+// scannedFile is a file of a source read, judged by the rules and, for a texture or a family
+// palette, decoded. decoded is false for textures no decoder handles.
+type scannedFile struct {
+	candidate Candidate
+	decision  Decision
+	texture   Texture
+	decoded   bool
+	palette   []string
+	err       error
+}
+
+// scanFile reads the file name of source and decodes it as its decision requires, holding a slot
+// of decoding meanwhile.
+func scanFile(source *scanSource, name string, root string, rules Rules, decoding chan struct{}, options ScanOptions) scannedFile {
+	filePath, familyName, filename := scanPath(name, root)
+	data, err := source.read(name)
+
+	if err != nil {
+		return scannedFile{err: err}
+	}
+
+	candidate := Candidate{Path: filePath, Family: familyName, File: filename, Extension: path.Ext(filename), Data: data}
+	scanned := scannedFile{candidate: candidate}
+
+	// Files at the root of an archive belong to no family.
+	scanned.decision = Decision{Path: filePath, Verdict: VerdictSkip, Rule: "family-directory", Reason: "not in a family directory"}
+
+	if familyName != "" {
+		scanned.decision = rules.Decide(candidate)
+	}
+
+	decoding <- struct{}{}
+	defer func() { <-decoding }()
+
+	if scanned.decision.Rule == "family-palette" {
+		if palette, err := readPalette(data, options); err == nil {
+			scanned.palette = palette
+		}
+	}
+
+	if scanned.decision.Verdict == VerdictTexture {
+		scanned.texture, scanned.decoded = scanTexture(candidate, options)
+	}
+
+	return scanned
+}
+
+// scanTexture decodes a candidate kept by the rules. ok is false when no decoder handles it.
+func scanTexture(candidate Candidate, options ScanOptions) (Texture, bool) {
+	decoder, _, ok := resolveDecoder(candidate.Extension, candidate.Data)
+
+	if !ok {
+		return Texture{}, false
+	}
+
+	data, filename, extension := candidate.Data, candidate.File, candidate.Extension
+	hash := sha256.Sum256(data)
+	name := strings.TrimSuffix(filename, extension)
+	_, mapType := SplitMapName(name)
+
+	texture := Texture{
+		Path:      candidate.Path,
+		Family:    candidate.Family,
+		Name:      name,
+		File:      filename,
+		Format:    decoder.Format(),
+		Extension: extension,
+		Size:      int64(len(data)),
+		SHA256:    hex.EncodeToString(hash[:]),
+		MapType:   mapType,
+	}
+
+	if options.HeaderOnly && decoder.DecodeConfig != nil {
+		if config, err := measureImage(decoder, data); err != nil {
+			texture.Error = err.Error()
+		} else {
+			texture.Width, texture.Height = config.Width, config.Height
+
+			if palette, ok := config.ColorModel.(color.Palette); ok {
+				texture.Palette = len(palette)
+			}
+		}
+
+		return texture, true
+	}
+
+	if options.Exec != "" {
+		converted, err := execTexture(options.Exec, candidate)
+
+		if err != nil {
+			texture.Error = err.Error()
+
+			return texture, true
+		}
+
+		if decoder, _, ok = resolveDecoder(".png", converted); !ok {
+			texture.Error = "exec: " + ErrUnknownFormat.Error()
+
+			return texture, true
+		}
+
+		data = converted
+	}
+
+	// Broken files are kept, with their error, so the page can show a placeholder for them.
+	if img, size, err := decodeLimited(decoder, data, options); err != nil {
+		texture.Error = err.Error()
+	} else {
+		texture.Image = img
+		texture.Width, texture.Height = size.X, size.Y
+
+		if paletted, ok := img.(*image.Paletted); ok {
+			texture.Palette = len(paletted.Palette)
+		}
+
+		stats := ComputeStats(img)
+		texture.Alpha = AlphaKind(stats)
+
+		if mapType == "" && LooksLikeNormalMap(stats) {
+			texture.MapType = "normal"
+		}
+	}
+
+	return texture, true
+}
@@ -0,0 +1,629 @@
+package gallery
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func encodePNG(t testing.TB, width int, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	img.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+
+	buffer := new(bytes.Buffer)
+
+	if err := png.Encode(buffer, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func TestScanFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"brick/wall.png":   {Data: encodePNG(t, 8, 4)},
+		"brick/full.pcx":   {Data: []byte("palette")},
+		"metal/plate.png":  {Data: encodePNG(t, 2, 2)},
+		"metal/readme.txt": {Data: []byte("not a texture")},
+		"metal/plate.mtl":  {Data: []byte("texture plate\n")},
+		"metal/Thumbs.db":  {Data: []byte("cache")},
+	}
+
+	inventory, err := ScanFS(fsys, "memory", DefaultScanOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.Source != "memory" || len(inventory.Families) != 2 || inventory.TextureCount() != 2 {
+		t.Fatalf("unexpected inventory: %+v", inventory)
+	}
+
+	wall := inventory.Families[0].Textures[0]
+
+	if wall.Key() != "brick/wall.png" || wall.Path != "brick/wall.png" || wall.Width != 8 || wall.Height != 4 {
+		t.Errorf("unexpected texture: %+v", wall)
+	}
+
+	if strings.Join(inventory.Skipped, ",") != "brick/full.pcx,metal/Thumbs.db,metal/readme.txt" {
+		t.Errorf("skipped = %q", inventory.Skipped)
+	}
+
+	// Family palettes are no other files.
+	if len(inventory.OtherFiles) != 2 || inventory.OtherFiles[1] != (OtherFile{"metal/readme.txt", 13, "no decoder for .txt and unrecognized content"}) {
+		t.Errorf("other files = %+v", inventory.OtherFiles)
+	}
+
+	if files := inventory.Families[1].MaterialFiles["plate"]; len(files) != 1 {
+		t.Errorf("material files = %q", files)
+	}
+
+	page := new(bytes.Buffer)
+
+	if err := RenderTo(page, inventory, DefaultRenderOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(page.String(), "<h2>brick <span class='badge count'>1</span></h2>") {
+		t.Error("page lacks the brick family")
+	}
+
+	if !strings.Contains(page.String(), "<tr><td>metal/Thumbs.db <span class='badge warning'>system file</span></td><td class='size'>5 bytes</td>") {
+		t.Error("page lacks the other files")
+	}
+}
+
+func TestScanProgress(t *testing.T) {
+	fsys := fstest.MapFS{
+		"brick/wall.png":   {Data: encodePNG(t, 8, 4)},
+		"brick/full.pcx":   {Data: []byte("palette")},
+		"metal/plate.png":  {Data: encodePNG(t, 2, 2)},
+		"metal/readme.txt": {Data: []byte("not a texture")},
+	}
+
+	var events []string
+	options := DefaultScanOptions()
+	options.Families = []string{"brick"}
+	options.Progress = func(event ProgressEvent) {
+		events = append(events, fmt.Sprintf("%s %s %s %d/%d %.0f%%", event.Stage, event.Event, event.Path, event.Done, event.Total, event.Percent))
+	}
+
+	inventory, err := ScanFS(fsys, "memory", options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renderOptions := DefaultRenderOptions()
+	renderOptions.Progress = options.Progress
+
+	if _, err := Render(inventory, renderOptions); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files of the other families do not count.
+	expected := []string{
+		"scan started brick/full.pcx 0/2 0%",
+		"scan skipped brick/full.pcx 1/2 50%",
+		"scan started brick/wall.png 1/2 50%",
+		"scan completed brick/wall.png 2/2 100%",
+		"render completed  1/1 100%",
+	}
+
+	if strings.Join(events, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("events:\n%s", strings.Join(events, "\n"))
+	}
+}
+
+// countingFS is a slow file system recording how many of its files are open at most at once.
+type countingFS struct {
+	fstest.MapFS
+
+	mutex   sync.Mutex
+	open    int
+	maxOpen int
+}
+
+func (fsys *countingFS) Open(name string) (fs.File, error) {
+	file, err := fsys.MapFS.Open(name)
+
+	if err != nil || name == "." || strings.HasSuffix(name, "/") {
+		return file, err
+	}
+
+	if info, err := file.Stat(); err != nil || info.IsDir() {
+		return file, err
+	}
+
+	fsys.mutex.Lock()
+	fsys.open++
+	fsys.maxOpen = max(fsys.maxOpen, fsys.open)
+	fsys.mutex.Unlock()
+	time.Sleep(5 * time.Millisecond)
+
+	return &countedFile{File: file, fsys: fsys}, nil
+}
+
+type countedFile struct {
+	fs.File
+	fsys *countingFS
+}
+
+func (file *countedFile) Close() error {
+	file.fsys.mutex.Lock()
+	file.fsys.open--
+	file.fsys.mutex.Unlock()
+
+	return file.File.Close()
+}
+
+// TestScanMaxOpenFiles checks that files are read concurrently, but never more than
+// ScanOptions.MaxOpenFiles at once.
+func TestScanMaxOpenFiles(t *testing.T) {
+	data := encodePNG(t, 2, 2)
+	fsys := &countingFS{MapFS: fstest.MapFS{}}
+
+	for i := 0; i < 24; i++ {
+		fsys.MapFS[fmt.Sprintf("walls/texture%02d.png", i)] = &fstest.MapFile{Data: data}
+	}
+
+	options := DefaultScanOptions()
+	options.MaxOpenFiles = 3
+	inventory, err := ScanFS(fsys, "test", options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count := inventory.TextureCount(); count != 24 {
+		t.Errorf("scanned %d textures, want 24", count)
+	}
+
+	if fsys.maxOpen > 3 {
+		t.Errorf("%d files open at once, want at most 3", fsys.maxOpen)
+	}
+
+	if fsys.maxOpen < 2 {
+		t.Errorf("%d files open at once, want files read concurrently", fsys.maxOpen)
+	}
+
+	for i, texture := range inventory.Families[0].Textures {
+		if want := fmt.Sprintf("texture%02d.png", i); texture.File != want {
+			t.Errorf("texture %d is %s, want %s", i, texture.File, want)
+		}
+	}
+}
+
+func TestScanRootEntries(t *testing.T) {
+	fsys := fstest.MapFS{
+		"wall.png":       {Data: encodePNG(t, 4, 4)},
+		"brick/wall.png": {Data: encodePNG(t, 4, 4)},
+	}
+
+	inventory, err := ScanFS(fsys, "memory", DefaultScanOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(inventory.Families) != 1 || len(inventory.EmptyFamilies) != 0 || inventory.Decisions[1].Rule != "family-directory" {
+		t.Errorf("unexpected inventory: %+v", inventory)
+	}
+}
+
+func TestScanTxt16(t *testing.T) {
+	fsys := fstest.MapFS{
+		"obj/txt/crate.pcx":   {Data: pcxFixture(t, 4, 4, true)},
+		"obj/txt/barrel.pcx":  {Data: pcxFixture(t, 4, 4, true)},
+		"obj/txt16/crate.png": {Data: encodePNG(t, 8, 8)},
+		"obj/txt16/lamp.png":  {Data: encodePNG(t, 8, 8)},
+		"mesh/txt/crate.pcx":  {Data: pcxFixture(t, 4, 4, true)},
+	}
+
+	inventory, err := ScanFS(fsys, "memory", DefaultScanOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var layout []string
+
+	for _, family := range inventory.Families {
+		for _, texture := range family.Textures {
+			entry := texture.Path
+
+			if texture.EightBit != nil {
+				entry += "<" + texture.EightBit.Path
+			}
+
+			layout = append(layout, entry)
+		}
+	}
+
+	sort.Strings(layout)
+
+	// The crate of mesh/txt has no replacement next to it.
+	if strings.Join(layout, " ") != "mesh/txt/crate.pcx obj/txt/barrel.pcx obj/txt16/crate.png<obj/txt/crate.pcx obj/txt16/lamp.png" {
+		t.Errorf("textures: %q", layout)
+	}
+
+	page, err := Render(inventory, DefaultRenderOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !regexp.MustCompile(`<details class='eight-bit'><summary>8-bit version</summary><div class='image'><img [^>]+></div><span class='info'>4x4 \(pcx\)</span></details>`).Match(page) {
+		t.Error("8-bit version missing from the page")
+	}
+}
+
+func TestScanFSArchiveInMemory(t *testing.T) {
+	archive := new(bytes.Buffer)
+	writer := zip.NewWriter(archive)
+	entry, err := writer.Create("stone/floor.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry.Write(encodePNG(t, 4, 4))
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inventory, err := ScanFS(zipReader, "stone.crf", DefaultScanOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.TextureCount() != 1 || inventory.Families[0].Name != "stone" {
+		t.Fatalf("unexpected inventory: %+v", inventory)
+	}
+
+	output := new(bytes.Buffer)
+
+	if err := inventory.WriteJSON(output); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(output.String(), `"file": "floor.png"`) {
+		t.Errorf("unexpected JSON: %s", output)
+	}
+}
+
+func TestScanExtensionAliases(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	jpegData := new(bytes.Buffer)
+
+	if err := jpeg.Encode(jpegData, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := fstest.MapFS{}
+
+	for _, file := range []string{"a.jpg", "b.jpeg", "c.JPE", "d.jfif"} {
+		fsys["stone/"+file] = &fstest.MapFile{Data: jpegData.Bytes()}
+	}
+
+	inventory, err := ScanFS(fsys, "memory", DefaultScanOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.TextureCount() != 4 {
+		t.Fatalf("textures = %d, skipped = %q", inventory.TextureCount(), inventory.Skipped)
+	}
+
+	for _, texture := range inventory.Families[0].Textures {
+		if texture.Format != "jpg" || texture.Width != 4 {
+			t.Errorf("unexpected texture: %+v", texture)
+		}
+	}
+}
+
+func TestScanSpill(t *testing.T) {
+	fsys := fstest.MapFS{
+		"brick/wall.png":  {Data: encodePNG(t, 8, 4)},
+		"metal/plate.png": {Data: encodePNG(t, 2, 2)},
+		"metal/bad.png":   {Data: []byte("\x89PNG\r\n\x1a\nbroken")},
+	}
+
+	spill, err := NewSpill(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer spill.Close()
+
+	options := DefaultScanOptions()
+	kept, err := ScanFS(fsys, "memory", options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options.Spill = spill
+	spilled, err := ScanFS(fsys, "memory", options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, family := range spilled.Families {
+		for j, texture := range family.Textures {
+			img, err := texture.LoadImage()
+
+			if texture.Image != nil || err != nil {
+				t.Errorf("%s: image kept in memory or not loaded: %v", texture.Key(), err)
+			} else if want := kept.Families[i].Textures[j]; (img == nil) != (want.Image == nil) || (img != nil && img.Bounds() != want.Image.Bounds()) {
+				t.Errorf("%s: loaded %v, want %v", texture.Key(), img, want.Image)
+			}
+		}
+	}
+
+	keptPage, spilledPage := new(bytes.Buffer), new(bytes.Buffer)
+
+	if err := RenderTo(keptPage, kept, DefaultRenderOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RenderTo(spilledPage, spilled, DefaultRenderOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	if keptPage.String() != spilledPage.String() {
+		t.Error("the spilled inventory renders differently")
+	}
+}
+
+func TestScanExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands need sh")
+	}
+
+	fsys := fstest.MapFS{"brick/wall.png": {Data: encodePNG(t, 8, 4)}}
+	replacementPath := filepath.Join(t.TempDir(), "replacement.png")
+
+	if err := os.WriteFile(replacementPath, encodePNG(t, 2, 2), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	spill, err := NewSpill(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer spill.Close()
+
+	options := DefaultScanOptions()
+	options.Exec = "test -f {path} && cp '" + replacementPath + "' {out}"
+
+	for _, spill := range []*Spill{nil, spill} {
+		options.Spill = spill
+		inventory, err := ScanFS(fsys, "memory", options)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The image and its dimensions are the command's, the size and hash the file's.
+		texture := inventory.Families[0].Textures[0]
+		img, err := texture.LoadImage()
+
+		if err != nil || img.Bounds() != image.Rect(0, 0, 2, 2) || texture.Width != 2 || texture.Size != int64(len(fsys["brick/wall.png"].Data)) {
+			t.Errorf("spill %v: unexpected texture %+v, image %v", spill != nil, texture, err)
+		}
+	}
+
+	options.Spill = nil
+	options.Exec = "echo no converter >&2; false {path} {out}"
+	inventory, err := ScanFS(fsys, "memory", options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if texture := inventory.Families[0].Textures[0]; !strings.HasPrefix(texture.Error, "exec: exit status 1: no converter") {
+		t.Errorf("unexpected error %q", texture.Error)
+	}
+}
+
+// TestScanExecHostileName checks that the name of an entry never reaches the command line.
+func TestScanExecHostileName(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands need sh")
+	}
+
+	directoryPath := t.TempDir()
+	fsys := fstest.MapFS{`brick/x";touch pwned;".png`: {Data: encodePNG(t, 8, 4)}, "brick/$(touch pwned).png": {Data: encodePNG(t, 8, 4)}}
+	options := DefaultScanOptions()
+	options.Exec = "cd '" + directoryPath + "' && case {path} in */in.png) cp {path} {out};; esac"
+	inventory, err := ScanFS(fsys, "memory", options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, texture := range inventory.Families[0].Textures {
+		if texture.Error != "" {
+			t.Errorf("%s: %s", texture.File, texture.Error)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(directoryPath, "pwned")); err == nil {
+		t.Error("the name of an entry ran as a shell command")
+	}
+}
+
+func TestSortFamilies(t *testing.T) {
+	inventory := &Inventory{Families: []Family{
+		{Name: "a", Textures: []Texture{{Size: 10}}},
+		{Name: "b", Textures: []Texture{{Size: 1}, {Size: 1}}},
+		{Name: "c", Textures: []Texture{{Size: 10}}},
+	}}
+
+	for order, expected := range map[string]string{"count": "bac", "bytes": "acb", "name": "abc"} {
+		if err := inventory.SortFamilies(order); err != nil {
+			t.Fatal(err)
+		}
+
+		names := ""
+
+		for _, family := range inventory.Families {
+			names += family.Name
+		}
+
+		if names != expected {
+			t.Errorf("%s order: %s, expected %s", order, names, expected)
+		}
+	}
+
+	if inventory.SortFamilies("size") == nil {
+		t.Error("unknown order accepted")
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	inventory := &Inventory{
+		Families: []Family{
+			{Name: "a", Textures: []Texture{{}, {}}},
+			{Name: "b", Textures: []Texture{{}}},
+			{Name: "c", Textures: []Texture{{}, {}, {}, {}}},
+			{Name: "d", Textures: []Texture{{}}},
+		},
+		EmptyFamilies: []string{"sky"},
+	}
+
+	var layout []string
+
+	for _, page := range inventory.Paginate(3) {
+		names := ""
+
+		for _, family := range page.Families {
+			names += family.Name
+		}
+
+		layout = append(layout, names+fmt.Sprint(len(page.EmptyFamilies)))
+	}
+
+	// Families are never split, the larger ones get a page of their own.
+	if strings.Join(layout, " ") != "ab1 c0 d0" {
+		t.Errorf("pages: %q", layout)
+	}
+
+	if pages := inventory.Paginate(0); len(pages) != 1 || pages[0] != inventory {
+		t.Errorf("unpaginated inventory split in %d pages", len(pages))
+	}
+}
+
+func TestDashboardStats(t *testing.T) {
+	inventory := &Inventory{Families: []Family{
+		{Name: "brick", Textures: []Texture{{Format: "pcx", Width: 64, Height: 32, Size: 100}, {Format: "png", Width: 512, Height: 512, Size: 300}}},
+		{Name: "metal", Textures: []Texture{{Format: "pcx", Error: "broken", Size: 10}, {Format: "dds", Width: 8192, Height: 10, Size: 1}, {Format: "pcx", Width: 8, Height: 8}}},
+	}}
+
+	stats := inventory.DashboardStats(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	expected := DashboardStats{
+		Generated:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Families:    2,
+		Textures:    5,
+		Broken:      1,
+		Bytes:       411,
+		Coverage:    0.4,
+		Formats:     map[string]int{"pcx": 3, "png": 1, "dds": 1},
+		Resolutions: map[string]int{"16": 1, "64": 1, "512": 1, "4096+": 1},
+	}
+
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("stats = %+v, want %+v", stats, expected)
+	}
+}
+
+func TestCollation(t *testing.T) {
+	compare, err := Collation("fr")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"zinc", "écorce", "brick10", "Brick2", "ecorce"}
+	sort.Slice(names, func(i, j int) bool { return compare(names[i], names[j]) < 0 })
+
+	if want := []string{"Brick2", "brick10", "ecorce", "écorce", "zinc"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("sorted to %v, want %v", names, want)
+	}
+
+	inventory := &Inventory{Families: []Family{{Name: "zinc"}, {Name: "écorce"}, {Name: "brick"}}}
+
+	if err := inventory.SortFamiliesBy("name", compare); err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.Families[1].Name != "écorce" {
+		t.Errorf("families sorted to %s, %s, %s", inventory.Families[0].Name, inventory.Families[1].Name, inventory.Families[2].Name)
+	}
+
+	if _, err := Collation("not a language"); err == nil {
+		t.Error("invalid collation accepted")
+	}
+}
+
+func TestNaturalCompare(t *testing.T) {
+	names := []string{"tile10", "tile2", "frame010", "tile1b", "tile", "frame9", "tile1", "frame10"}
+	sort.Slice(names, func(i, j int) bool { return NaturalCompare(names[i], names[j]) < 0 })
+
+	if want := []string{"frame9", "frame010", "frame10", "tile", "tile1", "tile1b", "tile2", "tile10"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("sorted to %v, want %v", names, want)
+	}
+
+	// Families and the textures of a page follow the same order.
+	var textures []Texture
+
+	for _, name := range []string{"tile10", "tile2", "tile1"} {
+		textures = append(textures, Texture{Family: "tiles", Name: name, File: name + ".png", Format: "png", Extension: ".png", Image: image.NewGray(image.Rect(0, 0, 4, 4))})
+	}
+
+	inventory := &Inventory{Families: []Family{{Name: "wall10", Textures: textures}, {Name: "wall9"}}}
+
+	if err := inventory.SortFamilies("name"); err != nil || inventory.Families[0].Name != "wall9" {
+		t.Errorf("wall10 sorted before wall9 (%v)", err)
+	}
+
+	page, err := Render(inventory, DefaultRenderOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !regexp.MustCompile(`>tile1</span>.*>tile2</span>.*>tile10</span>`).Match(page) {
+		t.Error("tiles not in natural order")
+	}
+}
@@ -0,0 +1,128 @@
+//go:build unix
+
+package gallery
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestScanManyFiles scans a directory holding far more files than the process may keep open.
+// Keeping each file open until Scan returns used to fail with "too many open files".
+func TestScanManyFiles(t *testing.T) {
+	const families, perFamily = 20, 150
+
+	data := encodePNG(t, 2, 2)
+	root := t.TempDir()
+
+	for f := 0; f < families; f++ {
+		directory := filepath.Join(root, fmt.Sprintf("family%02d", f))
+
+		if err := os.Mkdir(directory, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < perFamily; i++ {
+			if err := os.WriteFile(filepath.Join(directory, fmt.Sprintf("texture%03d.png", i)), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var limit syscall.Rlimit
+
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		t.Skip(err)
+	}
+
+	lowered := limit
+	lowered.Cur = 128
+
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lowered); err != nil {
+		t.Skip(err)
+	}
+
+	defer syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)
+
+	inventory, err := ScanWithOptions(root, ScanOptions{MaxOpenFiles: 16})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count := inventory.TextureCount(); count != families*perFamily {
+		t.Errorf("scanned %d textures, want %d", count, families*perFamily)
+	}
+}
+
+// TestScanMappedArchive scans and reads back an archive mapped into memory.
+func TestScanMappedArchive(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "stone.crf")
+	file, err := os.Create(archivePath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer := zip.NewWriter(file)
+	data := encodePNG(t, 4, 4)
+
+	for _, name := range []string{"stone/floor.png", "stone/wall.png"} {
+		entry, err := writer.Create(name)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entry.Write(data)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file.Close()
+
+	defer func(threshold int64) { mmapThreshold = threshold }(mmapThreshold)
+	mmapThreshold = 0
+
+	_, closer, err := openArchive(archivePath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := closer.(mapping); !ok {
+		t.Fatalf("archive opened through %T, want a mapping", closer)
+	}
+
+	closer.Close()
+
+	inventory, err := ScanWithOptions(archivePath, DefaultScanOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.TextureCount() != 2 {
+		t.Fatalf("scanned %d textures, want 2", inventory.TextureCount())
+	}
+
+	source, err := OpenSource(archivePath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := source.ReadFile("stone/wall.png")
+	source.Close()
+
+	if err != nil || !bytes.Equal(read, data) {
+		t.Errorf("read back %d bytes (%v), want %d", len(read), err, len(data))
+	}
+}
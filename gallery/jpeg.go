@@ -0,0 +1,608 @@
+package gallery
+
+/**
+ * JPEG encoder
+ *
+ * The standard library encoder always writes baseline 4:2:0 JPEGs. This encoder adds the knobs
+ * that matter for small gallery thumbnails: progressive scans (spectral selection) and 4:4:4
+ * or 4:2:0 chroma subsampling. It uses the standard quantization and Huffman tables.
+ *
+ * A gallery encodes thousands of thumbnails of the same size, so the color planes, the DCT
+ * coefficients and the output buffer of an encoding are kept in a pool for the next one rather
+ * than left to the garbage collector, and RGBA and gray pixels are read without boxing each
+ * color in an interface.
+ */
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"sync"
+)
+
+type JPEGOptions struct {
+	Quality     int
+	Progressive bool
+	Subsampling int // 444 or 420
+}
+
+// JPEGDefaults returns the encoder settings for thumbnails of a source format. Palettized formats
+// have flat colors and hard edges that chroma subsampling visibly smears, so they keep full chroma
+// resolution and a higher quality; truecolor sources compress well with 4:2:0.
+func JPEGDefaults(extension string) JPEGOptions {
+	switch extension {
+	case ".pcx", ".gif", ".lbm", ".iff", ".ilbm":
+		return JPEGOptions{Quality: 90, Subsampling: 444}
+	}
+
+	return JPEGOptions{Quality: 85, Subsampling: 420}
+}
+
+// zigzag maps the zig-zag position of a coefficient to its natural (row-major) index.
+var zigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34, 27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36, 29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46, 53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// Quantization tables from Annex K of the JPEG specification, in natural order.
+var baseQuantization = [2][64]int{
+	{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	},
+	{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+type huffmanSpec struct {
+	Counts [16]byte
+	Values []byte
+}
+
+// Huffman tables from Annex K: luminance DC, luminance AC, chrominance DC, chrominance AC.
+var huffmanSpecs = [4]huffmanSpec{
+	// Luminance DC.
+	{
+		Counts: [16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		Values: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	// Luminance AC.
+	{
+		Counts: [16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		Values: []byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	// Chrominance DC.
+	{
+		Counts: [16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		Values: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	// Chrominance AC.
+	{
+		Counts: [16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		Values: []byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+type huffmanCode struct {
+	Code   uint32
+	Length uint32
+}
+
+var huffmanTables = func() [4][256]huffmanCode {
+	var tables [4][256]huffmanCode
+
+	for i, spec := range huffmanSpecs {
+		code, k := uint32(0), 0
+
+		for length := 1; length <= 16; length++ {
+			for n := 0; n < int(spec.Counts[length-1]); n++ {
+				tables[i][spec.Values[k]] = huffmanCode{code, uint32(length)}
+				code++
+				k++
+			}
+
+			code <<= 1
+		}
+	}
+
+	return tables
+}()
+
+var dctCosines = func() [8][8]float64 {
+	var table [8][8]float64
+
+	for u := 0; u < 8; u++ {
+		scale := 0.5
+
+		if u == 0 {
+			scale = 0.5 / math.Sqrt2
+		}
+
+		for x := 0; x < 8; x++ {
+			table[u][x] = scale * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+
+	return table
+}()
+
+type jpegComponent struct {
+	ID           byte
+	H, V         int
+	Table        int
+	BlocksWide   int
+	BlocksHigh   int
+	ScanWide     int
+	ScanHigh     int
+	Coefficients [][64]int32 // quantized, in zig-zag order
+}
+
+// jpegScratch holds the buffers of one encoding, reused through jpegScratchPool. Every element
+// is written before being read, so they need no clearing between encodings.
+type jpegScratch struct {
+	planes       [3][]float64
+	coefficients [3][][64]int32
+	writer       *bufio.Writer
+}
+
+var jpegScratchPool = sync.Pool{New: func() any {
+	return &jpegScratch{writer: bufio.NewWriter(nil)}
+}}
+
+func (scratch *jpegScratch) plane(i int, size int) []float64 {
+	if cap(scratch.planes[i]) < size {
+		scratch.planes[i] = make([]float64, size)
+	}
+
+	return scratch.planes[i][:size]
+}
+
+func (scratch *jpegScratch) blocks(i int, count int) [][64]int32 {
+	if cap(scratch.coefficients[i]) < count {
+		scratch.coefficients[i] = make([][64]int32, count)
+	}
+
+	return scratch.coefficients[i][:count]
+}
+
+type jpegWriter struct {
+	writer *bufio.Writer
+	bits   uint32
+	count  uint32
+	err    error
+}
+
+func EncodeJPEG(w io.Writer, m image.Image, options JPEGOptions) error {
+	bounds := m.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if width <= 0 || height <= 0 || width > 65535 || height > 65535 {
+		return errors.New("jpeg: invalid image size")
+	}
+
+	quality := min(max(options.Quality, 1), 100)
+	scale := 200 - quality*2
+
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+
+	var quantization [2][64]int
+
+	for t := range quantization {
+		for i, base := range baseQuantization[t] {
+			quantization[t][i] = min(max((base*scale+50)/100, 1), 255)
+		}
+	}
+
+	scratch := jpegScratchPool.Get().(*jpegScratch)
+	scratch.writer.Reset(w)
+
+	defer func() {
+		scratch.writer.Reset(nil)
+		jpegScratchPool.Put(scratch)
+	}()
+
+	_, gray := m.(*image.Gray)
+	components := jpegComponents(m, gray, options.Subsampling == 420, quantization, scratch)
+
+	writer := &jpegWriter{writer: scratch.writer}
+	writer.writeHeaders(width, height, components, quantization, options.Progressive)
+
+	if options.Progressive {
+		// DC first, then the low luma frequencies, chroma, and the remaining luma detail.
+		writer.writeScan(components, 0, 0)
+		writer.writeScan(components[:1], 1, 5)
+
+		for i := 1; i < len(components); i++ {
+			writer.writeScan(components[i:i+1], 1, 63)
+		}
+
+		writer.writeScan(components[:1], 6, 63)
+	} else {
+		writer.writeScan(components, 0, 63)
+	}
+
+	writer.writeMarker(0xd9, nil)
+
+	if writer.err != nil {
+		return writer.err
+	}
+
+	return writer.writer.Flush()
+}
+
+// jpegComponents converts the image to level-shifted YCbCr planes, padded to whole MCUs, and
+// computes the quantized DCT coefficients of every block.
+func jpegComponents(m image.Image, gray bool, subsample bool, quantization [2][64]int, scratch *jpegScratch) []*jpegComponent {
+	bounds := m.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	maxH, maxV := 1, 1
+	components := []*jpegComponent{{ID: 1, H: 1, V: 1, Table: 0}}
+
+	if !gray {
+		if subsample {
+			maxH, maxV = 2, 2
+			components[0].H, components[0].V = 2, 2
+		}
+
+		components = append(components, &jpegComponent{ID: 2, H: 1, V: 1, Table: 1}, &jpegComponent{ID: 3, H: 1, V: 1, Table: 1})
+	}
+
+	mcusWide := (width + 8*maxH - 1) / (8 * maxH)
+	mcusHigh := (height + 8*maxV - 1) / (8 * maxV)
+	paddedWidth, paddedHeight := mcusWide*8*maxH, mcusHigh*8*maxV
+
+	planes := make([][]float64, len(components))
+
+	for i := range planes {
+		planes[i] = scratch.plane(i, paddedWidth*paddedHeight)
+	}
+
+	rgba, _ := m.(*image.RGBA)
+	grayImage, _ := m.(*image.Gray)
+
+	for y := 0; y < paddedHeight; y++ {
+		for x := 0; x < paddedWidth; x++ {
+			// Edge pixels are repeated into the padding to avoid ringing at the borders.
+			px, py := bounds.Min.X+min(x, width-1), bounds.Min.Y+min(y, height-1)
+			i := y*paddedWidth + x
+
+			switch {
+			case grayImage != nil:
+				planes[0][i] = float64(grayImage.Pix[grayImage.PixOffset(px, py)])
+			case gray:
+				planes[0][i] = float64(color.GrayModel.Convert(m.At(px, py)).(color.Gray).Y)
+			case rgba != nil:
+				pixel := rgba.Pix[rgba.PixOffset(px, py):]
+				luma, cb, cr := color.RGBToYCbCr(pixel[0], pixel[1], pixel[2])
+				planes[0][i], planes[1][i], planes[2][i] = float64(luma), float64(cb), float64(cr)
+			default:
+				r, g, b, _ := m.At(px, py).RGBA()
+				luma, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+				planes[0][i], planes[1][i], planes[2][i] = float64(luma), float64(cb), float64(cr)
+			}
+		}
+	}
+
+	for i, component := range components {
+		factorH, factorV := maxH/component.H, maxV/component.V
+
+		component.BlocksWide = mcusWide * component.H
+		component.BlocksHigh = mcusHigh * component.V
+		component.ScanWide = ((width*component.H+maxH-1)/maxH + 7) / 8
+		component.ScanHigh = ((height*component.V+maxV-1)/maxV + 7) / 8
+		component.Coefficients = scratch.blocks(i, component.BlocksWide*component.BlocksHigh)
+
+		sample := func(x, y int) float64 {
+			if factorH == 1 && factorV == 1 {
+				return planes[i][y*paddedWidth+x]
+			}
+
+			sum := 0.0
+
+			for dy := 0; dy < factorV; dy++ {
+				for dx := 0; dx < factorH; dx++ {
+					sum += planes[i][(y*factorV+dy)*paddedWidth+x*factorH+dx]
+				}
+			}
+
+			return sum / float64(factorH*factorV)
+		}
+
+		var block [64]float64
+
+		for by := 0; by < component.BlocksHigh; by++ {
+			for bx := 0; bx < component.BlocksWide; bx++ {
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						block[y*8+x] = sample(bx*8+x, by*8+y) - 128
+					}
+				}
+
+				component.Coefficients[by*component.BlocksWide+bx] = forwardDCT(&block, &quantization[component.Table])
+			}
+		}
+	}
+
+	return components
+}
+
+func forwardDCT(block *[64]float64, quantization *[64]int) [64]int32 {
+	var rows [64]float64
+	var coefficients [64]int32
+
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+
+			for x := 0; x < 8; x++ {
+				sum += block[y*8+x] * dctCosines[u][x]
+			}
+
+			rows[y*8+u] = sum
+		}
+	}
+
+	for k, natural := range zigzag {
+		u, v := natural%8, natural/8
+		sum := 0.0
+
+		for y := 0; y < 8; y++ {
+			sum += rows[y*8+u] * dctCosines[v][y]
+		}
+
+		limit := 1023.0
+
+		if k == 0 {
+			limit = 2047
+		}
+
+		coefficients[k] = int32(math.Max(-limit, math.Min(limit, math.Round(sum/float64(quantization[natural])))))
+	}
+
+	return coefficients
+}
+
+func (w *jpegWriter) writeMarker(marker byte, payload []byte) {
+	if w.err != nil {
+		return
+	}
+
+	header := []byte{0xff, marker}
+
+	if payload != nil {
+		header = append(header, byte((len(payload)+2)>>8), byte(len(payload)+2))
+	}
+
+	if _, w.err = w.writer.Write(header); w.err == nil && payload != nil {
+		_, w.err = w.writer.Write(payload)
+	}
+}
+
+func (w *jpegWriter) writeHeaders(width int, height int, components []*jpegComponent, quantization [2][64]int, progressive bool) {
+	w.writeMarker(0xd8, nil)
+
+	tables := 1
+
+	if len(components) > 1 {
+		tables = 2
+	}
+
+	var dqt, dht []byte
+
+	for t := 0; t < tables; t++ {
+		dqt = append(dqt, byte(t))
+
+		for _, natural := range zigzag {
+			dqt = append(dqt, byte(quantization[t][natural]))
+		}
+
+		for class := 0; class < 2; class++ {
+			spec := huffmanSpecs[t*2+class]
+			dht = append(dht, byte(class<<4|t))
+			dht = append(dht, spec.Counts[:]...)
+			dht = append(dht, spec.Values...)
+		}
+	}
+
+	w.writeMarker(0xdb, dqt)
+
+	frame := []byte{8, byte(height >> 8), byte(height), byte(width >> 8), byte(width), byte(len(components))}
+
+	for _, component := range components {
+		frame = append(frame, component.ID, byte(component.H<<4|component.V), byte(component.Table))
+	}
+
+	if progressive {
+		w.writeMarker(0xc2, frame)
+	} else {
+		w.writeMarker(0xc0, frame)
+	}
+
+	w.writeMarker(0xc4, dht)
+}
+
+// writeScan encodes the spectral band [start, end] of the given components. A DC-only or
+// full-band scan with several components is interleaved by MCU; any other scan holds a single
+// component, whose blocks are then traversed without MCU padding.
+func (w *jpegWriter) writeScan(components []*jpegComponent, start int, end int) {
+	header := []byte{byte(len(components))}
+
+	for _, component := range components {
+		header = append(header, component.ID, byte(component.Table<<4|component.Table))
+	}
+
+	header = append(header, byte(start), byte(end), 0)
+	w.writeMarker(0xda, header)
+
+	previousDC := make([]int32, len(components))
+
+	encodeBlock := func(c int, coefficients *[64]int32) {
+		table := components[c].Table * 2
+
+		if start == 0 {
+			w.writeValue(coefficients[0]-previousDC[c], table, 0)
+			previousDC[c] = coefficients[0]
+		}
+
+		run := int32(0)
+
+		for k := max(start, 1); k <= end; k++ {
+			if coefficients[k] == 0 {
+				run++
+
+				continue
+			}
+
+			for run > 15 {
+				w.writeCode(huffmanTables[table+1][0xf0])
+				run -= 16
+			}
+
+			w.writeValue(coefficients[k], table+1, run)
+			run = 0
+		}
+
+		if run > 0 {
+			w.writeCode(huffmanTables[table+1][0x00])
+		}
+	}
+
+	if len(components) == 1 {
+		component := components[0]
+
+		for by := 0; by < component.ScanHigh; by++ {
+			for bx := 0; bx < component.ScanWide; bx++ {
+				encodeBlock(0, &component.Coefficients[by*component.BlocksWide+bx])
+			}
+		}
+	} else {
+		mcusWide := components[0].BlocksWide / components[0].H
+		mcusHigh := components[0].BlocksHigh / components[0].V
+
+		for my := 0; my < mcusHigh; my++ {
+			for mx := 0; mx < mcusWide; mx++ {
+				for c, component := range components {
+					for v := 0; v < component.V; v++ {
+						for h := 0; h < component.H; h++ {
+							bx, by := mx*component.H+h, my*component.V+v
+							encodeBlock(c, &component.Coefficients[by*component.BlocksWide+bx])
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Pad the last byte with one bits.
+	w.writeBits(0x7f, 7)
+	w.count = 0
+	w.bits = 0
+}
+
+// writeValue emits the Huffman code for (run, size) followed by the size-bit magnitude of value.
+func (w *jpegWriter) writeValue(value int32, table int, run int32) {
+	magnitude := value
+
+	if value < 0 {
+		magnitude = -value
+		value--
+	}
+
+	size := uint32(0)
+
+	for magnitude > 0 {
+		size++
+		magnitude >>= 1
+	}
+
+	w.writeCode(huffmanTables[table][uint32(run)<<4|size])
+
+	if size > 0 {
+		w.writeBits(uint32(value)&(1<<size-1), size)
+	}
+}
+
+func (w *jpegWriter) writeCode(code huffmanCode) {
+	w.writeBits(code.Code, code.Length)
+}
+
+func (w *jpegWriter) writeBits(bits uint32, count uint32) {
+	w.bits = w.bits<<count | bits
+	w.count += count
+
+	for w.count >= 8 && w.err == nil {
+		b := byte(w.bits >> (w.count - 8))
+		w.count -= 8
+		w.bits &= 1<<w.count - 1
+
+		if w.err = w.writer.WriteByte(b); w.err == nil && b == 0xff {
+			w.err = w.writer.WriteByte(0)
+		}
+	}
+}
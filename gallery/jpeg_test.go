@@ -0,0 +1,129 @@
+package gallery
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+func gradient(width int, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 2), uint8(y * 3), uint8(x ^ y), 255})
+		}
+	}
+
+	return img
+}
+
+func TestEncodeJPEGReusesBuffers(t *testing.T) {
+	encode := func(img image.Image, options JPEGOptions) []byte {
+		output := new(bytes.Buffer)
+
+		if err := EncodeJPEG(output, img, options); err != nil {
+			t.Fatal(err)
+		}
+
+		return output.Bytes()
+	}
+
+	large, small := gradient(128, 96), gradient(20, 13)
+	options := JPEGOptions{Quality: 85, Subsampling: 420}
+	first := encode(small, options)
+
+	// Buffers left larger by another image, with other planes, must not leak into the next one.
+	encode(large, JPEGOptions{Quality: 50, Subsampling: 444, Progressive: true})
+
+	if !bytes.Equal(encode(small, options), first) {
+		t.Error("encoding after a larger image differs")
+	}
+
+	// RGBA pixels read directly give the same result as through the image interface.
+	if !bytes.Equal(encode(struct{ image.Image }{small}, options), first) {
+		t.Error("direct RGBA reading differs from image.At")
+	}
+}
+
+// TestEncodeJPEGRoundTrip decodes the output of every mode with image/jpeg, and checks that it
+// stays close to the source image.
+func TestEncodeJPEGRoundTrip(t *testing.T) {
+	// A smooth image with an edge, its size not a multiple of the 16-pixel blocks of 4:2:0.
+	source := image.NewRGBA(image.Rect(0, 0, 45, 37))
+
+	for y := 0; y < 37; y++ {
+		for x := 0; x < 45; x++ {
+			red := uint8(x * 5)
+
+			if x > 30 {
+				red = 40
+			}
+
+			source.SetRGBA(x, y, color.RGBA{red, uint8(y * 6), uint8(128 + x - y), 255})
+		}
+	}
+
+	// The bounds of 4:2:0 are those of the image/jpeg encoder, which subsamples the same way, with
+	// some margin; 4:4:4 keeps the chroma of the edge.
+	for _, test := range []struct {
+		options JPEGOptions
+		maxMean float64
+	}{
+		{JPEGOptions{Quality: 90, Subsampling: 444}, 1.5},
+		{JPEGOptions{Quality: 90, Subsampling: 420}, 3.5},
+		{JPEGOptions{Quality: 90, Subsampling: 444, Progressive: true}, 1.5},
+		{JPEGOptions{Quality: 90, Subsampling: 420, Progressive: true}, 3.5},
+		{JPEGOptions{Quality: 50, Subsampling: 420, Progressive: true}, 5},
+	} {
+		output := new(bytes.Buffer)
+
+		if err := EncodeJPEG(output, source, test.options); err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := jpeg.Decode(output)
+
+		if err != nil {
+			t.Errorf("%+v: %v", test.options, err)
+
+			continue
+		}
+
+		if decoded.Bounds() != source.Bounds() {
+			t.Errorf("%+v: decoded as %v", test.options, decoded.Bounds())
+
+			continue
+		}
+
+		var total, worst float64
+
+		for y := 0; y < 37; y++ {
+			for x := 0; x < 45; x++ {
+				red, green, blue, _ := decoded.At(x, y).RGBA()
+				expected := source.RGBAAt(x, y)
+
+				for _, difference := range []float64{float64(red>>8) - float64(expected.R), float64(green>>8) - float64(expected.G), float64(blue>>8) - float64(expected.B)} {
+					total += math.Abs(difference)
+					worst = math.Max(worst, math.Abs(difference))
+				}
+			}
+		}
+
+		if mean := total / (45 * 37 * 3); mean > test.maxMean || worst > 64 {
+			t.Errorf("%+v: mean error %.2f (at most %.1f), worst %.0f", test.options, mean, test.maxMean, worst)
+		}
+	}
+}
+
+func BenchmarkEncodeJPEG(b *testing.B) {
+	img := gradient(128, 128)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		EncodeJPEG(new(bytes.Buffer), img, JPEGOptions{Quality: 85, Subsampling: 420})
+	}
+}
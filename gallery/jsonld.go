@@ -0,0 +1,59 @@
+package gallery
+
+/**
+ * Structured data
+ *
+ * The page head carries a schema.org ImageGallery, in JSON-LD, listing every texture as an
+ * ImageObject, so search engines and asset crawlers can index a published gallery by texture
+ * rather than as one big page of base64.
+ */
+
+import (
+	"encoding/json"
+)
+
+type imageObject struct {
+	Type           string `json:"@type"`
+	Name           string `json:"name"`
+	Identifier     string `json:"identifier"`
+	Keywords       string `json:"keywords"`
+	EncodingFormat string `json:"encodingFormat,omitempty"`
+	Width          int    `json:"width,omitempty"`
+	Height         int    `json:"height,omitempty"`
+	SHA256         string `json:"sha256,omitempty"`
+}
+
+type imageGallery struct {
+	Context       string        `json:"@context"`
+	Type          string        `json:"@type"`
+	Name          string        `json:"name"`
+	NumberOfItems int           `json:"numberOfItems"`
+	HasPart       []imageObject `json:"hasPart"`
+}
+
+// StructuredData returns the JSON-LD description of inventory. encoding/json escapes '<', so the
+// result can be inlined in a <script> element.
+func StructuredData(inventory *Inventory, title string) ([]byte, error) {
+	gallery := imageGallery{Context: "https://schema.org", Type: "ImageGallery", Name: title, HasPart: []imageObject{}}
+
+	for _, family := range inventory.Families {
+		for _, texture := range family.Textures {
+			decoder, _ := DecoderForExtension("." + texture.Format)
+
+			gallery.HasPart = append(gallery.HasPart, imageObject{
+				Type:           "ImageObject",
+				Name:           texture.Name,
+				Identifier:     texture.Key(),
+				Keywords:       family.Name,
+				EncodingFormat: decoder.MediaType,
+				Width:          texture.Width,
+				Height:         texture.Height,
+				SHA256:         texture.SHA256,
+			})
+		}
+	}
+
+	gallery.NumberOfItems = len(gallery.HasPart)
+
+	return json.Marshal(gallery)
+}
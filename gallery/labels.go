@@ -0,0 +1,47 @@
+package gallery
+
+/**
+ * Family labels
+ *
+ * Family directories of a CRF often have cryptic names (`corint1`, `ctyst2`). A label file maps
+ * them to readable ones ("Corinthian Stone 1") for published galleries; the page shows the label
+ * and keeps the directory name in the tooltip of the heading.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// LoadFamilyLabels reads a JSON object mapping family directories to labels, such as
+// {"fam/corint1": "Corinthian Stone 1"}. Keys are matched on their last element, case-insensitively.
+func LoadFamilyLabels(labelsPath string) (map[string]string, error) {
+	data, err := os.ReadFile(labelsPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var entries map[string]string
+
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", labelsPath, err)
+	}
+
+	labels := make(map[string]string)
+
+	for family, label := range entries {
+		family = path.Base(strings.Trim(strings.ToLower(strings.ReplaceAll(family, "\\", "/")), "/"))
+
+		if label = strings.TrimSpace(label); label == "" || family == "." {
+			return nil, fmt.Errorf("%s: invalid label %q for %q", labelsPath, label, family)
+		}
+
+		labels[family] = label
+	}
+
+	return labels, nil
+}
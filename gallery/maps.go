@@ -0,0 +1,135 @@
+package gallery
+
+/**
+ * Material maps
+ *
+ * Recognizes normal, specular and diffuse maps from their name suffix (normal maps also from their
+ * blue-dominant colors), renders normal maps as a relief-shaded preview and groups the variants of
+ * a material (maps, and with -group-variants any configured suffix plus `.mtl` files) into a
+ * single compound tile.
+ */
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+)
+
+var mapSuffixes = []struct {
+	Suffix  string
+	MapType string
+}{
+	{"_normal", "normal"},
+	{"_nrm", "normal"},
+	{"_n", "normal"},
+	{"_specular", "specular"},
+	{"_spec", "specular"},
+	{"_s", "specular"},
+	{"_diffuse", "diffuse"},
+	{"_d", "diffuse"},
+}
+
+// SplitMapName returns the material base name and map type ("normal", "specular", "diffuse" or
+// "" for a plain texture) of a texture name.
+func SplitMapName(name string) (string, string) {
+	for _, suffix := range mapSuffixes {
+		if base := strings.TrimSuffix(name, suffix.Suffix); base != name && base != "" {
+			return base, suffix.MapType
+		}
+	}
+
+	return name, ""
+}
+
+// LooksLikeNormalMap reports whether the mean colors match a tangent-space normal map: blue close
+// to saturated while red and green hover around the neutral middle value.
+func LooksLikeNormalMap(stats ImageStats) bool {
+	red, green, blue := stats.Channels[0].Mean, stats.Channels[1].Mean, stats.Channels[2].Mean
+
+	return blue > 180 && math.Abs(red-128) < 40 && math.Abs(green-128) < 40
+}
+
+// ReliefShade lights a normal map from the top left and returns the resulting grayscale relief.
+func ReliefShade(img image.Image) image.Image {
+	bounds := img.Bounds()
+	shaded := image.NewGray(bounds)
+	light := [3]float64{-0.5, 0.5, 0.707}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			normal := [3]float64{float64(c.R)/127.5 - 1, float64(c.G)/127.5 - 1, float64(c.B)/127.5 - 1}
+			length := math.Sqrt(normal[0]*normal[0] + normal[1]*normal[1] + normal[2]*normal[2])
+
+			if length == 0 {
+				continue
+			}
+
+			intensity := (normal[0]*light[0] + normal[1]*light[1] + normal[2]*light[2]) / length
+			shaded.SetGray(x, y, color.Gray{uint8(math.Max(0, math.Min(1, intensity)) * 255)})
+		}
+	}
+
+	return shaded
+}
+
+// Suffixes grouped into a single material tile by default: the diffuse, normal and specular maps.
+var MapVariantSuffixes = []string{"_d", "_diffuse", "_n", "_normal", "_nrm", "_s", "_spec", "_specular"}
+
+// Suffixes grouped with -group-variants when no -variant-suffixes list is given.
+var DefaultVariantSuffixes = append(append([]string{}, MapVariantSuffixes...), "_h", "_height", "_bump", "_gloss", "_rough", "_ao", "_e", "_glow", "_mask")
+
+// SplitVariantName returns the material base name of a texture and the index of its variant suffix
+// in suffixes, or -1 when the name has none. Longer suffixes win, so "_normal" is not read as "_l".
+func SplitVariantName(name string, suffixes []string) (string, int) {
+	match := -1
+
+	for i, suffix := range suffixes {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) && (match < 0 || len(suffix) > len(suffixes[match])) {
+			match = i
+		}
+	}
+
+	if match < 0 {
+		return name, -1
+	}
+
+	return strings.TrimSuffix(name, suffixes[match]), match
+}
+
+// GroupVariants groups the textures of a family sharing a material base name, keeping the input
+// order of the groups. Within a group the plain texture comes first, then the variants in the
+// order of their suffix in suffixes.
+func GroupVariants(textures []Texture, suffixes []string) [][]Texture {
+	var bases []string
+	groups := make(map[string][]Texture)
+
+	for _, texture := range textures {
+		base, _ := SplitVariantName(texture.Name, suffixes)
+
+		if _, ok := groups[base]; !ok {
+			bases = append(bases, base)
+		}
+
+		groups[base] = append(groups[base], texture)
+	}
+
+	var grouped [][]Texture
+
+	for _, base := range bases {
+		group := groups[base]
+
+		sort.SliceStable(group, func(i, j int) bool {
+			_, left := SplitVariantName(group[i].Name, suffixes)
+			_, right := SplitVariantName(group[j].Name, suffixes)
+
+			return left < right
+		})
+
+		grouped = append(grouped, group)
+	}
+
+	return grouped
+}
@@ -0,0 +1,97 @@
+package gallery
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// normalGradient returns a normal map whose normals lean from the left at x 0 to the right at the
+// last column, facing the viewer in the middle.
+func normalGradient(width int, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(64 + 128*x/(width-1)), 128, 255, 255})
+		}
+	}
+
+	return img
+}
+
+func TestNormalMapDetection(t *testing.T) {
+	flat := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(flat, flat.Bounds(), &image.Uniform{color.NRGBA{128, 128, 255, 255}}, image.Point{}, draw.Src)
+	gray := image.NewGray(image.Rect(0, 0, 16, 4))
+
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 4; y++ {
+			gray.SetGray(x, y, color.Gray{uint8(x * 17)})
+		}
+	}
+
+	for _, test := range []struct {
+		name   string
+		img    image.Image
+		normal bool
+	}{
+		{"flat normal map", flat, true},
+		{"normal map gradient", normalGradient(16, 4), true},
+		{"gray gradient", gray, false},
+	} {
+		if normal := LooksLikeNormalMap(ComputeStats(test.img)); normal != test.normal {
+			t.Errorf("%s taken for a normal map: %v", test.name, normal)
+		}
+	}
+
+	for name, expected := range map[string]string{"brick_n": "normal", "brick_nrm": "normal", "brick_spec": "specular", "brick_d": "diffuse", "brick": "", "_n": ""} {
+		if _, mapType := SplitMapName(name); mapType != expected {
+			t.Errorf("%s is a %q map, want %q", name, mapType, expected)
+		}
+	}
+}
+
+func TestReliefShade(t *testing.T) {
+	flat := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(flat, flat.Bounds(), &image.Uniform{color.NRGBA{128, 128, 255, 255}}, image.Point{}, draw.Src)
+	shaded := ReliefShade(flat)
+
+	// A flat surface faces the viewer, lit at the angle of the light: cos 45° of full brightness.
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if level := shaded.At(x, y).(color.Gray).Y; level < 179 || level > 181 {
+				t.Fatalf("flat normal map shaded %d at %d,%d, want 180", level, x, y)
+			}
+		}
+	}
+
+	// Lit from the top left, surfaces turning left are brighter than those turning right.
+	shaded = ReliefShade(normalGradient(16, 1))
+
+	for x := 1; x < 16; x++ {
+		if left, right := shaded.At(x-1, 0).(color.Gray).Y, shaded.At(x, 0).(color.Gray).Y; right > left {
+			t.Errorf("shading rises from %d to %d at x %d", left, right, x)
+		}
+	}
+
+	if left, right := shaded.At(0, 0).(color.Gray).Y, shaded.At(15, 0).(color.Gray).Y; left-right < 100 {
+		t.Errorf("gradient shaded from %d to %d, want a clear relief", left, right)
+	}
+
+	texture := Texture{Family: "brick", Name: "wall_n", File: "wall_n.png", Format: "png", Extension: ".png", MapType: "normal", Image: normalGradient(16, 16)}
+	inventory := &Inventory{Families: []Family{{Name: "brick", Textures: []Texture{texture}}}}
+	options := DefaultRenderOptions()
+	plain, err := Render(inventory, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options.Relief = true
+
+	if relief, err := Render(inventory, options); err != nil || string(relief) == string(plain) {
+		t.Errorf("-relief left the normal map thumbnail alone: %v", err)
+	}
+}
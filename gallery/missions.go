@@ -0,0 +1,164 @@
+package gallery
+
+/**
+ * Mission texture usage
+ *
+ * Reads the TXLIST chunk of Dark Engine tag files (`.mis`, `.gam`) to count how many missions
+ * reference each "family/texture" pair.
+ */
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	tagFileHeaderSize  = 24
+	tagFileEntrySize   = 20
+	textureNameSize    = 16
+	textureEntrySize   = 20
+	textureListHeading = 12
+)
+
+// MissionTextures returns the "family/texture" keys listed in the TXLIST chunk of a tag file.
+// Files without a TXLIST chunk, such as most `.gam` files, reference no textures.
+func MissionTextures(data []byte) ([]string, error) {
+	chunk, err := tagFileChunk(data, "TXLIST")
+
+	if err != nil || chunk == nil {
+		return nil, err
+	}
+
+	if len(chunk) < textureListHeading {
+		return nil, errors.New("tagfile: short TXLIST chunk")
+	}
+
+	textureCount := int(binary.LittleEndian.Uint32(chunk[4:8]))
+	familyCount := int(binary.LittleEndian.Uint32(chunk[8:12]))
+	entries := chunk[textureListHeading:]
+
+	if familyCount < 0 || textureCount < 0 || familyCount*textureNameSize+textureCount*textureEntrySize > len(entries) {
+		return nil, errors.New("tagfile: TXLIST counts out of range")
+	}
+
+	families := make([]string, familyCount)
+
+	for i := range families {
+		families[i] = tagFileString(entries[i*textureNameSize : (i+1)*textureNameSize])
+	}
+
+	entries = entries[familyCount*textureNameSize:]
+
+	var textures []string
+
+	for i := 0; i < textureCount; i++ {
+		entry := entries[i*textureEntrySize : (i+1)*textureEntrySize]
+		family := int(entry[1])
+		name := tagFileString(entry[4:])
+
+		if family < 1 || family > familyCount || name == "" {
+			continue
+		}
+
+		textures = append(textures, families[family-1]+"/"+name)
+	}
+
+	return textures, nil
+}
+
+// tagFileChunk returns the data of the named chunk, or nil when the file has no such chunk.
+func tagFileChunk(data []byte, name string) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, errors.New("tagfile: invalid header")
+	}
+
+	tableOffset := int(binary.LittleEndian.Uint32(data[0:4]))
+
+	if tableOffset < 0 || tableOffset+4 > len(data) {
+		return nil, errors.New("tagfile: table of contents out of range")
+	}
+
+	count := int(binary.LittleEndian.Uint32(data[tableOffset:]))
+	table := data[tableOffset+4:]
+
+	if count < 0 || count*tagFileEntrySize > len(table) {
+		return nil, errors.New("tagfile: table of contents out of range")
+	}
+
+	for i := 0; i < count; i++ {
+		entry := table[i*tagFileEntrySize : (i+1)*tagFileEntrySize]
+
+		if !strings.EqualFold(tagFileString(entry[:12]), name) {
+			continue
+		}
+
+		offset := int(binary.LittleEndian.Uint32(entry[12:16])) + tagFileHeaderSize
+		length := int(binary.LittleEndian.Uint32(entry[16:20]))
+
+		if offset < tagFileHeaderSize || length < 0 || offset+length > len(data) {
+			return nil, fmt.Errorf("tagfile: %s chunk out of range", name)
+		}
+
+		return data[offset : offset+length], nil
+	}
+
+	return nil, nil
+}
+
+func tagFileString(data []byte) string {
+	name := string(data)
+
+	if end := strings.IndexByte(name, 0); end >= 0 {
+		name = name[:end]
+	}
+
+	return strings.ToLower(name)
+}
+
+// LoadMissionUsage counts, for each "family/texture" key, how many mission files reference it.
+func LoadMissionUsage(missionsPath string) (map[string]int, error) {
+	files, err := FileListing(missionsPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string]int)
+
+	for _, filePath := range files {
+		extension := strings.ToLower(filepath.Ext(filePath))
+
+		if extension != ".mis" && extension != ".gam" {
+			continue
+		}
+
+		data, err := os.ReadFile(filePath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		textures, err := MissionTextures(data)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping mission %s: %v\n", filePath, err)
+
+			continue
+		}
+
+		seen := make(map[string]bool)
+
+		for _, texture := range textures {
+			if !seen[texture] {
+				seen[texture] = true
+				usage[texture]++
+			}
+		}
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,127 @@
+package gallery
+
+import (
+	"encoding/binary"
+	"image"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// missionTexture is an entry of the TXLIST chunk of a mission fixture, family being the index of
+// its family, from 1.
+type missionTexture struct {
+	family int
+	name   string
+}
+
+// tagFileFixture returns a tag file holding a chunk of each name of chunks, after a header of
+// tagFileHeaderSize bytes and followed by the table of contents.
+func tagFileFixture(chunks map[string][]byte) []byte {
+	data := make([]byte, tagFileHeaderSize)
+	var table []byte
+
+	for name, chunk := range chunks {
+		entry := make([]byte, tagFileEntrySize)
+		copy(entry, name)
+		binary.LittleEndian.PutUint32(entry[12:], uint32(len(data)-tagFileHeaderSize))
+		binary.LittleEndian.PutUint32(entry[16:], uint32(len(chunk)))
+		table = append(table, entry...)
+		data = append(data, chunk...)
+	}
+
+	binary.LittleEndian.PutUint32(data[0:], uint32(len(data)))
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(chunks)))
+
+	return append(data, table...)
+}
+
+// textureListFixture returns a TXLIST chunk of families and textures.
+func textureListFixture(families []string, textures []missionTexture) []byte {
+	chunk := make([]byte, textureListHeading)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(textures)))
+	binary.LittleEndian.PutUint32(chunk[8:], uint32(len(families)))
+
+	for _, family := range families {
+		name := make([]byte, textureNameSize)
+		copy(name, family)
+		chunk = append(chunk, name...)
+	}
+
+	for _, texture := range textures {
+		entry := make([]byte, textureEntrySize)
+		entry[1] = byte(texture.family)
+		copy(entry[4:], texture.name)
+		chunk = append(chunk, entry...)
+	}
+
+	return chunk
+}
+
+func TestMissionTextures(t *testing.T) {
+	chunk := textureListFixture([]string{"Brick", "metal"}, []missionTexture{{1, "Wall"}, {2, "plate"}, {3, "out_of_range"}, {0, "none"}, {1, "floor"}})
+	mission := tagFileFixture(map[string][]byte{"BRLIST": make([]byte, 40), "TXLIST": chunk})
+	textures, err := MissionTextures(mission)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"brick/wall", "metal/plate", "brick/floor"}; !reflect.DeepEqual(textures, expected) {
+		t.Errorf("textures = %q, want %q", textures, expected)
+	}
+
+	if textures, err := MissionTextures(tagFileFixture(map[string][]byte{"BRLIST": make([]byte, 40)})); textures != nil || err != nil {
+		t.Errorf("file without TXLIST gave %q, %v", textures, err)
+	}
+
+	if _, err := MissionTextures(tagFileFixture(map[string][]byte{"TXLIST": chunk[:len(chunk)-1]})); err == nil {
+		t.Error("truncated TXLIST accepted")
+	}
+}
+
+func TestLoadMissionUsage(t *testing.T) {
+	directory := t.TempDir()
+	missions := map[string][]byte{
+		"miss1.mis": tagFileFixture(map[string][]byte{"TXLIST": textureListFixture([]string{"brick"}, []missionTexture{{1, "wall"}, {1, "floor"}, {1, "wall"}})}),
+		"miss2.MIS": tagFileFixture(map[string][]byte{"TXLIST": textureListFixture([]string{"brick"}, []missionTexture{{1, "wall"}})}),
+		"all.gam":   tagFileFixture(map[string][]byte{"GAMESYS": make([]byte, 8)}),
+		"notes.txt": textureListFixture([]string{"brick"}, []missionTexture{{1, "wall"}}),
+		"bad.mis":   []byte("junk"),
+	}
+
+	for name, data := range missions {
+		if err := os.WriteFile(filepath.Join(directory, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage, err := LoadMissionUsage(directory)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := map[string]int{"brick/wall": 2, "brick/floor": 1}; !reflect.DeepEqual(usage, expected) {
+		t.Errorf("usage = %v, want %v", usage, expected)
+	}
+
+	options := DefaultRenderOptions()
+	options.MissionUsage = usage
+	texture := func(name string) Texture {
+		return Texture{Family: "brick", Name: name, File: name + ".png", Format: "png", Extension: ".png", Image: image.NewRGBA(image.Rect(0, 0, 8, 8))}
+	}
+	page, err := Render(&Inventory{Families: []Family{{Name: "brick", Textures: []Texture{texture("wall"), texture("floor"), texture("arch")}}}}, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, caption := range []string{"used in 2 missions", "used in 1 mission<", "<span class='badge unused'>unused</span>"} {
+		if !strings.Contains(string(page), caption) {
+			t.Errorf("%q missing from the captions", caption)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package gallery
+
+import (
+	"archive/zip"
+	"io"
+)
+
+// openArchive opens the ZIP archive at archivePath: only Unix systems have archives mapped.
+func openArchive(archivePath string) (*zip.Reader, io.Closer, error) {
+	return openZip(archivePath)
+}
@@ -0,0 +1,68 @@
+//go:build unix
+
+package gallery
+
+/**
+ * Memory-mapped archives
+ *
+ * Large CRF files are mapped into memory rather than read through a file: the entries are paged
+ * in from the page cache when they are read, instead of being copied into buffers of the process
+ * first, which lowers the peak memory of a scan and makes reading an entry again, as the
+ * analyses making several passes do, nearly free. The mapping is read-only, and the archive must
+ * not be truncated while it is open.
+ */
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"syscall"
+)
+
+// mmapThreshold is the size from which archives are mapped; smaller ones gain nothing from it.
+var mmapThreshold int64 = 16 << 20
+
+// mapping is a file mapped into memory.
+type mapping []byte
+
+func (data mapping) Close() error {
+	return syscall.Munmap(data)
+}
+
+// openArchive opens the ZIP archive at archivePath, mapped into memory when it is large. Archives
+// that cannot be mapped are opened like small ones.
+func openArchive(archivePath string) (*zip.Reader, io.Closer, error) {
+	file, err := os.Open(archivePath)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := file.Stat()
+
+	if err != nil || info.Size() < mmapThreshold || info.Size() > math.MaxInt {
+		file.Close()
+
+		return openZip(archivePath)
+	}
+
+	// The mapping outlives the file descriptor.
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	file.Close()
+
+	if err != nil {
+		return openZip(archivePath)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+
+	if err != nil {
+		syscall.Munmap(data)
+
+		return nil, nil, err
+	}
+
+	return reader, mapping(data), nil
+}
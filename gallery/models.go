@@ -0,0 +1,162 @@
+package gallery
+
+/**
+ * Dark Engine model index
+ *
+ * Reads the material table of `.bin` object models (LGMD) from a directory or obj CRF/ZIP file and
+ * builds a reverse index from texture name to the models using it.
+ */
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	modelHeaderSize   = 78
+	modelMaterialSize = 26
+
+	modelMaterialTexture = 0
+)
+
+// ModelTextures returns the lowercased texture names (without extension) referenced by a model.
+func ModelTextures(data []byte) ([]string, error) {
+	if len(data) < modelHeaderSize || string(data[0:4]) != "LGMD" {
+		return nil, errors.New("bin: not a Dark Engine model")
+	}
+
+	materialCount := int(data[66])
+	materialOffset := int(binary.LittleEndian.Uint32(data[74:78]))
+
+	if materialOffset < 0 || materialOffset+materialCount*modelMaterialSize > len(data) {
+		return nil, errors.New("bin: material table out of range")
+	}
+
+	var textures []string
+
+	for i := 0; i < materialCount; i++ {
+		material := data[materialOffset+i*modelMaterialSize:]
+
+		if material[16] != modelMaterialTexture {
+			continue
+		}
+
+		name := string(material[:16])
+
+		if end := strings.IndexByte(name, 0); end >= 0 {
+			name = name[:end]
+		}
+
+		name = strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+
+		if name != "" {
+			textures = append(textures, name)
+		}
+	}
+
+	return textures, nil
+}
+
+// LoadModelIndex maps texture names to the sorted list of `.bin` models referencing them.
+func LoadModelIndex(modelsPath string) (map[string][]string, error) {
+	index := make(map[string][]string)
+
+	addModel := func(name string, reader io.Reader) error {
+		data, err := io.ReadAll(reader)
+
+		if err != nil {
+			return err
+		}
+
+		textures, err := ModelTextures(data)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping model %s: %v\n", name, err)
+
+			return nil
+		}
+
+		model := strings.ToLower(filepath.Base(name))
+		seen := make(map[string]bool)
+
+		for _, texture := range textures {
+			if !seen[texture] {
+				seen[texture] = true
+				index[texture] = append(index[texture], model)
+			}
+		}
+
+		return nil
+	}
+
+	isModel := func(name string) bool {
+		return strings.ToLower(filepath.Ext(name)) == ".bin"
+	}
+
+	if fileInfo, err := os.Stat(modelsPath); err == nil && fileInfo.IsDir() {
+		files, err := FileListing(modelsPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, filePath := range files {
+			if !isModel(filePath) {
+				continue
+			}
+
+			modelFile, err := os.Open(filePath)
+
+			if err != nil {
+				return nil, err
+			}
+
+			err = addModel(filePath, modelFile)
+			modelFile.Close()
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		zipReader, err := zip.OpenReader(modelsPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer zipReader.Close()
+
+		for _, file := range zipReader.File {
+			if !isModel(file.Name) {
+				continue
+			}
+
+			reader, err := file.Open()
+
+			if err != nil {
+				return nil, err
+			}
+
+			err = addModel(file.Name, reader)
+			reader.Close()
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for texture := range index {
+		sort.Strings(index[texture])
+	}
+
+	return index, nil
+}
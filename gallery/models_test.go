@@ -0,0 +1,119 @@
+package gallery
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"image"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// modelMaterial is a material of a model fixture: the name of its texture, or of a solid color
+// when kind is not modelMaterialTexture.
+type modelMaterial struct {
+	name string
+	kind byte
+}
+
+// modelFixture returns an LGMD model whose material table, after a few bytes of other data, holds
+// materials.
+func modelFixture(materials ...modelMaterial) []byte {
+	data := make([]byte, modelHeaderSize+10)
+	copy(data, "LGMD")
+	binary.LittleEndian.PutUint32(data[4:], 4)
+	data[66] = byte(len(materials))
+	binary.LittleEndian.PutUint32(data[74:], uint32(len(data)))
+
+	for _, material := range materials {
+		entry := make([]byte, modelMaterialSize)
+		copy(entry, material.name)
+		entry[16] = material.kind
+		data = append(data, entry...)
+	}
+
+	return data
+}
+
+func TestModelTextures(t *testing.T) {
+	model := modelFixture(modelMaterial{"Wall.PCX", modelMaterialTexture}, modelMaterial{"red", 1}, modelMaterial{"floor", modelMaterialTexture}, modelMaterial{"sixteencharsname", modelMaterialTexture})
+	textures, err := ModelTextures(model)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"wall", "floor", "sixteencharsname"}; !reflect.DeepEqual(textures, expected) {
+		t.Errorf("textures = %q, want %q", textures, expected)
+	}
+
+	if _, err := ModelTextures(model[:len(model)-1]); err == nil {
+		t.Error("truncated material table accepted")
+	}
+
+	if _, err := ModelTextures(append([]byte("LGMX"), model[4:]...)); err == nil {
+		t.Error("model without the LGMD signature accepted")
+	}
+}
+
+func TestLoadModelIndex(t *testing.T) {
+	models := map[string][]byte{
+		"sword.bin":       modelFixture(modelMaterial{"steel.gif", modelMaterialTexture}, modelMaterial{"leather", modelMaterialTexture}, modelMaterial{"steel", modelMaterialTexture}),
+		"objs/Hammer.BIN": modelFixture(modelMaterial{"steel", modelMaterialTexture}),
+		"readme.txt":      []byte("not a model"),
+		"broken.bin":      []byte("LGMD"),
+	}
+
+	directory := t.TempDir()
+	archivePath := filepath.Join(t.TempDir(), "obj.crf")
+	archiveFile, err := os.Create(archivePath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := zip.NewWriter(archiveFile)
+
+	for name, data := range models {
+		os.MkdirAll(filepath.Dir(filepath.Join(directory, name)), 0755)
+
+		if err := os.WriteFile(filepath.Join(directory, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		entry, _ := archive.Create(name)
+		entry.Write(data)
+	}
+
+	archive.Close()
+	archiveFile.Close()
+
+	expected := map[string][]string{"steel": {"hammer.bin", "sword.bin"}, "leather": {"sword.bin"}}
+
+	for _, modelsPath := range []string{directory, archivePath} {
+		index, err := LoadModelIndex(modelsPath)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(index, expected) {
+			t.Errorf("%s: index = %v, want %v", filepath.Base(modelsPath), index, expected)
+		}
+	}
+
+	options := DefaultRenderOptions()
+	options.ModelIndex = expected
+	texture := Texture{Family: "metal", Name: "steel", File: "steel.png", Format: "png", Extension: ".png", Image: image.NewRGBA(image.Rect(0, 0, 8, 8))}
+	page, err := Render(&Inventory{Families: []Family{{Name: "metal", Textures: []Texture{texture}}}}, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(page), "<span class='usage'>used by: hammer.bin, sword.bin</span>") {
+		t.Error("models missing from the caption")
+	}
+}
@@ -0,0 +1,61 @@
+package gallery
+
+/**
+ * Family mosaics
+ *
+ * Stitches the thumbnails of a family into a single grid image, handy for forum posts.
+ */
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// BuildMosaic lays thumbnails out in a roughly square grid of cellSize cells, each thumbnail
+// centered in its cell.
+func BuildMosaic(thumbnails []image.Image, cellSize int, gap int, background color.Color) image.Image {
+	columns := int(math.Ceil(math.Sqrt(float64(len(thumbnails)))))
+
+	if columns == 0 {
+		columns = 1
+	}
+
+	rows := (len(thumbnails) + columns - 1) / columns
+	width := columns*cellSize + (columns+1)*gap
+	height := rows*cellSize + (rows+1)*gap
+
+	mosaic := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(mosaic, mosaic.Bounds(), &image.Uniform{background}, image.Point{}, draw.Src)
+
+	for i, thumbnail := range thumbnails {
+		bounds := thumbnail.Bounds()
+		x := gap + (i%columns)*(cellSize+gap) + (cellSize-bounds.Dx())/2
+		y := gap + (i/columns)*(cellSize+gap) + (cellSize-bounds.Dy())/2
+
+		draw.Draw(mosaic, image.Rect(x, y, x+bounds.Dx(), y+bounds.Dy()), thumbnail, bounds.Min, draw.Over)
+	}
+
+	return mosaic
+}
+
+// WriteMosaic saves the mosaic of a family as `<family>.png` inside directoryPath.
+func WriteMosaic(directoryPath string, family string, mosaic image.Image) error {
+	if err := os.MkdirAll(directoryPath, 0755); err != nil {
+		return err
+	}
+
+	mosaicFile, err := os.Create(filepath.Join(directoryPath, family+".png"))
+
+	if err != nil {
+		return err
+	}
+
+	defer mosaicFile.Close()
+
+	return png.Encode(mosaicFile, mosaic)
+}
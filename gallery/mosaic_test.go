@@ -0,0 +1,76 @@
+package gallery
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildMosaic(t *testing.T) {
+	background := color.RGBA{51, 51, 51, 255}
+	var thumbnails []image.Image
+
+	for i := 0; i < 5; i++ {
+		thumbnail := image.NewRGBA(image.Rect(0, 0, 8, 4))
+		draw.Draw(thumbnail, thumbnail.Bounds(), &image.Uniform{color.RGBA{uint8(40 * (i + 1)), 0, 0, 255}}, image.Point{}, draw.Src)
+		thumbnails = append(thumbnails, thumbnail)
+	}
+
+	// Five thumbnails take three columns of two rows, with gaps around and between the cells.
+	mosaic := BuildMosaic(thumbnails, 16, 8, background)
+
+	if mosaic.Bounds() != image.Rect(0, 0, 3*16+4*8, 2*16+3*8) {
+		t.Fatalf("mosaic bounds %v", mosaic.Bounds())
+	}
+
+	// The fifth thumbnail is centered in the second cell of the second row, the sixth cell empty.
+	for _, pixel := range []struct {
+		x, y     int
+		expected color.RGBA
+	}{
+		{0, 0, background},
+		{8 + 4, 8 + 6, color.RGBA{40, 0, 0, 255}},
+		{36, 38, color.RGBA{200, 0, 0, 255}},
+		{43, 41, color.RGBA{200, 0, 0, 255}},
+		{35, 38, background},
+		{44, 41, background},
+		{36, 42, background},
+		{68, 40, background},
+	} {
+		if actual := mosaic.At(pixel.x, pixel.y); actual != pixel.expected {
+			t.Errorf("pixel %d,%d is %v, want %v", pixel.x, pixel.y, actual, pixel.expected)
+		}
+	}
+
+	if empty := BuildMosaic(nil, 16, 8, background); empty.Bounds() != image.Rect(0, 0, 32, 8) {
+		t.Errorf("empty mosaic bounds %v", empty.Bounds())
+	}
+
+	directory := filepath.Join(t.TempDir(), "mosaics")
+
+	if err := WriteMosaic(directory, "brick", mosaic); err != nil {
+		t.Fatal(err)
+	}
+
+	mosaicFile, err := os.Open(filepath.Join(directory, "brick.png"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer mosaicFile.Close()
+
+	written, err := png.Decode(mosaicFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if written.Bounds() != mosaic.Bounds() || color.RGBAModel.Convert(written.At(36, 38)) != (color.RGBA{200, 0, 0, 255}) {
+		t.Errorf("written mosaic differs: %v, %v", written.Bounds(), written.At(36, 38))
+	}
+}
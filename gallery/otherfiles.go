@@ -0,0 +1,50 @@
+package gallery
+
+/**
+ * Other files
+ *
+ * Besides their textures, material files and palettes, CRFs end up holding files nobody meant
+ * to ship: Photoshop sources, thumbnail caches, notes, scripts. The scan lists the files the
+ * rules skip in Inventory.OtherFiles, and the page ends with them and their size, the system
+ * files left by file managers marked as such, so maintainers notice them before a release.
+ */
+
+import (
+	"fmt"
+	"html"
+	"path"
+	"strings"
+)
+
+// SystemFile tells the files file managers leave in directories, which no pack wants: hidden
+// files, Thumbs.db and desktop.ini.
+func SystemFile(name string) bool {
+	base := path.Base(name)
+	lower := strings.ToLower(base)
+
+	return strings.HasPrefix(base, ".") || lower == "thumbs.db" || lower == "desktop.ini"
+}
+
+// renderOtherFiles renders the table of the other files of inventory, or nothing when it has
+// none.
+func renderOtherFiles(inventory *Inventory) string {
+	if len(inventory.OtherFiles) == 0 {
+		return ""
+	}
+
+	var rows strings.Builder
+	var total int64
+
+	for _, file := range inventory.OtherFiles {
+		badge := ""
+
+		if SystemFile(file.Path) {
+			badge = " <span class='badge warning'>system file</span>"
+		}
+
+		fmt.Fprintf(&rows, "<tr><td>%s%s</td><td class='size'>%s</td><td>%s</td></tr>", html.EscapeString(file.Path), badge, formatSize(file.Size), html.EscapeString(file.Reason))
+		total += file.Size
+	}
+
+	return fmt.Sprintf("<div class='other-files'><h2>Other files <span class='badge count'>%d</span></h2><p class='description'>%s in files that are neither textures nor material files nor family palettes.</p><table>%s</table></div>", len(inventory.OtherFiles), formatSize(total), rows.String())
+}
@@ -0,0 +1,119 @@
+package gallery
+
+/**
+ * Texture pack overlays
+ *
+ * NewDark loads textures from several resource paths, a mod directory shadowing the textures of
+ * the base CRF that share its family and name, whatever their format. Overlay applies the same
+ * precedence to inventories, so the page shows the textures the engine would actually use, each
+ * overriding texture noting the sources it shadows.
+ */
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Overlay merges inventories given in loading order: a texture of a later inventory replaces the
+// textures of earlier ones with the same family and name. Overriding textures have their Source
+// set and list the paths they shadow in Shadows.
+func Overlay(inventories ...*Inventory) *Inventory {
+	if len(inventories) == 1 {
+		return inventories[0]
+	}
+
+	var sources []string
+	merged := &Inventory{Metadata: make(map[string]string)}
+	families := make(map[string]*Family)
+	emptyFamilies := make(map[string]bool)
+
+	for layer, inventory := range inventories {
+		sources = append(sources, inventory.Source)
+		merged.Skipped = append(merged.Skipped, inventory.Skipped...)
+		merged.OtherFiles = append(merged.OtherFiles, inventory.OtherFiles...)
+		merged.Decisions = append(merged.Decisions, inventory.Decisions...)
+
+		if merged.Incomplete == "" && inventory.Incomplete != "" {
+			merged.Incomplete = fmt.Sprintf("%s: %s", inventory.Source, inventory.Incomplete)
+		}
+
+		// The base inventory describes the pack, the overrides only add to it.
+		for key, value := range inventory.Metadata {
+			if _, ok := merged.Metadata[key]; !ok {
+				merged.Metadata[key] = value
+			}
+		}
+
+		for _, name := range inventory.EmptyFamilies {
+			emptyFamilies[name] = true
+		}
+
+		for _, family := range inventory.Families {
+			current := families[family.Name]
+
+			if current == nil {
+				current = &Family{Name: family.Name, MaterialFiles: make(map[string][]string)}
+				families[family.Name] = current
+			}
+
+			if family.Description != "" {
+				current.Description = family.Description
+			}
+
+			for base, files := range family.MaterialFiles {
+				current.MaterialFiles[base] = append(current.MaterialFiles[base], files...)
+			}
+
+			overridden := make(map[string][]Texture)
+
+			for _, texture := range family.Textures {
+				overridden[texture.Name] = nil
+			}
+
+			var kept []Texture
+
+			for _, texture := range current.Textures {
+				if _, ok := overridden[texture.Name]; ok {
+					overridden[texture.Name] = append(overridden[texture.Name], texture)
+				} else {
+					kept = append(kept, texture)
+				}
+			}
+
+			for _, texture := range family.Textures {
+				if layer > 0 {
+					texture.Source = inventory.Source
+				}
+
+				for _, shadowed := range overridden[texture.Name] {
+					texture.Shadows = append(texture.Shadows, shadowed.Path)
+					texture.Shadows = append(texture.Shadows, shadowed.Shadows...)
+				}
+
+				kept = append(kept, texture)
+			}
+
+			current.Textures = kept
+		}
+	}
+
+	merged.Source = strings.Join(sources, " + ")
+
+	for _, family := range families {
+		merged.Families = append(merged.Families, *family)
+		delete(emptyFamilies, family.Name)
+	}
+
+	for name := range emptyFamilies {
+		merged.EmptyFamilies = append(merged.EmptyFamilies, name)
+	}
+
+	sort.Strings(merged.EmptyFamilies)
+
+	sort.Slice(merged.Families, func(i, j int) bool {
+		return merged.Families[i].Name < merged.Families[j].Name
+	})
+
+	return merged
+}
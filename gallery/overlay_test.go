@@ -0,0 +1,64 @@
+package gallery
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOverlay(t *testing.T) {
+	scan := func(name string, fsys fstest.MapFS) *Inventory {
+		inventory, err := ScanFS(fsys, name, DefaultScanOptions())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return inventory
+	}
+
+	base := scan("fam.crf", fstest.MapFS{
+		"brick/wall.png":  {Data: encodePNG(t, 8, 8)},
+		"brick/floor.png": {Data: encodePNG(t, 8, 8)},
+		"metal/plate.png": {Data: encodePNG(t, 8, 8)},
+	})
+	mod := scan("mod", fstest.MapFS{
+		"brick/WALL.jpg": {Data: encodePNG(t, 16, 16)},
+		"sky/clouds.png": {Data: encodePNG(t, 4, 4)},
+	})
+	patch := scan("patch", fstest.MapFS{
+		"brick/wall.png": {Data: encodePNG(t, 32, 32)},
+	})
+
+	merged := Overlay(base, mod, patch)
+
+	if merged.Source != "fam.crf + mod + patch" || len(merged.Families) != 3 || merged.TextureCount() != 4 {
+		t.Fatalf("unexpected inventory: %+v", merged)
+	}
+
+	var wall Texture
+
+	for _, texture := range merged.Families[0].Textures {
+		if texture.Name == "wall" {
+			wall = texture
+		}
+	}
+
+	if wall.Width != 32 || wall.Source != "patch" || strings.Join(wall.Shadows, ",") != "brick/WALL.jpg,brick/wall.png" {
+		t.Errorf("unexpected wall: %+v", wall)
+	}
+
+	if floor := merged.Families[0].Textures[0]; floor.Name != "floor" || floor.Source != "" || floor.Shadows != nil {
+		t.Errorf("unexpected floor: %+v", floor)
+	}
+
+	page, err := Render(merged, DefaultRenderOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(page), "<span class='usage override' title='brick/WALL.jpg\nbrick/wall.png'>WALL.jpg, wall.png overridden by patch</span>") {
+		t.Error("page lacks the override note")
+	}
+}
@@ -0,0 +1,179 @@
+package gallery
+
+/**
+ * Texture pack writing
+ *
+ * The inverse of the scan: WritePack stores the files of a texture pack as a CRF, one directory
+ * per family holding its files, with no other level, entries in name order so that families stay
+ * together. CheckPack goes over the files first with the inclusion rules and the decoders of the
+ * scan, so a pack the engine or crf2html would trip on is caught before it ships: files outside
+ * a family directory, names differing only by case or longer than 8.3, broken textures,
+ * dimensions the Dark Engine handles badly, and indexed textures whose palette is not the one of
+ * their family.
+ */
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"image"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// PackEntry is a file of a texture pack, under its slash-separated name in the archive.
+type PackEntry struct {
+	Name string
+	Data []byte
+}
+
+// PackIssue is a problem found in a file of a texture pack. Fatal ones make the pack unusable,
+// the others are warnings.
+type PackIssue struct {
+	Name    string
+	Message string
+	Fatal   bool
+}
+
+func (issue PackIssue) String() string {
+	if issue.Fatal {
+		return fmt.Sprintf("%s: error: %s", issue.Name, issue.Message)
+	}
+
+	return fmt.Sprintf("%s: warning: %s", issue.Name, issue.Message)
+}
+
+// CheckPack checks the files of a texture pack and returns their issues, in name order.
+func CheckPack(entries []PackEntry) []PackIssue {
+	var issues []PackIssue
+	names := make(map[string]string)
+	rules := DefaultRules()
+
+	// The palettes of the families, read first for the textures to be compared with them.
+	palettes := make(map[string][]string)
+
+	for _, entry := range entries {
+		parts := strings.Split(strings.ToLower(entry.Name), "/")
+
+		if len(parts) != 2 {
+			continue
+		}
+
+		candidate := Candidate{Path: entry.Name, Family: parts[0], File: parts[1], Extension: path.Ext(parts[1]), Data: entry.Data}
+
+		if rules.Decide(candidate).Rule != "family-palette" {
+			continue
+		}
+
+		if palette, err := readPalette(entry.Data, DefaultScanOptions()); err == nil {
+			palettes[parts[0]] = palette
+		}
+	}
+
+	for _, entry := range entries {
+		parts := strings.Split(entry.Name, "/")
+
+		if len(parts) != 2 {
+			issues = append(issues, PackIssue{entry.Name, "not directly in a family directory", true})
+
+			continue
+		}
+
+		lower := strings.ToLower(entry.Name)
+
+		if other, ok := names[lower]; ok {
+			issues = append(issues, PackIssue{entry.Name, fmt.Sprintf("same name as %s but for case", other), true})
+
+			continue
+		}
+
+		names[lower] = entry.Name
+
+		if strings.ContainsFunc(entry.Name, func(character rune) bool {
+			return character > 0x7e || character == ' '
+		}) {
+			issues = append(issues, PackIssue{entry.Name, "spaces or non-ASCII characters in the name", false})
+		}
+
+		if !ShortName(entry.Name) {
+			issues = append(issues, PackIssue{entry.Name, "name longer than 8.3 characters", false})
+		}
+
+		extension := strings.ToLower(path.Ext(parts[1]))
+		candidate := Candidate{Path: entry.Name, Family: strings.ToLower(parts[0]), File: strings.ToLower(parts[1]), Extension: extension, Data: entry.Data}
+
+		if rules.Decide(candidate).Verdict != VerdictTexture {
+			continue
+		}
+
+		decoder, _, _ := resolveDecoder(extension, entry.Data)
+		img, size, err := decodeLimited(decoder, entry.Data, DefaultScanOptions())
+
+		if err != nil {
+			issues = append(issues, PackIssue{entry.Name, err.Error(), true})
+
+			continue
+		}
+
+		if !powerOfTwo(size.X) || !powerOfTwo(size.Y) {
+			issues = append(issues, PackIssue{entry.Name, fmt.Sprintf("%dx%d is not a power of two on both sides", size.X, size.Y), false})
+		}
+
+		if AspectKind(size.X, size.Y) == AspectStrip {
+			issues = append(issues, PackIssue{entry.Name, fmt.Sprintf("%dx%d is a %s strip, check it is meant as a trim", size.X, size.Y, AspectRatio(size.X, size.Y)), false})
+		}
+
+		if paletted, ok := img.(*image.Paletted); ok && len(palettes[candidate.Family]) > 0 {
+			if mismatches := paletteMismatches(paletted.Palette, palettes[candidate.Family]); mismatches > 0 {
+				issues = append(issues, PackIssue{entry.Name, fmt.Sprintf("palette differs from the one of the family in %d colors, the engine will draw it with wrong colors", mismatches), false})
+			}
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Name < issues[j].Name
+	})
+
+	return issues
+}
+
+func powerOfTwo(value int) bool {
+	return value > 0 && value&(value-1) == 0
+}
+
+// WritePack writes entries to writer as a CRF, in name order, deflated at level (from
+// flate.NoCompression, which stores them, to flate.BestCompression).
+func WritePack(writer io.Writer, entries []PackEntry, level int) error {
+	sorted := append([]PackEntry(nil), entries...)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	archive := zip.NewWriter(writer)
+	archive.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, level)
+	})
+
+	method := zip.Deflate
+
+	if level == flate.NoCompression {
+		method = zip.Store
+	}
+
+	for _, entry := range sorted {
+		file, err := archive.CreateHeader(&zip.FileHeader{Name: entry.Name, Method: method})
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := file.Write(entry.Data); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
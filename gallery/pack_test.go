@@ -0,0 +1,199 @@
+package gallery
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"image"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/samuel/go-pcx/pcx"
+)
+
+func TestCheckPack(t *testing.T) {
+	entries := []PackEntry{
+		{"stone/floor.png", encodePNG(t, 4, 4)},
+		{"stone/Floor.png", encodePNG(t, 4, 4)},
+		{"stone/wide.png", encodePNG(t, 6, 4)},
+		{"stone/broken.png", []byte("\x89PNG\r\n\x1a\nbroken")},
+		{"stone/full.pcx", []byte("palette")},
+		{"stone/old wall.png", encodePNG(t, 8, 8)},
+		{"stone/cobblestone.png", encodePNG(t, 8, 8)},
+		{"readme.txt", []byte("root file")},
+		{"stone/old/floor.png", encodePNG(t, 4, 4)},
+	}
+
+	var messages []string
+
+	for _, issue := range CheckPack(entries) {
+		messages = append(messages, issue.String())
+	}
+
+	expected := []string{
+		"readme.txt: error: not directly in a family directory",
+		"stone/Floor.png: error: same name as stone/floor.png but for case",
+		"stone/broken.png: error: unexpected EOF",
+		"stone/cobblestone.png: warning: name longer than 8.3 characters",
+		"stone/old wall.png: warning: spaces or non-ASCII characters in the name",
+		"stone/old/floor.png: error: not directly in a family directory",
+		"stone/wide.png: warning: 6x4 is not a power of two on both sides",
+	}
+
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("CheckPack = %q, want %q", messages, expected)
+	}
+}
+
+// TestPaletteMismatch checks that indexed textures saved with a palette other than the one of
+// their family are caught by the scan, on the page and by CheckPack.
+func TestPaletteMismatch(t *testing.T) {
+	palette := make(color.Palette, 256)
+
+	for i := range palette {
+		palette[i] = color.RGBA{uint8(i), uint8(255 - i), uint8(i * 7), 255}
+	}
+
+	// The same texture, saved with its last three colors changed.
+	odd := append(color.Palette(nil), palette...)
+	odd[253], odd[254], odd[255] = color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255}, color.RGBA{0, 0, 255, 255}
+	output := new(bytes.Buffer)
+
+	if err := pcx.Encode(output, image.NewPaletted(image.Rect(0, 0, 8, 8), odd)); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []PackEntry{
+		{"brick/full.pcx", pcxFixture(t, 8, 8, true)},
+		{"brick/wall.pcx", pcxFixture(t, 8, 8, true)},
+		{"brick/odd.pcx", output.Bytes()},
+		{"brick/photo.png", encodePNG(t, 8, 8)},
+	}
+
+	var messages []string
+
+	for _, issue := range CheckPack(entries) {
+		messages = append(messages, issue.String())
+	}
+
+	if expected := []string{"brick/odd.pcx: warning: palette differs from the one of the family in 3 colors, the engine will draw it with wrong colors"}; !reflect.DeepEqual(messages, expected) {
+		t.Errorf("CheckPack = %q, want %q", messages, expected)
+	}
+
+	fsys := fstest.MapFS{}
+
+	for _, entry := range entries {
+		fsys[entry.Name] = &fstest.MapFile{Data: entry.Data}
+	}
+
+	inventory, err := ScanFS(fsys, "pack", DefaultScanOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mismatches := make(map[string]int)
+
+	for _, texture := range inventory.Families[0].Textures {
+		mismatches[texture.Name] = texture.PaletteMismatch
+	}
+
+	if expected := map[string]int{"odd": 3, "photo": 0, "wall": 0}; !reflect.DeepEqual(mismatches, expected) {
+		t.Errorf("palette mismatches %v, want %v", mismatches, expected)
+	}
+
+	page, err := Render(inventory, DefaultRenderOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if badge := "<span class='badge warning' title='3 colors differ from the palette of the family'>palette</span>"; strings.Count(string(page), badge) != 1 {
+		t.Errorf("%s expected once", badge)
+	}
+}
+
+func TestWritePack(t *testing.T) {
+	entries := []PackEntry{
+		{"stone/wall.png", encodePNG(t, 4, 4)},
+		{"brick/floor.png", encodePNG(t, 8, 8)},
+		{"brick/full.pcx", []byte("palette")},
+	}
+
+	for _, level := range []int{flate.NoCompression, flate.BestCompression} {
+		archive := new(bytes.Buffer)
+
+		if err := WritePack(archive, entries, level); err != nil {
+			t.Fatal(err)
+		}
+
+		zipReader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+
+		for _, file := range zipReader.File {
+			names = append(names, file.Name)
+		}
+
+		if expected := []string{"brick/floor.png", "brick/full.pcx", "stone/wall.png"}; !reflect.DeepEqual(names, expected) {
+			t.Errorf("level %d: entries %q, want %q", level, names, expected)
+		}
+
+		inventory, err := ScanFS(zipReader, "pack.crf", DefaultScanOptions())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if inventory.TextureCount() != 2 || len(inventory.Families) != 2 {
+			t.Errorf("level %d: unexpected inventory %+v", level, inventory)
+		}
+	}
+}
+
+func TestShortNames(t *testing.T) {
+	renames := ShortNames([]string{
+		"stone/wall.png",
+		"stone/cobblestone.png",
+		"stone/cobblestone.mtl",
+		"stone/cobblestones.jpeg",
+		"stone/cobblest.png",
+		"stonework/O'Brien's.tga",
+		"stonework/arch.png",
+	})
+
+	expected := []Rename{
+		{"stone/cobblestone.mtl", "stone/cobble~1.mtl"},
+		{"stone/cobblestone.png", "stone/cobble~1.png"},
+		{"stone/cobblestones.jpeg", "stone/cobble~2.jpg"},
+		{"stonework/O'Brien's.tga", "stonewor/O'Brien'.tga"},
+		{"stonework/arch.png", "stonewor/arch.png"},
+	}
+
+	if !reflect.DeepEqual(renames, expected) {
+		t.Fatalf("ShortNames = %q, want %q", renames, expected)
+	}
+
+	script := new(bytes.Buffer)
+
+	if err := WriteRenameScript(script, renames); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"mv -- 'stonework' 'stonewor'\n", "mv -- 'stone/cobblestones.jpeg' 'stone/cobble~2.jpg'\n", `mv -- 'stonewor/O'\''Brien'\''s.tga' 'stonewor/O'\''Brien'\''.tga'`} {
+		if !bytes.Contains(script.Bytes(), []byte(line)) {
+			t.Errorf("script lacks %q:\n%s", line, script)
+		}
+	}
+
+	if bytes.Contains(script.Bytes(), []byte("arch.png")) {
+		t.Errorf("script moves files renamed by their directory only:\n%s", script)
+	}
+}
@@ -0,0 +1,144 @@
+package gallery
+
+/**
+ * Family palettes
+ *
+ * The 8-bit textures of a Dark Engine family are drawn with the 256-color palette of its full.pcx
+ * file. The scan reads it into Family.Palette, and the page ends with the palettes of all the
+ * families having one side by side, sixteen colors a row in palette order, so the artists of a
+ * new family can pick colors that sit well with the existing ones.
+ *
+ * The engine draws every texture of the family with that palette, whatever palette the texture
+ * file carries: an indexed texture saved with another one shows with wrong colors in the game,
+ * though it looks right in any image viewer. The scan counts the entries of such palettes
+ * differing from the one of the family, tiles get a "palette" warning badge, and pack warns.
+ */
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// readPalette returns the colors of the palette of a full.pcx file, as #rrggbb strings.
+func readPalette(data []byte, options ScanOptions) ([]string, error) {
+	decoder, ok := DecoderForExtension(".pcx")
+
+	if !ok {
+		return nil, ErrUnknownFormat
+	}
+
+	img, _, err := decodeLimited(decoder, data, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	paletted, ok := img.(*image.Paletted)
+
+	if !ok {
+		return nil, errors.New("pcx: no palette")
+	}
+
+	var colors []string
+
+	for _, paletteColor := range paletted.Palette {
+		red, green, blue, _ := paletteColor.RGBA()
+		colors = append(colors, fmt.Sprintf("#%02x%02x%02x", red>>8, green>>8, blue>>8))
+	}
+
+	return colors, nil
+}
+
+// paletteMismatches returns the number of entries of palette differing from the #rrggbb colors
+// of the family, entries only one of them has included.
+func paletteMismatches(palette color.Palette, family []string) int {
+	mismatches := 0
+
+	for i := 0; i < max(len(palette), len(family)); i++ {
+		if i >= len(palette) || i >= len(family) {
+			mismatches++
+
+			continue
+		}
+
+		red, green, blue, _ := palette[i].RGBA()
+
+		if fmt.Sprintf("#%02x%02x%02x", red>>8, green>>8, blue>>8) != family[i] {
+			mismatches++
+		}
+	}
+
+	return mismatches
+}
+
+// checkPalettes sets the PaletteMismatch of the indexed textures of the families having a palette.
+// Textures scanned for their headers only have no image to compare, and are left unchecked.
+func (inventory *Inventory) checkPalettes() {
+	for i := range inventory.Families {
+		family := &inventory.Families[i]
+
+		if len(family.Palette) == 0 {
+			continue
+		}
+
+		for j := range family.Textures {
+			texture := &family.Textures[j]
+
+			if texture.Palette == 0 || texture.Error != "" {
+				continue
+			}
+
+			if img, err := texture.LoadImage(); err == nil {
+				if paletted, ok := img.(*image.Paletted); ok {
+					texture.PaletteMismatch = paletteMismatches(paletted.Palette, family.Palette)
+				}
+			}
+		}
+	}
+}
+
+// renderPaletteMismatch returns the badge of a texture whose palette differs from the one of its
+// family, or nothing.
+func renderPaletteMismatch(texture Texture) string {
+	if texture.PaletteMismatch == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(" <span class='badge warning' title='%d colors differ from the palette of the family'>palette</span>", texture.PaletteMismatch)
+}
+
+// renderPalettes renders the palettes of the families having one, side by side, or nothing when
+// none has.
+func renderPalettes(inventory *Inventory, options RenderOptions) string {
+	var figures []string
+
+	for _, family := range inventory.Families {
+		if len(family.Palette) == 0 {
+			continue
+		}
+
+		label := family.Name
+
+		if familyLabel, ok := options.FamilyLabels[family.Name]; ok {
+			label = familyLabel
+		}
+
+		var swatches strings.Builder
+
+		for i, swatch := range family.Palette {
+			fmt.Fprintf(&swatches, "<rect x='%d' y='%d' width='1' height='1' fill='%s'><title>%d %s</title></rect>", i%16, i/16, swatch, i, swatch)
+		}
+
+		figures = append(figures, fmt.Sprintf("<figure class='palette'><svg viewBox='0 0 16 %d' shape-rendering='crispEdges'>%s</svg><figcaption>%s</figcaption></figure>", (len(family.Palette)+15)/16, swatches.String(), html.EscapeString(label)))
+	}
+
+	if len(figures) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("<div class='palettes'><h2>Palettes</h2><div class='palette-list'>%s</div></div>", strings.Join(figures, ""))
+}
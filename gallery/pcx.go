@@ -0,0 +1,171 @@
+package gallery
+
+/**
+ * PCX decoder
+ *
+ * Dark Engine textures are mostly 8-bit paletted PCX files, and decoding them dominates the
+ * scan of a CRF. The decoder of go-pcx reads them one byte at a time through a bufio.Reader
+ * and copies every run byte by byte; DecodePCX works on the file already in memory instead,
+ * unpacking runs in bulk straight into the pixels of the image. The RLE stream has no index of
+ * its scanlines, so it is unpacked sequentially: the gain comes from the inner loop, not from
+ * more cores. 8-bit paletted and 24/32-bit files, everything a texture pack holds, take this
+ * path; the other variants (EGA, CGA, planar, grayscale) are left to go-pcx.
+ */
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/samuel/go-pcx/pcx"
+)
+
+const (
+	pcxHeaderSize   = 128
+	pcxPaletteMagic = 0x0c
+	pcxPaletteSize  = 3 * 256
+)
+
+// DecodePCX decodes a PCX image, to the same image types as go-pcx.
+func DecodePCX(reader io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < pcxHeaderSize || data[0] != 0x0a {
+		return pcx.Decode(bytes.NewReader(data))
+	}
+
+	word := func(offset int) int {
+		return int(binary.LittleEndian.Uint16(data[offset:]))
+	}
+
+	bounds := image.Rect(word(4), word(6), word(8)+1, word(10)+1)
+	rle, bitsPerPixel, planes, bytesPerLine := data[2] == 1, data[3], int(data[65]), word(66)
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if !rle || bitsPerPixel != 8 || data[68] == 2 || (planes != 1 && planes != 3 && planes != 4) || width <= 0 || height <= 0 || bytesPerLine < width {
+		return pcx.Decode(bytes.NewReader(data))
+	}
+
+	// A byte of the RLE stream unpacks to 31.5 bytes at most, which bounds the lines a file can
+	// hold: a corrupt header cannot have gigabytes allocated for a few bytes of data.
+	if int64(height)*int64(planes)*int64(bytesPerLine) > 32*int64(len(data)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if planes == 1 {
+		img := image.NewPaletted(bounds, make(color.Palette, 256))
+		lines := img.Pix
+
+		// Lines padded to an even width are unpacked apart, then their padding dropped.
+		if bytesPerLine != width {
+			lines = make([]byte, height*bytesPerLine)
+		}
+
+		end, err := unpackPCX(data[pcxHeaderSize:], lines)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if bytesPerLine != width {
+			for y := 0; y < height; y++ {
+				copy(img.Pix[y*width:(y+1)*width], lines[y*bytesPerLine:])
+			}
+		}
+
+		// The palette follows the pixels, or failing that, closes the file as the format says.
+		palette := data[pcxHeaderSize+end:]
+
+		if len(palette) < 1+pcxPaletteSize || palette[0] != pcxPaletteMagic {
+			if len(data) < pcxHeaderSize+1+pcxPaletteSize || data[len(data)-1-pcxPaletteSize] != pcxPaletteMagic {
+				return nil, errors.New("pcx: missing extended palette")
+			}
+
+			palette = data[len(data)-1-pcxPaletteSize:]
+		}
+
+		for i := range img.Palette {
+			img.Palette[i] = color.RGBA{palette[1+i*3], palette[2+i*3], palette[3+i*3], 255}
+		}
+
+		return img, nil
+	}
+
+	lines := make([]byte, height*planes*bytesPerLine)
+
+	if _, err := unpackPCX(data[pcxHeaderSize:], lines); err != nil {
+		return nil, err
+	}
+
+	img := image.NewRGBA(bounds)
+
+	for y := 0; y < height; y++ {
+		line := lines[y*planes*bytesPerLine:]
+		red, green, blue := line[:width], line[bytesPerLine:bytesPerLine+width], line[2*bytesPerLine:2*bytesPerLine+width]
+		pixels := img.Pix[y*img.Stride : y*img.Stride+4*width]
+
+		for x := 0; x < width; x++ {
+			pixels[4*x], pixels[4*x+1], pixels[4*x+2], pixels[4*x+3] = red[x], green[x], blue[x], 255
+		}
+
+		if planes == 4 {
+			alpha := line[3*bytesPerLine : 3*bytesPerLine+width]
+
+			for x, value := range alpha {
+				pixels[4*x+3] = value
+			}
+		}
+	}
+
+	return img, nil
+}
+
+// unpackPCX fills output from the RLE stream of data and returns the number of bytes read.
+func unpackPCX(data []byte, output []byte) (int, error) {
+	in, out := 0, 0
+
+	for out < len(output) {
+		if in >= len(data) {
+			return in, io.ErrUnexpectedEOF
+		}
+
+		value := data[in]
+		in++
+
+		if value < 0xc0 {
+			output[out] = value
+			out++
+
+			continue
+		}
+
+		if in >= len(data) {
+			return in, io.ErrUnexpectedEOF
+		}
+
+		count := int(value & 0x3f)
+
+		if out+count > len(output) {
+			return in, errors.New("pcx: RLE overrun")
+		}
+
+		run := output[out : out+count]
+		value = data[in]
+		in++
+
+		for i := range run {
+			run[i] = value
+		}
+
+		out += count
+	}
+
+	return in, nil
+}
@@ -0,0 +1,110 @@
+package gallery
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"reflect"
+	"testing"
+
+	"github.com/samuel/go-pcx/pcx"
+)
+
+// pcxFixture encodes a width x height texture with runs and single pixels, paletted or RGB.
+func pcxFixture(t testing.TB, width int, height int, paletted bool) []byte {
+	palette := make(color.Palette, 256)
+
+	for i := range palette {
+		palette[i] = color.RGBA{uint8(i), uint8(255 - i), uint8(i * 7), 255}
+	}
+
+	var img draw.Image
+
+	if paletted {
+		img = image.NewPaletted(image.Rect(0, 0, width, height), palette)
+	} else {
+		img = image.NewRGBA(image.Rect(0, 0, width, height))
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			index := uint8(x / 8 * y)
+
+			if x%5 == 0 {
+				index = uint8(x ^ y)
+			}
+
+			img.Set(x, y, palette[index])
+		}
+	}
+
+	output := new(bytes.Buffer)
+
+	if err := pcx.Encode(output, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return output.Bytes()
+}
+
+func TestDecodePCX(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		width, height int
+		paletted      bool
+	}{
+		{"paletted", 64, 32, true},
+		{"paletted odd width", 33, 7, true},
+		{"rgb", 64, 32, false},
+		{"rgb odd width", 33, 7, false},
+	} {
+		data := pcxFixture(t, test.width, test.height, test.paletted)
+		want, err := pcx.Decode(bytes.NewReader(data))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := DecodePCX(bytes.NewReader(data))
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: differs from go-pcx", test.name)
+		}
+	}
+
+	data := pcxFixture(t, 64, 32, true)
+
+	if _, err := DecodePCX(bytes.NewReader(data[:200])); err == nil {
+		t.Error("truncated pixels: got no error")
+	}
+
+	if _, err := DecodePCX(bytes.NewReader(data[:len(data)-pcxPaletteSize-1])); err == nil {
+		t.Error("missing palette: got no error")
+	}
+
+	// A palette separated from the pixels by padding is found at the end of the file.
+	padded := append(append(append([]byte(nil), data[:len(data)-pcxPaletteSize-1]...), 0, 0), data[len(data)-pcxPaletteSize-1:]...)
+
+	if img, err := DecodePCX(bytes.NewReader(padded)); err != nil || img.(*image.Paletted).Palette[3] != (color.RGBA{3, 252, 21, 255}) {
+		t.Errorf("padded palette: got %v", err)
+	}
+}
+
+func BenchmarkDecodePCX(b *testing.B) {
+	data := pcxFixture(b, 1024, 1024, true)
+
+	b.Run("go-pcx", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			pcx.Decode(bytes.NewReader(data))
+		}
+	})
+
+	b.Run("gallery", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			DecodePCX(bytes.NewReader(data))
+		}
+	})
+}
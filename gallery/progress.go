@@ -0,0 +1,64 @@
+package gallery
+
+/**
+ * Progress and interruption
+ *
+ * Scanning and rendering a whole game takes minutes, so both report their progress through an
+ * optional callback of their options: the scan before and after each file, the rendering after
+ * each family. Every event counts the steps done out of the total of its stage, for wrappers to
+ * show a progress bar or a log to tell where a run stopped.
+ *
+ * Both can also be stopped by closing the Stop channel of their options, between two files or
+ * textures so that nothing is left half done: the scan then returns the textures scanned so far,
+ * marked Incomplete, and the rendering returns ErrInterrupted.
+ */
+
+import "errors"
+
+// ErrInterrupted is returned by renderings stopped through RenderOptions.Stop.
+var ErrInterrupted = errors.New("gallery: interrupted")
+
+// stopped reports whether stop is closed. A nil stop never is.
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// ProgressEvent describes a step of a scan ("scan" stage) or a rendering ("render" stage).
+type ProgressEvent struct {
+	Stage string `json:"stage"`
+	// Event is "started" or "completed" for a file or family, or "skipped" for a file the
+	// rules left out, with their Reason.
+	Event  string `json:"event"`
+	Source string `json:"source,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Family string `json:"family,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Error is set for textures that failed to decode, which are still completed.
+	Error   string  `json:"error,omitempty"`
+	Done    int     `json:"done"`
+	Total   int     `json:"total"`
+	Percent float64 `json:"percent"`
+}
+
+func reportProgress(progress func(event ProgressEvent), event ProgressEvent, done int, total int) {
+	if progress == nil {
+		return
+	}
+
+	event.Done, event.Total, event.Percent = done, total, 100
+
+	if total > 0 {
+		event.Percent = float64(done*1000/total) / 10
+	}
+
+	progress(event)
+}
+
+func (options ScanOptions) report(event ProgressEvent, done int, total int) {
+	reportProgress(options.Progress, event, done, total)
+}
@@ -0,0 +1,432 @@
+package gallery
+
+/**
+ * PSD decoder
+ *
+ * Decodes the flattened composite ("merged") image stored at the end of Photoshop PSD/PSB files.
+ * Layers are ignored, which is enough to preview layered texture sources next to their exports.
+ *
+ * Supported: 1, 8 and 16 bits per channel; Bitmap, Grayscale, Indexed, RGB and CMYK color modes;
+ * raw and PackBits (RLE) compressed image data.
+ *
+ * Channels past the colors of the merged image are only its transparency when the file says so,
+ * with a negative layer count; otherwise they are spot colors or selections saved as alpha
+ * channels, which Photoshop does not apply to the image, and are ignored.
+ */
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+const (
+	psdModeBitmap    = 0
+	psdModeGrayscale = 1
+	psdModeIndexed   = 2
+	psdModeRGB       = 3
+	psdModeCMYK      = 4
+)
+
+type psdHeader struct {
+	Version   uint16
+	Channels  int
+	Height    int
+	Width     int
+	Depth     int
+	ColorMode int
+	// MergedAlpha is set when the channel after the colors is the transparency of the image.
+	MergedAlpha bool
+}
+
+func init() {
+	image.RegisterFormat("psd", "8BPS", DecodePSD, DecodePSDConfig)
+}
+
+func readPSDHeader(data []byte) (psdHeader, error) {
+	var header psdHeader
+
+	if len(data) < 26 || string(data[0:4]) != "8BPS" {
+		return header, errors.New("psd: invalid signature")
+	}
+
+	header.Version = binary.BigEndian.Uint16(data[4:6])
+	header.Channels = int(binary.BigEndian.Uint16(data[12:14]))
+	header.Height = int(binary.BigEndian.Uint32(data[14:18]))
+	header.Width = int(binary.BigEndian.Uint32(data[18:22]))
+	header.Depth = int(binary.BigEndian.Uint16(data[22:24]))
+	header.ColorMode = int(binary.BigEndian.Uint16(data[24:26]))
+
+	if header.Version != 1 && header.Version != 2 {
+		return header, fmt.Errorf("psd: unsupported version %d", header.Version)
+	}
+
+	if header.Width <= 0 || header.Height <= 0 || header.Channels <= 0 {
+		return header, errors.New("psd: invalid dimensions")
+	}
+
+	return header, nil
+}
+
+func DecodePSDConfig(reader io.Reader) (image.Config, error) {
+	data := make([]byte, 26)
+
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return image.Config{}, err
+	}
+
+	header, err := readPSDHeader(data)
+
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	colorModel := color.NRGBAModel
+
+	switch header.ColorMode {
+	case psdModeBitmap, psdModeGrayscale:
+		colorModel = color.GrayModel
+	case psdModeCMYK:
+		colorModel = color.CMYKModel
+	}
+
+	return image.Config{ColorModel: colorModel, Width: header.Width, Height: header.Height}, nil
+}
+
+func DecodePSD(reader io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := readPSDHeader(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	offset := 26
+
+	// Color mode data holds the palette for indexed images.
+	colorModeData, offset, err := psdSection(data, offset, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Image resources are not needed for the composite, and of the layer information only whether
+	// the merged image has transparency.
+	if _, offset, err = psdSection(data, offset, false); err != nil {
+		return nil, err
+	}
+
+	layers, offset, err := psdSection(data, offset, header.Version == 2)
+
+	if err != nil {
+		return nil, err
+	}
+
+	channels, err := psdImageData(data[offset:], header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	header.MergedAlpha = psdMergedAlpha(layers, header.Version == 2)
+
+	return psdComposite(header, channels, colorModeData)
+}
+
+// psdMergedAlpha reports whether the layer and mask information section flags the first extra
+// channel of the merged image as its transparency: by a negative layer count, in the layer info or,
+// for 16 and 32-bit files, in the Lr16 or Lr32 block following it.
+func psdMergedAlpha(layers []byte, long bool) bool {
+	layerInfo, offset, err := psdSection(layers, 0, long)
+
+	if err != nil {
+		return false
+	}
+
+	if len(layerInfo) >= 2 {
+		return int16(binary.BigEndian.Uint16(layerInfo)) < 0
+	}
+
+	// The global layer mask info, then the tagged blocks.
+	if _, offset, err = psdSection(layers, offset, false); err != nil {
+		return false
+	}
+
+	for offset+12 <= len(layers) {
+		signature, key := string(layers[offset:offset+4]), string(layers[offset+4:offset+8])
+
+		if signature != "8BIM" && signature != "8B64" {
+			return false
+		}
+
+		block, next, err := psdSection(layers, offset+8, long && (key == "Lr16" || key == "Lr32" || key == "Layr"))
+
+		if err != nil {
+			return false
+		}
+
+		if key == "Lr16" || key == "Lr32" || key == "Layr" {
+			return len(block) >= 2 && int16(binary.BigEndian.Uint16(block)) < 0
+		}
+
+		// Blocks are padded to 4 bytes.
+		offset = next + (4-(next-offset)%4)%4
+	}
+
+	return false
+}
+
+func psdSection(data []byte, offset int, long bool) ([]byte, int, error) {
+	size := 4
+
+	if long {
+		size = 8
+	}
+
+	if offset+size > len(data) {
+		return nil, offset, io.ErrUnexpectedEOF
+	}
+
+	var length uint64
+
+	if long {
+		length = binary.BigEndian.Uint64(data[offset:])
+	} else {
+		length = uint64(binary.BigEndian.Uint32(data[offset:]))
+	}
+
+	offset += size
+
+	if length > uint64(len(data)-offset) {
+		return nil, offset, io.ErrUnexpectedEOF
+	}
+
+	return data[offset : offset+int(length)], offset + int(length), nil
+}
+
+func psdImageData(data []byte, header psdHeader) ([][]byte, error) {
+	if len(data) < 2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	compression := binary.BigEndian.Uint16(data[0:2])
+	data = data[2:]
+
+	rowBytes := (header.Width*header.Depth + 7) / 8
+	channels := make([][]byte, header.Channels)
+
+	switch compression {
+	case 0:
+		channelBytes := rowBytes * header.Height
+
+		if len(data) < channelBytes*header.Channels {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		for i := range channels {
+			channels[i] = data[i*channelBytes : (i+1)*channelBytes]
+		}
+	case 1:
+		countSize := 2
+
+		if header.Version == 2 {
+			countSize = 4
+		}
+
+		rows := header.Channels * header.Height
+		countBytes := rows * countSize
+
+		if len(data) < countBytes {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		counts, packed := data[:countBytes], data[countBytes:]
+
+		for i := range channels {
+			channel := make([]byte, 0, rowBytes*header.Height)
+
+			for y := 0; y < header.Height; y++ {
+				row := i*header.Height + y
+
+				var length int
+
+				if countSize == 2 {
+					length = int(binary.BigEndian.Uint16(counts[row*2:]))
+				} else {
+					length = int(binary.BigEndian.Uint32(counts[row*4:]))
+				}
+
+				if length > len(packed) {
+					return nil, io.ErrUnexpectedEOF
+				}
+
+				unpacked, err := unpackBits(packed[:length], rowBytes)
+
+				if err != nil {
+					return nil, err
+				}
+
+				channel = append(channel, unpacked...)
+				packed = packed[length:]
+			}
+
+			channels[i] = channel
+		}
+	default:
+		return nil, fmt.Errorf("psd: unsupported compression %d", compression)
+	}
+
+	return channels, nil
+}
+
+// unpackBits expands a PackBits encoded row to exactly size bytes.
+func unpackBits(src []byte, size int) ([]byte, error) {
+	dst := make([]byte, 0, size)
+
+	for len(src) > 0 && len(dst) < size {
+		n := int(int8(src[0]))
+		src = src[1:]
+
+		switch {
+		case n >= 0:
+			if n+1 > len(src) {
+				return nil, io.ErrUnexpectedEOF
+			}
+
+			dst = append(dst, src[:n+1]...)
+			src = src[n+1:]
+		case n > -128:
+			if len(src) == 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+
+			dst = append(dst, bytes.Repeat(src[:1], 1-n)...)
+			src = src[1:]
+		}
+	}
+
+	if len(dst) < size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return dst[:size], nil
+}
+
+func psdComposite(header psdHeader, channels [][]byte, colorModeData []byte) (image.Image, error) {
+	width, height := header.Width, header.Height
+	bounds := image.Rect(0, 0, width, height)
+
+	sample := func(channel []byte, i int) uint8 {
+		switch header.Depth {
+		case 8:
+			return channel[i]
+		case 16:
+			return channel[i*2]
+		}
+
+		return 0
+	}
+
+	if header.Depth != 8 && header.Depth != 16 && !(header.Depth == 1 && header.ColorMode == psdModeBitmap) {
+		return nil, fmt.Errorf("psd: unsupported depth %d", header.Depth)
+	}
+
+	switch header.ColorMode {
+	case psdModeBitmap:
+		img := image.NewGray(bounds)
+		rowBytes := (width + 7) / 8
+
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				// Set bits are black in bitmap mode.
+				if channels[0][y*rowBytes+x/8]&(0x80>>uint(x%8)) == 0 {
+					img.Pix[y*img.Stride+x] = 255
+				}
+			}
+		}
+
+		return img, nil
+	case psdModeGrayscale:
+		if header.Channels >= 2 && header.MergedAlpha {
+			img := image.NewNRGBA(bounds)
+
+			for i := 0; i < width*height; i++ {
+				gray := sample(channels[0], i)
+				img.Pix[i*4+0], img.Pix[i*4+1], img.Pix[i*4+2], img.Pix[i*4+3] = gray, gray, gray, sample(channels[1], i)
+			}
+
+			return img, nil
+		}
+
+		img := image.NewGray(bounds)
+
+		for i := 0; i < width*height; i++ {
+			img.Pix[i] = sample(channels[0], i)
+		}
+
+		return img, nil
+	case psdModeIndexed:
+		if len(colorModeData) < 768 || header.Depth != 8 {
+			return nil, errors.New("psd: invalid indexed color table")
+		}
+
+		palette := make(color.Palette, 256)
+
+		for i := range palette {
+			palette[i] = color.RGBA{colorModeData[i], colorModeData[256+i], colorModeData[512+i], 255}
+		}
+
+		img := image.NewPaletted(bounds, palette)
+		copy(img.Pix, channels[0])
+
+		return img, nil
+	case psdModeRGB:
+		if header.Channels < 3 {
+			return nil, errors.New("psd: missing color channels")
+		}
+
+		img := image.NewNRGBA(bounds)
+
+		for i := 0; i < width*height; i++ {
+			alpha := uint8(255)
+
+			if header.Channels >= 4 && header.MergedAlpha {
+				alpha = sample(channels[3], i)
+			}
+
+			img.Pix[i*4+0] = sample(channels[0], i)
+			img.Pix[i*4+1] = sample(channels[1], i)
+			img.Pix[i*4+2] = sample(channels[2], i)
+			img.Pix[i*4+3] = alpha
+		}
+
+		return img, nil
+	case psdModeCMYK:
+		if header.Channels < 4 {
+			return nil, errors.New("psd: missing color channels")
+		}
+
+		img := image.NewCMYK(bounds)
+
+		// Photoshop stores CMYK inverted, 255 meaning no ink.
+		for i := 0; i < width*height; i++ {
+			img.Pix[i*4+0] = 255 - sample(channels[0], i)
+			img.Pix[i*4+1] = 255 - sample(channels[1], i)
+			img.Pix[i*4+2] = 255 - sample(channels[2], i)
+			img.Pix[i*4+3] = 255 - sample(channels[3], i)
+		}
+
+		return img, nil
+	}
+
+	return nil, fmt.Errorf("psd: unsupported color mode %d", header.ColorMode)
+}
@@ -0,0 +1,94 @@
+package gallery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// psdFile returns a 2x1 8-bit PSD file of mode with the raw planes of channels, and the layer and
+// mask information section layers.
+func psdFile(mode int, channels [][]byte, layers []byte) []byte {
+	file := new(bytes.Buffer)
+	file.WriteString("8BPS")
+	binary.Write(file, binary.BigEndian, []uint16{1, 0, 0, 0, uint16(len(channels))})
+	binary.Write(file, binary.BigEndian, []uint32{1, 2})
+	binary.Write(file, binary.BigEndian, []uint16{8, uint16(mode)})
+
+	// No color mode data nor image resources.
+	binary.Write(file, binary.BigEndian, []uint32{0, 0, uint32(len(layers))})
+	file.Write(layers)
+
+	binary.Write(file, binary.BigEndian, uint16(0))
+
+	for _, channel := range channels {
+		file.Write(channel)
+	}
+
+	return file.Bytes()
+}
+
+// psdLayers returns a layer and mask information section whose layer info holds count layers
+// and nothing else, as written for a merged image with transparency when count is -1.
+func psdLayers(count int16) []byte {
+	section := new(bytes.Buffer)
+	binary.Write(section, binary.BigEndian, uint32(2))
+	binary.Write(section, binary.BigEndian, count)
+	binary.Write(section, binary.BigEndian, uint32(0))
+
+	return section.Bytes()
+}
+
+// psdLr16Layers returns the layer and mask information section of a 16-bit file, with an empty
+// layer info and the layers of count in an Lr16 block.
+func psdLr16Layers(count int16) []byte {
+	section := new(bytes.Buffer)
+	binary.Write(section, binary.BigEndian, []uint32{0, 0})
+	section.WriteString("8BIMLr16")
+	binary.Write(section, binary.BigEndian, uint32(4))
+	binary.Write(section, binary.BigEndian, []int16{count, 0})
+
+	return section.Bytes()
+}
+
+func TestDecodePSD(t *testing.T) {
+	red, green, blue, alpha := []byte{255, 10}, []byte{0, 20}, []byte{0, 30}, []byte{128, 0}
+
+	for _, test := range []struct {
+		name     string
+		file     []byte
+		expected []color.Color
+	}{
+		{"rgb", psdFile(psdModeRGB, [][]byte{red, green, blue}, nil), []color.Color{color.NRGBA{255, 0, 0, 255}, color.NRGBA{10, 20, 30, 255}}},
+		{"rgba", psdFile(psdModeRGB, [][]byte{red, green, blue, alpha}, psdLayers(-1)), []color.Color{color.NRGBA{255, 0, 0, 128}, color.NRGBA{10, 20, 30, 0}}},
+		{"rgba in Lr16", psdFile(psdModeRGB, [][]byte{red, green, blue, alpha}, psdLr16Layers(-1)), []color.Color{color.NRGBA{255, 0, 0, 128}, color.NRGBA{10, 20, 30, 0}}},
+		// A fourth channel not flagged as transparency is a selection or a spot color.
+		{"rgb and alpha channel", psdFile(psdModeRGB, [][]byte{red, green, blue, alpha}, nil), []color.Color{color.NRGBA{255, 0, 0, 255}, color.NRGBA{10, 20, 30, 255}}},
+		{"rgb and alpha channel with layers", psdFile(psdModeRGB, [][]byte{red, green, blue, alpha}, psdLayers(1)), []color.Color{color.NRGBA{255, 0, 0, 255}, color.NRGBA{10, 20, 30, 255}}},
+		{"gray", psdFile(psdModeGrayscale, [][]byte{red}, nil), []color.Color{color.Gray{255}, color.Gray{10}}},
+		{"gray and alpha", psdFile(psdModeGrayscale, [][]byte{red, alpha}, psdLayers(-1)), []color.Color{color.NRGBA{255, 255, 255, 128}, color.NRGBA{10, 10, 10, 0}}},
+		{"gray and alpha channel", psdFile(psdModeGrayscale, [][]byte{red, alpha}, nil), []color.Color{color.Gray{255}, color.Gray{10}}},
+	} {
+		img, err := DecodePSD(bytes.NewReader(test.file))
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+
+			continue
+		}
+
+		if img.Bounds() != image.Rect(0, 0, 2, 1) {
+			t.Errorf("%s: bounds %v", test.name, img.Bounds())
+
+			continue
+		}
+
+		for x, expected := range test.expected {
+			if got := img.ColorModel().Convert(img.At(x, 0)); got != img.ColorModel().Convert(expected) || got != img.At(x, 0) {
+				t.Errorf("%s: pixel %d is %v, want %v", test.name, x, img.At(x, 0), expected)
+			}
+		}
+	}
+}
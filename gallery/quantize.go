@@ -0,0 +1,162 @@
+package gallery
+
+/**
+ * Color quantization
+ *
+ * With RenderOptions.Quantize, thumbnails are reduced to a palette of that many colors and stored
+ * as paletted PNGs: they look like the 8-bit textures of the engine, and flat-colored textures
+ * take a fraction of the bytes of a JPEG. The palette is picked by median cut, alpha included,
+ * over the distinct colors of the thumbnail weighted by their pixel count; pixels then take the
+ * nearest palette color, without dithering, as the engine draws them.
+ */
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// quantizeColor is a distinct color of an image and its number of pixels.
+type quantizeColor struct {
+	channels [4]uint8
+	count    int
+}
+
+// Quantize returns img reduced to a palette of at most colors colors, chosen by median cut. Images
+// with no more colors keep them all. Palettes hold at most 256 colors, the most a paletted PNG can.
+func Quantize(img image.Image, colors int) *image.Paletted {
+	colors = max(1, min(colors, paletteLimit))
+	bounds := img.Bounds()
+	counts := make(map[color.NRGBA]int)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			counts[color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)]++
+		}
+	}
+
+	histogram := make([]quantizeColor, 0, len(counts))
+
+	for c, count := range counts {
+		histogram = append(histogram, quantizeColor{[4]uint8{c.R, c.G, c.B, c.A}, count})
+	}
+
+	// Map iteration order would make the palette differ from one run to the next.
+	sort.Slice(histogram, func(i, j int) bool {
+		a, b := histogram[i].channels, histogram[j].channels
+
+		return a[0] < b[0] || a[0] == b[0] && (a[1] < b[1] || a[1] == b[1] && (a[2] < b[2] || a[2] == b[2] && a[3] < b[3]))
+	})
+
+	var palette color.Palette
+
+	for _, box := range medianCut(histogram, colors) {
+		palette = append(palette, box.average())
+	}
+
+	paletted := image.NewPaletted(bounds, palette)
+	indices := make(map[color.NRGBA]uint8, len(counts))
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			index, ok := indices[c]
+
+			if !ok {
+				index = uint8(palette.Index(c))
+				indices[c] = index
+			}
+
+			paletted.SetColorIndex(x, y, index)
+		}
+	}
+
+	return paletted
+}
+
+// quantizeBox is a set of colors of the histogram, to be represented by one palette color.
+type quantizeBox []quantizeColor
+
+// widest returns the channel over which the colors of box spread the most, and that spread.
+func (box quantizeBox) widest() (int, int) {
+	channel, spread := 0, -1
+
+	for c := 0; c < 4; c++ {
+		low, high := 255, 0
+
+		for _, entry := range box {
+			low, high = min(low, int(entry.channels[c])), max(high, int(entry.channels[c]))
+		}
+
+		if high-low > spread {
+			channel, spread = c, high-low
+		}
+	}
+
+	return channel, spread
+}
+
+// average returns the mean color of box, weighted by pixel count.
+func (box quantizeBox) average() color.Color {
+	var sums [4]int
+	total := 0
+
+	for _, entry := range box {
+		for c := range sums {
+			sums[c] += int(entry.channels[c]) * entry.count
+		}
+
+		total += entry.count
+	}
+
+	return color.NRGBA{uint8(sums[0] / total), uint8(sums[1] / total), uint8(sums[2] / total), uint8(sums[3] / total)}
+}
+
+// medianCut splits histogram into at most colors boxes: the box spreading the most over a channel
+// is cut in two along it, at the median pixel, until there are enough boxes or none can be cut.
+func medianCut(histogram []quantizeColor, colors int) []quantizeBox {
+	if len(histogram) == 0 {
+		return nil
+	}
+
+	boxes := []quantizeBox{histogram}
+
+	for len(boxes) < colors {
+		widestBox, widestChannel, widestSpread := -1, 0, 0
+
+		for i, box := range boxes {
+			if channel, spread := box.widest(); len(box) > 1 && spread > widestSpread {
+				widestBox, widestChannel, widestSpread = i, channel, spread
+			}
+		}
+
+		if widestBox < 0 {
+			break
+		}
+
+		box := boxes[widestBox]
+
+		sort.SliceStable(box, func(i, j int) bool {
+			return box[i].channels[widestChannel] < box[j].channels[widestChannel]
+		})
+
+		total := 0
+
+		for _, entry := range box {
+			total += entry.count
+		}
+
+		// The cut leaves at least one color on each side.
+		cut, seen := 1, box[0].count
+
+		for cut < len(box)-1 && seen+box[cut].count <= total/2 {
+			seen += box[cut].count
+			cut++
+		}
+
+		boxes[widestBox] = box[:cut:cut]
+		boxes = append(boxes, box[cut:])
+	}
+
+	return boxes
+}
@@ -0,0 +1,112 @@
+package gallery
+
+/**
+ * Texture ratings
+ *
+ * Reviews of a texture pack, made in another tool or by hand, come as a JSON object mapping
+ * texture keys ("family/file") to ratings: stars from 1 to 5, a verdict ("approved" or
+ * "rejected") and a note. Inventory.ApplyRatings attaches them to the textures, whose tiles then
+ * show them as badges that the page can filter on, and the JSON inventory carries them back out.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Rating verdicts.
+const (
+	RatingApproved = "approved"
+	RatingRejected = "rejected"
+)
+
+// Rating is the review of a texture. Every field is optional.
+type Rating struct {
+	Stars   int    `json:"stars,omitempty"`
+	Verdict string `json:"verdict,omitempty"`
+	Note    string `json:"note,omitempty"`
+}
+
+// LoadRatings reads a JSON object mapping texture keys to ratings, such as
+// {"brick/wall.png": {"stars": 4, "verdict": "approved"}}.
+func LoadRatings(ratingsPath string) (map[string]Rating, error) {
+	data, err := os.ReadFile(ratingsPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var entries map[string]Rating
+
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", ratingsPath, err)
+	}
+
+	ratings := make(map[string]Rating)
+
+	for key, rating := range entries {
+		if rating.Stars < 0 || rating.Stars > 5 {
+			return nil, fmt.Errorf("%s: invalid stars %d for %q", ratingsPath, rating.Stars, key)
+		}
+
+		if rating.Verdict != "" && rating.Verdict != RatingApproved && rating.Verdict != RatingRejected {
+			return nil, fmt.Errorf("%s: invalid verdict %q for %q", ratingsPath, rating.Verdict, key)
+		}
+
+		ratings[strings.Trim(strings.ReplaceAll(key, "\\", "/"), "/")] = rating
+	}
+
+	return ratings, nil
+}
+
+// ApplyRatings sets the rating of the textures found in ratings, and returns the keys of the
+// ratings matching no texture, sorted.
+func (inventory *Inventory) ApplyRatings(ratings map[string]Rating) []string {
+	found := make(map[string]bool)
+
+	for i := range inventory.Families {
+		for j := range inventory.Families[i].Textures {
+			texture := &inventory.Families[i].Textures[j]
+
+			if rating, ok := ratings[texture.Key()]; ok {
+				texture.Rating = &rating
+				found[texture.Key()] = true
+			}
+		}
+	}
+
+	var unknown []string
+
+	for key := range ratings {
+		if !found[key] {
+			unknown = append(unknown, key)
+		}
+	}
+
+	sort.Strings(unknown)
+
+	return unknown
+}
+
+// renderRating renders the badges of a rating.
+func renderRating(rating Rating) string {
+	badges := ""
+
+	if rating.Stars > 0 {
+		badges += fmt.Sprintf(" <span class='badge stars' title='%d of 5'>%s</span>", rating.Stars, strings.Repeat("★", rating.Stars)+strings.Repeat("☆", 5-rating.Stars))
+	}
+
+	if rating.Verdict != "" {
+		badges += fmt.Sprintf(" <span class='badge %s'>%s</span>", html.EscapeString(rating.Verdict), html.EscapeString(rating.Verdict))
+	}
+
+	if rating.Note != "" {
+		badges += fmt.Sprintf(" <span class='usage note'>%s</span>", html.EscapeString(rating.Note))
+	}
+
+	return badges
+}
@@ -0,0 +1,1260 @@
+package gallery
+
+/**
+ * Page rendering
+ *
+ * Render turns an Inventory into the HTML page: thumbnails (inlined as base64 or handed to an
+ * asset writer), captions with badges and usage, material tiles, and the page template with its
+ * embedded script.
+ */
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nfnt/resize"
+)
+
+// Script of the generated page: search, lightbox, family collapsing and keyboard navigation.
+//
+//go:embed gallery.js
+var galleryScript string
+
+// printStyle is the stylesheet of printed pages: dark text on white, one family per page, no
+// interactive controls, and collapsed families expanded.
+const printStyle = `body{background:#fff}
+		body,h1,h2{color:#000}
+		h2{border-color:#999;break-after:avoid}
+		h2::after{display:none}
+		section{break-before:page;padding:0 0 16px}
+		section:first-of-type{break-before:auto}
+		.collapsed .family{display:grid}
+		.texture,.material{break-inside:avoid}
+		.caption,.material-name,.material-files,.changes,.description{color:#333}
+		.badge{background:none;border:1px solid #333;color:#000}
+		.placeholder{background:#eee;color:#333}
+		#search,.filter,#density,#engine-view,#slideshow-start,#lightbox,#slideshow,.stats,.eight-bit,.download,.pages,.slider{display:none}`
+
+// RenderOptions controls the page produced by Render. Zero JPEG fields fall back to the
+// per-format defaults of JPEGDefaults. Lossless makes every thumbnail a PNG, AutoFormat only those
+// with transparency or few colors.
+type RenderOptions struct {
+	Title           string
+	ThumbnailSize   int
+	Background      color.RGBA
+	JPEGQuality     int
+	Subsampling     int
+	Progressive     bool
+	AutoFormat      bool
+	Lossless        bool
+	Stats           bool
+	Seams           bool
+	Relief          bool
+	GroupVariants   bool
+	VariantSuffixes []string
+
+	// Quantize, when not zero, reduces every thumbnail to a paletted PNG of that many colors,
+	// taking precedence over Lossless and AutoFormat; see Quantize.
+	Quantize int
+
+	// EngineView adds to the tiles of truecolor textures their thumbnail dithered to the palette
+	// of their family, which a button of the page shows instead; see Dither. It needs the script.
+	EngineView bool
+
+	// Columns caps the number of tiles per row. Zero fits as many as the window allows.
+	Columns int
+
+	// Themes names the themes applied over the default one, in order; see Themes.
+	Themes []string
+
+	// Print applies the print stylesheet on screen too, for reference sheets saved as PDF.
+	Print bool
+
+	// ModelIndex maps texture names to the models using them, MissionUsage maps "family/name"
+	// keys to the number of missions using them. Nil disables the matching caption lines.
+	ModelIndex   map[string][]string
+	MissionUsage map[string]int
+
+	// FamilyLabels maps family names to the names shown in their heading, which keeps the
+	// family name as tooltip.
+	FamilyLabels map[string]string
+
+	// Status maps texture keys ("family/file") to a badge such as "new" or "changed".
+	Status map[string]string
+
+	// Notice is a line of text shown under the page title.
+	Notice string
+
+	// Caption, when set, is the template of the captions under the thumbnails, with fields such
+	// as {name} and {size} (see CaptionFields).
+	Caption string
+
+	// NoCaptions hides the captions, leaving a contact sheet of images whose name and dimensions
+	// show as tooltips. The captions stay in the page for the search box and the slideshow.
+	NoCaptions bool
+
+	// InlineBelow, along with Asset, keeps the thumbnails and upscaled previews of fewer bytes
+	// inlined as data URIs, which cost less than a request of their own.
+	InlineBelow int
+
+	// SRI, along with Asset, stores the stylesheets and the script as assets of the "crf2html"
+	// family, linked with integrity attributes, instead of inlining them.
+	SRI bool
+
+	// NoJS leaves the script out, along with the controls needing it: search box, filters,
+	// density and slideshow buttons, lightbox. Thumbnails are then inlined in every tile using
+	// them, the script no longer copying shared ones.
+	NoJS bool
+
+	// Pages holds the URL of every page of a gallery split by Inventory.Paginate, in order, and
+	// PageIndex the index of the one rendered, which links to the others above and below its
+	// families.
+	Pages     []string
+	PageIndex int
+
+	// Upscaler, when set, adds to each tile a preview of its texture scaled UpscaleFactor times,
+	// shown by the lightbox.
+	Upscaler Upscaler
+
+	// Trend, the totals of the previous runs and this one, oldest first, adds a chart of their
+	// evolution under the title once there are two of them.
+	Trend []RunTotals
+
+	// Asset, when set, stores a thumbnail and returns the URL the page links to instead of
+	// inlining it; its data is reused once it returns, so it must be copied to be kept. WebP,
+	// when set along with Asset, encodes a WebP variant of the thumbnail; returning nil data
+	// skips the variant.
+	Asset func(family string, name string, data []byte) (string, error)
+	WebP  func(img image.Image, quality int) ([]byte, error)
+
+	// FamilyArchive, when set, stores the archive of a family's files and returns the URL its
+	// heading links to.
+	FamilyArchive func(family string) (string, error)
+
+	// FamilyThumbnails, when set, receives the thumbnails of each family in page order.
+	FamilyThumbnails func(family string, thumbnails []image.Image) error
+
+	// Progress, when set, is called after each family is rendered.
+	Progress func(event ProgressEvent)
+
+	// Stop, once closed, ends the rendering after the texture in progress with ErrInterrupted.
+	Stop <-chan struct{}
+
+	// Workers, over 1, renders that many tiles of a family at once, handing them out ChunkSize
+	// consecutive textures at a time (see Tune). Asset, WebP and Upscaler are then called from
+	// several goroutines.
+	Workers   int
+	ChunkSize int
+
+	// CompareNames orders the textures of a family by name, such as a Collation; nil stands for
+	// NaturalCompare. Textures of the same name are sorted by caption.
+	CompareNames func(a string, b string) int
+
+	// FamilyCards, on the first page of a gallery split into Pages, adds an index of the
+	// families above them, linking to the page of each.
+	FamilyCards []FamilyCard
+
+	// palette is the palette of the family being rendered, for EngineView.
+	palette color.Palette
+}
+
+// DefaultRenderOptions returns the options of a plain run.
+func DefaultRenderOptions() RenderOptions {
+	return RenderOptions{
+		Title:           "Textures",
+		ThumbnailSize:   128,
+		Background:      color.RGBA{255, 255, 255, 255},
+		VariantSuffixes: DefaultVariantSuffixes,
+	}
+}
+
+// Thumbnail scales img down (or up) so that its longest side is size pixels.
+func Thumbnail(img image.Image, size int) image.Image {
+	newBounds := img.Bounds().Max
+
+	if newBounds.X > newBounds.Y {
+		newBounds.Y = int(float64(size) * float64(newBounds.Y) / float64(newBounds.X))
+		newBounds.X = size
+	} else {
+		newBounds.X = int(float64(size) * float64(newBounds.X) / float64(newBounds.Y))
+		newBounds.Y = size
+	}
+
+	return resize.Resize(uint(newBounds.X), uint(newBounds.Y), img, resize.Bilinear)
+}
+
+// tile is a rendered texture. Image is the element showing its thumbnail, empty for broken ones.
+type tile struct {
+	Texture   Texture
+	Caption   string
+	HTML      string
+	Image     string
+	Thumbnail image.Image
+}
+
+// renderPlaceholder renders a texture that failed to decode as a grey box with its error.
+func renderPlaceholder(texture Texture, options RenderOptions) tile {
+	placeholder := image.NewRGBA(image.Rect(0, 0, options.ThumbnailSize, options.ThumbnailSize))
+	draw.Draw(placeholder, placeholder.Bounds(), &image.Uniform{color.RGBA{85, 85, 85, 255}}, image.Point{}, draw.Src)
+
+	filenameSpan := fmt.Sprintf("<span class='filename'>%s</span>", html.EscapeString(texture.Name))
+	infoSpan := fmt.Sprintf("<span class='info'>(%s)</span>", html.EscapeString(texture.Format))
+	caption := fmt.Sprintf("%s %s <span class='badge warning'>broken</span>", filenameSpan, infoSpan)
+
+	return tile{
+		Texture:   texture,
+		Caption:   caption,
+		HTML:      fmt.Sprintf("<div class='texture broken' tabindex='0'><div class='image placeholder'><span>%s</span><span>%s</span></div><div class='caption'>%s</div></div>", html.EscapeString(texture.File), html.EscapeString(texture.Error), caption),
+		Thumbnail: placeholder,
+	}
+}
+
+// encodeBuffers holds the buffers thumbnails are encoded into, reused from one tile to the next.
+var encodeBuffers = sync.Pool{New: func() any {
+	return new(bytes.Buffer)
+}}
+
+func renderTile(texture Texture, options RenderOptions) (tile, error) {
+	if texture.Error != "" {
+		return renderPlaceholder(texture, options), nil
+	}
+
+	img, err := texture.LoadImage()
+
+	if err != nil {
+		return tile{}, err
+	}
+
+	extension := "." + texture.Format
+	statsHTML := ""
+
+	if options.Stats {
+		statsHTML = ComputeStats(img).HTML()
+	}
+
+	imageObj := Thumbnail(img, options.ThumbnailSize)
+
+	if texture.MapType == "normal" && options.Relief {
+		imageObj = ReliefShade(imageObj)
+	}
+
+	thumbnailFormat := "jpeg"
+
+	if options.Quantize != 0 {
+		thumbnailFormat = "png"
+		imageObj = Quantize(imageObj, options.Quantize)
+	} else if options.Lossless {
+		thumbnailFormat = "png"
+	} else if options.AutoFormat {
+		thumbnailFormat = ChooseThumbnailFormat(imageObj)
+	}
+
+	if thumbnailFormat == "jpeg" && (imageObj.ColorModel() == color.RGBAModel || imageObj.ColorModel() == color.NRGBAModel) {
+		backgroundImage := image.NewRGBA(imageObj.Bounds())
+		draw.Draw(backgroundImage, backgroundImage.Bounds(), &image.Uniform{options.Background}, image.Point{}, draw.Over)
+		draw.Draw(backgroundImage, backgroundImage.Bounds(), imageObj, imageObj.Bounds().Min, draw.Over)
+		imageObj = backgroundImage
+	}
+
+	jpegOptions := JPEGDefaults(extension)
+	jpegOptions.Progressive = options.Progressive
+
+	if options.JPEGQuality != 0 {
+		jpegOptions.Quality = options.JPEGQuality
+	}
+
+	if options.Subsampling != 0 {
+		jpegOptions.Subsampling = options.Subsampling
+	}
+
+	buffer := encodeBuffers.Get().(*bytes.Buffer)
+	buffer.Reset()
+	defer encodeBuffers.Put(buffer)
+
+	contentType := "image/jpg"
+
+	if thumbnailFormat == "png" {
+		contentType = "image/png"
+		err = png.Encode(buffer, Palettize(imageObj))
+	} else {
+		err = EncodeJPEG(buffer, imageObj, jpegOptions)
+	}
+
+	if err != nil {
+		return tile{}, err
+	}
+
+	encodedImage := base64.StdEncoding.EncodeToString(buffer.Bytes())
+	uri := fmt.Sprintf("data:%s;base64,%s", contentType, encodedImage)
+	imageHTML := fmt.Sprintf("<img src='%s'>", uri)
+
+	if options.Asset != nil && buffer.Len() >= options.InlineBelow {
+		assetURL, err := options.Asset(texture.Family, texture.File+"."+thumbnailFormat, buffer.Bytes())
+
+		if err != nil {
+			return tile{}, err
+		}
+
+		imageHTML = fmt.Sprintf("<img src='%s'>", html.EscapeString(assetURL))
+
+		if options.WebP != nil {
+			webpData, err := options.WebP(imageObj, jpegOptions.Quality)
+
+			if err != nil {
+				return tile{}, err
+			}
+
+			if webpData != nil {
+				webpURL, err := options.Asset(texture.Family, texture.File+".webp", webpData)
+
+				if err != nil {
+					return tile{}, err
+				}
+
+				imageHTML = fmt.Sprintf("<picture><source type='image/webp' srcset='%s'>%s</picture>", html.EscapeString(webpURL), imageHTML)
+			}
+		}
+	}
+
+	imageDimensions := fmt.Sprintf("%dx%d", imageObj.Bounds().Dx(), imageObj.Bounds().Dy())
+
+	filenameSpan := fmt.Sprintf("<span class='filename'>%s</span>", html.EscapeString(texture.Name))
+	infoSpan := fmt.Sprintf("<span class='info'>%s (%s)</span>", imageDimensions, html.EscapeString(texture.Format))
+	caption := fmt.Sprintf("%s %s", filenameSpan, infoSpan)
+
+	if status := options.Status[texture.Key()]; status != "" {
+		caption = fmt.Sprintf("%s <span class='badge %s'>%s</span>", caption, html.EscapeString(status), html.EscapeString(status))
+	}
+
+	caption += renderAlpha(texture)
+	caption += renderAspect(texture)
+
+	if options.Seams {
+		caption += ComputeSeams(img).HTML()
+	}
+
+	// The filters of the page match these attributes.
+	attributes := ""
+
+	if texture.Alpha != "" {
+		attributes += fmt.Sprintf(" data-alpha='%s'", html.EscapeString(texture.Alpha))
+	}
+
+	if aspect := AspectKind(texture.Width, texture.Height); aspect != "" {
+		attributes += fmt.Sprintf(" data-aspect='%s'", aspect)
+	}
+
+	if options.Upscaler != nil {
+		upscaleURL, err := renderUpscale(texture, img, jpegOptions, options)
+
+		if err != nil {
+			return tile{}, err
+		}
+
+		attributes += fmt.Sprintf(" data-upscale='%s'", html.EscapeString(upscaleURL))
+		caption += fmt.Sprintf(" <span class='badge upscale' title='%s'>%dx</span>", html.EscapeString(options.Upscaler.Name()), UpscaleFactor)
+	}
+
+	if texture.Rating != nil {
+		caption += renderRating(*texture.Rating)
+
+		if texture.Rating.Verdict != "" {
+			attributes += fmt.Sprintf(" data-verdict='%s'", html.EscapeString(texture.Rating.Verdict))
+		}
+	}
+
+	if texture.WrongExtension() && texture.Extension == "" {
+		caption = fmt.Sprintf("%s <span class='badge warning'>no extension</span>", caption)
+	} else if texture.WrongExtension() {
+		caption = fmt.Sprintf("%s <span class='badge warning'>named %s</span>", caption, html.EscapeString(texture.Extension))
+	}
+
+	caption += renderPaletteMismatch(texture)
+
+	if SourceExtensions[extension] {
+		caption = fmt.Sprintf("%s <span class='badge'>source</span>", caption)
+	}
+
+	if texture.MapType == "normal" {
+		caption = fmt.Sprintf("%s <span class='badge normal'>normal</span>", caption)
+	}
+
+	if len(texture.Shadows) > 0 {
+		var shadowed []string
+
+		for _, shadow := range texture.Shadows {
+			shadowed = append(shadowed, path.Base(filepath.ToSlash(shadow)))
+		}
+
+		caption = fmt.Sprintf("%s <span class='usage override' title='%s'>%s overridden by %s</span>", caption, html.EscapeString(strings.Join(texture.Shadows, "\n")), html.EscapeString(strings.Join(shadowed, ", ")), html.EscapeString(texture.Source))
+	} else if texture.Source != "" {
+		caption = fmt.Sprintf("%s <span class='usage override'>from %s</span>", caption, html.EscapeString(texture.Source))
+	}
+
+	if models := options.ModelIndex[texture.Name]; len(models) > 0 {
+		caption = fmt.Sprintf("%s <span class='usage'>used by: %s</span>", caption, html.EscapeString(strings.Join(models, ", ")))
+	}
+
+	if options.MissionUsage != nil {
+		switch count := options.MissionUsage[texture.Family+"/"+texture.Name]; count {
+		case 0:
+			caption = fmt.Sprintf("%s <span class='badge unused'>unused</span>", caption)
+		case 1:
+			caption = fmt.Sprintf("%s <span class='usage'>used in 1 mission</span>", caption)
+		default:
+			caption = fmt.Sprintf("%s <span class='usage'>used in %d missions</span>", caption, count)
+		}
+	}
+
+	// A template replaces the name and dimensions, the badges following it still. Tiles keep
+	// sorting by the default caption.
+	captionHTML := caption
+
+	if options.Caption != "" {
+		captionHTML = expandCaption(options.Caption, texture) + strings.TrimPrefix(caption, fmt.Sprintf("%s %s", filenameSpan, infoSpan))
+	}
+
+	// A 16-bit texture can be compared with the 8-bit one it replaces.
+	if texture.EightBit != nil {
+		eightBit := *texture.EightBit
+		eightBitHTML := fmt.Sprintf("<span class='info'>broken: %s</span>", html.EscapeString(eightBit.Error))
+
+		if eightBit.Error == "" {
+			eightBitOptions := options
+			eightBitOptions.Upscaler = nil
+			rendered, err := renderTile(eightBit, eightBitOptions)
+
+			if err != nil {
+				return tile{}, err
+			}
+
+			eightBitHTML = fmt.Sprintf("<div class='image'>%s</div><span class='info'>%dx%d (%s)</span>", rendered.Image, eightBit.Width, eightBit.Height, html.EscapeString(eightBit.Format))
+		}
+
+		statsHTML += fmt.Sprintf("<details class='eight-bit'><summary>8-bit version</summary>%s</details>", eightBitHTML)
+	}
+
+	imageDiv := fmt.Sprintf("<div class='image'>%s</div>", imageHTML)
+
+	engineHTML, err := renderEngineView(texture, imageObj, options)
+
+	if err != nil {
+		return tile{}, err
+	}
+
+	if engineHTML != "" {
+		imageDiv = fmt.Sprintf("<div class='image dithered'>%s%s</div>", imageHTML, engineHTML)
+	}
+
+	// A modified texture shows its previous version on the left of a slider, its thumbnail
+	// stored under another name than the current one.
+	if texture.Before != nil && texture.Before.Error == "" {
+		before := *texture.Before
+		beforeOptions := options
+		beforeOptions.Upscaler = nil
+
+		if options.Asset != nil {
+			beforeOptions.Asset = func(family string, name string, data []byte) (string, error) {
+				return options.Asset(family, "before."+name, data)
+			}
+		}
+
+		rendered, err := renderTile(before, beforeOptions)
+
+		if err != nil {
+			return tile{}, err
+		}
+
+		imageDiv = fmt.Sprintf("<div class='image compare'><div class='before'>%s</div><div class='after'>%s</div></div>", rendered.Image, imageHTML)
+
+		if !options.NoJS {
+			imageDiv += "<input type='range' class='slider' min='0' max='100' value='50' aria-label='Before and after'>"
+		}
+
+		captionHTML += fmt.Sprintf(" <span class='info'>before: %dx%d (%s)</span>", before.Width, before.Height, html.EscapeString(before.Format))
+	}
+
+	tooltip := ""
+
+	if options.NoCaptions {
+		tooltip = fmt.Sprintf(" title='%s'", html.EscapeString(fmt.Sprintf("%s %s (%s)", texture.Name, imageDimensions, texture.Format)))
+	}
+
+	return tile{
+		Texture:   texture,
+		Caption:   caption,
+		HTML:      fmt.Sprintf("<div class='texture' tabindex='0'%s%s>%s<div class='caption'>%s%s</div></div>", attributes, tooltip, imageDiv, captionHTML, statsHTML),
+		Image:     imageHTML,
+		Thumbnail: imageObj,
+	}, nil
+}
+
+// renderTiles renders the tiles of textures, in order, on options.Workers goroutines. The error
+// returned is the one of the first texture failing.
+func renderTiles(textures []Texture, options RenderOptions) ([]tile, error) {
+	tiles := make([]tile, len(textures))
+	errs := make([]error, len(textures))
+	workers := max(1, min(options.Workers, len(textures)))
+	chunkSize := max(1, options.ChunkSize)
+	chunks := make(chan int)
+	var wait sync.WaitGroup
+
+	// Textures after the first one failing are left alone, those before it still rendered in
+	// case one of them fails too.
+	var firstFailure atomic.Int64
+	firstFailure.Store(int64(len(textures)))
+
+	for worker := 0; worker < workers; worker++ {
+		wait.Add(1)
+
+		go func() {
+			defer wait.Done()
+
+			for start := range chunks {
+				for i := start; i < min(start+chunkSize, len(textures)) && int64(i) < firstFailure.Load(); i++ {
+					if stopped(options.Stop) {
+						errs[i] = ErrInterrupted
+					} else {
+						tiles[i], errs[i] = renderTile(textures[i], options)
+					}
+
+					for failure := firstFailure.Load(); errs[i] != nil && int64(i) < failure; failure = firstFailure.Load() {
+						if firstFailure.CompareAndSwap(failure, int64(i)) {
+							break
+						}
+					}
+				}
+			}
+		}()
+	}
+
+	for start := 0; start < len(textures); start += chunkSize {
+		chunks <- start
+	}
+
+	close(chunks)
+	wait.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tiles, nil
+}
+
+func renderFamily(family Family, options RenderOptions) (string, error) {
+	if options.EngineView {
+		options.palette = parsePalette(family.Palette)
+	}
+
+	tiles, err := renderTiles(family.Textures, options)
+
+	if err != nil {
+		return "", err
+	}
+
+	compareNames := options.CompareNames
+
+	if compareNames == nil {
+		compareNames = NaturalCompare
+	}
+
+	sort.Slice(tiles, func(i, j int) bool {
+		if order := compareNames(tiles[i].Texture.Name, tiles[j].Texture.Name); order != 0 {
+			return order < 0
+		}
+
+		return tiles[i].Caption < tiles[j].Caption
+	})
+
+	var textures []Texture
+	var thumbnails []image.Image
+	tileHTML := make(map[string]string)
+
+	for _, rendered := range tiles {
+		textures = append(textures, rendered.Texture)
+		thumbnails = append(thumbnails, rendered.Thumbnail)
+		tileHTML[rendered.Texture.Key()] = rendered.HTML
+	}
+
+	if options.FamilyThumbnails != nil {
+		if err := options.FamilyThumbnails(family.Name, thumbnails); err != nil {
+			return "", err
+		}
+	}
+
+	suffixes := MapVariantSuffixes
+
+	if options.GroupVariants {
+		suffixes = options.VariantSuffixes
+	}
+
+	var texturesHTML []string
+
+	for _, group := range GroupVariants(textures, suffixes) {
+		base, _ := SplitVariantName(group[0].Name, suffixes)
+
+		var files []string
+
+		if options.GroupVariants {
+			files = append(files, family.MaterialFiles[base]...)
+		}
+
+		if len(group) == 1 && len(files) == 0 {
+			texturesHTML = append(texturesHTML, tileHTML[group[0].Key()])
+
+			continue
+		}
+
+		var variantsHTML []string
+
+		for _, texture := range group {
+			variantsHTML = append(variantsHTML, tileHTML[texture.Key()])
+		}
+
+		filesHTML := ""
+
+		if len(files) > 0 {
+			sort.Strings(files)
+			filesHTML = fmt.Sprintf("<div class='material-files'>%s</div>", html.EscapeString(strings.Join(files, ", ")))
+		}
+
+		texturesHTML = append(texturesHTML, fmt.Sprintf("<div class='material'><div class='material-name'>%s</div><div class='variants'>%s</div>%s</div>", html.EscapeString(base), strings.Join(variantsHTML, ""), filesHTML))
+	}
+
+	heading := fmt.Sprintf("<h2>%s", html.EscapeString(family.Name))
+
+	if label, ok := options.FamilyLabels[family.Name]; ok {
+		heading = fmt.Sprintf("<h2 title='%s'>%s", html.EscapeString(family.Name), html.EscapeString(label))
+	}
+
+	heading = fmt.Sprintf("%s <span class='badge count'>%d</span>", heading, len(family.Textures))
+
+	if options.FamilyArchive != nil {
+		archiveURL, err := options.FamilyArchive(family.Name)
+
+		if err != nil {
+			return "", err
+		}
+
+		heading = fmt.Sprintf("%s <a class='download' href='%s' download='%s.zip' title='Original files of the family'>zip</a>", heading, html.EscapeString(archiveURL), html.EscapeString(family.Name))
+	}
+
+	heading += "</h2>"
+
+	if family.Description != "" {
+		heading += fmt.Sprintf("<p class='description'>%s</p>", html.EscapeString(family.Description))
+	}
+
+	// The families of a gallery with a family index are anchored, for its cards to link to.
+	anchor := ""
+
+	if len(options.FamilyCards) > 0 {
+		anchor = fmt.Sprintf(" id='%s'", html.EscapeString(familyAnchor(family.Name)))
+	}
+
+	return fmt.Sprintf("<section data-family='%s'%s>%s<div class='family'>%s</div></section>", html.EscapeString(family.Name), anchor, heading, strings.Join(texturesHTML, "")), nil
+}
+
+// Stylesheet returns the CSS of the page, without the print rules. Its colors, font, corner
+// radius, thumbnail size and gaps are custom properties of body (of the crf2html element of a
+// fragment), so a small stylesheet can restyle a gallery by setting them, for the whole page or
+// for a section[data-family] only.
+func Stylesheet(options RenderOptions) string {
+	// The grid fits as many tiles as the window allows, or at most Columns of them: the minimum
+	// track width grows with the window so that no more fit, and never goes below a thumbnail.
+	gridColumns := "repeat(auto-fill,minmax(var(--tile),1fr))"
+
+	if options.Columns > 0 {
+		gridColumns = fmt.Sprintf("repeat(auto-fill,minmax(max(var(--tile),(100%% - %d*var(--gap))/%d),1fr))", options.Columns-1, options.Columns)
+	}
+
+	return fmt.Sprintf(`body{--background:#333;--text:#fff;--muted:#899;--subtle:#ccc;--panel:#222;--placeholder:#555;--border:#444;--backdrop:#000;--accent:#fc6;--warning:#e55;--success:#6c6;--caution:#c96;--info:#6cf;--normal:#88f;--cutout:#9cf;--blended:#c9f;--upscale:#9c9;--seam:#f96;--strip:#fc9;--green:#5c5;--blue:#59f;--radius:4px;--font:Arial,sans-serif;--tile:%dpx;--gap:16px}
+		body,h1,h2{color:var(--text);font-family:var(--font);line-height:1}
+		body{background:var(--background)}
+		body.compact{--tile:%dpx;--gap:8px}
+		h1{font-size:18px;text-transform:uppercase}
+		h2{border-bottom:1px solid var(--muted);font-size:16px;padding:0 0 8px;text-transform:capitalize}
+		section{padding:24px 0}
+		.description{color:var(--muted);font-size:14px;line-height:1.4;margin:0 0 16px;max-width:80ch;white-space:pre-line}
+		.family{display:grid;gap:var(--gap);grid-auto-flow:dense;grid-template-columns:%s}
+		.texture{min-width:0}
+		.image{height:var(--tile)}
+		picture{display:block;height:100%%}
+		img{width:100%%;height:100%%;object-fit:contain}
+		.caption{color:var(--muted);font-size:12px;text-align:center;padding:16px 0;display:flex;flex-direction:column;gap:8px}
+		.filename{font-size:14px;font-weight:bold}
+		.usage{font-style:italic}
+		.badge{align-self:center;background:var(--muted);border-radius:var(--radius);color:var(--background);font-size:10px;padding:2px 6px;text-transform:uppercase}
+		.badge.unused{background:var(--caution)}
+		.badge.warning{background:var(--warning)}
+		.placeholder{align-items:center;background:var(--placeholder);box-sizing:border-box;color:var(--subtle);display:flex;flex-direction:column;font-size:11px;gap:8px;justify-content:center;overflow:hidden;padding:8px;text-align:center;word-break:break-all}
+		.material{border:1px solid var(--placeholder);border-radius:var(--radius);grid-column:1/-1;padding:8px}
+		.material-name{color:var(--muted);font-size:14px;font-weight:bold;padding:0 0 8px}
+		.variants{display:flex;flex-wrap:wrap;gap:var(--gap)}
+		.variants .texture{width:var(--tile)}
+		.material-files{color:var(--muted);font-size:12px;font-style:italic;text-align:center}
+		.badge.normal{background:var(--normal)}
+		.badge.new{background:var(--success)}
+		.badge.changed{background:var(--accent)}
+		.badge.renamed{background:var(--info)}
+		.badge.approved{background:var(--success)}
+		.badge.rejected{background:var(--warning)}
+		.badge.cutout{background:var(--cutout)}
+		.badge.blended{background:var(--blended)}
+		.badge.upscale{background:var(--upscale);text-transform:none}
+		.badge.seam{background:var(--seam);text-transform:none}
+		.badge.strip{background:var(--strip);text-transform:none}
+		.badge.stars{background:none;color:var(--accent);font-size:12px;letter-spacing:1px}
+		.note{color:var(--subtle)}
+		.changes{color:var(--muted);font-size:14px}
+		.changes.empty{color:var(--caution)}
+		h2 .badge.count{font-size:11px;margin-left:6px;text-transform:none;vertical-align:middle}
+		h2 .download{color:var(--muted);font-size:11px;margin-left:6px;text-transform:none;vertical-align:middle}
+		.image .engine{display:none;image-rendering:pixelated}
+		.engine-view .dithered>*{display:none}
+		.engine-view .dithered>.engine{display:block}
+		.stats summary,.eight-bit summary{cursor:pointer}
+		.eight-bit .image{margin:8px 0}
+		.compare{position:relative}
+		.compare .before,.compare .after{height:100%%;inset:0;position:absolute}
+		.compare .after{clip-path:inset(0 0 0 50%%)}
+		.slider{display:block;margin:4px 0 0;width:100%%}
+		.stats svg{background:var(--panel);height:48px;margin:8px 0;width:100%%}
+		.stats polyline{fill:none;stroke-width:1;vector-effect:non-scaling-stroke}
+		.stats .red{stroke:var(--warning)}
+		.stats .green{stroke:var(--green)}
+		.stats .blue{stroke:var(--blue)}
+		.stats .alpha{stroke:var(--subtle)}
+		.stats table{border-collapse:collapse;margin:0 auto}
+		.incomplete{border-top:1px solid var(--warning);color:var(--warning);font-size:14px;padding:16px 0}
+		.pages{color:var(--muted);font-size:14px;margin:16px 0}
+		.pages a,.pages span{color:inherit;margin-right:8px}
+		.pages span{font-weight:bold}
+		.palettes{padding:24px 0}
+		.palette-list{display:flex;flex-wrap:wrap;gap:16px}
+		.palette{margin:0}
+		.palette svg{display:block;height:128px;width:128px}
+		.palette figcaption{color:var(--muted);font-size:12px;padding:8px 0;text-align:center}
+		.family-index{display:grid;gap:var(--gap);grid-template-columns:repeat(auto-fill,minmax(var(--tile),1fr));padding:8px 0 16px}
+		.family-card{color:var(--text);font-size:14px;text-align:center;text-decoration:none;text-transform:capitalize}
+		.family-card img,.family-card .placeholder{display:block;height:var(--tile);margin-bottom:8px;object-fit:contain;width:100%%}
+		.family-card:hover .name{text-decoration:underline}
+		.other-files{padding:24px 0}
+		.other-files table{border-collapse:collapse;color:var(--muted);font-size:12px}
+		.other-files td{border-bottom:1px solid var(--border);padding:4px 16px 4px 0}
+		.other-files .size{text-align:right}
+		.trend{color:var(--muted);font-size:14px;margin:0 0 16px}
+		.trend svg{background:var(--panel);display:block;height:50px;margin-bottom:4px;width:255px}
+		.trend polyline{fill:none;stroke-width:1.5}
+		.trend .textures{color:var(--blue);stroke:var(--blue)}
+		.trend .coverage{color:var(--accent);stroke:var(--accent)}
+		.trend circle{fill:var(--accent)}
+		.stats th,.stats td{padding:2px 4px;text-align:right}
+		#search{background:var(--panel);border:1px solid var(--muted);border-radius:var(--radius);color:var(--text);font-size:14px;padding:6px 8px;width:240px}
+		select.filter{background:var(--panel);border:1px solid var(--muted);border-radius:var(--radius);color:var(--muted);font-size:14px;margin-left:8px;padding:6px 8px}
+		#density,#engine-view{background:var(--panel);border:1px solid var(--muted);border-radius:var(--radius);color:var(--muted);cursor:pointer;font-size:14px;margin-left:8px;padding:6px 8px}
+		.compact .caption{gap:4px;padding:8px 0}
+		.compact .usage,.compact .stats{display:none}
+		h2{cursor:pointer}
+		.collapsed .family{display:none}
+		.collapsed h2::after{content:" (collapsed)";color:var(--muted)}
+		.filtered{display:none}
+		.texture:focus{outline:2px solid var(--accent);outline-offset:4px}
+		#lightbox{align-items:center;background:rgba(0,0,0,.85);cursor:zoom-out;display:flex;inset:0;justify-content:center;position:fixed}
+		#lightbox[hidden]{display:none}
+		#lightbox img{height:90vh;image-rendering:pixelated;width:90vw}
+		#slideshow-start{background:var(--panel);border:1px solid var(--muted);border-radius:var(--radius);color:var(--muted);cursor:pointer;font-size:14px;margin-left:8px;padding:6px 8px}
+		#slideshow{align-items:center;background:var(--backdrop);display:flex;flex-direction:column;gap:12px;inset:0;justify-content:center;position:fixed}
+		#slideshow[hidden]{display:none}
+		#slideshow img{image-rendering:pixelated}
+		.slideshow-caption{color:var(--muted);font-size:14px}
+		%s
+		@media (pointer:coarse){
+		#search,.filter,#density,#engine-view,#slideshow-start{min-height:44px}
+		}
+		@media (max-width:600px){
+		body{--tile:min(%dpx,40vw);--gap:8px;margin:8px}
+		#search{box-sizing:border-box;display:block;margin:0 0 8px;width:100%%}
+		.filter,#density,#engine-view,#slideshow-start{margin:0 8px 8px 0}
+		.family{grid-template-columns:1fr}
+		.texture,.variants .texture{align-items:center;display:grid;gap:12px;grid-template-columns:var(--tile) 1fr;width:auto}
+		.caption{align-items:flex-start;padding:0;text-align:left}
+		.variants{flex-direction:column}
+		}`,
+		options.ThumbnailSize,
+		options.ThumbnailSize*3/4,
+		gridColumns,
+		iconStylesheet(),
+		options.ThumbnailSize,
+	) + noCaptionsStylesheet(options) + themeStylesheet(options.Themes)
+}
+
+// noCaptionsStylesheet hides the captions and tightens the grid for RenderOptions.NoCaptions.
+func noCaptionsStylesheet(options RenderOptions) string {
+	if !options.NoCaptions {
+		return ""
+	}
+
+	return `
+		body{--gap:4px}
+		body.compact{--gap:2px}
+		.texture .caption,.material-files{display:none}
+		.texture:hover{outline:1px solid var(--muted)}
+		@media (max-width:600px){
+		.family{grid-template-columns:repeat(auto-fill,minmax(var(--tile),1fr))}
+		.texture,.variants .texture{display:block}
+		.variants{flex-direction:row}
+		}`
+}
+
+// renderSections renders the section of each family, in order.
+func renderSections(inventory *Inventory, options RenderOptions) (string, error) {
+	if inventory == nil {
+		return "", errors.New("gallery: nil inventory")
+	}
+
+	if options.ThumbnailSize <= 0 {
+		return "", fmt.Errorf("gallery: invalid thumbnail size %d", options.ThumbnailSize)
+	}
+
+	var sections []string
+	done, total := 0, inventory.TextureCount()
+
+	for _, family := range inventory.Families {
+		section, err := renderFamily(family, options)
+
+		if err != nil {
+			return "", err
+		}
+
+		sections = append(sections, section)
+		done += len(family.Textures)
+		reportProgress(options.Progress, ProgressEvent{Stage: "render", Event: "completed", Family: family.Name}, done, total)
+	}
+
+	return strings.Join(sections, ""), nil
+}
+
+// renderNotice renders the lines shown under the page title: the notice, the empty families and
+// the trend chart.
+func renderNotice(inventory *Inventory, options RenderOptions) string {
+	notice := ""
+
+	if options.Notice != "" {
+		notice = fmt.Sprintf("<p class='changes'>%s</p>", html.EscapeString(options.Notice))
+	}
+
+	if len(inventory.EmptyFamilies) > 0 {
+		notice += fmt.Sprintf("<p class='changes empty'>Empty families: %s</p>", html.EscapeString(strings.Join(inventory.EmptyFamilies, ", ")))
+	}
+
+	return notice + renderTrend(options.Trend)
+}
+
+// renderPager renders the links to the other pages of a paginated gallery, if any.
+func renderPager(options RenderOptions) string {
+	if len(options.Pages) < 2 {
+		return ""
+	}
+
+	pager := "<nav class='pages'>Pages: "
+
+	for i, url := range options.Pages {
+		if i == options.PageIndex {
+			pager += fmt.Sprintf("<span>%d</span>", i+1)
+		} else {
+			pager += fmt.Sprintf("<a href='%s'>%d</a>", html.EscapeString(url), i+1)
+		}
+	}
+
+	return pager + "</nav>"
+}
+
+// Render produces the HTML page of inventory. Identical inlined thumbnails are embedded once, the
+// script copying them into the other tiles. The stylesheet is inlined in the head and the script
+// comes last, once the tiles are shown, so the page is readable without it: browsers with
+// JavaScript disabled hide the controls needing it.
+func Render(inventory *Inventory, options RenderOptions) ([]byte, error) {
+	sections, err := renderSections(inventory, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sections += renderPalettes(inventory, options) + renderOtherFiles(inventory)
+
+	if !options.NoJS {
+		sections = shareDataURIs(sections)
+	}
+
+	var metadataKeys []string
+
+	for key := range inventory.Metadata {
+		metadataKeys = append(metadataKeys, key)
+	}
+
+	sort.Strings(metadataKeys)
+
+	metadata := ""
+
+	for _, key := range metadataKeys {
+		metadata += fmt.Sprintf("<meta name='crf2html:%s' content='%s'>", html.EscapeString(key), html.EscapeString(inventory.Metadata[key]))
+	}
+
+	structuredData, err := StructuredData(inventory, options.Title)
+
+	if err != nil {
+		return nil, err
+	}
+
+	printMedia := " media='print'"
+
+	if options.Print {
+		printMedia = ""
+	}
+
+	notice := renderNotice(inventory, options)
+	familyIndex, err := renderFamilyIndex(options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Pages with transparent textures get a filter on their alpha, pages with strips one on their
+	// aspect, pages with reviews one on their verdicts.
+	filters := ""
+
+	if inventory.Filter(func(texture Texture) bool { return texture.Alpha == AlphaCutout || texture.Alpha == AlphaBlended }).TextureCount() > 0 {
+		filters += "<select id='alpha' class='filter' data-filter='alpha'><option value=''>Any alpha</option><option value='opaque'>Opaque</option><option value='cutout'>Cutout</option><option value='blended'>Blended</option></select>"
+	}
+
+	if inventory.Filter(func(texture Texture) bool { return AspectKind(texture.Width, texture.Height) == AspectStrip }).TextureCount() > 0 {
+		filters += "<select id='aspect' class='filter' data-filter='aspect'><option value=''>Any aspect</option><option value='square'>Square</option><option value='wide'>Wide</option><option value='tall'>Tall</option><option value='strip'>Strips</option></select>"
+	}
+
+	if inventory.Filter(func(texture Texture) bool { return texture.Rating != nil }).TextureCount() > 0 {
+		filters += "<select id='verdict' class='filter' data-filter='verdict'><option value=''>All textures</option><option value='approved'>Approved</option><option value='rejected'>Rejected</option><option value='none'>Not reviewed</option></select>"
+	}
+
+	// Galleries of interrupted scans say so, lest missing textures pass for missing files.
+	footer := ""
+
+	if inventory.Incomplete != "" {
+		footer = fmt.Sprintf("<footer class='incomplete'>Incomplete gallery: %s.</footer>", html.EscapeString(inventory.Incomplete))
+	}
+
+	// Pages with dithered thumbnails get a button switching to them.
+	engineButton := ""
+
+	if strings.Contains(sections, "<img class='engine'") {
+		engineButton = "<button id='engine-view' type='button'>Engine view</button>"
+	}
+
+	controls := fmt.Sprintf(
+		"<input id='search' type='search' placeholder='Search textures (press /)'>%s<button id='density' type='button'>Compact</button>%s<button id='slideshow-start' type='button'>Slideshow</button>",
+		filters,
+		engineButton,
+	)
+	noscriptStyle := "#search,.filter,#density,#engine-view,#slideshow-start{display:none}"
+	noscript := fmt.Sprintf("\n<noscript><style>%s</style></noscript>", noscriptStyle)
+	script := fmt.Sprintf(
+		`<div id='lightbox' hidden><img alt=''></div>
+		<div id='slideshow' hidden><img alt=''><div class='slideshow-caption'></div></div>
+		<script>%s</script>`,
+		galleryScript,
+	)
+
+	// The policy allows the inline elements by the hash of their exact content.
+	stylesheet, printStylesheet := "\n\t\t"+Stylesheet(options)+"\n\t\t", "\n\t\t"+printStyle+"\n\t\t"
+	styles := fmt.Sprintf("<style>%s</style>\t\t\n\t\t<style%s>%s</style>", stylesheet, printMedia, printStylesheet)
+	inlineStyles, inlineScripts := []string{stylesheet, printStylesheet}, []string{galleryScript}
+	linked := options.SRI && options.Asset != nil
+
+	if linked {
+		styles, err = linkAssets(options, []linkedAsset{{"gallery.css", Stylesheet(options), ""}, {"print.css", printStyle, printMedia}})
+
+		if err != nil {
+			return nil, err
+		}
+
+		inlineStyles, inlineScripts = nil, nil
+	}
+
+	if options.NoJS {
+		controls, noscript, script = "", "", ""
+		inlineScripts = nil
+	} else {
+		inlineStyles = append(inlineStyles, noscriptStyle)
+	}
+
+	if linked && !options.NoJS {
+		scriptLink, err := linkAssets(options, []linkedAsset{{"gallery.js", galleryScript, ""}})
+
+		if err != nil {
+			return nil, err
+		}
+
+		script = strings.Replace(script, fmt.Sprintf("<script>%s</script>", galleryScript), scriptLink, 1)
+	}
+
+	policy := ContentSecurityPolicy(inlineStyles, inlineScripts, linked)
+
+	page := fmt.Sprintf(
+		`<!DOCTYPE html>
+		<html>
+		<head>
+		<meta charset='utf-8'>
+		<meta http-equiv='Content-Security-Policy' content='%s'>
+		<meta name='viewport' content='width=device-width,initial-scale=1'>
+		<title>%s</title>
+		%s%s
+		<script type='application/ld+json'>%s</script>
+		%s%s
+		</head>
+		<body>
+		<h1>%s</h1>%s
+		%s
+		%s%s%s%s
+		%s
+		</body>
+		</html>`,
+		html.EscapeString(policy),
+		html.EscapeString(options.Title),
+		faviconLink(),
+		metadata,
+		structuredData,
+		styles,
+		noscript,
+		html.EscapeString(options.Title),
+		notice,
+		controls,
+		familyIndex+renderPager(options),
+		sections,
+		renderPager(options),
+		footer,
+		script,
+	)
+
+	return []byte(page), nil
+}
+
+// RenderTo writes the HTML page of inventory to writer.
+func RenderTo(writer io.Writer, inventory *Inventory, options RenderOptions) error {
+	page, err := Render(inventory, options)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(page)
+
+	return err
+}
+
+// RenderFragment produces the family sections alone, in a <div class='crf2html'> meant to be
+// embedded in another page, and their stylesheet. The stylesheet is scoped to that element so it
+// leaves the rest of the page alone. The fragment has no script: no search, lightbox or keyboard
+// navigation.
+func RenderFragment(inventory *Inventory, options RenderOptions) ([]byte, []byte, error) {
+	sections, err := renderSections(inventory, options)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fragment := fmt.Sprintf("<div class='crf2html'>%s%s</div>\n", renderNotice(inventory, options), sections)
+	stylesheet := scopeCSS(Stylesheet(options), ".crf2html") + "@media print{\n" + scopeCSS(printStyle, ".crf2html") + "}\n"
+
+	return []byte(fragment), []byte(stylesheet), nil
+}
+
+// ReplaceSection returns page, a page or fragment rendered earlier, with the section of family
+// rendered again from inventory, so a single family can be updated without rendering the others.
+// A family missing from inventory has its section removed; a new one is inserted in name order,
+// by options.CompareNames like the families of a page.
+func ReplaceSection(page []byte, inventory *Inventory, family string, options RenderOptions) ([]byte, error) {
+	section := ""
+
+	for _, current := range inventory.Families {
+		if current.Name != family {
+			continue
+		}
+
+		rendered, err := renderFamily(current, options)
+
+		if err != nil {
+			return nil, err
+		}
+
+		section = rendered
+	}
+
+	// Shared thumbnails may have their inlined copy in the replaced section, so they are inlined
+	// everywhere first and shared again afterwards.
+	shared := strings.Contains(string(page), " data-thumb='")
+	content := unshareDataURIs(string(page))
+	compareNames := options.CompareNames
+
+	if compareNames == nil {
+		compareNames = NaturalCompare
+	}
+
+	spliced, err := spliceSection(content, section, family, compareNames)
+
+	if err != nil || !shared {
+		return []byte(spliced), err
+	}
+
+	return []byte(shareDataURIs(spliced)), nil
+}
+
+// spliceSection replaces the section of family in content with section, or inserts it before the
+// first family sorting after it by compare.
+func spliceSection(content string, section string, family string, compare func(a string, b string) int) (string, error) {
+	const sectionStart, sectionEnd = "<section data-family='", "</section>"
+	insertAt, lastEnd := -1, -1
+
+	for offset := 0; ; {
+		index := strings.Index(content[offset:], sectionStart)
+
+		if index < 0 {
+			break
+		}
+
+		start := offset + index
+		end := strings.Index(content[start:], sectionEnd)
+
+		if end < 0 {
+			return "", errors.New("gallery: unterminated section in page")
+		}
+
+		end += start + len(sectionEnd)
+		name := html.UnescapeString(strings.SplitN(content[start+len(sectionStart):], "'", 2)[0])
+
+		if name == family {
+			return content[:start] + section + content[end:], nil
+		}
+
+		if compare(name, family) > 0 && insertAt < 0 {
+			insertAt = start
+		}
+
+		offset, lastEnd = end, end
+	}
+
+	if lastEnd < 0 {
+		return "", errors.New("gallery: no family sections in page")
+	}
+
+	if insertAt < 0 {
+		insertAt = lastEnd
+	}
+
+	return content[:insertAt] + section + content[insertAt:], nil
+}
+
+// inlineImagePattern matches the inlined thumbnails of tiles, and sharedImagePattern the first
+// copy of a shared one.
+var (
+	inlineImagePattern = regexp.MustCompile(`<img src='(data:[^']+)'>`)
+	sharedImagePattern = regexp.MustCompile(`<img src='(data:[^']+)' data-thumb='(\d+)'>`)
+)
+
+// shareDataURIs keeps the first copy of each thumbnail inlined several times in content, marked
+// with a data-thumb number, and leaves the others as empty images with that number.
+func shareDataURIs(content string) string {
+	counts := make(map[string]int)
+
+	for _, match := range inlineImagePattern.FindAllStringSubmatch(content, -1) {
+		counts[match[1]]++
+	}
+
+	numbers := make(map[string]int)
+
+	return inlineImagePattern.ReplaceAllStringFunc(content, func(image string) string {
+		uri := inlineImagePattern.FindStringSubmatch(image)[1]
+
+		if counts[uri] < 2 {
+			return image
+		}
+
+		if number, ok := numbers[uri]; ok {
+			return fmt.Sprintf("<img data-thumb='%d'>", number)
+		}
+
+		numbers[uri] = len(numbers) + 1
+
+		return fmt.Sprintf("<img src='%s' data-thumb='%d'>", uri, numbers[uri])
+	})
+}
+
+// unshareDataURIs inlines every shared thumbnail of content again, undoing shareDataURIs.
+func unshareDataURIs(content string) string {
+	uris := make(map[string]string)
+
+	for _, match := range sharedImagePattern.FindAllStringSubmatch(content, -1) {
+		uris[match[2]] = match[1]
+	}
+
+	content = sharedImagePattern.ReplaceAllString(content, "<img src='$1'>")
+
+	return regexp.MustCompile(`<img data-thumb='(\d+)'>`).ReplaceAllStringFunc(content, func(image string) string {
+		return fmt.Sprintf("<img src='%s'>", uris[strings.TrimSuffix(strings.TrimPrefix(image, "<img data-thumb='"), "'>")])
+	})
+}
+
+// scopeCSS prefixes every selector of css, written one rule per line, with scope. Rules for body
+// apply to the scope element itself. At-rules such as @media open and close on lines of their own,
+// kept as they are around the rules they hold.
+func scopeCSS(css string, scope string) string {
+	var rules []string
+
+	for _, line := range strings.Split(css, "\n") {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "@") || line == "}" {
+			rules = append(rules, line+"\n")
+
+			continue
+		}
+
+		brace := strings.Index(line, "{")
+
+		if brace < 0 {
+			continue
+		}
+
+		var selectors []string
+
+		for _, selector := range strings.Split(line[:brace], ",") {
+			if selector = strings.TrimSpace(selector); strings.HasPrefix(selector, "body") {
+				selectors = append(selectors, scope+strings.TrimPrefix(selector, "body"))
+			} else {
+				selectors = append(selectors, scope+" "+selector)
+			}
+		}
+
+		rules = append(rules, strings.Join(selectors, ",")+line[brace:]+"\n")
+	}
+
+	return strings.Join(rules, "")
+}
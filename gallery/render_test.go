@@ -0,0 +1,594 @@
+package gallery
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestStylesheetColumns(t *testing.T) {
+	options := DefaultRenderOptions()
+
+	if css := Stylesheet(options); !strings.Contains(css, "grid-template-columns:repeat(auto-fill,minmax(var(--tile),1fr))") {
+		t.Errorf("auto columns missing from:\n%s", css)
+	}
+
+	options.Columns = 4
+
+	if css := Stylesheet(options); !strings.Contains(css, "minmax(max(var(--tile),(100% - 3*var(--gap))/4),1fr)") {
+		t.Errorf("4 columns missing from:\n%s", css)
+	}
+}
+
+func TestStylesheetThemes(t *testing.T) {
+	options := DefaultRenderOptions()
+	options.Themes = []string{"light", "colorblind"}
+	css := Stylesheet(options)
+
+	light := strings.Index(css, "body{--background:#f4f4f4;")
+	colorblind := strings.Index(css, ".badge.warning::before{")
+
+	if light < 0 || colorblind < light || strings.Index(css, ".badge.warning{background:#e55}") > light {
+		t.Errorf("themes missing or out of order in:\n%s", css)
+	}
+}
+
+// TestStylesheetVariables checks that every custom property the stylesheet declares is used, and
+// that no color of the page is written out in its rules.
+func TestStylesheetVariables(t *testing.T) {
+	css := Stylesheet(DefaultRenderOptions())
+	declarations, rules, _ := strings.Cut(css, "\n")
+
+	for _, declaration := range regexp.MustCompile(`--[a-z]+`).FindAllString(declarations, -1) {
+		if !strings.Contains(rules, "var("+declaration+")") {
+			t.Errorf("%s declared but never used", declaration)
+		}
+	}
+
+	for _, literal := range append(regexp.MustCompile(`[: ]#[0-9a-f]{3}([0-9a-f]{3})?\b`).FindAllString(rules, -1), "Arial") {
+		if strings.Contains(rules, literal) {
+			t.Errorf("%s written out instead of its custom property", literal)
+		}
+	}
+
+	if scoped := scopeCSS(css, ".crf2html"); !strings.HasPrefix(scoped, ".crf2html{--background:#333;") {
+		t.Errorf("custom properties not declared on the fragment element:\n%s", scoped)
+	}
+}
+
+func TestRenderFragment(t *testing.T) {
+	inventory := &Inventory{Families: []Family{{Name: "brick", Textures: []Texture{{Family: "brick", Name: "wall", File: "wall.png", Format: "png", Extension: ".png", Error: "broken"}}}}}
+
+	fragment, stylesheet, err := RenderFragment(inventory, DefaultRenderOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(fragment), "<div class='crf2html'><section data-family='brick'><h2>brick <span class='badge count'>1</span></h2>") {
+		t.Errorf("unexpected fragment: %s", fragment)
+	}
+
+	depth := 0
+
+	for _, line := range strings.Split(strings.TrimSpace(string(stylesheet)), "\n") {
+		switch {
+		case strings.HasPrefix(line, "@media ") && strings.HasSuffix(line, "{"):
+			depth++
+		case line == "}":
+			depth--
+		case !strings.HasPrefix(line, ".crf2html"):
+			t.Errorf("unscoped rule: %s", line)
+		}
+
+		if depth < 0 || depth > 1 {
+			t.Fatalf("unbalanced @media blocks at %s", line)
+		}
+	}
+
+	if depth != 0 {
+		t.Error("unclosed @media block")
+	}
+
+	for _, rule := range []string{".crf2html,.crf2html h1,.crf2html h2{", ".crf2html.compact{", ".crf2html .stats th,.crf2html .stats td{", "@media (max-width:600px){\n.crf2html{--tile:min(", "@media (pointer:coarse){\n.crf2html #search,"} {
+		if !strings.Contains(string(stylesheet), rule) {
+			t.Errorf("stylesheet lacks %q", rule)
+		}
+	}
+}
+
+func TestStructuredData(t *testing.T) {
+	inventory := &Inventory{Families: []Family{{Name: "brick", Textures: []Texture{{Family: "brick", Name: "</script>", File: "wall.pcx", Format: "pcx", Width: 8, Height: 4}}}}}
+
+	data, err := StructuredData(inventory, "Castle")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"@context":"https://schema.org","@type":"ImageGallery","name":"Castle","numberOfItems":1,"hasPart":[{"@type":"ImageObject","name":"\u003c/script\u003e","identifier":"brick/wall.pcx","keywords":"brick","encodingFormat":"image/x-pcx","width":8,"height":4}]}`
+
+	if string(data) != expected {
+		t.Errorf("got  %s\nwant %s", data, expected)
+	}
+}
+
+func TestReplaceSection(t *testing.T) {
+	family := func(name string, file string) Family {
+		return Family{Name: name, Textures: []Texture{{Family: name, Name: strings.TrimSuffix(file, ".png"), File: file, Format: "png", Extension: ".png", Error: "broken"}}}
+	}
+
+	options := DefaultRenderOptions()
+	page, _, err := RenderFragment(&Inventory{Families: []Family{family("brick", "wall.png"), family("metal", "plate.png")}}, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates := []struct {
+		inventory *Inventory
+		family    string
+		sections  string
+	}{
+		{&Inventory{Families: []Family{family("brick", "floor.png")}}, "brick", "brick:floor,metal:plate"},
+		{&Inventory{Families: []Family{family("crate", "lid.png")}}, "crate", "brick:floor,crate:lid,metal:plate"},
+		{&Inventory{Families: []Family{family("wood", "oak.png")}}, "wood", "brick:floor,crate:lid,metal:plate,wood:oak"},
+		{&Inventory{}, "metal", "brick:floor,crate:lid,wood:oak"},
+	}
+
+	pattern := regexp.MustCompile(`<section data-family='(\w+)'>.*?<span class='filename'>(\w+)</span>`)
+
+	for _, update := range updates {
+		if page, err = ReplaceSection(page, update.inventory, update.family, options); err != nil {
+			t.Fatal(err)
+		}
+
+		var sections []string
+
+		for _, match := range pattern.FindAllStringSubmatch(string(page), -1) {
+			sections = append(sections, match[1]+":"+match[2])
+		}
+
+		if strings.Join(sections, ",") != update.sections {
+			t.Errorf("after updating %s, sections = %q, want %s", update.family, sections, update.sections)
+		}
+	}
+
+	if _, err := ReplaceSection([]byte("<html></html>"), &Inventory{}, "brick", options); err == nil {
+		t.Error("page without sections accepted")
+	}
+}
+
+// TestReplaceSectionOrder checks that new families are inserted in the order of the families of
+// the page, natural or collated, rather than byte by byte.
+func TestReplaceSectionOrder(t *testing.T) {
+	family := func(name string) Family {
+		return Family{Name: name, Textures: []Texture{{Family: name, Name: "wall", File: "wall.png", Format: "png", Extension: ".png", Error: "broken"}}}
+	}
+
+	french, err := Collation("fr")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pattern := regexp.MustCompile(`<section data-family='([^']+)'>`)
+
+	for _, test := range []struct {
+		compare  func(a string, b string) int
+		families []string
+		family   string
+		expected string
+	}{
+		{nil, []string{"tex2", "tex20"}, "tex10", "tex2,tex10,tex20"},
+		{french, []string{"dalle", "fer"}, "épave", "dalle,épave,fer"},
+	} {
+		options := DefaultRenderOptions()
+		options.CompareNames = test.compare
+		inventory := &Inventory{}
+
+		for _, name := range test.families {
+			inventory.Families = append(inventory.Families, family(name))
+		}
+
+		page, _, err := RenderFragment(inventory, options)
+
+		if err == nil {
+			page, err = ReplaceSection(page, &Inventory{Families: []Family{family(test.family)}}, test.family, options)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var sections []string
+
+		for _, match := range pattern.FindAllStringSubmatch(string(page), -1) {
+			sections = append(sections, html.UnescapeString(match[1]))
+		}
+
+		if strings.Join(sections, ",") != test.expected {
+			t.Errorf("%s inserted in %q, want %s", test.family, sections, test.expected)
+		}
+	}
+}
+
+func TestRenderSharesThumbnails(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	texture := func(family string, name string) Texture {
+		return Texture{Family: family, Name: name, File: name + ".png", Format: "png", Extension: ".png", Image: img, Width: 4, Height: 4}
+	}
+
+	inventory := &Inventory{Families: []Family{
+		{Name: "brick", Textures: []Texture{texture("brick", "a"), texture("brick", "b")}},
+		{Name: "metal", Textures: []Texture{texture("metal", "c")}},
+	}}
+
+	page, err := Render(inventory, DefaultRenderOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count := strings.Count(string(page), "src='data:image/"); count != 1 || strings.Count(string(page), "<img data-thumb='1'>") != 2 {
+		t.Fatalf("thumbnail inlined %d times", count)
+	}
+
+	// Replacing the section holding the inlined copy moves it to the remaining tiles.
+	inventory.Families[0].Textures = []Texture{texture("brick", "a")}
+
+	if page, err = ReplaceSection(page, inventory, "brick", DefaultRenderOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Count(string(page), "src='data:image/") != 1 || strings.Count(string(page), "<img data-thumb='1'>") != 1 {
+		t.Errorf("unexpected shared thumbnails after replacing a section")
+	}
+}
+
+func TestUpscalers(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	upscalers := []Upscaler{Upscalers["nearest"], Upscalers["bicubic"]}
+
+	if runtime.GOOS != "windows" {
+		upscalers = append(upscalers, CommandUpscaler{Command: "cp {in} {out}"})
+	}
+
+	for _, upscaler := range upscalers {
+		upscaled, err := upscaler.Upscale(img, UpscaleFactor)
+
+		if err != nil {
+			t.Fatalf("%s: %v", upscaler.Name(), err)
+		}
+
+		// The command copies its input, at the same size.
+		expected := image.Rect(0, 0, 32, 16)
+
+		if _, ok := upscaler.(CommandUpscaler); ok {
+			expected = img.Bounds()
+		}
+
+		if upscaled.Bounds() != expected {
+			t.Errorf("%s: upscaled to %v, want %v", upscaler.Name(), upscaled.Bounds(), expected)
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	if _, err := (CommandUpscaler{Command: "true {in} {out}"}).Upscale(img, UpscaleFactor); err == nil {
+		t.Error("command writing nothing accepted")
+	}
+}
+
+func TestRenderPalettes(t *testing.T) {
+	broken := []Texture{{Name: "wall", File: "wall.png", Format: "png", Extension: ".png", Error: "broken"}}
+	inventory := &Inventory{Families: []Family{
+		{Name: "brick", Textures: broken, Palette: []string{"#000000", "#ff0000"}},
+		{Name: "metal", Textures: broken},
+		{Name: "stone", Textures: broken, Palette: make([]string, 256)},
+	}}
+
+	options := DefaultRenderOptions()
+	options.FamilyLabels = map[string]string{"stone": "Stone & Mortar"}
+	page, err := Render(inventory, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	figures := regexp.MustCompile(`<figure class='palette'><svg viewBox='0 0 16 (\d+)'[^>]*>.*?</svg><figcaption>([^<]+)</figcaption></figure>`).FindAllStringSubmatch(string(page), -1)
+
+	if len(figures) != 2 || figures[0][1] != "1" || figures[0][2] != "brick" || figures[1][1] != "16" || figures[1][2] != "Stone &amp; Mortar" {
+		t.Errorf("palettes: %q", figures)
+	}
+
+	if !strings.Contains(string(page), "<rect x='1' y='0' width='1' height='1' fill='#ff0000'><title>1 #ff0000</title></rect>") {
+		t.Error("swatch missing")
+	}
+
+	inventory.Families[0].Palette, inventory.Families[2].Palette = nil, nil
+
+	if page, _ := Render(inventory, options); strings.Contains(string(page), "class='palettes'") {
+		t.Error("palette section without palettes")
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	gradient := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			gradient.Set(x, y, color.NRGBA{uint8(x * 4), uint8(y * 4), uint8(x + y), 255})
+		}
+	}
+
+	for colors, expected := range map[int]int{16: 16, 1000: 256} {
+		if quantized := Quantize(gradient, colors); len(quantized.Palette) != expected {
+			t.Errorf("%d colors: got a palette of %d", colors, len(quantized.Palette))
+		}
+	}
+
+	// Images with fewer colors than asked keep them exactly, transparency included.
+	flat := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	flat.Set(1, 1, color.NRGBA{200, 10, 10, 255})
+	flat.Set(2, 2, color.NRGBA{10, 200, 10, 128})
+	quantized := Quantize(flat, 16)
+
+	for _, point := range []image.Point{{0, 0}, {1, 1}, {2, 2}} {
+		if got, want := quantized.At(point.X, point.Y), flat.At(point.X, point.Y); got != want {
+			t.Errorf("pixel %v: got %v, want %v", point, got, want)
+		}
+	}
+}
+
+func TestRenderQuantize(t *testing.T) {
+	options := DefaultRenderOptions()
+	options.Quantize = 8
+	inventory := &Inventory{Families: []Family{{Name: "brick", Textures: []Texture{
+		{Family: "brick", Name: "wall", File: "wall.png", Format: "png", Extension: ".png", Image: image.NewGray(image.Rect(0, 0, 8, 8))},
+	}}}}
+
+	page, err := Render(inventory, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(page), "data:image/png;base64,") {
+		t.Error("quantized thumbnail is not a PNG")
+	}
+}
+
+func TestDither(t *testing.T) {
+	gray := image.NewUniform(color.Gray{128})
+	palette := color.Palette{color.RGBA{0, 0, 0, 255}, color.RGBA{255, 255, 255, 255}}
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(img, img.Bounds(), gray, image.Point{}, draw.Src)
+
+	// Mid gray alternates black and white pixels rather than being matched with either.
+	dithered := Dither(img, palette, color.White)
+	counts := make(map[uint8]int)
+
+	for _, index := range dithered.Pix {
+		counts[index]++
+	}
+
+	if counts[0] < 16 || counts[1] < 16 {
+		t.Errorf("mid gray dithered to %d black and %d white pixels", counts[0], counts[1])
+	}
+}
+
+func TestRenderEngineView(t *testing.T) {
+	truecolor := Texture{Family: "brick", Name: "wall", File: "wall.png", Format: "png", Extension: ".png", Image: image.NewRGBA(image.Rect(0, 0, 8, 8))}
+	paletted := Texture{Family: "brick", Name: "floor", File: "floor.pcx", Format: "pcx", Extension: ".pcx", Palette: 2, Image: image.NewRGBA(image.Rect(0, 0, 8, 8))}
+	inventory := &Inventory{Families: []Family{{Name: "brick", Textures: []Texture{truecolor, paletted}, Palette: []string{"#000000", "#ff0000"}}}}
+
+	options := DefaultRenderOptions()
+	options.EngineView = true
+	page, err := Render(inventory, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Count(string(page), "<img class='engine' src='data:image/png;base64,") != 1 || !strings.Contains(string(page), "<button id='engine-view'") {
+		t.Error("truecolor texture without its dithered thumbnail, or paletted one with")
+	}
+
+	inventory.Families[0].Palette = nil
+
+	if page, _ := Render(inventory, options); strings.Contains(string(page), "<button id='engine-view'") {
+		t.Error("engine view button without dithered thumbnails")
+	}
+}
+
+func TestComputeSeams(t *testing.T) {
+	// A gradient across the width tiles vertically, but jumps from white to black horizontally.
+	gradient := image.NewGray(image.Rect(0, 0, 64, 64))
+
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			gradient.SetGray(x, y, color.Gray{uint8(x * 4)})
+		}
+	}
+
+	if scores := ComputeSeams(gradient); !scores.Seamed() || scores.Horizontal < 10 || scores.Vertical != 0 {
+		t.Errorf("gradient: %+v", scores)
+	}
+
+	// Waves whose period divides the size tile both ways.
+	waves := image.NewGray(image.Rect(0, 0, 64, 64))
+
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			waves.SetGray(x, y, color.Gray{uint8(128 + 60*math.Sin(float64(x)*math.Pi/16) + 60*math.Cos(float64(y)*math.Pi/32))})
+		}
+	}
+
+	if scores := ComputeSeams(waves); scores.Seamed() || scores.HTML() != "" || scores.Horizontal > 2 || scores.Vertical > 2 {
+		t.Errorf("waves: %+v", scores)
+	}
+}
+
+func TestTune(t *testing.T) {
+	small := Tune(0, 10000, 10000*50000)
+
+	if small.Workers != min(small.CPUs, 10000) || small.ChunkSize < 1 || small.ChunkSize > maxChunkSize {
+		t.Errorf("small textures tuned to %v", small)
+	}
+
+	if large := Tune(0, 100, 100*(8<<20)); large.ChunkSize != 1 || large.Workers > large.CPUs {
+		t.Errorf("large textures tuned to %v", large)
+	}
+
+	if few := Tune(16, 3, 3000); few.Workers != 3 || few.ChunkSize != 1 {
+		t.Errorf("3 textures with -jobs 16 tuned to %v", few)
+	}
+}
+
+// TestRenderWorkers checks that tiles rendered on several goroutines make the same page, and
+// that the error of the first failing texture is the one returned.
+func TestRenderWorkers(t *testing.T) {
+	var textures []Texture
+
+	for i := 0; i < 40; i++ {
+		img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+		img.Set(i%8, i/8, color.RGBA{uint8(i * 6), 0, 0, 255})
+		textures = append(textures, Texture{Family: "brick", Name: fmt.Sprintf("wall%02d", i), File: fmt.Sprintf("wall%02d.png", i), Format: "png", Extension: ".png", Image: img})
+	}
+
+	inventory := &Inventory{Families: []Family{{Name: "brick", Textures: textures}}}
+	options := DefaultRenderOptions()
+	sequential, err := Render(inventory, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options.Workers, options.ChunkSize = 4, 3
+	parallel, err := Render(inventory, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(sequential, parallel) {
+		t.Error("the page rendered by 4 workers differs from the sequential one")
+	}
+
+	options.Asset = func(family string, name string, data []byte) (string, error) {
+		return "", fmt.Errorf("cannot store %s", name)
+	}
+
+	if _, err := Render(inventory, options); err == nil || err.Error() != "cannot store wall00.png.jpeg" {
+		t.Errorf("got error %v, want the one of wall00.png", err)
+	}
+}
+
+func TestAspect(t *testing.T) {
+	for _, test := range []struct {
+		width, height int
+		kind, ratio   string
+	}{
+		{64, 64, AspectSquare, "1:1"},
+		{128, 64, AspectWide, "2:1"},
+		{64, 256, AspectTall, "1:4"},
+		{256, 16, AspectStrip, "16:1"},
+		{24, 256, AspectStrip, "1:10.7"},
+	} {
+		if kind, ratio := AspectKind(test.width, test.height), AspectRatio(test.width, test.height); kind != test.kind || ratio != test.ratio {
+			t.Errorf("%dx%d is %s %s, want %s %s", test.width, test.height, kind, ratio, test.kind, test.ratio)
+		}
+	}
+
+	strip := Texture{Family: "trim", Name: "molding", File: "molding.png", Format: "png", Extension: ".png", Width: 128, Height: 8, Image: image.NewGray(image.Rect(0, 0, 128, 8))}
+	inventory := &Inventory{Families: []Family{{Name: "trim", Textures: []Texture{strip}}}}
+	page, err := Render(inventory, DefaultRenderOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"data-aspect='strip'", "<span class='badge strip' title='16:1 strip, likely a trim texture'>16:1</span>", "<select id='aspect' class='filter' data-filter='aspect'>"} {
+		if !strings.Contains(string(page), expected) {
+			t.Errorf("%s missing", expected)
+		}
+	}
+}
+
+// TestRenderIcons checks that the icons of the page are inlined, so it loads nothing.
+func TestRenderIcons(t *testing.T) {
+	page, err := Render(&Inventory{}, DefaultRenderOptions())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"<link rel='icon' type='image/svg+xml' href='data:image/svg+xml;base64,", "#slideshow-start::before{background:url(data:image/svg+xml;base64,"} {
+		if !strings.Contains(string(page), expected) {
+			t.Errorf("%s missing", expected)
+		}
+	}
+
+	if regexp.MustCompile(`(src|href)='https?:|url\(https?:`).Match(page) {
+		t.Error("the page loads a remote resource")
+	}
+}
+
+func TestRenderFamilyIndex(t *testing.T) {
+	texture := func(family string, name string, size int) Texture {
+		img := image.NewRGBA(image.Rect(0, 0, size, size))
+		return Texture{Family: family, Name: name, File: name + ".png", Format: "png", Extension: ".png", Width: size, Height: size, Image: img}
+	}
+
+	pages := []*Inventory{
+		{Families: []Family{{Name: "brick", Textures: []Texture{texture("brick", "wall", 8), texture("brick", "brick", 4)}}}},
+		{Families: []Family{{Name: "metal", Textures: []Texture{texture("metal", "plate", 4), texture("metal", "grate", 8)}}, {Name: "rust", Textures: []Texture{{Family: "rust", Name: "flake", File: "flake.png", Error: "broken"}}}}},
+	}
+
+	cards := FamilyCards(pages)
+
+	if len(cards) != 3 || cards[0].Texture.Name != "brick" || cards[1].Page != 1 || cards[1].Texture.Name != "grate" || cards[2].HasTexture {
+		t.Fatalf("unexpected cards %+v", cards)
+	}
+
+	options := DefaultRenderOptions()
+	options.Pages, options.FamilyCards = []string{"index.html", "index-2.html"}, cards
+	page, err := Render(pages[0], options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"<nav class='family-index'><a class='family-card' href='#family-brick'><img src='data:image/jpg;base64,",
+		"<a class='family-card' href='index-2.html#family-metal'>",
+		"<a class='family-card' href='index-2.html#family-rust'><span class='image placeholder'></span><span class='name'>rust</span> <span class='badge count'>1</span></a>",
+		"<section data-family='brick' id='family-brick'>",
+	} {
+		if !strings.Contains(string(page), expected) {
+			t.Errorf("%s missing", expected)
+		}
+	}
+
+	options.PageIndex = 1
+	page, err = Render(pages[1], options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(page), "<nav class='family-index'>") {
+		t.Error("the family index is repeated on the second page")
+	}
+}
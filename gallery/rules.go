@@ -0,0 +1,126 @@
+package gallery
+
+/**
+ * Inclusion rules
+ *
+ * Every file of a source goes through an ordered list of rules deciding whether it is a texture,
+ * a material file or skipped; the first rule with a verdict wins. The decision, with the rule
+ * and the reason behind it, is kept in the inventory, so a surprising skip can be explained
+ * instead of guessed at. Programs can put their own rules (ignore lists, presets) in front of
+ * DefaultRules through ScanOptions.
+ */
+
+import (
+	"fmt"
+)
+
+// Verdict is the outcome of the rules for a file.
+type Verdict string
+
+const (
+	VerdictTexture  Verdict = "texture"
+	VerdictMaterial Verdict = "material"
+	VerdictSkip     Verdict = "skip"
+
+	// VerdictDescription keeps a file as the description of its family.
+	VerdictDescription Verdict = "description"
+)
+
+// Candidate is a file submitted to the rules. File and Extension are lowercase.
+type Candidate struct {
+	Path      string
+	Family    string
+	File      string
+	Extension string
+	Data      []byte
+}
+
+// Rule decides on candidates. Decide returns an empty verdict to leave the candidate to the
+// following rules, or a verdict and the reason for it.
+type Rule struct {
+	Name   string
+	Decide func(candidate Candidate) (Verdict, string)
+}
+
+// Decision records which rule decided on a file, and why. Source names the scanned source the
+// file belongs to.
+type Decision struct {
+	Source  string  `json:"source"`
+	Path    string  `json:"path"`
+	Verdict Verdict `json:"verdict"`
+	Rule    string  `json:"rule"`
+	Reason  string  `json:"reason"`
+}
+
+// String describes the decision on one line.
+func (decision Decision) String() string {
+	return fmt.Sprintf("%s: %s (rule %s: %s)", decision.Path, decision.Verdict, decision.Rule, decision.Reason)
+}
+
+// Rules is an ordered list of rules.
+type Rules []Rule
+
+// Decide runs candidate through the rules. Candidates no rule decides on are skipped.
+func (rules Rules) Decide(candidate Candidate) Decision {
+	for _, rule := range rules {
+		if verdict, reason := rule.Decide(candidate); verdict != "" {
+			return Decision{Path: candidate.Path, Verdict: verdict, Rule: rule.Name, Reason: reason}
+		}
+	}
+
+	return Decision{Path: candidate.Path, Verdict: VerdictSkip, Rule: "default", Reason: "no rule matched"}
+}
+
+// DefaultRules returns the rules used by Scan: material files are gathered, family palettes
+// skipped, and the files of a known image format, by content or by extension, kept.
+func DefaultRules() Rules {
+	return Rules{
+		{Name: "material-file", Decide: func(candidate Candidate) (Verdict, string) {
+			if MaterialExtensions[candidate.Extension] {
+				return VerdictMaterial, fmt.Sprintf("%s is a material file extension", candidate.Extension)
+			}
+
+			return "", ""
+		}},
+		{Name: "family-description", Decide: func(candidate Candidate) (Verdict, string) {
+			if candidate.File == "description.txt" {
+				return VerdictDescription, "description.txt describes the family"
+			}
+
+			return "", ""
+		}},
+		{Name: "family-palette", Decide: func(candidate Candidate) (Verdict, string) {
+			if candidate.File == "full.pcx" {
+				return VerdictSkip, "full.pcx holds the palette of the family"
+			}
+
+			return "", ""
+		}},
+		{Name: "image-format", Decide: func(candidate Candidate) (Verdict, string) {
+			decoder, sniffed, ok := resolveDecoder(candidate.Extension, candidate.Data)
+
+			switch {
+			case !ok && candidate.Extension == "":
+				return VerdictSkip, "no extension and unrecognized content"
+			case !ok:
+				return VerdictSkip, fmt.Sprintf("no decoder for %s and unrecognized content", candidate.Extension)
+			case sniffed:
+				return VerdictTexture, fmt.Sprintf("content recognized as %s despite the name", decoder.Name)
+			default:
+				return VerdictTexture, fmt.Sprintf("%s image", decoder.Name)
+			}
+		}},
+	}
+}
+
+// resolveDecoder picks the decoder of a file; sniffed tells whether it was chosen by content
+// against the extension. The content wins: CRFs contain textures with wrong or missing extensions.
+func resolveDecoder(extension string, data []byte) (decoder Decoder, sniffed bool, ok bool) {
+	decoder, known := DecoderForExtension(extension)
+
+	if byContent, ok := SniffDecoder(data); ok && (!known || byContent.Name != decoder.Name) {
+		return byContent, true, true
+	}
+
+	return decoder, false, known
+}
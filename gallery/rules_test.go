@@ -0,0 +1,56 @@
+package gallery
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRules(t *testing.T) {
+	fsys := fstest.MapFS{
+		"brick/wall.png":    {Data: encodePNG(t, 8, 4)},
+		"brick/moss.jpg":    {Data: encodePNG(t, 2, 2)},
+		"brick/full.pcx":    {Data: []byte("palette")},
+		"drafts/sketch.png": {Data: encodePNG(t, 2, 2)},
+		"metal/readme.txt":  {Data: []byte("not a texture")},
+		"metal/plate.mtl":   {Data: []byte("texture plate\n")},
+	}
+
+	options := DefaultScanOptions()
+	options.Rules = append(Rules{{Name: "no-drafts", Decide: func(candidate Candidate) (Verdict, string) {
+		if candidate.Family == "drafts" {
+			return VerdictSkip, "drafts are not published"
+		}
+
+		return "", ""
+	}}}, DefaultRules()...)
+
+	inventory, err := ScanFS(fsys, "memory", options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"brick/full.pcx":    "brick/full.pcx: skip (rule family-palette: full.pcx holds the palette of the family)",
+		"brick/moss.jpg":    "brick/moss.jpg: texture (rule image-format: content recognized as png despite the name)",
+		"brick/wall.png":    "brick/wall.png: texture (rule image-format: png image)",
+		"drafts/sketch.png": "drafts/sketch.png: skip (rule no-drafts: drafts are not published)",
+		"metal/plate.mtl":   "metal/plate.mtl: material (rule material-file: .mtl is a material file extension)",
+		"metal/readme.txt":  "metal/readme.txt: skip (rule image-format: no decoder for .txt and unrecognized content)",
+	}
+
+	if len(inventory.Decisions) != len(expected) {
+		t.Fatalf("decisions = %v", inventory.Decisions)
+	}
+
+	for _, decision := range inventory.Decisions {
+		if decision.String() != expected[decision.Path] {
+			t.Errorf("got  %s\nwant %s", decision, expected[decision.Path])
+		}
+	}
+
+	if inventory.TextureCount() != 2 || strings.Join(inventory.EmptyFamilies, ",") != "drafts,metal" {
+		t.Errorf("unexpected inventory: %+v", inventory)
+	}
+}
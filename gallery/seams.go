@@ -0,0 +1,105 @@
+package gallery
+
+/**
+ * Seam analysis
+ *
+ * Textures are tiled across walls and floors, so the left edge of a texture is drawn next to its
+ * right edge, and its top edge next to its bottom one. With RenderOptions.Seams, each texture
+ * gets a score per direction: the mean color difference across the wrapped edge, divided by the
+ * mean difference between neighbouring pixels inside the texture. Tileable textures score about
+ * 1 whatever their amount of detail; a visible seam scores several times that, and gets a badge.
+ */
+
+import (
+	"fmt"
+	"html"
+	"image"
+)
+
+// SeamThreshold is the score from which an edge is reported as a likely seam.
+const SeamThreshold = 3.0
+
+// seamMinimumDifference is the mean difference, out of 255 per channel, under which an edge is
+// never reported: flat textures have near-zero interior differences, which inflate their score.
+const seamMinimumDifference = 8.0
+
+// SeamScores are the edge mismatches of a texture, left/right (Horizontal) and top/bottom
+// (Vertical). Zero scores stand for textures too small to tell.
+type SeamScores struct {
+	Horizontal float64
+	Vertical   float64
+
+	horizontalDifference float64
+	verticalDifference   float64
+}
+
+// pixelDifference returns the mean absolute difference of the color channels of two pixels.
+func pixelDifference(img image.Image, x1 int, y1 int, x2 int, y2 int) float64 {
+	r1, g1, b1, _ := img.At(x1, y1).RGBA()
+	r2, g2, b2, _ := img.At(x2, y2).RGBA()
+
+	difference := func(a uint32, b uint32) float64 {
+		if a > b {
+			return float64(a-b) / 257
+		}
+
+		return float64(b-a) / 257
+	}
+
+	return (difference(r1, r2) + difference(g1, g2) + difference(b1, b2)) / 3
+}
+
+// ComputeSeams compares the opposite edges of img.
+func ComputeSeams(img image.Image) SeamScores {
+	bounds := img.Bounds()
+	var scores SeamScores
+
+	if bounds.Dx() >= 3 {
+		edge, interior := 0.0, 0.0
+
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			edge += pixelDifference(img, bounds.Min.X, y, bounds.Max.X-1, y)
+
+			for x := bounds.Min.X; x < bounds.Max.X-1; x++ {
+				interior += pixelDifference(img, x, y, x+1, y)
+			}
+		}
+
+		scores.horizontalDifference = edge / float64(bounds.Dy())
+		scores.Horizontal = scores.horizontalDifference / max(interior/float64(bounds.Dy()*(bounds.Dx()-1)), 1)
+	}
+
+	if bounds.Dy() >= 3 {
+		edge, interior := 0.0, 0.0
+
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			edge += pixelDifference(img, x, bounds.Min.Y, x, bounds.Max.Y-1)
+
+			for y := bounds.Min.Y; y < bounds.Max.Y-1; y++ {
+				interior += pixelDifference(img, x, y, x, y+1)
+			}
+		}
+
+		scores.verticalDifference = edge / float64(bounds.Dx())
+		scores.Vertical = scores.verticalDifference / max(interior/float64(bounds.Dx()*(bounds.Dy()-1)), 1)
+	}
+
+	return scores
+}
+
+// Seamed reports whether the edges of either direction likely show a seam once tiled.
+func (scores SeamScores) Seamed() bool {
+	return scores.Horizontal >= SeamThreshold && scores.horizontalDifference >= seamMinimumDifference ||
+		scores.Vertical >= SeamThreshold && scores.verticalDifference >= seamMinimumDifference
+}
+
+// HTML renders the scores as a caption badge for seamed textures, nothing for the others.
+func (scores SeamScores) HTML() string {
+	if !scores.Seamed() {
+		return ""
+	}
+
+	title := fmt.Sprintf("Edge mismatch: left/right %.1f×, top/bottom %.1f× the differences inside the texture", scores.Horizontal, scores.Vertical)
+
+	return fmt.Sprintf(" <span class='badge seam' title='%s'>seam %.1f×</span>", html.EscapeString(title), max(scores.Horizontal, scores.Vertical))
+}
@@ -0,0 +1,185 @@
+package gallery
+
+/**
+ * 8.3 names
+ *
+ * The legacy engine and the tools of its time read names of at most eight characters and a
+ * three-letter extension, family directories included. CheckPack warns about longer names, and
+ * ShortNames suggests replacements: names cut to 8.3, with the extensions of the image formats
+ * spelt in three letters, and a "~N" suffix where two names would clash, the way DOS did. Files
+ * sharing a base name, such as a texture and its material, are renamed together. The suggestions
+ * are written as a JSON map or as a shell script by pack and repack, which can also apply them.
+ */
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// shortExtensions spells the extensions of the image formats in three letters.
+var shortExtensions = map[string]string{".jpeg": ".jpg", ".tiff": ".tif", ".targa": ".tga"}
+
+// Rename is a suggested new name of a file, both slash-separated paths.
+type Rename struct {
+	From string
+	To   string
+}
+
+// ShortName reports whether the slash-separated name has 8.3 components only.
+func ShortName(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		extension := path.Ext(part)
+
+		if len(strings.TrimSuffix(part, extension)) > 8 || len(extension) > 4 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// shortenBase returns base cut to 8 characters or, when taken tells that this is already used,
+// to fewer followed by "~N", for the first N free.
+func shortenBase(base string, taken func(string) bool) string {
+	if cut := base[:min(len(base), 8)]; !taken(cut) {
+		return cut
+	}
+
+	for attempt := 1; ; attempt++ {
+		suffix := fmt.Sprintf("~%d", attempt)
+		candidate := base[:min(len(base), 8-len(suffix))] + suffix
+
+		if !taken(candidate) {
+			return candidate
+		}
+	}
+}
+
+// ShortNames suggests 8.3 names for names, slash-separated "family/file" paths, and returns the
+// renames of the names changing, in order. Names already 8.3 are kept, and new names never take
+// one of them.
+func ShortNames(names []string) []Rename {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+
+	// Short names are kept as they are, and reserved first.
+	directories := make(map[string]string)
+	usedDirectories := make(map[string]bool)
+	bases := make(map[string]string)
+	usedBases := make(map[string]bool)
+
+	for _, name := range sorted {
+		directory, file := path.Split(name)
+		base := strings.TrimSuffix(file, path.Ext(file))
+
+		if len(path.Base(directory)) <= 8 {
+			usedDirectories[strings.ToLower(directory)] = true
+		}
+
+		if len(base) <= 8 {
+			usedBases[strings.ToLower(directory+base)] = true
+		}
+	}
+
+	var renames []Rename
+
+	for _, name := range sorted {
+		directory, file := path.Split(name)
+		extension := path.Ext(file)
+		base := strings.TrimSuffix(file, extension)
+
+		newDirectory, ok := directories[directory]
+
+		if !ok {
+			newDirectory = directory
+
+			if directory != "" && len(path.Base(directory)) > 8 {
+				parent := path.Dir(strings.TrimSuffix(directory, "/"))
+				prefix := strings.TrimPrefix(parent+"/", "./")
+				newDirectory = prefix + shortenBase(path.Base(directory), func(candidate string) bool {
+					return usedDirectories[strings.ToLower(prefix+candidate+"/")]
+				}) + "/"
+				usedDirectories[strings.ToLower(newDirectory)] = true
+			}
+
+			directories[directory] = newDirectory
+		}
+
+		newBase, ok := bases[directory+base]
+
+		if !ok {
+			newBase = base
+
+			if len(base) > 8 {
+				newBase = shortenBase(base, func(candidate string) bool {
+					return usedBases[strings.ToLower(directory+candidate)]
+				})
+				usedBases[strings.ToLower(directory+newBase)] = true
+			}
+
+			bases[directory+base] = newBase
+		}
+
+		newExtension := extension
+
+		if short, ok := shortExtensions[strings.ToLower(extension)]; ok {
+			newExtension = short
+		} else if len(extension) > 4 {
+			newExtension = extension[:4]
+		}
+
+		if newName := newDirectory + newBase + newExtension; newName != name {
+			renames = append(renames, Rename{From: name, To: newName})
+		}
+	}
+
+	return renames
+}
+
+// shellQuote quotes value for a POSIX shell.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// WriteRenameScript writes renames as a POSIX shell script, run from the directory holding the
+// families: directories are renamed first, then the files in their new directory.
+func WriteRenameScript(writer io.Writer, renames []Rename) error {
+	if _, err := io.WriteString(writer, "#!/bin/sh\n# 8.3 names suggested by crf2html, run from the directory holding the families.\nset -e\n"); err != nil {
+		return err
+	}
+
+	directories := make(map[string]string)
+	var directoryOrder []string
+
+	for _, rename := range renames {
+		from, to := path.Dir(rename.From), path.Dir(rename.To)
+
+		if _, ok := directories[from]; !ok && from != to {
+			directories[from] = to
+			directoryOrder = append(directoryOrder, from)
+		}
+	}
+
+	for _, directory := range directoryOrder {
+		if _, err := fmt.Fprintf(writer, "mv -- %s %s\n", shellQuote(directory), shellQuote(directories[directory])); err != nil {
+			return err
+		}
+	}
+
+	for _, rename := range renames {
+		from := path.Join(path.Dir(rename.To), path.Base(rename.From))
+
+		if from == rename.To {
+			continue
+		}
+
+		if _, err := fmt.Fprintf(writer, "mv -- %s %s\n", shellQuote(from), shellQuote(rename.To)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package gallery
+
+/**
+ * Spilling textures to disk
+ *
+ * A scan keeps every decoded texture in memory until the page is rendered, which a whole game
+ * (tens of thousands of textures, some of them 4K) does not fit on a modest machine. With a
+ * Spill in ScanOptions, the scan still decodes each texture, to measure and check it, but then
+ * writes its source file to a temporary file and drops the pixels. Rendering decodes the
+ * textures again one at a time through Texture.LoadImage, trading a second decoding for memory
+ * bounded by one texture rather than the whole inventory.
+ */
+
+import (
+	"image"
+	"io"
+	"os"
+	"sync"
+)
+
+// Spill is a temporary file holding the source files of spilled textures. It is safe for
+// concurrent use, and must be closed to remove the file.
+type Spill struct {
+	mutex  sync.Mutex
+	file   *os.File
+	offset int64
+}
+
+// spilledImage locates the source file of a texture in a spill, with what decoding it needs.
+type spilledImage struct {
+	spill     *Spill
+	offset    int64
+	length    int
+	extension string
+	options   ScanOptions
+}
+
+// NewSpill creates a spill in directory, or in the default temporary directory when empty.
+func NewSpill(directory string) (*Spill, error) {
+	file, err := os.CreateTemp(directory, "crf2html-spill-")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Spill{file: file}, nil
+}
+
+// Close removes the spill. Textures spilled to it can no longer be loaded.
+func (spill *Spill) Close() error {
+	spill.file.Close()
+
+	return os.Remove(spill.file.Name())
+}
+
+func (spill *Spill) store(data []byte, extension string, options ScanOptions) (*spilledImage, error) {
+	spill.mutex.Lock()
+	defer spill.mutex.Unlock()
+
+	if _, err := spill.file.WriteAt(data, spill.offset); err != nil {
+		return nil, err
+	}
+
+	spilled := &spilledImage{spill: spill, offset: spill.offset, length: len(data), extension: extension, options: options}
+	spill.offset += int64(len(data))
+
+	return spilled, nil
+}
+
+func (spilled *spilledImage) load() (image.Image, error) {
+	data := make([]byte, spilled.length)
+
+	if _, err := spilled.spill.file.ReadAt(data, spilled.offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	decoder, _, ok := resolveDecoder(spilled.extension, data)
+
+	if !ok {
+		return nil, ErrUnknownFormat
+	}
+
+	img, _, err := decodeLimited(decoder, data, spilled.options)
+
+	return img, err
+}
@@ -0,0 +1,113 @@
+package gallery
+
+/**
+ * Channel statistics
+ *
+ * Per-channel histograms and min/max/mean values of a decoded texture, rendered as an expandable
+ * detail panel to help diagnose washed-out or clipped textures.
+ */
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+const histogramBins = 64
+
+type ChannelStats struct {
+	Min       uint8
+	Max       uint8
+	Mean      float64
+	Histogram [256]int
+}
+
+type ImageStats struct {
+	Pixels   int
+	Opaque   bool
+	Channels [4]ChannelStats // red, green, blue, alpha
+}
+
+var channelNames = [4]string{"red", "green", "blue", "alpha"}
+
+func ComputeStats(img image.Image) ImageStats {
+	bounds := img.Bounds()
+	stats := ImageStats{Pixels: bounds.Dx() * bounds.Dy(), Opaque: true}
+
+	var sums [4]float64
+
+	for i := range stats.Channels {
+		stats.Channels[i].Min = 255
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+
+			for i, value := range [4]uint8{c.R, c.G, c.B, c.A} {
+				channel := &stats.Channels[i]
+				channel.Histogram[value]++
+				channel.Min = min(channel.Min, value)
+				channel.Max = max(channel.Max, value)
+				sums[i] += float64(value)
+			}
+
+			if c.A != 255 {
+				stats.Opaque = false
+			}
+		}
+	}
+
+	for i := range stats.Channels {
+		if stats.Pixels > 0 {
+			stats.Channels[i].Mean = sums[i] / float64(stats.Pixels)
+		}
+	}
+
+	return stats
+}
+
+// HTML renders the statistics table and a histogram chart inside a collapsed details element.
+func (stats ImageStats) HTML() string {
+	channels := 3
+
+	if !stats.Opaque {
+		channels = 4
+	}
+
+	var rows, lines []string
+
+	peak := 1
+	var bins [4][histogramBins]int
+
+	for i := 0; i < channels; i++ {
+		for value, count := range stats.Channels[i].Histogram {
+			bins[i][value*histogramBins/256] += count
+		}
+
+		for _, count := range bins[i] {
+			peak = max(peak, count)
+		}
+	}
+
+	for i := 0; i < channels; i++ {
+		channel := stats.Channels[i]
+		rows = append(rows, fmt.Sprintf("<tr><th>%s</th><td>%d</td><td>%d</td><td>%.1f</td></tr>", channelNames[i], channel.Min, channel.Max, channel.Mean))
+
+		points := make([]string, histogramBins)
+
+		for bin, count := range bins[i] {
+			points[bin] = fmt.Sprintf("%d,%d", bin, 32-count*32/peak)
+		}
+
+		lines = append(lines, fmt.Sprintf("<polyline class='%s' points='%s'/>", channelNames[i], strings.Join(points, " ")))
+	}
+
+	return fmt.Sprintf(
+		"<details class='stats'><summary>stats</summary><svg viewBox='0 0 %d 32' preserveAspectRatio='none'>%s</svg><table><tr><th></th><th>min</th><th>max</th><th>mean</th></tr>%s</table></details>",
+		histogramBins-1,
+		strings.Join(lines, ""),
+		strings.Join(rows, ""),
+	)
+}
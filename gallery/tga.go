@@ -0,0 +1,86 @@
+package gallery
+
+/**
+ * TGA orientation
+ *
+ * The first pixel of a TGA file may be any corner of the image, as told by bits 4 (right) and 5
+ * (top) of its image descriptor byte. Tools disagree on this: some decoders ignore the bits and
+ * show bottom-left files, the default of most exporters, upside down. DecodeTGA leaves nothing
+ * to the decoder: it hands it the file marked as starting at the top-left corner, so the pixels
+ * come back in file order, and puts them in place itself from the original descriptor.
+ */
+
+import (
+	"bytes"
+	"image"
+	"io"
+
+	"github.com/ftrvxmtrx/tga"
+)
+
+const (
+	tgaDescriptorOffset = 17
+	tgaOriginRight      = 1 << 4
+	tgaOriginTop        = 1 << 5
+)
+
+// DecodeTGA decodes a TGA file, whatever corner its pixels start from.
+func DecodeTGA(reader io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) <= tgaDescriptorOffset {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	descriptor := data[tgaDescriptorOffset]
+	topLeft := append([]byte(nil), data...)
+	topLeft[tgaDescriptorOffset] = descriptor&^tgaOriginRight | tgaOriginTop
+
+	img, err := tga.Decode(bytes.NewReader(topLeft))
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch img := img.(type) {
+	case *image.NRGBA:
+		orientPixels(img.Pix, img.Stride, img.Rect, descriptor&tgaOriginRight != 0, descriptor&tgaOriginTop == 0)
+	case *image.RGBA:
+		orientPixels(img.Pix, img.Stride, img.Rect, descriptor&tgaOriginRight != 0, descriptor&tgaOriginTop == 0)
+	}
+
+	return img, nil
+}
+
+// orientPixels mirrors the 4-byte pixels of an image: horizontally for files starting from the
+// right, vertically for those starting from the bottom.
+func orientPixels(pix []byte, stride int, bounds image.Rectangle, horizontal bool, vertical bool) {
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if horizontal {
+		for y := 0; y < height; y++ {
+			row := pix[y*stride : y*stride+width*4]
+
+			for left, right := 0, width-1; left < right; left, right = left+1, right-1 {
+				for i := 0; i < 4; i++ {
+					row[left*4+i], row[right*4+i] = row[right*4+i], row[left*4+i]
+				}
+			}
+		}
+	}
+
+	if vertical {
+		swap := make([]byte, width*4)
+
+		for top, bottom := 0, height-1; top < bottom; top, bottom = top+1, bottom-1 {
+			topRow, bottomRow := pix[top*stride:top*stride+width*4], pix[bottom*stride:bottom*stride+width*4]
+			copy(swap, topRow)
+			copy(topRow, bottomRow)
+			copy(bottomRow, swap)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package gallery
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+)
+
+// tgaFile returns an uncompressed 24-bit 2x2 TGA file with the given descriptor, whose pixels are
+// red, green, blue and white in file order.
+func tgaFile(descriptor byte) []byte {
+	header := []byte{0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 2, 0, 24, descriptor}
+	pixels := []byte{0, 0, 255, 0, 255, 0, 255, 0, 0, 255, 255, 255}
+
+	return append(header, pixels...)
+}
+
+func TestDecodeTGAOrigins(t *testing.T) {
+	names := map[color.NRGBA]string{
+		{255, 0, 0, 255}:     "R",
+		{0, 255, 0, 255}:     "G",
+		{0, 0, 255, 255}:     "B",
+		{255, 255, 255, 255}: "W",
+	}
+
+	// Rows of the decoded image, top first, for each corner the file starts from.
+	for descriptor, expected := range map[byte]string{
+		0x20: "RG BW",
+		0x00: "BW RG",
+		0x30: "GR WB",
+		0x10: "WB GR",
+	} {
+		img, err := DecodeTGA(bytes.NewReader(tgaFile(descriptor)))
+
+		if err != nil {
+			t.Fatalf("descriptor %#x: %v", descriptor, err)
+		}
+
+		layout := ""
+
+		for y := 0; y < 2; y++ {
+			if y > 0 {
+				layout += " "
+			}
+
+			for x := 0; x < 2; x++ {
+				layout += names[color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)]
+			}
+		}
+
+		if layout != expected {
+			t.Errorf("descriptor %#x: rows %q, want %q", descriptor, layout, expected)
+		}
+	}
+
+	if _, err := DecodeTGA(bytes.NewReader([]byte("short"))); err == nil {
+		t.Error("truncated header accepted")
+	}
+}
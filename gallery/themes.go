@@ -0,0 +1,49 @@
+package gallery
+
+/**
+ * Page themes
+ *
+ * Themes are stylesheets layered over the default dark one, in the order given by
+ * RenderOptions.Themes. Both set the custom properties of the colors of the page, like any
+ * stylesheet restyling a gallery can: "light" those of the background and text, "colorblind" those
+ * of the badges and statuses, to the Okabe-Ito palette, which stays distinguishable with the
+ * common color vision deficiencies. "colorblind" also marks each badge with a symbol and warnings
+ * with stripes so that color is never the only cue.
+ */
+
+import "strings"
+
+// Themes maps theme names to the rules they add to the stylesheet.
+var Themes = map[string]string{
+	"dark": "",
+	"light": `body{--background:#f4f4f4;--text:#222;--muted:#556;--subtle:#444;--panel:#fff;--placeholder:#ddd;--border:#ccc}
+		h2{border-color:#99a}`,
+	"colorblind": `body{--warning:#d55e00;--caution:#e69f00;--normal:#56b4e9;--success:#009e73;--accent:#f0e442;--info:#56b4e9;--green:#009e73;--blue:#56b4e9}
+		.badge.warning{background-image:repeating-linear-gradient(45deg,transparent 0 3px,rgba(0,0,0,.25) 3px 6px);color:#fff}
+		.badge.warning::before{content:"\26a0  "}
+		.badge.unused::before{content:"\25cb  "}
+		.badge.normal::before{content:"\25c6  "}
+		.badge.new,.badge.approved,.badge.rejected{color:#fff}
+		.badge.new::before{content:"+ "}
+		.badge.changed::before{content:"\21bb  "}
+		.badge.renamed::before{content:"\2192  "}
+		.badge.approved::before{content:"\2713  "}
+		.badge.rejected::before{content:"\2717  "}`,
+}
+
+// themeStylesheet returns the rules of themes, in order. Unknown names are ignored.
+func themeStylesheet(themes []string) string {
+	var rules []string
+
+	for _, theme := range themes {
+		if css := Themes[theme]; css != "" {
+			rules = append(rules, css)
+		}
+	}
+
+	if len(rules) == 0 {
+		return ""
+	}
+
+	return "\n\t\t" + strings.Join(rules, "\n\t\t")
+}
@@ -0,0 +1,103 @@
+package gallery
+
+/**
+ * Run history
+ *
+ * Programs regenerating a gallery after each change to a texture pack can keep the totals of
+ * every run (RunTotals) and pass them back in RenderOptions.Trend: the page then opens with a
+ * small chart of the texture count and of the HD coverage, the share of textures at least
+ * HDSize pixels on their longest side, over time, showing how an HD project progresses.
+ */
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HDSize is the longest side, in pixels, from which a texture counts as high definition. The
+// original textures of the Dark Engine games are at most 256 pixels wide.
+const HDSize = 512
+
+// RunTotals sums up the inventory of one run.
+type RunTotals struct {
+	Generated time.Time `json:"generated"`
+	Textures  int       `json:"textures"`
+	Bytes     int64     `json:"bytes"`
+	// Coverage is the share of HD textures, from 0 to 1.
+	Coverage float64 `json:"coverage"`
+}
+
+// Totals sums up the inventory as of generated.
+func (inventory *Inventory) Totals(generated time.Time) RunTotals {
+	totals := RunTotals{Generated: generated}
+	hd := 0
+
+	for _, family := range inventory.Families {
+		for _, texture := range family.Textures {
+			totals.Textures++
+			totals.Bytes += texture.Size
+
+			if max(texture.Width, texture.Height) >= HDSize {
+				hd++
+			}
+		}
+	}
+
+	if totals.Textures > 0 {
+		totals.Coverage = float64(hd) / float64(totals.Textures)
+	}
+
+	return totals
+}
+
+// renderTrend renders the chart of history, oldest run first, or nothing for fewer than two runs.
+func renderTrend(history []RunTotals) string {
+	if len(history) < 2 {
+		return ""
+	}
+
+	peak := 1
+
+	for _, totals := range history {
+		peak = max(peak, totals.Textures)
+	}
+
+	var textures, coverage, marks []string
+	step := 200.0 / float64(len(history)-1)
+
+	for i, totals := range history {
+		x := float64(i) * step
+		textures = append(textures, fmt.Sprintf("%.1f,%.1f", x, 36-float64(totals.Textures)*36/float64(peak)))
+		coverage = append(coverage, fmt.Sprintf("%.1f,%.1f", x, 36-totals.Coverage*36))
+		marks = append(marks, fmt.Sprintf(
+			"<circle cx='%.1f' cy='%.1f' r='1.5'><title>%s: %d textures, %.0f%% HD, %s</title></circle>",
+			x, 36-totals.Coverage*36, totals.Generated.Format("2006-01-02 15:04"), totals.Textures, totals.Coverage*100, formatSize(totals.Bytes),
+		))
+	}
+
+	first, last := history[0], history[len(history)-1]
+	legend := fmt.Sprintf(
+		"<span class='textures'>%d textures</span> (%+d) · <span class='coverage'>%.0f%% HD</span> (%+.0f points) · %s, over %d runs since %s",
+		last.Textures, last.Textures-first.Textures, last.Coverage*100, (last.Coverage-first.Coverage)*100, formatSize(last.Bytes), len(history), first.Generated.Format("2006-01-02"),
+	)
+
+	return fmt.Sprintf(
+		"<figure class='trend'><svg viewBox='-2 -2 204 40'><polyline class='textures' points='%s'/><polyline class='coverage' points='%s'/>%s</svg><figcaption>%s</figcaption></figure>",
+		strings.Join(textures, " "),
+		strings.Join(coverage, " "),
+		strings.Join(marks, ""),
+		legend,
+	)
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d bytes", size)
+	}
+}
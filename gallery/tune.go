@@ -0,0 +1,80 @@
+package gallery
+
+/**
+ * Tuning
+ *
+ * The scan and the rendering run on several goroutines. Tune picks their number and how work is
+ * handed out from the number of CPUs Go may use (GOMAXPROCS, which follows container CPU limits
+ * the way the runtime sees them) and the size of the pack, so that default runs keep a laptop
+ * and a 32-core build server equally busy. Workers take chunks of consecutive textures of a
+ * family rather than one texture at a time: their images and encoded thumbnails stay on the
+ * memory of one CPU, and fewer handoffs cost less with thousands of small textures. Packs of
+ * large textures get single-texture chunks, balancing the work, and fewer workers on machines
+ * with many CPUs, bounding the images decoded at once.
+ */
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// largeTextureBytes is the mean file size from which textures count as large.
+const largeTextureBytes = 4 << 20
+
+// maxChunkSize caps the textures handed out at once, so no worker is left with a long tail.
+const maxChunkSize = 16
+
+// Tuning is the parallelism of a run.
+type Tuning struct {
+	// CPUs is the number of CPUs Go may use, and Jobs the worker count asked for, zero for
+	// automatic.
+	CPUs int
+	Jobs int
+
+	// Textures and Bytes measure the pack.
+	Textures int
+	Bytes    int64
+
+	// Workers is the number of textures decoded or rendered at once, and ChunkSize the number
+	// of consecutive textures of a family given to a worker at a time.
+	Workers   int
+	ChunkSize int
+}
+
+// Tune returns the parallelism of a run rendering textures files of bytes in total, with jobs
+// workers or, for zero, a number depending on the CPUs.
+func Tune(jobs int, textures int, bytes int64) Tuning {
+	tuning := Tuning{CPUs: runtime.GOMAXPROCS(0), Jobs: jobs, Textures: textures, Bytes: bytes}
+	large := textures > 0 && bytes/int64(textures) >= largeTextureBytes
+	tuning.Workers = jobs
+
+	if jobs == 0 {
+		tuning.Workers = tuning.CPUs
+
+		if large && tuning.CPUs > 8 {
+			tuning.Workers = max(8, tuning.CPUs/2)
+		}
+	}
+
+	tuning.Workers = max(1, min(tuning.Workers, textures))
+
+	// Each worker gets about eight chunks, enough to even out textures of different sizes.
+	tuning.ChunkSize = max(1, min(maxChunkSize, textures/(tuning.Workers*8)))
+
+	if large {
+		tuning.ChunkSize = 1
+	}
+
+	return tuning
+}
+
+// String describes the tuning for the -tune report.
+func (tuning Tuning) String() string {
+	jobs := "auto"
+
+	if tuning.Jobs != 0 {
+		jobs = fmt.Sprint(tuning.Jobs)
+	}
+
+	return fmt.Sprintf("cpus %d, jobs %s, %d textures, %d bytes: %d workers, chunks of %d textures", tuning.CPUs, jobs, tuning.Textures, tuning.Bytes, tuning.Workers, tuning.ChunkSize)
+}
@@ -0,0 +1,67 @@
+package gallery
+
+/**
+ * 16-bit texture directories
+ *
+ * Thief 2 keeps the 8-bit textures of objects and meshes in a txt directory, and their 16-bit
+ * replacements in a txt16 directory next to it, which the engine prefers when it finds one of
+ * the same name. Listing both as unrelated textures of two families hides that they are the same
+ * texture, so the scan pairs them: the 16-bit texture stays in the txt16 family, carrying the
+ * 8-bit one it replaces as EightBit, which the page shows on demand for comparison, and the txt
+ * family keeps the textures without a replacement.
+ */
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// txt16Key returns the key pairing a texture of a txt or txt16 directory with its counterpart in
+// the other one: the directory holding both, and the texture name.
+func txt16Key(texture Texture) string {
+	return strings.ToLower(path.Dir(path.Dir(filepath.ToSlash(texture.Path))) + "/" + texture.Name)
+}
+
+// pairTxt16 attaches the textures of txt directories to their replacements of the txt16 directory
+// next to them, removing them from their family, and drops the txt families left empty.
+func (inventory *Inventory) pairTxt16() {
+	replacements := make(map[string]*Texture)
+
+	for i := range inventory.Families {
+		if family := &inventory.Families[i]; family.Name == "txt16" {
+			for j := range family.Textures {
+				replacements[txt16Key(family.Textures[j])] = &family.Textures[j]
+			}
+		}
+	}
+
+	if len(replacements) == 0 {
+		return
+	}
+
+	var families []Family
+
+	for _, family := range inventory.Families {
+		if family.Name == "txt" {
+			var textures []Texture
+
+			for _, texture := range family.Textures {
+				if replacement := replacements[txt16Key(texture)]; replacement != nil && replacement.EightBit == nil {
+					eightBit := texture
+					replacement.EightBit = &eightBit
+				} else {
+					textures = append(textures, texture)
+				}
+			}
+
+			if family.Textures = textures; len(textures) == 0 {
+				continue
+			}
+		}
+
+		families = append(families, family)
+	}
+
+	inventory.Families = families
+}
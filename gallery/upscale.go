@@ -0,0 +1,152 @@
+package gallery
+
+/**
+ * Upscaled previews
+ *
+ * HD remaster projects start from the original textures scaled up, by plain interpolation or by
+ * a neural upscaler such as ESRGAN, then retouched. With RenderOptions.Upscaler set, every tile
+ * gets a preview of its texture scaled UpscaleFactor times, which the lightbox shows instead of
+ * the thumbnail, so candidates can be judged in the gallery. Nearest and bicubic interpolation
+ * are built in; CommandUpscaler runs any external program reading and writing PNG files, like
+ * the commands of ScanOptions.Exec.
+ */
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// UpscaleFactor is the scale of the upscaled previews.
+const UpscaleFactor = 4
+
+// Upscaler scales an image up.
+type Upscaler interface {
+	// Name names the upscaler in the page.
+	Name() string
+	// Upscale returns img scaled factor times.
+	Upscale(img image.Image, factor int) (image.Image, error)
+}
+
+// InterpolationUpscaler scales images up with an interpolation function of resize.
+type InterpolationUpscaler struct {
+	Label         string
+	Interpolation resize.InterpolationFunction
+}
+
+// Upscalers are the built-in upscalers, by name.
+var Upscalers = map[string]Upscaler{
+	"nearest": InterpolationUpscaler{"nearest", resize.NearestNeighbor},
+	"bicubic": InterpolationUpscaler{"bicubic", resize.Bicubic},
+}
+
+func (upscaler InterpolationUpscaler) Name() string {
+	return upscaler.Label
+}
+
+func (upscaler InterpolationUpscaler) Upscale(img image.Image, factor int) (image.Image, error) {
+	bounds := img.Bounds()
+
+	return resize.Resize(uint(bounds.Dx()*factor), uint(bounds.Dy()*factor), img, upscaler.Interpolation), nil
+}
+
+// CommandUpscaler runs a shell command upscaling a PNG file, such as
+// "realesrgan-ncnn-vulkan -i {in} -o {out} -s {factor}". {in} is replaced with the path of the
+// image to upscale, {out} with the path the command writes its result to, both quoted, and
+// {factor} with the scale.
+type CommandUpscaler struct {
+	Command string
+}
+
+func (upscaler CommandUpscaler) Name() string {
+	return strings.Fields(upscaler.Command)[0]
+}
+
+func (upscaler CommandUpscaler) Upscale(img image.Image, factor int) (image.Image, error) {
+	directoryPath, err := os.MkdirTemp("", "crf2html-upscale-")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(directoryPath)
+
+	inputPath, outputPath := filepath.Join(directoryPath, "in.png"), filepath.Join(directoryPath, "out.png")
+	input, err := os.Create(inputPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = png.Encode(input, img)
+
+	if closeErr := input.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	commandLine := strings.NewReplacer(
+		"{in}", quotePath(inputPath),
+		"{out}", quotePath(outputPath),
+		"{factor}", fmt.Sprint(factor),
+	).Replace(upscaler.Command)
+
+	if output, err := ShellCommand(commandLine).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("upscale: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	output, err := os.Open(outputPath)
+
+	if err != nil {
+		return nil, errors.New("upscale: the command wrote no {out} file")
+	}
+
+	defer output.Close()
+
+	upscaled, err := png.Decode(output)
+
+	if err != nil {
+		return nil, fmt.Errorf("upscale: %v", err)
+	}
+
+	return upscaled, nil
+}
+
+// renderUpscale upscales img, the image of texture, and returns the URL of the preview: an asset
+// if options.Asset is set and the preview not under options.InlineBelow bytes, a data URI
+// otherwise. Previews are JPEGs over the background, like the thumbnails.
+func renderUpscale(texture Texture, img image.Image, jpegOptions JPEGOptions, options RenderOptions) (string, error) {
+	upscaled, err := options.Upscaler.Upscale(img, UpscaleFactor)
+
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", texture.Key(), err)
+	}
+
+	flattened := image.NewRGBA(upscaled.Bounds())
+	draw.Draw(flattened, flattened.Bounds(), &image.Uniform{options.Background}, image.Point{}, draw.Src)
+	draw.Draw(flattened, flattened.Bounds(), upscaled, upscaled.Bounds().Min, draw.Over)
+
+	buffer := new(bytes.Buffer)
+
+	if err := EncodeJPEG(buffer, flattened, jpegOptions); err != nil {
+		return "", err
+	}
+
+	if options.Asset != nil && buffer.Len() >= options.InlineBelow {
+		return options.Asset(texture.Family, fmt.Sprintf("%s.%dx.jpg", texture.File, UpscaleFactor), buffer.Bytes())
+	}
+
+	return "data:image/jpg;base64," + base64.StdEncoding.EncodeToString(buffer.Bytes()), nil
+}
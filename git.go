@@ -0,0 +1,86 @@
+package main
+
+/**
+ * Git sources
+ *
+ * With `-git-ref`, a source directory in a git work tree is read as of a commit, tag or branch
+ * rather than as checked out: `git archive` writes the files of that revision to a temporary ZIP
+ * file, scanned like a CRF, so the gallery of a release tag needs no checkout and leaves the
+ * work tree alone. A source in a subdirectory of the repository is limited to that directory.
+ *
+ * `crf2html git-diff repo_dir base_ref head_ref output_path [options]` builds on it for the
+ * review of texture changes: the page of head_ref only shows the textures added or modified
+ * since base_ref, the modified ones under a slider uncovering their version of base_ref.
+ */
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ArchiveGitRef writes the files of the work tree directory directoryPath as of ref to a
+// temporary ZIP file, and returns its path, which the caller removes once done.
+func ArchiveGitRef(directoryPath string, ref string) (string, error) {
+	if fileInfo, err := os.Stat(directoryPath); err != nil || !fileInfo.IsDir() {
+		return "", errors.New("-git-ref requires a directory in a git work tree")
+	}
+
+	prefix, err := exec.Command("git", "-C", directoryPath, "rev-parse", "--show-prefix").Output()
+
+	if err != nil {
+		return "", fmt.Errorf("%s is not in a git work tree: %v", directoryPath, err)
+	}
+
+	treeish := ref
+
+	if directory := strings.TrimSpace(string(prefix)); directory != "" {
+		treeish = ref + ":" + directory
+	}
+
+	archive, err := os.CreateTemp("", "crf2html-git-*.zip")
+
+	if err != nil {
+		return "", err
+	}
+
+	archive.Close()
+
+	if output, err := exec.Command("git", "-C", directoryPath, "archive", "--format=zip", "-o", archive.Name(), treeish).CombinedOutput(); err != nil {
+		os.Remove(archive.Name())
+
+		return "", fmt.Errorf("git archive %s: %v: %s", treeish, err, strings.TrimSpace(string(output)))
+	}
+
+	return archive.Name(), nil
+}
+
+// RunGitDiff renders the textures of the work tree args[0] changed from the ref args[1] to the ref
+// args[2] to args[3], the other arguments being options parsed like the ones following
+// output_path.
+func RunGitDiff(args []string) error {
+	if len(args) < 4 {
+		return errors.New("Usage: program git-diff repo_dir base_ref head_ref output_path [options]")
+	}
+
+	settings, err := ParseArguments(append([]string{args[0], args[3]}, args[4:]...))
+
+	if err != nil {
+		return err
+	}
+
+	if settings.Page.Format != "html" || settings.Page.OnlyFamily != "" {
+		return errors.New("git-diff writes an HTML page of all families")
+	}
+
+	settings.Source.BaseRef, settings.Source.GitRef = args[1], args[2]
+	settings.Page.ChangedOnly = true
+
+	if settings.Page.Title == "Textures" {
+		settings.Page.Title = fmt.Sprintf("Textures changed from %s to %s", args[1], args[2])
+	}
+
+	return Generate(settings)
+}
@@ -7,3 +7,7 @@ require github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 require github.com/samuel/go-pcx v0.0.0-20210515040514-6a5ce4d132f7
 
 require github.com/ftrvxmtrx/tga v0.0.0-20150524081124-bd8e8d5be13a
+
+require github.com/mattn/go-sqlite3 v1.14.22
+
+require golang.org/x/text v0.21.0
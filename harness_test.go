@@ -0,0 +1,290 @@
+package main
+
+/**
+ * Test harness
+ *
+ * Builds fixture texture sets on the fly (as directories or CRF/ZIP archives), runs the full
+ * pipeline on them and compares the normalized output with golden files stored under
+ * `testdata/golden`. Run `go test -update` to rewrite the golden files after an intended change.
+ */
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ftrvxmtrx/tga"
+	"github.com/samuel/go-pcx/pcx"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// Fixture maps slash-separated paths ("family/name.ext") to file contents.
+type Fixture map[string][]byte
+
+// paths returns the fixture paths in a stable order, so archives are built deterministically.
+func (fixture Fixture) paths() []string {
+	var paths []string
+
+	for path := range fixture {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
+
+// WriteDirectory writes the fixture below a fresh temporary directory and returns its path.
+func (fixture Fixture) WriteDirectory(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+
+	for _, path := range fixture.paths() {
+		filePath := filepath.Join(root, filepath.FromSlash(path))
+
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filePath, fixture[path], 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+// WriteArchive writes the fixture as a CRF (a plain ZIP file) and returns its path.
+func (fixture Fixture) WriteArchive(t *testing.T, name string) string {
+	t.Helper()
+
+	buffer := new(bytes.Buffer)
+	writer := zip.NewWriter(buffer)
+
+	for _, path := range fixture.paths() {
+		entry, err := writer.Create(path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := entry.Write(fixture[path]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(t.TempDir(), name)
+
+	if err := os.WriteFile(archivePath, buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return archivePath
+}
+
+// fixturePalette is a small palette shared by the indexed fixture images.
+var fixturePalette = color.Palette{
+	color.RGBA{0, 0, 0, 255},
+	color.RGBA{160, 64, 32, 255},
+	color.RGBA{200, 200, 200, 255},
+	color.RGBA{64, 96, 160, 255},
+}
+
+// fixtureRGBA returns a width x height image filled with a diagonal gradient derived from seed.
+func fixtureRGBA(width int, height int, seed uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x*255/width) ^ seed, uint8(y*255/height) + seed, seed, 255})
+		}
+	}
+
+	return img
+}
+
+// fixtureNormal returns a flat, blue-dominant image that the pipeline detects as a normal map.
+func fixtureNormal(width int, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(120 + x%16), uint8(120 + y%16), 250, 255})
+		}
+	}
+
+	return img
+}
+
+// fixturePaletted returns a checkerboard of the fixture palette.
+func fixturePaletted(width int, height int) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, width, height), fixturePalette)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetColorIndex(x, y, uint8((x/4+y/4)%len(fixturePalette)))
+		}
+	}
+
+	return img
+}
+
+// EncodeFixture encodes img in the format matching extension.
+func EncodeFixture(t *testing.T, extension string, img image.Image) []byte {
+	t.Helper()
+
+	buffer := new(bytes.Buffer)
+	var err error
+
+	switch extension {
+	case ".png":
+		err = png.Encode(buffer, img)
+	case ".gif":
+		err = gif.Encode(buffer, img, nil)
+	case ".jpg":
+		err = jpeg.Encode(buffer, img, &jpeg.Options{Quality: 90})
+	case ".pcx":
+		err = pcx.Encode(buffer, img)
+	case ".tga":
+		err = tga.Encode(buffer, img)
+	default:
+		t.Fatalf("no fixture encoder for %s", extension)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+var (
+	dataURIPattern = regexp.MustCompile(`data:([a-z/]+);base64,([A-Za-z0-9+/=]+)`)
+	hashPattern    = regexp.MustCompile(`"sha256":"[0-9a-f]{64}"`)
+	scriptPattern  = regexp.MustCompile(`(?s)<script>.*?</script>`)
+)
+
+// NormalizeOutput makes generated pages comparable: embedded images are replaced by their
+// content type and decoded dimensions, the texture hashes of the structured data (fixture bytes
+// depend on the encoders of the Go release) by "[hash]", and the inlined gallery script by a
+// placeholder.
+func NormalizeOutput(output string) string {
+	output = dataURIPattern.ReplaceAllStringFunc(output, func(uri string) string {
+		match := dataURIPattern.FindStringSubmatch(uri)
+		data, err := base64.StdEncoding.DecodeString(match[2])
+
+		if err != nil {
+			return fmt.Sprintf("data:%s;base64,[invalid]", match[1])
+		}
+
+		decodeConfig := jpeg.DecodeConfig
+
+		if match[1] == "image/png" {
+			decodeConfig = png.DecodeConfig
+		}
+
+		config, err := decodeConfig(bytes.NewReader(data))
+
+		if err != nil {
+			return fmt.Sprintf("data:%s;base64,[undecodable]", match[1])
+		}
+
+		return fmt.Sprintf("data:%s;base64,[%dx%d]", match[1], config.Width, config.Height)
+	})
+
+	output = hashPattern.ReplaceAllString(output, `"sha256":"[hash]"`)
+	output = scriptPattern.ReplaceAllString(output, "<script>[gallery.js]</script>")
+
+	// Split the page between elements so golden diffs point at a single tile.
+	output = strings.ReplaceAll(output, "><", ">\n<")
+
+	return output
+}
+
+// CompareGolden compares output with testdata/golden/<name>, or rewrites it under -update.
+func CompareGolden(t *testing.T, name string, output string) {
+	t.Helper()
+
+	goldenPath := filepath.Join("testdata", "golden", name)
+
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(goldenPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(goldenPath, []byte(output), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+
+	expected, err := os.ReadFile(goldenPath)
+
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+
+	expectedLines := strings.Split(string(expected), "\n")
+	actualLines := strings.Split(output, "\n")
+
+	for i := 0; i < len(expectedLines) || i < len(actualLines); i++ {
+		var expectedLine, actualLine string
+
+		if i < len(expectedLines) {
+			expectedLine = expectedLines[i]
+		}
+
+		if i < len(actualLines) {
+			actualLine = actualLines[i]
+		}
+
+		if expectedLine != actualLine {
+			t.Fatalf("%s differs at line %d:\n  want: %q\n  got:  %q", goldenPath, i+1, expectedLine, actualLine)
+		}
+	}
+}
+
+// RunPipeline parses args (with the output path appended after source) and generates the page,
+// returning its normalized contents.
+func RunPipeline(t *testing.T, source string, options ...string) string {
+	t.Helper()
+
+	outputPath := filepath.Join(t.TempDir(), "index.html")
+	settings, err := ParseArguments(append([]string{source, outputPath}, options...))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Generate(settings); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := os.ReadFile(outputPath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NormalizeOutput(string(output))
+}
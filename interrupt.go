@@ -0,0 +1,36 @@
+package main
+
+/**
+ * Interruption
+ *
+ * Killing a run in the middle leaves its temporary files behind (spill, downloaded source) and
+ * possibly a page half written. The first Ctrl-C therefore only asks the run to stop after the
+ * file or texture in progress, and lets -on-interrupt decide what it writes; a second one quits
+ * at once, for runs stuck on a huge texture.
+ */
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+)
+
+// InterruptModes lists the values of -on-interrupt.
+var InterruptModes = []string{"abort", "partial"}
+
+// NotifyInterrupt returns a channel closed by the first interrupt signal. Later ones are left
+// to their default handling, which ends the process.
+func NotifyInterrupt() <-chan struct{} {
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		fmt.Fprintln(os.Stderr, "interrupt: stopping after the file or texture in progress, press Ctrl-C again to quit at once")
+		close(stop)
+	}()
+
+	return stop
+}
@@ -0,0 +1,37 @@
+package main
+
+/**
+ * List mode
+ *
+ * With -list, the run stops after the scan and prints the textures of the source, one a line,
+ * with their format, dimensions and size, instead of writing the page. The scan reads their
+ * dimensions from the headers of the files without decoding the pixels, so listing a huge
+ * archive takes about the time of reading it.
+ */
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"crf2html/gallery"
+)
+
+// ListTextures writes the textures of inventory to writer as aligned columns.
+func ListTextures(writer io.Writer, inventory *gallery.Inventory) error {
+	table := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+
+	for _, family := range inventory.Families {
+		for _, texture := range family.Textures {
+			dimensions := fmt.Sprintf("%dx%d", texture.Width, texture.Height)
+
+			if texture.Error != "" {
+				dimensions = "broken: " + texture.Error
+			}
+
+			fmt.Fprintf(table, "%s/%s\t%s\t%d\t%s\n", texture.Family, texture.File, texture.Format, texture.Size, dimensions)
+		}
+	}
+
+	return table.Flush()
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+// LongPath returns path unchanged: only Windows limits the length of paths.
+func LongPath(path string) string {
+	return path
+}
@@ -0,0 +1,36 @@
+package main
+
+/**
+ * Long paths on Windows
+ *
+ * Windows APIs refuse paths longer than MAX_PATH (260 characters) unless they are absolute and
+ * given in extended form, prefixed with \\?\. Deep asset trees of a texture pack easily go past
+ * it, so the paths written to are converted to that form when they need it.
+ */
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxPath leaves room for the 8.3 name Windows may append to a directory path.
+const maxPath = 248
+
+// LongPath returns path in extended form when it is too long for MAX_PATH, and unchanged otherwise.
+func LongPath(path string) string {
+	if path == "" || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+
+	absolutePath, err := filepath.Abs(path)
+
+	if err != nil || len(absolutePath) < maxPath {
+		return path
+	}
+
+	if strings.HasPrefix(absolutePath, `\\`) {
+		return `\\?\UNC\` + absolutePath[2:]
+	}
+
+	return `\\?\` + absolutePath
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLongPath(t *testing.T) {
+	if LongPath(`C:\out\index.html`) != `C:\out\index.html` {
+		t.Error("short path changed")
+	}
+
+	if LongPath(`\\server\share\`+strings.Repeat("a", 300)) != `\\?\UNC\server\share\`+strings.Repeat("a", 300) {
+		t.Error("long UNC path not extended")
+	}
+
+	// Files can be written deeper than MAX_PATH.
+	directoryPath := filepath.Join(t.TempDir(), strings.Repeat("d", 100), strings.Repeat("e", 100), strings.Repeat("f", 100))
+
+	if err := os.MkdirAll(LongPath(directoryPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(LongPath(filepath.Join(directoryPath, "index.html")), []byte("page"), 0644); err != nil {
+		t.Error(err)
+	}
+}
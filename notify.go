@@ -0,0 +1,178 @@
+package main
+
+/**
+ * Webhook notifications
+ *
+ * Posts a summary of a finished generation to a Discord or Slack incoming webhook. Discord
+ * messages carry a collage of the first thumbnails as an attachment; Slack webhooks only accept
+ * text, so they get the summary alone.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"crf2html/gallery"
+)
+
+const notifyCollageSize = 36
+
+// GenerationSummary describes a finished run for notifications.
+type GenerationSummary struct {
+	Title      string
+	Families   int
+	Textures   int
+	OutputSize int64
+	Link       string
+	Thumbnails []image.Image
+	CellSize   int
+}
+
+// Text renders the summary as a short chat message.
+func (summary GenerationSummary) Text() string {
+	text := fmt.Sprintf("**%s** regenerated: %d textures in %d families, %s", summary.Title, summary.Textures, summary.Families, formatSize(summary.OutputSize))
+
+	if summary.Link != "" {
+		text += "\n" + summary.Link
+	}
+
+	return text
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d bytes", size)
+	}
+}
+
+// NotifyWebhook posts summary to webhookURL, using the Slack payload for slack.com URLs, such as
+// hooks.slack.com ones, and the Discord one otherwise.
+func NotifyWebhook(webhookURL string, summary GenerationSummary) error {
+	parsedURL, err := url.Parse(webhookURL)
+
+	if err != nil {
+		return err
+	}
+
+	var request *http.Request
+
+	if slackHost(parsedURL.Hostname()) {
+		request, err = slackRequest(webhookURL, summary)
+	} else {
+		request, err = discordRequest(webhookURL, summary)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook: %s", response.Status)
+	}
+
+	return nil
+}
+
+// slackHost reports whether hostname is slack.com or one of its subdomains, and not merely a
+// name ending with it, such as notslack.com.
+func slackHost(hostname string) bool {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+
+	return hostname == "slack.com" || strings.HasSuffix(hostname, ".slack.com")
+}
+
+func slackRequest(webhookURL string, summary GenerationSummary) (*http.Request, error) {
+	// Slack uses single asterisks for bold.
+	text := strings.ReplaceAll(summary.Text(), "**", "*")
+	body, err := json.Marshal(map[string]string{"text": text})
+
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	return request, nil
+}
+
+func discordRequest(webhookURL string, summary GenerationSummary) (*http.Request, error) {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+
+	payload := map[string]interface{}{"content": summary.Text()}
+	thumbnails := summary.Thumbnails
+
+	if len(thumbnails) > notifyCollageSize {
+		thumbnails = thumbnails[:notifyCollageSize]
+	}
+
+	if len(thumbnails) > 0 {
+		payload["embeds"] = []map[string]interface{}{{"image": map[string]string{"url": "attachment://preview.png"}}}
+	}
+
+	payloadJSON, err := json.Marshal(payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writer.WriteField("payload_json", string(payloadJSON)); err != nil {
+		return nil, err
+	}
+
+	if len(thumbnails) > 0 {
+		collage := gallery.BuildMosaic(thumbnails, summary.CellSize, 4, color.RGBA{51, 51, 51, 255})
+		part, err := writer.CreateFormFile("files[0]", "preview.png")
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := png.Encode(part, collage); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, webhookURL, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+
+	return request, nil
+}
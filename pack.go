@@ -0,0 +1,245 @@
+package main
+
+/**
+ * Pack
+ *
+ * `crf2html pack source_dir output.crf` builds a CRF out of a directory holding one directory
+ * per family, after checking its files like a scan would (see gallery.CheckPack). Errors leave
+ * the output unwritten; warnings, such as non power-of-two textures, are printed and the pack
+ * written anyway. System files left by file managers are not packed. Names longer than 8.3 can be
+ * shortened on the way with -short-names, and the renames written with -rename-map.
+ */
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"crf2html/gallery"
+)
+
+// ReadPackDirectory returns the files of directoryPath as pack entries, named by their
+// slash-separated path in the directory.
+func ReadPackDirectory(directoryPath string) ([]gallery.PackEntry, error) {
+	var entries []gallery.PackEntry
+
+	err := filepath.WalkDir(directoryPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if gallery.SystemFile(entry.Name()) && filePath != directoryPath {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		data, err := os.ReadFile(filePath)
+
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(directoryPath, filePath)
+
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, gallery.PackEntry{Name: filepath.ToSlash(name), Data: data})
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// ShortNameOptions are the options of pack and repack about names longer than 8.3.
+type ShortNameOptions struct {
+	// RenameMap, when set, is the file receiving the suggested 8.3 names: a shell script for
+	// ".sh" files, a JSON object mapping the old names to the new ones otherwise.
+	RenameMap string
+	// ShortNames applies the suggested names to the pack.
+	ShortNames bool
+}
+
+// parseOption reads the short name option at args[i], and returns the index of its last
+// argument, or -1 when args[i] is no such option.
+func (options *ShortNameOptions) parseOption(args []string, i int) (int, error) {
+	switch args[i] {
+	case "-short-names":
+		options.ShortNames = true
+
+		return i, nil
+	case "-rename-map":
+		if i+1 >= len(args) {
+			return i, fmt.Errorf("Missing value for %s", args[i])
+		}
+
+		options.RenameMap = args[i+1]
+
+		return i + 1, nil
+	}
+
+	return -1, nil
+}
+
+// apply writes the renames suggested for entries to the rename map, and returns entries under
+// their new names with ShortNames, unchanged otherwise.
+func (options ShortNameOptions) apply(entries []gallery.PackEntry) ([]gallery.PackEntry, error) {
+	if options.RenameMap == "" && !options.ShortNames {
+		return entries, nil
+	}
+
+	var names []string
+
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+
+	renames := gallery.ShortNames(names)
+
+	if options.RenameMap != "" {
+		buffer := new(bytes.Buffer)
+
+		if filepath.Ext(options.RenameMap) == ".sh" {
+			if err := gallery.WriteRenameScript(buffer, renames); err != nil {
+				return nil, err
+			}
+		} else {
+			renameMap := make(map[string]string)
+
+			for _, rename := range renames {
+				renameMap[rename.From] = rename.To
+			}
+
+			data, err := json.MarshalIndent(renameMap, "", "  ")
+
+			if err != nil {
+				return nil, err
+			}
+
+			buffer.Write(append(data, '\n'))
+		}
+
+		if err := os.WriteFile(options.RenameMap, buffer.Bytes(), 0644); err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(os.Stderr, "%d renames to 8.3 names written to %s\n", len(renames), options.RenameMap)
+	}
+
+	if !options.ShortNames {
+		return entries, nil
+	}
+
+	newNames := make(map[string]string)
+
+	for _, rename := range renames {
+		newNames[rename.From] = rename.To
+	}
+
+	renamed := make([]gallery.PackEntry, len(entries))
+
+	for i, entry := range entries {
+		renamed[i] = entry
+
+		if newName, ok := newNames[entry.Name]; ok {
+			renamed[i].Name = newName
+		}
+	}
+
+	return renamed, nil
+}
+
+// RunPack packs the directory args[0] into the CRF args[1], with the options following them.
+func RunPack(args []string) error {
+	if len(args) < 2 {
+		return errors.New("Usage: program pack source_dir output_path [-short-names] [-rename-map path]")
+	}
+
+	var options ShortNameOptions
+
+	for i := 2; i < len(args); i++ {
+		last, err := options.parseOption(args, i)
+
+		if err != nil {
+			return err
+		}
+
+		if last < 0 {
+			return fmt.Errorf("Unknown option: %s", args[i])
+		}
+
+		i = last
+	}
+
+	entries, err := ReadPackDirectory(args[0])
+
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		return fmt.Errorf("no files in %s", args[0])
+	}
+
+	if entries, err = options.apply(entries); err != nil {
+		return err
+	}
+
+	size, err := writeCheckedPack(entries, args[0], args[1], flate.DefaultCompression)
+
+	if err != nil {
+		return err
+	}
+
+	families := make(map[string]bool)
+
+	for _, entry := range entries {
+		families[strings.ToLower(strings.Split(entry.Name, "/")[0])] = true
+	}
+
+	fmt.Fprintf(os.Stderr, "packed %d files of %d families into %s (%d bytes)\n", len(entries), len(families), args[1], size)
+
+	return nil
+}
+
+// writeCheckedPack checks entries, read from sourcePath, printing their issues, and writes them
+// to the CRF outputPath at the compression level when none is fatal. It returns the size of the
+// CRF.
+func writeCheckedPack(entries []gallery.PackEntry, sourcePath string, outputPath string, level int) (int, error) {
+	fatal := 0
+
+	for _, issue := range gallery.CheckPack(entries) {
+		fmt.Fprintln(os.Stderr, issue)
+
+		if issue.Fatal {
+			fatal++
+		}
+	}
+
+	if fatal > 0 {
+		return 0, fmt.Errorf("%d errors in %s, %s not written", fatal, sourcePath, outputPath)
+	}
+
+	buffer := new(bytes.Buffer)
+
+	if err := gallery.WritePack(buffer, entries, level); err != nil {
+		return 0, err
+	}
+
+	return buffer.Len(), os.WriteFile(outputPath, buffer.Bytes(), 0644)
+}
@@ -0,0 +1,43 @@
+package main
+
+/**
+ * Profiles
+ *
+ * `-profile name` applies a preset of options for a given use, before the other options, which
+ * can override them. "datasaver" makes galleries light enough for slow connections: small
+ * thumbnails at a lower quality, written as files with a WebP variant rather than inlined, no
+ * upscaled previews, and a page per hundred textures. Without cwebp, the thumbnails are only JPEGs.
+ * "archive" preserves a texture set in the directory of the page: lossless thumbnails, none
+ * inlined, and the original files of each family in an assets directory, every detail of the
+ * textures in their captions, and the inventory as a JSON manifest.
+ */
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Profiles are the presets of -profile, by name. They are applied with the output path set.
+var Profiles = map[string]func(settings *Settings){
+	"datasaver": func(settings *Settings) {
+		settings.Thumbnails.Size = min(settings.Thumbnails.Size, 96)
+		settings.Thumbnails.JPEGQuality = 70
+		settings.Thumbnails.WebPQuality = 70
+		settings.Thumbnails.Upscale = ""
+		settings.Thumbnails.UpscaleCommand = ""
+		// WebP variants are only added to thumbnails written as assets.
+		settings.Page.AssetsPath = filepath.Join(filepath.Dir(settings.Page.OutputPath), "assets")
+		settings.Page.InlineBelow = 0
+		settings.Page.PerPage = 100
+	},
+	"archive": func(settings *Settings) {
+		outputPath := settings.Page.OutputPath
+
+		settings.Thumbnails.Lossless = true
+		settings.Page.Caption = "{name} · {width}x{height} · {format} · {palette} · {size} · {sha256}"
+		settings.Page.AssetsPath = filepath.Join(filepath.Dir(outputPath), "assets")
+		settings.Page.InlineBelow = 0
+		settings.Page.ManifestPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".json"
+		settings.Page.PerPage = 0
+	},
+}
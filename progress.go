@@ -0,0 +1,35 @@
+package main
+
+/**
+ * Progress events
+ *
+ * With -progress json, the scan and rendering steps are written to stderr as they happen, one
+ * JSON object per line (NDJSON), for GUI wrappers and CI logs to follow long runs. The other
+ * messages of the run still go to stderr as plain text, so readers skip lines not starting
+ * with "{".
+ */
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"crf2html/gallery"
+)
+
+// ProgressFormats lists the values of -progress.
+var ProgressFormats = []string{"", "json"}
+
+// JSONProgress returns a progress callback writing each event to writer as a line of JSON. It is
+// safe for concurrent use.
+func JSONProgress(writer io.Writer) func(event gallery.ProgressEvent) {
+	var mutex sync.Mutex
+	encoder := json.NewEncoder(writer)
+
+	return func(event gallery.ProgressEvent) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		encoder.Encode(event)
+	}
+}
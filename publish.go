@@ -0,0 +1,355 @@
+package main
+
+/**
+ * Publishing
+ *
+ * Uploads the generated page, and the assets and mosaics written next to it, once a run succeeds:
+ * either to S3 (`-publish s3://bucket/prefix`, signed with AWS Signature Version 4) or through an
+ * arbitrary shell command (`-publish-cmd`).
+ *
+ * S3 credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN,
+ * the region from AWS_REGION (default us-east-1). AWS_ENDPOINT_URL points the upload at an
+ * S3-compatible service instead, using path-style URLs.
+ */
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"crf2html/gallery"
+)
+
+// PublishFile is a generated file and the slash-separated key it is published under.
+type PublishFile struct {
+	Path string
+	Key  string
+}
+
+// PublishedFiles lists the page, its stylesheet for fragments, the feed, and every file of the
+// assets and mosaic directories, keyed relative to the directory of the page so relative links
+// keep working once uploaded. The stylesheet and the feed are published next to the page.
+func PublishedFiles(settings Settings) ([]PublishFile, error) {
+	outputDirectory, err := filepath.Abs(filepath.Dir(settings.Page.OutputPath))
+
+	if err != nil {
+		return nil, err
+	}
+
+	files := []PublishFile{{Path: settings.Page.OutputPath, Key: filepath.Base(settings.Page.OutputPath)}}
+
+	for number := 2; settings.Page.PerPage > 0; number++ {
+		pagePath := PagePath(settings.Page.OutputPath, number)
+
+		if _, err := os.Stat(pagePath); err != nil {
+			break
+		}
+
+		files = append(files, PublishFile{Path: pagePath, Key: filepath.Base(pagePath)})
+	}
+
+	if settings.Page.Fragment {
+		stylesheetPath := FragmentStylesheetPath(settings.Page.OutputPath)
+		files = append(files, PublishFile{Path: stylesheetPath, Key: filepath.Base(stylesheetPath)})
+	}
+
+	if settings.Page.FeedPath != "" {
+		files = append(files, PublishFile{Path: settings.Page.FeedPath, Key: filepath.Base(settings.Page.FeedPath)})
+	}
+
+	if settings.Page.StatsJSONPath != "" {
+		files = append(files, PublishFile{Path: settings.Page.StatsJSONPath, Key: filepath.Base(settings.Page.StatsJSONPath)})
+	}
+
+	if settings.Page.ManifestPath != "" {
+		files = append(files, PublishFile{Path: settings.Page.ManifestPath, Key: filepath.Base(settings.Page.ManifestPath)})
+	}
+
+	for _, directory := range []string{settings.Page.AssetsPath, settings.Page.MosaicPath, settings.Page.BadgesPath} {
+		if directory == "" {
+			continue
+		}
+
+		directoryFiles, err := gallery.FileListing(directory)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, filePath := range directoryFiles {
+			absolutePath, err := filepath.Abs(filePath)
+
+			if err != nil {
+				return nil, err
+			}
+
+			key, err := filepath.Rel(outputDirectory, absolutePath)
+
+			// Directories outside of the page directory are published under their own name.
+			if err != nil || strings.HasPrefix(key, "..") {
+				relativePath, err := filepath.Rel(directory, filePath)
+
+				if err != nil {
+					return nil, err
+				}
+
+				key = filepath.Join(filepath.Base(directory), relativePath)
+			}
+
+			files = append(files, PublishFile{Path: filePath, Key: filepath.ToSlash(key)})
+		}
+	}
+
+	return files, nil
+}
+
+// Publish uploads the generated files to settings.Delivery.Publish and runs settings.Delivery.PublishCommand.
+func Publish(settings Settings) error {
+	files, err := PublishedFiles(settings)
+
+	if err != nil {
+		return err
+	}
+
+	if settings.Delivery.Publish != "" {
+		if err := publishS3(settings.Delivery.Publish, files); err != nil {
+			return err
+		}
+	}
+
+	if settings.Delivery.PublishCommand != "" {
+		if err := runPublishCommand(settings, files); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runPublishCommand(settings Settings, files []PublishFile) error {
+	command := gallery.ShellCommand(settings.Delivery.PublishCommand)
+
+	var paths []string
+
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+
+	command.Env = append(os.Environ(),
+		"CRF2HTML_OUTPUT="+settings.Page.OutputPath,
+		"CRF2HTML_OUTPUT_DIR="+filepath.Dir(settings.Page.OutputPath),
+		"CRF2HTML_ASSETS="+settings.Page.AssetsPath,
+		"CRF2HTML_MOSAIC="+settings.Page.MosaicPath,
+		"CRF2HTML_BADGES="+settings.Page.BadgesPath,
+		"CRF2HTML_FILES="+strings.Join(paths, string(os.PathListSeparator)),
+	)
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("publish-cmd: %v", err)
+	}
+
+	return nil
+}
+
+// s3Target is a parsed s3://bucket/prefix destination with its credentials.
+type s3Target struct {
+	Bucket       string
+	Prefix       string
+	Region       string
+	Endpoint     string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+func parseS3Target(destination string) (s3Target, error) {
+	parsedURL, err := url.Parse(destination)
+
+	if err != nil || parsedURL.Scheme != "s3" || parsedURL.Host == "" {
+		return s3Target{}, fmt.Errorf("publish: invalid destination %s, expected s3://bucket/prefix", destination)
+	}
+
+	target := s3Target{
+		Bucket:       parsedURL.Host,
+		Prefix:       strings.Trim(parsedURL.Path, "/"),
+		Region:       os.Getenv("AWS_REGION"),
+		Endpoint:     strings.TrimSuffix(os.Getenv("AWS_ENDPOINT_URL"), "/"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	if target.Region == "" {
+		target.Region = "us-east-1"
+	}
+
+	if target.AccessKey == "" || target.SecretKey == "" {
+		return s3Target{}, errors.New("publish: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+
+	return target, nil
+}
+
+// objectURL returns the URL of key, virtual-hosted on AWS and path-style on custom endpoints.
+func (target s3Target) objectURL(key string) string {
+	escapedKey := awsEscapePath(key)
+
+	if target.Endpoint != "" {
+		return fmt.Sprintf("%s/%s/%s", target.Endpoint, target.Bucket, escapedKey)
+	}
+
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", target.Bucket, target.Region, escapedKey)
+}
+
+func publishS3(destination string, files []PublishFile) error {
+	target, err := parseS3Target(destination)
+
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file.Path)
+
+		if err != nil {
+			return err
+		}
+
+		key := file.Key
+
+		if target.Prefix != "" {
+			key = path.Join(target.Prefix, key)
+		}
+
+		request, err := http.NewRequest(http.MethodPut, target.objectURL(key), bytes.NewReader(data))
+
+		if err != nil {
+			return err
+		}
+
+		contentType := mime.TypeByExtension(path.Ext(key))
+
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		request.Header.Set("Content-Type", contentType)
+		SignV4(request, data, target, time.Now())
+
+		response, err := client.Do(request)
+
+		if err != nil {
+			return err
+		}
+
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		response.Body.Close()
+
+		if response.StatusCode/100 != 2 {
+			return fmt.Errorf("publish: PUT %s: %s %s", key, response.Status, strings.TrimSpace(string(body)))
+		}
+	}
+
+	return nil
+}
+
+// SignV4 adds the AWS Signature Version 4 headers for the s3 service to request.
+func SignV4(request *http.Request, payload []byte, target s3Target, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	request.Header.Set("Host", request.URL.Host)
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	if target.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", target.SessionToken)
+	}
+
+	var headerNames []string
+
+	for name := range request.Header {
+		headerNames = append(headerNames, strings.ToLower(name))
+	}
+
+	sort.Strings(headerNames)
+
+	var canonicalHeaders strings.Builder
+
+	for _, name := range headerNames {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(request.Header.Get(name)) + "\n")
+	}
+
+	signedHeaders := strings.Join(headerNames, ";")
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + target.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+target.SecretKey), date)
+	key = hmacSHA256(key, target.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", target.AccessKey, scope, signedHeaders, signature))
+
+	// net/http sends the Host header from request.Host; keeping it in Header is only for signing.
+	request.Header.Del("Host")
+}
+
+// awsEscapePath percent-encodes everything but unreserved characters and slashes, as required
+// for SigV4 canonical URIs (url.PathEscape keeps characters such as '$' or '+').
+func awsEscapePath(key string) string {
+	var escaped strings.Builder
+
+	for _, b := range []byte(key) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', strings.IndexByte("-_.~/", b) >= 0:
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+
+	return escaped.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,315 @@
+package main
+
+/**
+ * Remote sources
+ *
+ * Downloads CRF/ZIP sources given as http(s) URLs to a temporary file before processing.
+ * Interrupted downloads are resumed with Range requests, and the result is checked against the
+ * `.sha256` sidecar published next to the archive, when the server serves one. A partial download is
+ * only resumed with If-Range and the ETag or Last-Modified date it was started with, so that an
+ * archive replaced on the server in between is downloaded again rather than spliced. A lock file
+ * keeps two runs from writing the same partial download: the second one downloads to a file of
+ * its own.
+ */
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	downloadAttempts = 5
+	downloadTimeout  = 30 * time.Minute
+)
+
+// IsRemoteSource reports whether sourcePath is an http(s) URL.
+func IsRemoteSource(sourcePath string) bool {
+	lower := strings.ToLower(sourcePath)
+
+	return strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "http://")
+}
+
+// DownloadSource downloads sourceURL and returns the path of the local copy, which the caller
+// removes once done. The partial download is kept across runs so the next one can resume it.
+func DownloadSource(sourceURL string) (string, error) {
+	directory := filepath.Join(os.TempDir(), "crf2html-downloads")
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return "", err
+	}
+
+	key := sha256.Sum256([]byte(sourceURL))
+	partialPath := filepath.Join(directory, hex.EncodeToString(key[:8])+".part")
+	client := &http.Client{Timeout: downloadTimeout}
+
+	unlock, locked := lockDownload(partialPath)
+
+	if !locked {
+		// Another run is downloading the same URL: start afresh in a file of this run.
+		partialFile, err := os.CreateTemp(directory, hex.EncodeToString(key[:8])+"-*.part")
+
+		if err != nil {
+			return "", err
+		}
+
+		partialFile.Close()
+		partialPath = partialFile.Name()
+	}
+
+	defer unlock()
+
+	var err error
+
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		if err = downloadRange(client, sourceURL, partialPath); err == nil {
+			break
+		}
+
+		fmt.Fprintf(os.Stderr, "download %s (attempt %d/%d): %v\n", sourceURL, attempt, downloadAttempts, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+
+	if err != nil {
+		// Only the partial download of the lock is resumed by later runs.
+		if !locked {
+			removePartial(partialPath)
+		}
+
+		return "", err
+	}
+
+	// The sidecar is optional: a server failing to serve it leaves the archive unchecked, only a
+	// mismatch with it fails the download.
+	expected, err := fetchChecksum(client, checksumURL(sourceURL))
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "download %s: no usable sha256 file: %v\n", sourceURL, err)
+	}
+
+	if expected != "" {
+		actual, err := FileSHA256(partialPath)
+
+		if err != nil {
+			return "", err
+		}
+
+		if actual != expected {
+			// A corrupted partial file would otherwise be resumed forever.
+			removePartial(partialPath)
+
+			return "", fmt.Errorf("download %s: sha256 mismatch, got %s, expected %s", sourceURL, actual, expected)
+		}
+	}
+
+	localPath := strings.TrimSuffix(partialPath, ".part") + sourceExtension(sourceURL)
+
+	if err := os.Rename(partialPath, localPath); err != nil {
+		return "", err
+	}
+
+	os.Remove(partialPath + ".validator")
+
+	return localPath, nil
+}
+
+// lockDownload takes the lock of the partial download at partialPath, and returns the function
+// releasing it. Locks older than a download can last are left by crashed runs, and taken over.
+func lockDownload(partialPath string) (func(), bool) {
+	lockPath := partialPath + ".lock"
+
+	for attempt := 0; attempt < 2; attempt++ {
+		lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+
+		if err == nil {
+			fmt.Fprintf(lockFile, "%d\n", os.Getpid())
+			lockFile.Close()
+
+			return func() { os.Remove(lockPath) }, true
+		}
+
+		if fileInfo, statErr := os.Stat(lockPath); statErr != nil || time.Since(fileInfo.ModTime()) < downloadTimeout {
+			break
+		}
+
+		os.Remove(lockPath)
+	}
+
+	return func() {}, false
+}
+
+// removePartial removes the partial download at partialPath and its validator.
+func removePartial(partialPath string) {
+	os.Remove(partialPath)
+	os.Remove(partialPath + ".validator")
+}
+
+// responseValidator returns the validator of response to resume its body with: its ETag, unless
+// weak, which If-Range does not accept, or else its Last-Modified date.
+func responseValidator(response *http.Response) string {
+	if etag := response.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+
+	return response.Header.Get("Last-Modified")
+}
+
+// downloadRange appends the missing bytes of sourceURL to partialPath. The bytes are only
+// requested with the validator the download was started with, in If-Range: the server sends the
+// whole archive again if it changed, and so does a server ignoring the Range header, either of
+// which restarts the download. A partial download without a validator is never resumed.
+func downloadRange(client *http.Client, sourceURL string, partialPath string) error {
+	var offset int64
+	validatorData, _ := os.ReadFile(partialPath + ".validator")
+	validator := strings.TrimSpace(string(validatorData))
+
+	if fileInfo, err := os.Stat(partialPath); err == nil && validator != "" {
+		offset = fileInfo.Size()
+	}
+
+	request, err := http.NewRequest(http.MethodGet, sourceURL, nil)
+
+	if err != nil {
+		return err
+	}
+
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		request.Header.Set("If-Range", validator)
+	}
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	// A server honoring If-Range only sends a part of the version of the validator; any other
+	// part, from a server ignoring it, is stale.
+	current := offset > 0 && (responseValidator(response) == "" || responseValidator(response) == validator)
+
+	switch {
+	case response.StatusCode == http.StatusOK:
+		flags |= os.O_TRUNC
+
+		if err := os.WriteFile(partialPath+".validator", []byte(responseValidator(response)+"\n"), 0644); err != nil {
+			return err
+		}
+	case response.StatusCode == http.StatusPartialContent && current && strings.HasPrefix(response.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)):
+		flags |= os.O_APPEND
+	case response.StatusCode == http.StatusRequestedRangeNotSatisfiable && current && response.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", offset):
+		// The partial file is already complete.
+		return nil
+	case response.StatusCode == http.StatusPartialContent || response.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The partial file cannot be completed with this response: download it again.
+		removePartial(partialPath)
+
+		return fmt.Errorf("GET %s: %s does not match the partial download, restarting", sourceURL, response.Status)
+	default:
+		return fmt.Errorf("GET %s: %s", sourceURL, response.Status)
+	}
+
+	partialFile, err := os.OpenFile(partialPath, flags, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(partialFile, response.Body)
+
+	if closeErr := partialFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// fetchChecksum returns the hash listed in a sha256sum-style sidecar, or "" when there is none.
+// checksumURL returns the URL of the .sha256 sidecar of sourceURL, the suffix going to the path, so
+// that the query of a presigned link or the fragment is not taken for it.
+func checksumURL(sourceURL string) string {
+	parsed, err := url.Parse(sourceURL)
+
+	if err != nil {
+		return sourceURL + ".sha256"
+	}
+
+	parsed.Fragment, parsed.RawFragment = "", ""
+	parsed.Path += ".sha256"
+
+	if parsed.RawPath != "" {
+		parsed.RawPath += ".sha256"
+	}
+
+	return parsed.String()
+}
+
+func fetchChecksum(client *http.Client, checksumURL string) (string, error) {
+	response, err := client.Get(checksumURL)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusForbidden {
+		return "", nil
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s", checksumURL, response.Status)
+	}
+
+	scanner := bufio.NewScanner(io.LimitReader(response.Body, 4096))
+
+	if scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 && len(fields[0]) == 64 {
+			if _, err := hex.DecodeString(fields[0]); err == nil {
+				return strings.ToLower(fields[0]), nil
+			}
+		}
+	}
+
+	return "", errors.New("invalid checksum file " + checksumURL)
+}
+
+// FileSHA256 returns the hex-encoded SHA-256 of the file at filePath.
+func FileSHA256(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	hash := sha256.New()
+
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// sourceExtension keeps the archive extension of the URL path, ignoring any query string.
+func sourceExtension(sourceURL string) string {
+	if end := strings.IndexAny(sourceURL, "?#"); end >= 0 {
+		sourceURL = sourceURL[:end]
+	}
+
+	return strings.ToLower(filepath.Ext(sourceURL))
+}
@@ -0,0 +1,235 @@
+package main
+
+/**
+ * Repack
+ *
+ * `crf2html repack input.crf output.crf [options]` rewrites a CRF the way pack writes one: names
+ * in order, optionally lowercased, deflated at the chosen level, and checked along the way. Files
+ * can be dropped on the way: system files always, others by pattern with -exclude, and with
+ * -missions the textures no mission uses. Names can be shortened to 8.3 as pack does. The savings
+ * are reported, file by file for the drops.
+ */
+
+import (
+	"compress/flate"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"crf2html/gallery"
+)
+
+// RepackOptions are the options of repack.
+type RepackOptions struct {
+	// Level is the deflate level, from 0 (stored) to 9.
+	Level int
+	// Exclude holds path.Match patterns of the files to drop, matched against their
+	// "family/file" name and their file name, case-insensitively.
+	Exclude []string
+	// MissionsPath, when set, drops the textures used by none of its missions.
+	MissionsPath string
+	// Lowercase lowercases the names of the files.
+	Lowercase bool
+
+	ShortNameOptions
+}
+
+// parseRepackArguments reads the options following the input and output paths of repack.
+func parseRepackArguments(args []string) (RepackOptions, error) {
+	options := RepackOptions{Level: flate.BestCompression}
+
+	for i := 0; i < len(args); i++ {
+		option := args[i]
+
+		if option == "-lowercase" {
+			options.Lowercase = true
+
+			continue
+		}
+
+		if last, err := options.parseOption(args, i); err != nil || last >= 0 {
+			if err != nil {
+				return options, err
+			}
+
+			i = last
+
+			continue
+		}
+
+		if i+1 >= len(args) {
+			return options, fmt.Errorf("Missing value for %s", option)
+		}
+
+		i++
+		value := args[i]
+
+		switch option {
+		case "-level":
+			level, err := strconv.Atoi(value)
+
+			if err != nil || level < flate.NoCompression || level > flate.BestCompression {
+				return options, fmt.Errorf("Invalid value for %s: %s", option, value)
+			}
+
+			options.Level = level
+		case "-exclude":
+			if _, err := path.Match(value, ""); err != nil {
+				return options, fmt.Errorf("Invalid value for %s: %s", option, value)
+			}
+
+			options.Exclude = append(options.Exclude, strings.ToLower(value))
+		case "-missions":
+			options.MissionsPath = value
+		default:
+			return options, fmt.Errorf("Unknown option: %s", option)
+		}
+	}
+
+	return options, nil
+}
+
+// excluded tells whether name matches one of the patterns of options.
+func (options RepackOptions) excluded(name string) bool {
+	name = strings.ToLower(name)
+
+	for _, pattern := range options.Exclude {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+
+		if matched, _ := path.Match(pattern, path.Base(name)); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Repack reads the CRF inputPath and returns the entries of its repacked version, with the
+// names of the files dropped and why.
+func Repack(inputPath string, options RepackOptions) ([]gallery.PackEntry, []string, error) {
+	var usage map[string]int
+
+	if options.MissionsPath != "" {
+		var err error
+		usage, err = gallery.LoadMissionUsage(options.MissionsPath)
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	source, err := gallery.OpenSource(inputPath)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer source.Close()
+
+	names, err := source.Names()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var entries []gallery.PackEntry
+	var dropped []string
+	rules := gallery.DefaultRules()
+
+	for _, name := range names {
+		if gallery.SystemFile(path.Base(name)) {
+			dropped = append(dropped, name+": system file")
+
+			continue
+		}
+
+		if options.excluded(name) {
+			dropped = append(dropped, name+": excluded")
+
+			continue
+		}
+
+		data, err := source.ReadFile(name)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if usage != nil {
+			lower := strings.ToLower(name)
+			file := path.Base(lower)
+			candidate := gallery.Candidate{Path: name, Family: path.Base(path.Dir(lower)), File: file, Extension: path.Ext(file), Data: data}
+
+			if rules.Decide(candidate).Verdict == gallery.VerdictTexture && usage[candidate.Family+"/"+strings.TrimSuffix(file, candidate.Extension)] == 0 {
+				dropped = append(dropped, name+": used in no mission")
+
+				continue
+			}
+		}
+
+		if options.Lowercase {
+			name = strings.ToLower(name)
+		}
+
+		entries = append(entries, gallery.PackEntry{Name: name, Data: data})
+	}
+
+	return entries, dropped, nil
+}
+
+// RunRepack repacks the CRF args[0] into args[1], with the options following them.
+func RunRepack(args []string) error {
+	if len(args) < 2 {
+		return errors.New("Usage: program repack input_path output_path [-level 0-9] [-exclude pattern] [-missions path] [-lowercase] [-short-names] [-rename-map path]")
+	}
+
+	inputInfo, err := os.Stat(args[0])
+
+	if err != nil {
+		return err
+	}
+
+	if inputInfo.IsDir() {
+		return fmt.Errorf("%s is a directory, pack it with the pack subcommand", args[0])
+	}
+
+	options, err := parseRepackArguments(args[2:])
+
+	if err != nil {
+		return err
+	}
+
+	entries, dropped, err := Repack(args[0], options)
+
+	if err != nil {
+		return err
+	}
+
+	for _, drop := range dropped {
+		fmt.Fprintf(os.Stderr, "dropped %s\n", drop)
+	}
+
+	if len(entries) == 0 {
+		return fmt.Errorf("no files left in %s", args[0])
+	}
+
+	if entries, err = options.apply(entries); err != nil {
+		return err
+	}
+
+	size, err := writeCheckedPack(entries, args[0], args[1], options.Level)
+
+	if err != nil {
+		return err
+	}
+
+	saved := inputInfo.Size() - int64(size)
+	fmt.Fprintf(os.Stderr, "repacked %d files (%d dropped) into %s: %d bytes, %d bytes (%.1f%%) saved\n", len(entries), len(dropped), args[1], size, saved, float64(saved)*100/float64(max(inputInfo.Size(), 1)))
+
+	return nil
+}
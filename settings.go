@@ -0,0 +1,666 @@
+package main
+
+/**
+ * Settings
+ *
+ * The settings of a run, grouped by concern: where the textures come from (SourceOptions), how
+ * thumbnails are encoded (ThumbOptions), what the page shows and where it goes (PageOptions), and
+ * what happens once it is written (DeliveryOptions). Command-line flags, JSON configuration files
+ * (-config) and programs building Settings directly all go through the same Validate methods.
+ */
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"crf2html/gallery"
+)
+
+// SourceOptions describes the textures to scan and the data used to annotate them.
+type SourceOptions struct {
+	Path         string   `json:"path,omitempty"`
+	VerifySHA256 string   `json:"verify_sha256,omitempty"`
+	MaxOpenFiles int      `json:"max_open_files,omitempty"`
+	ModelsPath   string   `json:"models,omitempty"`
+	MissionsPath string   `json:"missions,omitempty"`
+	Overlays     []string `json:"overlays,omitempty"`
+	Spill        bool     `json:"spill,omitempty"`
+	Exec         string   `json:"exec,omitempty"`
+	GitRef       string   `json:"git_ref,omitempty"`
+	// DecodeTimeout is a duration such as "30s"; "0" disables the timeout.
+	DecodeTimeout string `json:"decode_timeout,omitempty"`
+
+	// BaseRef, set by the git-diff subcommand along with GitRef, is the ref the textures of
+	// GitRef are compared with: the page only shows the ones added or modified since.
+	BaseRef string `json:"-"`
+	// Explain names a file whose rule decision is printed instead of writing the page.
+	Explain string `json:"-"`
+	// List prints the textures of the source, measured from the headers of their files, instead
+	// of writing the page.
+	List bool `json:"-"`
+}
+
+// ThumbOptions describes the thumbnails. Zero JPEGQuality and Subsampling pick a value per source,
+// zero WebPQuality the JPEG quality of each thumbnail.
+type ThumbOptions struct {
+	Size        int        `json:"size,omitempty"`
+	Background  color.RGBA `json:"background"`
+	JPEGQuality int        `json:"quality,omitempty"`
+	WebPQuality int        `json:"webp_quality,omitempty"`
+	Subsampling int        `json:"subsampling,omitempty"`
+	Progressive bool       `json:"progressive,omitempty"`
+	AutoFormat  bool       `json:"auto_format,omitempty"`
+	Lossless    bool       `json:"lossless,omitempty"`
+	Relief      bool       `json:"relief,omitempty"`
+	Quantize    int        `json:"quantize,omitempty"`
+	// Upscale names a built-in upscaler of the 4x previews, UpscaleCommand is an external one.
+	Upscale        string `json:"upscale,omitempty"`
+	UpscaleCommand string `json:"upscale_cmd,omitempty"`
+}
+
+// PageOptions describes the generated page and the files written next to it.
+type PageOptions struct {
+	OutputPath      string   `json:"output,omitempty"`
+	Title           string   `json:"title,omitempty"`
+	Caption         string   `json:"caption,omitempty"`
+	NoCaptions      bool     `json:"no_captions,omitempty"`
+	NoJS            bool     `json:"no_js,omitempty"`
+	EngineView      bool     `json:"engine_view,omitempty"`
+	FamilyNamesPath string   `json:"family_names,omitempty"`
+	RatingsPath     string   `json:"ratings,omitempty"`
+	FamilyOrder     string   `json:"sort_families,omitempty"`
+	Collation       string   `json:"collation,omitempty"`
+	Format          string   `json:"format,omitempty"`
+	Columns         int      `json:"columns,omitempty"`
+	PerPage         int      `json:"per_page,omitempty"`
+	MinDim          int      `json:"min_dim,omitempty"`
+	MaxDim          int      `json:"max_dim,omitempty"`
+	Themes          []string `json:"themes,omitempty"`
+	Print           bool     `json:"print,omitempty"`
+	Fragment        bool     `json:"fragment,omitempty"`
+	Stats           bool     `json:"stats,omitempty"`
+	Seams           bool     `json:"seams,omitempty"`
+	GroupVariants   bool     `json:"group_variants,omitempty"`
+	VariantSuffixes []string `json:"variant_suffixes,omitempty"`
+	ChangedOnly     bool     `json:"changed_only,omitempty"`
+	Check           bool     `json:"check,omitempty"`
+	OnlyFamily      string   `json:"-"`
+	FeedPath        string   `json:"feed,omitempty"`
+	ManifestPath    string   `json:"manifest,omitempty"`
+	MaxEmbedBytes   int      `json:"max_embed_bytes,omitempty"`
+	AssetsPath      string   `json:"assets,omitempty"`
+	SRI             bool     `json:"sri,omitempty"`
+	InlineBelow     int      `json:"inline_below,omitempty"`
+	AssetLayout     string   `json:"asset_layout,omitempty"`
+	MosaicPath      string   `json:"mosaic,omitempty"`
+	BadgesPath      string   `json:"badges,omitempty"`
+	StatsJSONPath   string   `json:"stats_json,omitempty"`
+}
+
+// DeliveryOptions describes what happens once the page is written.
+type DeliveryOptions struct {
+	Publish        string `json:"publish,omitempty"`
+	PublishCommand string `json:"publish_cmd,omitempty"`
+	NotifyWebhook  string `json:"notify_webhook,omitempty"`
+	NotifyLink     string `json:"notify_link,omitempty"`
+}
+
+// Settings holds everything a run needs.
+type Settings struct {
+	Source     SourceOptions   `json:"source"`
+	Thumbnails ThumbOptions    `json:"thumbnails"`
+	Page       PageOptions     `json:"page"`
+	Delivery   DeliveryOptions `json:"delivery"`
+	// Progress is the format of the progress events written to stderr, "json", or empty for none.
+	Progress string `json:"progress,omitempty"`
+	// OnInterrupt is what an interrupted run writes: nothing ("abort") or the textures scanned
+	// so far ("partial").
+	OnInterrupt string `json:"on_interrupt,omitempty"`
+	// Jobs is the number of textures decoded and rendered at once, zero to size it after the CPUs
+	// and the pack (see gallery.Tune). Tune reports the parallelism chosen on stderr.
+	Jobs int  `json:"jobs,omitempty"`
+	Tune bool `json:"tune,omitempty"`
+}
+
+// DefaultSettings returns the settings of a run without options.
+func DefaultSettings() Settings {
+	return Settings{
+		Source:      SourceOptions{MaxOpenFiles: gallery.DefaultScanOptions().MaxOpenFiles, DecodeTimeout: gallery.DefaultScanOptions().DecodeTimeout.String()},
+		Thumbnails:  ThumbOptions{Size: 128, Background: color.RGBA{255, 255, 255, 255}},
+		Page:        PageOptions{Title: "Textures", Format: "html", VariantSuffixes: gallery.DefaultVariantSuffixes, AssetLayout: "family", InlineBelow: 8192, FamilyOrder: "name"},
+		OnInterrupt: "abort",
+	}
+}
+
+// Validate checks the source options.
+func (options SourceOptions) Validate() error {
+	if options.MaxOpenFiles < 1 {
+		return fmt.Errorf("invalid source.max_open_files: %d", options.MaxOpenFiles)
+	}
+
+	if timeout, err := time.ParseDuration(options.DecodeTimeout); err != nil || timeout < 0 {
+		return fmt.Errorf("invalid source.decode_timeout: %s", options.DecodeTimeout)
+	}
+
+	if options.List && options.Explain != "" {
+		return fmt.Errorf("-list and -explain are exclusive")
+	}
+
+	if _, err := hex.DecodeString(options.VerifySHA256); err != nil || (options.VerifySHA256 != "" && len(options.VerifySHA256) != 64) {
+		return fmt.Errorf("invalid source.verify_sha256: %s", options.VerifySHA256)
+	}
+
+	if options.Exec != "" && (!strings.Contains(options.Exec, "{path}") || !strings.Contains(options.Exec, "{out}")) {
+		return fmt.Errorf("invalid source.exec, missing {path} or {out}: %s", options.Exec)
+	}
+
+	return nil
+}
+
+// Validate checks the thumbnail options.
+func (options ThumbOptions) Validate() error {
+	if options.Size < 1 {
+		return fmt.Errorf("invalid thumbnails.size: %d", options.Size)
+	}
+
+	if options.JPEGQuality < 0 || options.JPEGQuality > 100 {
+		return fmt.Errorf("invalid thumbnails.quality: %d", options.JPEGQuality)
+	}
+
+	if options.WebPQuality < 0 || options.WebPQuality > 100 {
+		return fmt.Errorf("invalid thumbnails.webp_quality: %d", options.WebPQuality)
+	}
+
+	if options.Subsampling != 0 && options.Subsampling != 444 && options.Subsampling != 420 {
+		return fmt.Errorf("invalid thumbnails.subsampling: %d", options.Subsampling)
+	}
+
+	if options.Quantize < 0 || options.Quantize == 1 || options.Quantize > 256 {
+		return fmt.Errorf("invalid thumbnails.quantize: %d", options.Quantize)
+	}
+
+	if _, ok := gallery.Upscalers[options.Upscale]; options.Upscale != "" && !ok {
+		return fmt.Errorf("invalid thumbnails.upscale: %s", options.Upscale)
+	}
+
+	if options.Upscale != "" && options.UpscaleCommand != "" {
+		return fmt.Errorf("thumbnails.upscale and thumbnails.upscale_cmd are exclusive")
+	}
+
+	if options.UpscaleCommand != "" && (!strings.Contains(options.UpscaleCommand, "{in}") || !strings.Contains(options.UpscaleCommand, "{out}")) {
+		return fmt.Errorf("invalid thumbnails.upscale_cmd, missing {in} or {out}: %s", options.UpscaleCommand)
+	}
+
+	return nil
+}
+
+// Upscaler returns the upscaler of the previews, or nil for none.
+func (options ThumbOptions) Upscaler() gallery.Upscaler {
+	if options.UpscaleCommand != "" {
+		return gallery.CommandUpscaler{Command: options.UpscaleCommand}
+	}
+
+	return gallery.Upscalers[options.Upscale]
+}
+
+// Validate checks the page options.
+func (options PageOptions) Validate() error {
+	if options.Format != "html" && options.Format != "json" && options.Format != "sqlite" {
+		return fmt.Errorf("invalid page.format: %s", options.Format)
+	}
+
+	if options.Format == "sqlite" && options.ChangedOnly {
+		return fmt.Errorf("page.format sqlite records whole inventories, not changed_only")
+	}
+
+	if options.Fragment && options.Format != "html" {
+		return fmt.Errorf("page.fragment requires the html format, not %s", options.Format)
+	}
+
+	if options.OnlyFamily != "" && (options.Format != "html" || options.ChangedOnly) {
+		return fmt.Errorf("-only-family requires the html format without changed_only")
+	}
+
+	if options.EngineView && (options.NoJS || options.Fragment) {
+		return fmt.Errorf("page.engine_view needs the script, not no_js nor fragment")
+	}
+
+	if options.SRI && (options.AssetsPath == "" || options.Fragment) {
+		return fmt.Errorf("page.sri requires page.assets, and a whole page")
+	}
+
+	if options.Check && (options.OnlyFamily != "" || options.ChangedOnly) {
+		return fmt.Errorf("page.check compares whole galleries, not -only-family nor changed_only ones")
+	}
+
+	if err := gallery.CheckCaption(options.Caption); err != nil {
+		return fmt.Errorf("invalid page.caption: %v", err)
+	}
+
+	if options.InlineBelow < 0 {
+		return fmt.Errorf("invalid page.inline_below: %d", options.InlineBelow)
+	}
+
+	if options.MaxEmbedBytes < 0 {
+		return fmt.Errorf("invalid page.max_embed_bytes: %d", options.MaxEmbedBytes)
+	}
+
+	if options.Columns < 0 {
+		return fmt.Errorf("invalid page.columns: %d", options.Columns)
+	}
+
+	if options.PerPage < 0 {
+		return fmt.Errorf("invalid page.per_page: %d", options.PerPage)
+	}
+
+	if options.MinDim < 0 || options.MaxDim < 0 || options.MaxDim > 0 && options.MinDim > options.MaxDim {
+		return fmt.Errorf("invalid page.min_dim and page.max_dim: %d to %d", options.MinDim, options.MaxDim)
+	}
+
+	if options.Check && (options.MinDim > 0 || options.MaxDim > 0) {
+		return fmt.Errorf("page.check compares whole galleries, not ones filtered by min_dim or max_dim")
+	}
+
+	if !slices.Contains(gallery.FamilyOrders, options.FamilyOrder) {
+		return fmt.Errorf("invalid page.sort_families: %s", options.FamilyOrder)
+	}
+
+	if _, err := gallery.Collation(options.Collation); options.Collation != "" && err != nil {
+		return fmt.Errorf("invalid page.collation: %s", options.Collation)
+	}
+
+	if !slices.Contains(AssetLayouts, options.AssetLayout) {
+		return fmt.Errorf("invalid page.asset_layout: %s", options.AssetLayout)
+	}
+
+	for _, theme := range options.Themes {
+		if _, ok := gallery.Themes[theme]; !ok {
+			return fmt.Errorf("invalid page.themes: %s", theme)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks all the settings.
+func (settings Settings) Validate() error {
+	if err := settings.Source.Validate(); err != nil {
+		return err
+	}
+
+	if err := settings.Thumbnails.Validate(); err != nil {
+		return err
+	}
+
+	if !slices.Contains(ProgressFormats, settings.Progress) {
+		return fmt.Errorf("invalid progress: %s", settings.Progress)
+	}
+
+	if !slices.Contains(InterruptModes, settings.OnInterrupt) {
+		return fmt.Errorf("invalid on_interrupt: %s", settings.OnInterrupt)
+	}
+
+	if settings.Jobs < 0 {
+		return fmt.Errorf("invalid jobs: %d", settings.Jobs)
+	}
+
+	if settings.OnInterrupt == "partial" && settings.Page.Format == "sqlite" {
+		return fmt.Errorf("on_interrupt partial writes pages, not sqlite databases")
+	}
+
+	return settings.Page.Validate()
+}
+
+// FromFile returns settings overridden by the keys present in the JSON file at path.
+func (settings Settings) FromFile(path string) (Settings, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return settings, err
+	}
+
+	// Unmarshal allocates a new slice for the suffixes, so the receiver's one is left untouched.
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return settings, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if err := settings.Validate(); err != nil {
+		return settings, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return settings, nil
+}
+
+// Merge returns settings overridden by the fields of override that are not zero. A false
+// boolean or an empty list in override therefore keeps the value of settings.
+func (settings Settings) Merge(override Settings) Settings {
+	mergeValue(reflect.ValueOf(&settings).Elem(), reflect.ValueOf(override))
+
+	return settings
+}
+
+func mergeValue(target reflect.Value, override reflect.Value) {
+	for i := 0; i < target.NumField(); i++ {
+		field := override.Field(i)
+
+		if field.Kind() == reflect.Struct && target.Type().Field(i).Type != reflect.TypeOf(color.RGBA{}) {
+			mergeValue(target.Field(i), field)
+		} else if !field.IsZero() {
+			target.Field(i).Set(field)
+		}
+	}
+}
+
+// FromFlags returns settings overridden by the options of args, such as "-size 64". settings
+// must be valid: each value is checked right after being set.
+func (settings Settings) FromFlags(args []string) (Settings, error) {
+	if err := settings.Validate(); err != nil {
+		return settings, err
+	}
+
+	for i := 0; i < len(args); i++ {
+		option := args[i]
+
+		switch option {
+		case "-progressive":
+			settings.Thumbnails.Progressive = true
+
+			continue
+		case "-auto-format":
+			settings.Thumbnails.AutoFormat = true
+
+			continue
+		case "-lossless":
+			settings.Thumbnails.Lossless = true
+
+			continue
+		case "-stats":
+			settings.Page.Stats = true
+
+			continue
+		case "-seams":
+			settings.Page.Seams = true
+
+			continue
+		case "-relief":
+			settings.Thumbnails.Relief = true
+
+			continue
+		case "-group-variants":
+			settings.Page.GroupVariants = true
+
+			continue
+		case "-changed-only":
+			settings.Page.ChangedOnly = true
+
+			continue
+		case "-check":
+			settings.Page.Check = true
+
+			continue
+		case "-no-captions":
+			settings.Page.NoCaptions = true
+
+			continue
+		case "-no-js":
+			settings.Page.NoJS = true
+
+			continue
+		case "-engine-view":
+			settings.Page.EngineView = true
+
+			continue
+		case "-sri":
+			settings.Page.SRI = true
+
+			continue
+		case "-print":
+			settings.Page.Print = true
+
+			continue
+		case "-fragment":
+			settings.Page.Fragment = true
+
+			continue
+		case "-spill":
+			settings.Source.Spill = true
+
+			continue
+		case "-tune":
+			settings.Tune = true
+
+			continue
+		case "-list":
+			settings.Source.List = true
+
+			continue
+		}
+
+		if i+1 >= len(args) {
+			return settings, fmt.Errorf("Missing value for %s", option)
+		}
+
+		i++
+		value := args[i]
+
+		switch option {
+		case "-title":
+			settings.Page.Title = value
+		case "-caption":
+			settings.Page.Caption = value
+		case "-family-names":
+			settings.Page.FamilyNamesPath = value
+		case "-ratings":
+			settings.Page.RatingsPath = value
+		case "-sort-families":
+			settings.Page.FamilyOrder = value
+		case "-collation":
+			settings.Page.Collation = value
+		case "-progress":
+			settings.Progress = value
+		case "-on-interrupt":
+			settings.OnInterrupt = value
+		case "-exec":
+			settings.Source.Exec = value
+		case "-decode-timeout":
+			settings.Source.DecodeTimeout = value
+		case "-git-ref":
+			settings.Source.GitRef = value
+		case "-upscale":
+			settings.Thumbnails.Upscale = value
+		case "-upscale-cmd":
+			settings.Thumbnails.UpscaleCommand = value
+		case "-format":
+			settings.Page.Format = value
+		case "-columns":
+			if value == "auto" {
+				settings.Page.Columns = 0
+
+				break
+			}
+
+			number, err := strconv.Atoi(value)
+
+			if err != nil || number == 0 {
+				return settings, fmt.Errorf("Invalid value for %s: %s", option, value)
+			}
+
+			settings.Page.Columns = number
+		case "-size", "-quality", "-webp-quality", "-subsampling", "-quantize", "-max-open-files", "-max-embed-bytes", "-per-page", "-min-dim", "-max-dim", "-jobs":
+			number, err := strconv.Atoi(value)
+
+			// Zero is rejected: for -quality, -webp-quality and -subsampling it stands for the
+			// per-source default, which leaving the flag out already gives.
+			if err != nil || number == 0 {
+				return settings, fmt.Errorf("Invalid value for %s: %s", option, value)
+			}
+
+			switch option {
+			case "-size":
+				settings.Thumbnails.Size = number
+			case "-quality":
+				settings.Thumbnails.JPEGQuality = number
+			case "-webp-quality":
+				settings.Thumbnails.WebPQuality = number
+			case "-subsampling":
+				settings.Thumbnails.Subsampling = number
+			case "-quantize":
+				settings.Thumbnails.Quantize = number
+			case "-max-open-files":
+				settings.Source.MaxOpenFiles = number
+			case "-max-embed-bytes":
+				settings.Page.MaxEmbedBytes = number
+			case "-per-page":
+				settings.Page.PerPage = number
+			case "-min-dim":
+				settings.Page.MinDim = number
+			case "-max-dim":
+				settings.Page.MaxDim = number
+			case "-jobs":
+				settings.Jobs = number
+			}
+		case "-inline-below":
+			number, err := strconv.Atoi(value)
+
+			if err != nil {
+				return settings, fmt.Errorf("Invalid value for %s: %s", option, value)
+			}
+
+			settings.Page.InlineBelow = number
+		case "-models":
+			settings.Source.ModelsPath = value
+		case "-missions":
+			settings.Source.MissionsPath = value
+		case "-overlay":
+			settings.Source.Overlays = append(settings.Source.Overlays, value)
+		case "-only-family":
+			settings.Page.OnlyFamily = strings.ToLower(value)
+		case "-explain":
+			settings.Source.Explain = value
+		case "-mosaic":
+			settings.Page.MosaicPath = value
+		case "-badges":
+			settings.Page.BadgesPath = value
+		case "-stats-json":
+			settings.Page.StatsJSONPath = value
+		case "-feed":
+			settings.Page.FeedPath = value
+		case "-manifest":
+			settings.Page.ManifestPath = value
+		case "-assets":
+			settings.Page.AssetsPath = value
+		case "-asset-layout":
+			settings.Page.AssetLayout = value
+		case "-notify-webhook":
+			settings.Delivery.NotifyWebhook = value
+		case "-notify-link":
+			settings.Delivery.NotifyLink = value
+		case "-verify":
+			if !strings.HasPrefix(value, "sha256:") {
+				return settings, fmt.Errorf("Invalid value for %s: %s", option, value)
+			}
+
+			settings.Source.VerifySHA256 = strings.ToLower(strings.TrimPrefix(value, "sha256:"))
+		case "-publish":
+			settings.Delivery.Publish = value
+		case "-publish-cmd":
+			settings.Delivery.PublishCommand = value
+		case "-theme":
+			settings.Page.Themes = nil
+
+			for _, theme := range strings.Split(strings.ToLower(value), ",") {
+				if theme = strings.TrimSpace(theme); theme != "" {
+					settings.Page.Themes = append(settings.Page.Themes, theme)
+				}
+			}
+		case "-variant-suffixes":
+			settings.Page.VariantSuffixes = nil
+
+			for _, suffix := range strings.Split(strings.ToLower(value), ",") {
+				if suffix = strings.TrimSpace(suffix); suffix != "" {
+					settings.Page.VariantSuffixes = append(settings.Page.VariantSuffixes, suffix)
+				}
+			}
+		default:
+			return settings, fmt.Errorf("Unknown option: %s", option)
+		}
+
+		if err := settings.Validate(); err != nil {
+			return settings, fmt.Errorf("Invalid value for %s: %s", option, value)
+		}
+	}
+
+	// Boolean options can only conflict with others, such as -fragment with -format json.
+	return settings, settings.Validate()
+}
+
+// ScanOptions returns the gallery options used to scan the source.
+func (settings Settings) ScanOptions() gallery.ScanOptions {
+	options := gallery.DefaultScanOptions()
+	options.MaxOpenFiles = settings.Source.MaxOpenFiles
+	options.Workers = settings.Jobs
+	// Validate has checked the duration.
+	options.DecodeTimeout, _ = time.ParseDuration(settings.Source.DecodeTimeout)
+	options.Exec = settings.Source.Exec
+	// Listing and explaining need no pixels.
+	options.HeaderOnly = settings.Source.List || settings.Source.Explain != ""
+
+	// The statistics and seams are computed on the full images, the thumbnails need no more than
+	// their size.
+	if !settings.Page.Stats && !settings.Page.Seams {
+		options.PreviewSize = settings.Thumbnails.Size
+	}
+
+	if settings.Page.OnlyFamily != "" {
+		options.Families = []string{settings.Page.OnlyFamily}
+	}
+
+	return options
+}
+
+// RenderOptions returns the gallery options rendering the page, without the data loaded from
+// the models and missions nor the callbacks, which Generate adds.
+func (settings Settings) RenderOptions() gallery.RenderOptions {
+	var compareNames func(a string, b string) int
+
+	// Validate has checked the collation.
+	if settings.Page.Collation != "" {
+		compareNames, _ = gallery.Collation(settings.Page.Collation)
+	}
+
+	return gallery.RenderOptions{
+		Title:           settings.Page.Title,
+		Caption:         settings.Page.Caption,
+		NoCaptions:      settings.Page.NoCaptions,
+		NoJS:            settings.Page.NoJS,
+		EngineView:      settings.Page.EngineView,
+		SRI:             settings.Page.SRI,
+		InlineBelow:     settings.Page.InlineBelow,
+		ThumbnailSize:   settings.Thumbnails.Size,
+		Background:      settings.Thumbnails.Background,
+		JPEGQuality:     settings.Thumbnails.JPEGQuality,
+		Subsampling:     settings.Thumbnails.Subsampling,
+		Progressive:     settings.Thumbnails.Progressive,
+		AutoFormat:      settings.Thumbnails.AutoFormat,
+		Lossless:        settings.Thumbnails.Lossless,
+		Stats:           settings.Page.Stats,
+		Seams:           settings.Page.Seams,
+		Relief:          settings.Thumbnails.Relief,
+		Quantize:        settings.Thumbnails.Quantize,
+		Upscaler:        settings.Thumbnails.Upscaler(),
+		GroupVariants:   settings.Page.GroupVariants,
+		VariantSuffixes: settings.Page.VariantSuffixes,
+		Columns:         settings.Page.Columns,
+		Themes:          settings.Page.Themes,
+		Print:           settings.Page.Print,
+		CompareNames:    compareNames,
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+/**
+ * Generation state
+ *
+ * Every run stores the SHA-256 of each texture next to the page (`<output_path>.state.json`), so
+ * the next run can tell which textures were added or modified since and, with -changed-only,
+ * show those alone, or with -feed, announce them. The hash identifies a texture whatever its
+ * name, so a texture gone under one name and back, identical, under another was renamed rather
+ * than removed and added. The totals of every run are kept too, for the trend chart of the page.
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"time"
+
+	"crf2html/gallery"
+)
+
+// GenerationState is the content of the state file.
+type GenerationState struct {
+	Generated time.Time           `json:"generated"`
+	Textures  map[string]string   `json:"textures"`
+	History   []gallery.RunTotals `json:"history,omitempty"`
+}
+
+// StatePath returns the path of the state file kept for outputPath.
+func StatePath(outputPath string) string {
+	return outputPath + ".state.json"
+}
+
+// LoadState reads the state file of outputPath. A missing file yields an empty state.
+func LoadState(outputPath string) (GenerationState, error) {
+	state := GenerationState{Textures: make(map[string]string)}
+	data, err := os.ReadFile(StatePath(outputPath))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+
+	if err != nil {
+		return state, err
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, err
+	}
+
+	if state.Textures == nil {
+		state.Textures = make(map[string]string)
+	}
+
+	return state, nil
+}
+
+// SaveState writes the state file of outputPath.
+func SaveState(outputPath string, state GenerationState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(StatePath(outputPath), append(data, '\n'), 0644)
+}
+
+// TextureChanges maps the keys of the textures of current that are new, changed or renamed since
+// previous to "new", "changed" or "renamed".
+func TextureChanges(previous GenerationState, current GenerationState) map[string]string {
+	changes := make(map[string]string)
+	renames := TextureRenames(previous, current)
+
+	for key, hash := range current.Textures {
+		if _, renamed := renames[key]; renamed {
+			changes[key] = "renamed"
+		} else if previousHash, known := previous.Textures[key]; !known {
+			changes[key] = "new"
+		} else if previousHash != hash {
+			changes[key] = "changed"
+		}
+	}
+
+	return changes
+}
+
+// TextureRenames maps the keys of the textures of current missing from previous to the key of a
+// texture of previous missing from current with the same hash. Identical textures renamed
+// together are paired in key order.
+func TextureRenames(previous GenerationState, current GenerationState) map[string]string {
+	gone := make(map[string][]string)
+
+	for _, key := range sortedKeys(previous.Textures) {
+		if _, ok := current.Textures[key]; !ok {
+			gone[previous.Textures[key]] = append(gone[previous.Textures[key]], key)
+		}
+	}
+
+	renames := make(map[string]string)
+
+	for _, key := range sortedKeys(current.Textures) {
+		hash := current.Textures[key]
+
+		if _, known := previous.Textures[key]; known || len(gone[hash]) == 0 {
+			continue
+		}
+
+		renames[key] = gone[hash][0]
+		gone[hash] = gone[hash][1:]
+	}
+
+	return renames
+}
+
+// RemovedTextures returns the sorted keys of previous missing from current, but for renamed ones.
+func RemovedTextures(previous GenerationState, current GenerationState) []string {
+	renamed := make(map[string]bool)
+
+	for _, from := range TextureRenames(previous, current) {
+		renamed[from] = true
+	}
+
+	var removed []string
+
+	for key := range previous.Textures {
+		if _, ok := current.Textures[key]; !ok && !renamed[key] {
+			removed = append(removed, key)
+		}
+	}
+
+	sort.Strings(removed)
+
+	return removed
+}
+
+func sortedKeys(textures map[string]string) []string {
+	keys := make([]string, 0, len(textures))
+
+	for key := range textures {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}